@@ -4,26 +4,38 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"net/http"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/hdu-dp/backend/internal/auth"
+	"github.com/hdu-dp/backend/internal/captcha"
 	"github.com/hdu-dp/backend/internal/config"
 	"github.com/hdu-dp/backend/internal/database"
+	"github.com/hdu-dp/backend/internal/emailtemplate"
 	"github.com/hdu-dp/backend/internal/handlers"
 	adminHandlers "github.com/hdu-dp/backend/internal/handlers/admin"
+	"github.com/hdu-dp/backend/internal/imaging"
 	"github.com/hdu-dp/backend/internal/logging"
 	"github.com/hdu-dp/backend/internal/middleware"
+	"github.com/hdu-dp/backend/internal/passwordpolicy"
+	"github.com/hdu-dp/backend/internal/ratelimit"
 	"github.com/hdu-dp/backend/internal/repository"
 	"github.com/hdu-dp/backend/internal/router"
 	backendserver "github.com/hdu-dp/backend/internal/server"
 	"github.com/hdu-dp/backend/internal/services"
+	"github.com/hdu-dp/backend/internal/sms"
 	"github.com/hdu-dp/backend/internal/storage"
+	"github.com/hdu-dp/backend/internal/utils"
 )
 
 // App is the composed backend application.
 type App struct {
-	server *backendserver.HTTPServer
+	server      *backendserver.HTTPServer
+	emailOutbox *services.EmailOutboxService
+	webhooks    *services.WebhookService
+	signingKeys *services.SigningKeyService
+	accounts    *services.AccountService
 }
 
 // New wires the backend dependencies and returns a runnable application.
@@ -39,12 +51,38 @@ func New(cfg *config.Config) (*App, error) {
 	}
 	gin.EnableJsonDecoderDisallowUnknownFields()
 
+	if err := utils.SetPasswordParams(utils.PasswordParams{
+		Algorithm:         cfg.Auth.Password.Algorithm,
+		Argon2Memory:      cfg.Auth.Password.Argon2Memory,
+		Argon2Iterations:  cfg.Auth.Password.Argon2Iterations,
+		Argon2Parallelism: cfg.Auth.Password.Argon2Parallelism,
+		BcryptCost:        cfg.Auth.Password.BcryptCost,
+	}); err != nil {
+		return nil, fmt.Errorf("init password hashing: %w", err)
+	}
+	passwordPolicy, err := passwordpolicy.New(passwordpolicy.Options{
+		MinLength:          cfg.Auth.Password.MinLength,
+		MaxLength:          cfg.Auth.Password.MaxLength,
+		MinClasses:         cfg.Auth.Password.MinClasses,
+		RejectPersonalInfo: cfg.Auth.Password.RejectPersonal,
+		CheckBreached:      cfg.Auth.Password.BreachedCheck,
+		BreachedListFile:   cfg.Auth.Password.BreachedListFile,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("init password policy: %w", err)
+	}
+
 	db, err := database.Init(cfg)
 	if err != nil {
 		return nil, fmt.Errorf("init database: %w", err)
 	}
 
 	userRepo := repository.NewUserRepository(db)
+	if changed, err := userRepo.SyncCampusVerified(cfg.Campus.EmailDomains); err != nil {
+		return nil, fmt.Errorf("sync campus verification: %w", err)
+	} else if changed > 0 {
+		slog.Info("updated campus verification", slog.Int64("users", changed))
+	}
 	reviewRepo := repository.NewReviewRepository(db)
 	refreshRepo := repository.NewRefreshTokenRepository(db)
 	smsCodeRepo := repository.NewSMSCodeRepository(db)
@@ -52,22 +90,101 @@ func New(cfg *config.Config) (*App, error) {
 	reviewStatsRepo := repository.NewReviewStatsRepository(db)
 	reviewReactionRepo := repository.NewReviewReactionRepository(db)
 	siteStatsRepo := repository.NewSiteStatsRepository(db)
+	emailOutboxRepo := repository.NewEmailOutboxRepository(db)
+	webhookRepo := repository.NewWebhookRepository(db)
+	passwordResetRepo := repository.NewPasswordResetRepository(db)
+	emailChangeRepo := repository.NewEmailChangeRepository(db)
+	mfaRepo := repository.NewMFARepository(db)
+	signingKeyRepo := repository.NewSigningKeyRepository(db)
+	apiTokenRepo := repository.NewAPITokenRepository(db)
+
+	rateLimitStore, err := newRateLimitStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+	limiter := ratelimit.New(rateLimitStore)
+	codeLimits := services.CodeRateLimits{
+		Cooldown:    cfg.RateLimit.CodeCooldown,
+		PerTarget:   cfg.RateLimit.CodePerTarget,
+		Window:      cfg.RateLimit.CodeWindow,
+		MaxAttempts: cfg.RateLimit.MaxCodeAttempts,
+	}
 
 	emailCfg := config.LoadEmailConfig()
+	emailTemplates, err := emailtemplate.New(emailCfg.TemplateDir, emailCfg.DefaultLocale)
+	if err != nil {
+		return nil, fmt.Errorf("init email templates: %w", err)
+	}
 	emailService := services.NewEmailService(emailCfg)
+	emailOutboxService := services.NewEmailOutboxService(emailOutboxRepo, emailService, services.EmailOutboxOptions{
+		MaxAttempts:   emailCfg.Queue.MaxAttempts,
+		PollInterval:  emailCfg.Queue.PollInterval,
+		BaseBackoff:   emailCfg.Queue.BaseBackoff,
+		MaxBackoff:    emailCfg.Queue.MaxBackoff,
+		SentRetention: emailCfg.Queue.SentRetention,
+	})
 	emailVerificationService := services.NewEmailVerificationService(
 		emailVerificationRepo,
 		userRepo,
-		emailService,
+		emailOutboxService,
+		emailTemplates,
+		limiter,
+		codeLimits,
 		emailCfg.FrontendBaseURL,
+		cfg.Campus.EmailDomains,
 	)
+	accountMailer := services.NewAccountMailer(emailOutboxService, emailTemplates)
 
 	storageProvider, err := storage.New(cfg)
 	if err != nil {
 		return nil, fmt.Errorf("init storage: %w", err)
 	}
 
+	webhookService := services.NewWebhookService(webhookRepo, services.WebhookOptions{
+		MaxAttempts: cfg.Webhook.MaxAttempts,
+		Timeout:     cfg.Webhook.Timeout,
+		BaseBackoff: cfg.Webhook.BaseBackoff,
+		MaxBackoff:  cfg.Webhook.MaxBackoff,
+		Retention:   cfg.Webhook.Retention,
+	})
+
+	var smsSender sms.Sender
+	if cfg.Auth.SMS.Enabled {
+		if smsSender, err = sms.New(cfg); err != nil {
+			return nil, fmt.Errorf("init sms provider: %w", err)
+		}
+	}
+
+	captchaVerifier, err := captcha.New(cfg, limiter)
+	if err != nil {
+		return nil, fmt.Errorf("init captcha provider: %w", err)
+	}
+	captchaGuard := captcha.NewGuard(captchaVerifier, limiter, captcha.GuardOptions{
+		Provider:          cfg.Captcha.Provider,
+		SiteKey:           cfg.Captcha.SiteKey,
+		Endpoints:         cfg.Captcha.Endpoints,
+		AdaptiveThreshold: cfg.Captcha.AdaptiveThreshold,
+		AdaptiveWindow:    cfg.Captcha.AdaptiveWindow,
+	})
+
 	jwtManager := auth.NewJWTManager(cfg.Auth.JWTSecret, cfg.Auth.AccessTokenTTL)
+	signingKeys := auth.NewKeySet()
+	signingKeyService, err := services.NewSigningKeyService(signingKeyRepo, signingKeys, services.SigningKeyOptions{
+		Algorithm:        cfg.Auth.JWT.Algorithm,
+		KeysDir:          cfg.Auth.JWT.KeysDir,
+		RotationInterval: cfg.Auth.JWT.RotationInterval,
+		AccessTokenTTL:   cfg.Auth.AccessTokenTTL,
+		EncryptionKey:    cfg.Auth.JWT.KeyEncryptionKey,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("init signing keys: %w", err)
+	}
+	if signingKeyService.Enabled() {
+		if err := signingKeyService.Refresh(context.Background()); err != nil {
+			return nil, fmt.Errorf("load signing keys: %w", err)
+		}
+		jwtManager.UseKeys(signingKeys, cfg.Auth.JWT.HS256Fallback)
+	}
 	qqOAuthService := services.NewQQOAuthService(
 		cfg.Auth.QQ.Enabled,
 		cfg.Auth.QQ.AppID,
@@ -81,35 +198,148 @@ func New(cfg *config.Config) (*App, error) {
 		cfg.Auth.WeChat.Secret,
 	)
 
+	mfaService, err := services.NewMFAService(mfaRepo, jwtManager, limiter, services.MFAOptions{
+		Issuer:        cfg.Auth.MFA.Issuer,
+		RequiredRoles: cfg.Auth.MFA.RequiredRoles,
+		ChallengeTTL:  cfg.Auth.MFA.ChallengeTTL,
+		EncryptionKey: cfg.Auth.MFA.EncryptionKey,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("init mfa: %w", err)
+	}
+
+	loginProtection := services.LoginProtection{
+		MaxFailures: cfg.RateLimit.Login.MaxFailures,
+		Window:      cfg.RateLimit.Login.Window,
+		Lockout:     cfg.RateLimit.Login.Lockout,
+		DelayAfter:  cfg.RateLimit.Login.DelayAfter,
+		BaseDelay:   cfg.RateLimit.Login.BaseDelay,
+		MaxDelay:    cfg.RateLimit.Login.MaxDelay,
+		PerIP:       cfg.RateLimit.Login.PerIP,
+	}
+	passwordGuard := services.NewPasswordGuard(userRepo, accountMailer, loginProtection)
+
 	authService := services.NewAuthService(
 		userRepo,
 		jwtManager,
 		refreshRepo,
 		smsCodeRepo,
+		smsSender,
+		limiter,
 		qqOAuthService,
 		wechatOAuthService,
+		webhookService,
+		accountMailer,
+		mfaService,
 		services.AuthServiceOptions{
-			RefreshTTL: cfg.Auth.RefreshTokenTTL,
-			SMSCodeTTL: cfg.Auth.SMS.CodeTTL,
-			SMSEnabled: cfg.Auth.SMS.Enabled,
-			SMSDevMode: cfg.Auth.SMS.DevMode,
-			AdminEmail: cfg.Admin.Email,
+			RefreshTTL:       cfg.Auth.RefreshTokenTTL,
+			RefreshSecretKey: cfg.Auth.RefreshSecretKey,
+			SMSCodeTTL:       cfg.Auth.SMS.CodeTTL,
+			SMSEnabled:       cfg.Auth.SMS.Enabled,
+			SMSDevMode:       cfg.Auth.SMS.DevMode,
+			SMSLimits:        codeLimits,
+			Login:            loginProtection,
+			AdminEmail:       cfg.Admin.Email,
+			PasswordPolicy:   passwordPolicy,
+		},
+	)
+	passwordService := services.NewPasswordService(
+		userRepo,
+		refreshRepo,
+		apiTokenRepo,
+		passwordResetRepo,
+		accountMailer,
+		passwordGuard,
+		limiter,
+		codeLimits,
+		passwordPolicy,
+		emailCfg.FrontendBaseURL,
+	)
+	emailChangeService := services.NewEmailChangeService(
+		userRepo,
+		emailChangeRepo,
+		refreshRepo,
+		apiTokenRepo,
+		accountMailer,
+		passwordGuard,
+		limiter,
+		codeLimits,
+		passwordPolicy,
+		emailCfg.FrontendBaseURL,
+		cfg.Campus.EmailDomains,
+	)
+	imageLimits := imaging.Limits{Formats: cfg.Upload.ImageFormats, MaxPixels: cfg.Upload.MaxImagePixels}
+	sessionService := services.NewSessionService(refreshRepo)
+	profileService := services.NewProfileService(userRepo, storageProvider, imageLimits)
+	apiTokenService := services.NewAPITokenService(apiTokenRepo, userRepo)
+	accountService := services.NewAccountService(
+		userRepo,
+		reviewRepo,
+		reviewReactionRepo,
+		refreshRepo,
+		apiTokenRepo,
+		passwordGuard,
+		storageProvider,
+		limiter,
+		services.AccountOptions{
+			GracePeriod:   cfg.Account.DeletionGracePeriod,
+			ContentPolicy: cfg.Account.DeletionContentPolicy,
+		},
+	)
+	identityService := services.NewIdentityService(
+		userRepo,
+		smsCodeRepo,
+		limiter,
+		qqOAuthService,
+		wechatOAuthService,
+		emailVerificationService,
+		services.IdentityOptions{
+			SMSEnabled:     cfg.Auth.SMS.Enabled,
+			SMSLimits:      codeLimits,
+			PasswordPolicy: passwordPolicy,
 		},
 	)
-	reviewService := services.NewReviewService(reviewRepo, storageProvider)
+	reviewService := services.NewReviewService(reviewRepo, storageProvider, webhookService, services.ReviewOptions{
+		RequireCampusVerified: cfg.Campus.RequireForReviews,
+		ImageLimits:           imageLimits,
+		MaxImages:             cfg.Upload.MaxImagesPerReview,
+	})
 	reviewStatsService := services.NewReviewStatsService(reviewStatsRepo, reviewReactionRepo, siteStatsRepo)
 
-	authHandler := handlers.NewAuthHandler(authService, emailVerificationService)
-	userHandler := handlers.NewUserHandler(userRepo)
+	refreshCookies := handlers.NewRefreshCookies(handlers.RefreshCookieOptions{
+		Enabled:  cfg.Auth.RefreshCookie.Enabled,
+		Name:     cfg.Auth.RefreshCookie.Name,
+		Domain:   cfg.Auth.RefreshCookie.Domain,
+		Secure:   cfg.Auth.RefreshCookie.Secure,
+		SameSite: cookieSameSite(cfg.Auth.RefreshCookie.SameSite),
+		MaxAge:   cfg.Auth.RefreshTokenTTL,
+	})
+	authHandler := handlers.NewAuthHandler(authService, emailVerificationService, refreshCookies)
+	userHandler := handlers.NewUserHandler(userRepo, profileService)
+	passwordHandler := handlers.NewPasswordHandler(passwordService)
+	emailChangeHandler := handlers.NewEmailChangeHandler(emailChangeService)
+	accountHandler := handlers.NewAccountHandler(accountService)
+	sessionHandler := handlers.NewSessionHandler(sessionService)
+	mfaHandler := handlers.NewMFAHandler(authService, mfaService, userRepo, refreshCookies)
+	identityHandler := handlers.NewIdentityHandler(identityService)
+	apiTokenHandler := handlers.NewAPITokenHandler(apiTokenService)
+	jwksHandler := handlers.NewJWKSHandler(signingKeys)
 	reviewHandler := handlers.NewReviewHandler(reviewService)
 	reviewStatsHandler := handlers.NewReviewStatsHandler(reviewStatsService, reviewService)
 	adminReviewHandler := adminHandlers.NewReviewAdminHandler(reviewService)
-	adminUserHandler := adminHandlers.NewUserAdminHandler(userRepo)
+	adminUserHandler := adminHandlers.NewUserAdminHandler(userRepo, sessionService, identityService, accountService)
+	adminEmailOutboxHandler := adminHandlers.NewEmailOutboxAdminHandler(emailOutboxService)
+	adminWebhookHandler := adminHandlers.NewWebhookAdminHandler(webhookService)
 	emailVerificationHandler := handlers.NewEmailVerificationHandler(emailVerificationService)
+	captchaHandler := handlers.NewCaptchaHandler(captchaGuard)
 
-	authMiddleware := middleware.NewAuthMiddleware(jwtManager, userRepo)
+	authMiddleware := middleware.NewAuthMiddleware(jwtManager, userRepo, apiTokenRepo)
 
 	engine := gin.New()
+	// Per-IP limits key on c.ClientIP(), which must not take X-Forwarded-For from arbitrary clients.
+	if err := engine.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("set trusted proxies: %w", err)
+	}
 	engine.Use(
 		middleware.RequestID(),
 		middleware.StructuredLogger(logger),
@@ -122,29 +352,69 @@ func New(cfg *config.Config) (*App, error) {
 		staticUploads = ""
 	}
 
+	codeRequestLimit := middleware.RateLimitByIP(limiter, ratelimit.Rule{
+		Name:   "code_ip",
+		Limit:  cfg.RateLimit.CodePerIP,
+		Window: cfg.RateLimit.CodeWindow,
+	})
+
+	requireCaptcha := func(endpoint string) gin.HandlerFunc {
+		return middleware.RequireCaptcha(captchaGuard, endpoint)
+	}
+
 	router.Register(router.Params{
 		Engine:                   engine,
 		AuthMiddleware:           authMiddleware,
 		AuthHandler:              authHandler,
 		UserHandler:              userHandler,
+		PasswordHandler:          passwordHandler,
+		SessionHandler:           sessionHandler,
+		MFAHandler:               mfaHandler,
+		IdentityHandler:          identityHandler,
+		EmailChangeHandler:       emailChangeHandler,
+		AccountHandler:           accountHandler,
+		APITokenHandler:          apiTokenHandler,
+		JWKSHandler:              jwksHandler,
 		ReviewHandler:            reviewHandler,
 		ReviewStatsHandler:       reviewStatsHandler,
 		EmailVerificationHandler: emailVerificationHandler,
 		AdminHandler:             adminReviewHandler,
 		AdminUserHandler:         adminUserHandler,
+		AdminEmailOutboxHandler:  adminEmailOutboxHandler,
+		AdminWebhookHandler:      adminWebhookHandler,
+		CaptchaHandler:           captchaHandler,
+		CodeRequestLimit:         codeRequestLimit,
+		Captcha:                  requireCaptcha,
 		StaticUploadDir:          staticUploads,
 	})
 
 	return &App{
-		server: backendserver.New(cfg, engine, logger),
+		server:      backendserver.New(cfg, engine, logger),
+		emailOutbox: emailOutboxService,
+		webhooks:    webhookService,
+		signingKeys: signingKeyService,
+		accounts:    accountService,
 	}, nil
 }
 
-// Run starts the backend server and blocks until shutdown completes.
+// Run starts the background workers and the backend server, blocking until shutdown completes.
 func (a *App) Run(ctx context.Context) error {
+	go a.emailOutbox.Run(ctx)
+	go a.webhooks.Run(ctx)
+	go a.signingKeys.Run(ctx)
+	go a.accounts.Run(ctx)
 	return a.server.Run(ctx)
 }
 
+func newRateLimitStore(cfg *config.Config) (ratelimit.Store, error) {
+	switch cfg.RateLimit.Store {
+	case "memory", "":
+		return ratelimit.NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unsupported rate limit store: %s", cfg.RateLimit.Store)
+	}
+}
+
 func buildCORSConfig(cfg *config.Config) cors.Config {
 	allowedOrigins := cfg.CORS.AllowOrigins
 	if len(allowedOrigins) == 0 {
@@ -160,8 +430,19 @@ func buildCORSConfig(cfg *config.Config) cors.Config {
 	return cors.Config{
 		AllowOrigins:     allowedOrigins,
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "X-Request-ID"},
-		ExposeHeaders:    []string{"Content-Length", "X-Request-ID"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "X-Request-ID", "X-Device-Name", handlers.RefreshTokenModeHeader, handlers.CSRFTokenHeader, middleware.CaptchaTokenHeader},
+		ExposeHeaders:    []string{"Content-Length", "X-Request-ID", "Retry-After"},
 		AllowCredentials: true,
 	}
 }
+
+func cookieSameSite(mode string) http.SameSite {
+	switch mode {
+	case "lax":
+		return http.SameSiteLaxMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteStrictMode
+	}
+}
@@ -1,6 +1,9 @@
 package auth
 
 import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -9,29 +12,48 @@ import (
 )
 
 // Claims represents JWT payload containing user identity and role.
+// SessionID is the refresh token the access token was issued with.
 type Claims struct {
-	UserID string `json:"user_id"`
-	Role   string `json:"role"`
+	UserID    string `json:"user_id"`
+	Role      string `json:"role"`
+	SessionID string `json:"sid,omitempty"`
 	jwt.RegisteredClaims
 }
 
 // JWTManager handles generation and validation of JWT tokens.
+// Access tokens are signed with the active key of keys (RS256 or EdDSA, identified by the kid header)
+// and fall back to HS256 with secret while no key is available.
 type JWTManager struct {
-	secret []byte
-	ttl    time.Duration
+	secret     []byte
+	ttl        time.Duration
+	keys       *KeySet
+	allowHS256 bool
 }
 
-// NewJWTManager constructs a token manager.
+// NewJWTManager constructs a token manager that signs with HS256 until UseKeys is called.
 func NewJWTManager(secret string, ttl time.Duration) *JWTManager {
-	return &JWTManager{secret: []byte(secret), ttl: ttl}
+	return &JWTManager{secret: []byte(secret), ttl: ttl, allowHS256: true}
 }
 
-// Generate issues a signed JWT for the provided user.
-func (m *JWTManager) Generate(user *models.User) (string, error) {
+// UseKeys switches signing to the asymmetric keys in keys. allowHS256 keeps accepting tokens signed
+// with the shared secret, which lets sessions issued before the switch continue until they expire.
+func (m *JWTManager) UseKeys(keys *KeySet, allowHS256 bool) {
+	m.keys = keys
+	m.allowHS256 = allowHS256
+}
+
+// TTL returns the lifetime of access tokens.
+func (m *JWTManager) TTL() time.Duration {
+	return m.ttl
+}
+
+// Generate issues a signed JWT for the provided user within the given session.
+func (m *JWTManager) Generate(user *models.User, sessionID string) (string, error) {
 	now := time.Now()
 	claims := Claims{
-		UserID: user.ID.String(),
-		Role:   user.Role,
+		UserID:    user.ID.String(),
+		Role:      user.Role,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
 			Subject:   user.ID.String(),
 			ExpiresAt: jwt.NewNumericDate(now.Add(m.ttl)),
@@ -39,15 +61,20 @@ func (m *JWTManager) Generate(user *models.User) (string, error) {
 		},
 	}
 
+	if key := m.keys.Signing(); key != nil {
+		token := jwt.NewWithClaims(signingMethod(key.Algorithm), claims)
+		token.Header["kid"] = key.ID
+		return token.SignedString(key.Private)
+	}
+
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString(m.secret)
 }
 
 // Parse validates a token string and returns claims.
 func (m *JWTManager) Parse(tokenStr string) (*Claims, error) {
-	token, err := jwt.ParseWithClaims(tokenStr, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return m.secret, nil
-	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	token, err := jwt.ParseWithClaims(tokenStr, &Claims{}, m.verificationKey,
+		jwt.WithValidMethods([]string{AlgorithmHS256, AlgorithmRS256, AlgorithmEdDSA}))
 	if err != nil {
 		return nil, err
 	}
@@ -60,3 +87,79 @@ func (m *JWTManager) Parse(tokenStr string) (*Claims, error) {
 	}
 	return nil, jwt.ErrTokenInvalidClaims
 }
+
+// verificationKey selects the key for a token by its algorithm and kid header.
+func (m *JWTManager) verificationKey(token *jwt.Token) (interface{}, error) {
+	if token.Method.Alg() == AlgorithmHS256 {
+		if !m.allowHS256 && m.keys.Signing() != nil {
+			return nil, errors.New("hs256 tokens are no longer accepted")
+		}
+		return m.secret, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	key := m.keys.Lookup(kid)
+	if key == nil {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if key.Algorithm != token.Method.Alg() {
+		return nil, fmt.Errorf("signing key %q does not use %s", kid, token.Method.Alg())
+	}
+	return key.Public, nil
+}
+
+func signingMethod(algorithm string) jwt.SigningMethod {
+	if algorithm == AlgorithmEdDSA {
+		return jwt.SigningMethodEdDSA
+	}
+	return jwt.SigningMethodRS256
+}
+
+// challengePurpose marks tokens issued between the password step and the second factor.
+const challengePurpose = "mfa"
+
+// ChallengeClaims identifies a user who passed the first login step and still owes a second factor.
+type ChallengeClaims struct {
+	UserID  string `json:"user_id"`
+	Purpose string `json:"purpose"`
+	jwt.RegisteredClaims
+}
+
+// GenerateChallenge issues a short-lived MFA challenge token for userID. It is signed with a key derived
+// from the access token secret, so it can never be accepted as an access token and vice versa.
+func (m *JWTManager) GenerateChallenge(userID uuid.UUID, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims := ChallengeClaims{
+		UserID:  userID.String(),
+		Purpose: challengePurpose,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   userID.String(),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(m.challengeKey())
+}
+
+// ParseChallenge validates an MFA challenge token and returns the user it was issued for.
+func (m *JWTManager) ParseChallenge(tokenStr string) (uuid.UUID, error) {
+	token, err := jwt.ParseWithClaims(tokenStr, &ChallengeClaims{}, func(token *jwt.Token) (interface{}, error) {
+		return m.challengeKey(), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	claims, ok := token.Claims.(*ChallengeClaims)
+	if !ok || !token.Valid || claims.Purpose != challengePurpose {
+		return uuid.Nil, jwt.ErrTokenInvalidClaims
+	}
+	return uuid.Parse(claims.UserID)
+}
+
+func (m *JWTManager) challengeKey() []byte {
+	sum := sha256.Sum256(append(append([]byte{}, m.secret...), ":mfa"...))
+	return sum[:]
+}
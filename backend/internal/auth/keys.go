@@ -0,0 +1,234 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Asymmetric signing algorithms supported for access tokens.
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+const rsaKeyBits = 2048
+
+// JWKSMaxAge is how long clients may cache the published key set.
+const JWKSMaxAge = 5 * time.Minute
+
+// SigningKey is one asymmetric key pair identified by the kid header of the tokens it signs.
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	Private   crypto.Signer
+	Public    crypto.PublicKey
+	CreatedAt time.Time
+}
+
+// GenerateKey creates a new key pair for algorithm with a random kid.
+func GenerateKey(algorithm string) (*SigningKey, error) {
+	var private crypto.Signer
+	switch algorithm {
+	case AlgorithmRS256:
+		key, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+		if err != nil {
+			return nil, err
+		}
+		private = key
+	case AlgorithmEdDSA:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		private = key
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	return &SigningKey{
+		ID:        base64.RawURLEncoding.EncodeToString(id),
+		Algorithm: algorithm,
+		Private:   private,
+		Public:    private.Public(),
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+// ParsePrivateKeyPEM reads a PKCS#8 (or PKCS#1 RSA) private key. The algorithm follows the key type.
+func ParsePrivateKeyPEM(id string, data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var parsed any
+	var err error
+	if block.Type == "RSA PRIVATE KEY" {
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &SigningKey{ID: id}
+	switch private := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Algorithm = AlgorithmRS256
+		key.Private = private
+	case ed25519.PrivateKey:
+		key.Algorithm = AlgorithmEdDSA
+		key.Private = private
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", parsed)
+	}
+	key.Public = key.Private.Public()
+	return key, nil
+}
+
+// MarshalPrivateKeyPEM encodes the private key as PKCS#8 PEM.
+func (k *SigningKey) MarshalPrivateKeyPEM() ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(k.Private)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// LoadKeyDir reads every *.pem private key in dir, using the file name without extension as kid.
+// Keys are ordered by file name, so the last one is the newest and signs new tokens.
+func LoadKeyDir(dir string) ([]*SigningKey, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	keys := make([]*SigningKey, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		id := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		key, err := ParsePrivateKeyPEM(id, data)
+		if err != nil {
+			return nil, fmt.Errorf("load signing key %s: %w", path, err)
+		}
+		if info, err := os.Stat(path); err == nil {
+			key.CreatedAt = info.ModTime()
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// KeySet holds the key that signs new access tokens and every key still accepted for verification.
+// It is safe for concurrent use and may be swapped while the server runs.
+type KeySet struct {
+	mu      sync.RWMutex
+	signing *SigningKey
+	verify  map[string]*SigningKey
+}
+
+// NewKeySet returns an empty key set; until keys are added, tokens are signed with HS256.
+func NewKeySet() *KeySet {
+	return &KeySet{verify: map[string]*SigningKey{}}
+}
+
+// Replace installs signing as the active key and verify (which should include signing) as accepted keys.
+func (s *KeySet) Replace(signing *SigningKey, verify []*SigningKey) {
+	keys := make(map[string]*SigningKey, len(verify)+1)
+	for _, key := range verify {
+		keys[key.ID] = key
+	}
+	if signing != nil {
+		keys[signing.ID] = signing
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.signing = signing
+	s.verify = keys
+}
+
+// Signing returns the active key, or nil when none is configured.
+func (s *KeySet) Signing() *SigningKey {
+	if s == nil {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.signing
+}
+
+// Lookup returns the verification key with the given kid.
+func (s *KeySet) Lookup(id string) *SigningKey {
+	if s == nil {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.verify[id]
+}
+
+// JWK is the public part of a signing key in RFC 7517 form.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+// JWKS returns the public keys accepted for verification, ordered by kid.
+func (s *KeySet) JWKS() []JWK {
+	if s == nil {
+		return []JWK{}
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]JWK, 0, len(s.verify))
+	for _, key := range s.verify {
+		jwk := JWK{KeyID: key.ID, Use: "sig", Algorithm: key.Algorithm}
+		switch public := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+		keys = append(keys, jwk)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].KeyID < keys[j].KeyID })
+	return keys
+}
@@ -0,0 +1,69 @@
+// Package captcha checks that requests to abuse-prone endpoints come from a person, either through a hosted
+// provider (hCaptcha, reCAPTCHA, Cloudflare Turnstile) or a built-in image challenge for offline deployments.
+package captcha
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hdu-dp/backend/internal/config"
+	"github.com/hdu-dp/backend/internal/ratelimit"
+)
+
+const defaultRequestTimeout = 10 * time.Second
+
+// Providers accepted by APP_CAPTCHA_PROVIDER.
+const (
+	ProviderNone      = "none"
+	ProviderBuiltin   = "builtin"
+	ProviderHCaptcha  = "hcaptcha"
+	ProviderReCAPTCHA = "recaptcha"
+	ProviderTurnstile = "turnstile"
+)
+
+var (
+	// ErrMissing indicates a captcha was required but no token was sent.
+	ErrMissing = errors.New("captcha token missing")
+	// ErrFailed indicates the token was rejected, expired or already used.
+	ErrFailed = errors.New("captcha verification failed")
+)
+
+// Verifier checks a captcha token produced by the client. It returns ErrFailed for tokens the provider
+// rejects and other errors when the provider could not be asked.
+type Verifier interface {
+	Verify(ctx context.Context, token, remoteIP string) error
+}
+
+// Challenge is an image challenge issued by the built-in verifier. The client shows Image and sends
+// "<ID>:<answer>" as the captcha token.
+type Challenge struct {
+	ID        string    `json:"id"`
+	Image     string    `json:"image"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ChallengeIssuer is implemented by verifiers that create their own challenges.
+type ChallengeIssuer interface {
+	NewChallenge(ctx context.Context) (*Challenge, error)
+}
+
+// New creates the verifier selected by configuration, or nil when captchas are disabled.
+func New(cfg *config.Config, limiter *ratelimit.Limiter) (Verifier, error) {
+	c := cfg.Captcha
+	client := &http.Client{Timeout: defaultRequestTimeout}
+
+	switch strings.ToLower(c.Provider) {
+	case ProviderNone, "":
+		return nil, nil
+	case ProviderBuiltin:
+		return NewBuiltin(c.SecretKey, limiter), nil
+	case ProviderHCaptcha, ProviderReCAPTCHA, ProviderTurnstile:
+		return NewSiteVerify(c.Provider, c.SecretKey, c.VerifyURL, client)
+	default:
+		return nil, fmt.Errorf("unsupported captcha provider: %s", c.Provider)
+	}
+}
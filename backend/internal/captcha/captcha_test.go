@@ -0,0 +1,144 @@
+package captcha
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hdu-dp/backend/internal/ratelimit"
+)
+
+func TestBuiltinVerifiesOnceAndExpires(t *testing.T) {
+	ctx := context.Background()
+	b := NewBuiltin("test-secret", ratelimit.New(ratelimit.NewMemoryStore()))
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	b.now = func() time.Time { return now }
+
+	challenge, err := b.NewChallenge(ctx)
+	if err != nil {
+		t.Fatalf("NewChallenge: %v", err)
+	}
+	if !strings.HasPrefix(challenge.Image, "data:image/png;base64,") {
+		t.Fatalf("image is not a png data url: %.40s", challenge.Image)
+	}
+	if err := b.Verify(ctx, challenge.ID+":WRONG", ""); !errors.Is(err, ErrFailed) {
+		t.Fatalf("wrong answer: got %v, want ErrFailed", err)
+	}
+
+	expiresAt := now.Add(challengeTTL)
+	id := b.challengeID([]byte("0123456789ab"), expiresAt, "K7WAP")
+	if err := b.Verify(ctx, id+": k7wap ", ""); err != nil {
+		t.Fatalf("correct answer: %v", err)
+	}
+	if err := b.Verify(ctx, id+":K7WAP", ""); !errors.Is(err, ErrFailed) {
+		t.Fatalf("replayed answer: got %v, want ErrFailed", err)
+	}
+
+	id = b.challengeID([]byte("ba9876543210"), expiresAt, "K7WAP")
+	now = expiresAt
+	if err := b.Verify(ctx, id+":K7WAP", ""); !errors.Is(err, ErrFailed) {
+		t.Fatalf("expired challenge: got %v, want ErrFailed", err)
+	}
+
+	other := NewBuiltin("other-secret", nil)
+	other.now = b.now
+	if err := other.Verify(ctx, b.challengeID([]byte("0123456789ab"), expiresAt.Add(time.Minute), "K7WAP")+":K7WAP", ""); !errors.Is(err, ErrFailed) {
+		t.Fatalf("challenge from another key: got %v, want ErrFailed", err)
+	}
+	if err := b.Verify(ctx, "", ""); !errors.Is(err, ErrMissing) {
+		t.Fatalf("empty token: got %v, want ErrMissing", err)
+	}
+}
+
+func TestSiteVerifyPostsTokenAndReadsResult(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("parse form: %v", err)
+		}
+		if r.PostForm.Get("secret") != "shh" || r.PostForm.Get("remoteip") != "203.0.113.9" {
+			t.Errorf("unexpected form: %v", r.PostForm)
+		}
+		if r.PostForm.Get("response") == "good" {
+			_, _ = w.Write([]byte(`{"success":true}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"success":false,"error-codes":["invalid-input-response"]}`))
+	}))
+	defer server.Close()
+
+	verifier, err := NewSiteVerify(ProviderTurnstile, "shh", server.URL, server.Client())
+	if err != nil {
+		t.Fatalf("NewSiteVerify: %v", err)
+	}
+	ctx := context.Background()
+	if err := verifier.Verify(ctx, "good", "203.0.113.9"); err != nil {
+		t.Fatalf("good token: %v", err)
+	}
+	if err := verifier.Verify(ctx, "bad", "203.0.113.9"); !errors.Is(err, ErrFailed) {
+		t.Fatalf("bad token: got %v, want ErrFailed", err)
+	}
+	if err := verifier.Verify(ctx, "", "203.0.113.9"); !errors.Is(err, ErrMissing) {
+		t.Fatalf("empty token: got %v, want ErrMissing", err)
+	}
+}
+
+type stubVerifier struct{ token string }
+
+func (s stubVerifier) Verify(_ context.Context, token, _ string) error {
+	switch token {
+	case "":
+		return ErrMissing
+	case s.token:
+		return nil
+	default:
+		return ErrFailed
+	}
+}
+
+func TestGuardRequiresCaptchaAfterThreshold(t *testing.T) {
+	ctx := context.Background()
+	guard := NewGuard(stubVerifier{token: "ok"}, ratelimit.New(ratelimit.NewMemoryStore()), GuardOptions{
+		Provider:          ProviderTurnstile,
+		Endpoints:         []string{EndpointRegister},
+		AdaptiveThreshold: 2,
+		AdaptiveWindow:    time.Hour,
+	})
+
+	if guard.Protects(EndpointSMSCode) {
+		t.Fatal("sms_code is not configured and should not be protected")
+	}
+	if err := guard.Check(ctx, EndpointSMSCode, "198.51.100.1", ""); err != nil {
+		t.Fatalf("unprotected endpoint: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if required, _ := guard.Required(ctx, "198.51.100.1"); required {
+			t.Fatalf("request %d: captcha required before threshold", i+1)
+		}
+		if err := guard.Check(ctx, EndpointRegister, "198.51.100.1", ""); err != nil {
+			t.Fatalf("request %d: %v", i+1, err)
+		}
+	}
+
+	if required, _ := guard.Required(ctx, "198.51.100.1"); !required {
+		t.Fatal("captcha not required after threshold")
+	}
+	if err := guard.Check(ctx, EndpointRegister, "198.51.100.1", ""); !errors.Is(err, ErrMissing) {
+		t.Fatalf("missing token past threshold: got %v, want ErrMissing", err)
+	}
+	if err := guard.Check(ctx, EndpointRegister, "198.51.100.1", "ok"); err != nil {
+		t.Fatalf("solved captcha past threshold: %v", err)
+	}
+	if err := guard.Check(ctx, EndpointRegister, "198.51.100.2", ""); err != nil {
+		t.Fatalf("other ip should not be affected: %v", err)
+	}
+
+	var disabled *Guard
+	if err := disabled.Check(ctx, EndpointRegister, "198.51.100.1", ""); err != nil || disabled.Provider() != ProviderNone {
+		t.Fatalf("nil guard should not require captchas: %v", err)
+	}
+}
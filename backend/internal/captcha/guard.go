@@ -0,0 +1,128 @@
+package captcha
+
+import (
+	"context"
+	"time"
+
+	"github.com/hdu-dp/backend/internal/ratelimit"
+)
+
+// Endpoints accepted by APP_CAPTCHA_ENDPOINTS.
+const (
+	EndpointRegister       = "register"
+	EndpointSMSCode        = "sms_code"
+	EndpointEmailCode      = "email_code"
+	EndpointForgotPassword = "forgot_password"
+)
+
+// GuardOptions configures a Guard.
+type GuardOptions struct {
+	Provider  string
+	SiteKey   string
+	Endpoints []string
+	// AdaptiveThreshold is how many protected requests an IP may make within AdaptiveWindow before it has
+	// to solve a captcha. Zero requires a captcha on every protected request.
+	AdaptiveThreshold int
+	AdaptiveWindow    time.Duration
+}
+
+func (o GuardOptions) withDefaults() GuardOptions {
+	if o.AdaptiveWindow <= 0 {
+		o.AdaptiveWindow = time.Hour
+	}
+	return o
+}
+
+// Guard decides when a request needs a captcha and checks it. A nil Guard, or one without a verifier,
+// never asks for one.
+type Guard struct {
+	verifier  Verifier
+	limiter   *ratelimit.Limiter
+	endpoints map[string]bool
+	opts      GuardOptions
+}
+
+// NewGuard creates a Guard. verifier may be nil to disable captchas.
+func NewGuard(verifier Verifier, limiter *ratelimit.Limiter, opts GuardOptions) *Guard {
+	opts = opts.withDefaults()
+	endpoints := make(map[string]bool, len(opts.Endpoints))
+	for _, endpoint := range opts.Endpoints {
+		endpoints[endpoint] = true
+	}
+	return &Guard{verifier: verifier, limiter: limiter, endpoints: endpoints, opts: opts}
+}
+
+// Enabled reports whether a verifier is configured.
+func (g *Guard) Enabled() bool {
+	return g != nil && g.verifier != nil
+}
+
+// Provider returns the configured provider name, or ProviderNone.
+func (g *Guard) Provider() string {
+	if !g.Enabled() {
+		return ProviderNone
+	}
+	return g.opts.Provider
+}
+
+// SiteKey returns the public key the client widget needs for hosted providers.
+func (g *Guard) SiteKey() string {
+	if !g.Enabled() {
+		return ""
+	}
+	return g.opts.SiteKey
+}
+
+// Protects reports whether requests to endpoint may need a captcha.
+func (g *Guard) Protects(endpoint string) bool {
+	return g.Enabled() && g.endpoints[endpoint]
+}
+
+// Required reports whether the next protected request from ip needs a captcha.
+func (g *Guard) Required(ctx context.Context, ip string) (bool, error) {
+	if !g.Enabled() || len(g.endpoints) == 0 {
+		return false, nil
+	}
+	if g.opts.AdaptiveThreshold == 0 {
+		return true, nil
+	}
+	count, _, err := g.limiter.Failures(ctx, volumeKey(ip))
+	if err != nil {
+		return false, err
+	}
+	return count >= g.opts.AdaptiveThreshold, nil
+}
+
+// Check counts a request to endpoint from ip and, once the IP is past the adaptive threshold, verifies token.
+// It returns ErrMissing or ErrFailed when the captcha is needed and not solved.
+func (g *Guard) Check(ctx context.Context, endpoint, ip, token string) error {
+	if !g.Protects(endpoint) {
+		return nil
+	}
+	if g.opts.AdaptiveThreshold > 0 {
+		count, err := g.limiter.RecordFailure(ctx, volumeKey(ip), g.opts.AdaptiveWindow)
+		if err != nil {
+			return err
+		}
+		if count <= g.opts.AdaptiveThreshold {
+			return nil
+		}
+	}
+	return g.verifier.Verify(ctx, token, ip)
+}
+
+// NewChallenge issues a challenge when the verifier creates its own, and returns nil otherwise.
+func (g *Guard) NewChallenge(ctx context.Context) (*Challenge, error) {
+	if !g.Enabled() {
+		return nil, nil
+	}
+	issuer, ok := g.verifier.(ChallengeIssuer)
+	if !ok {
+		return nil, nil
+	}
+	return issuer.NewChallenge(ctx)
+}
+
+func volumeKey(ip string) string {
+	return "captcha_volume:" + ip
+}
@@ -7,12 +7,18 @@ var (
 	ErrEmailAlreadyUsed = errors.New("email already in use")
 	// ErrInvalidCredentials indicates login failure.
 	ErrInvalidCredentials = errors.New("invalid email or password")
+	// ErrAccountLocked indicates password login is blocked after repeated failures.
+	ErrAccountLocked = errors.New("account temporarily locked")
 	// ErrInvalidPhoneNumber indicates an invalid phone format.
 	ErrInvalidPhoneNumber = errors.New("invalid phone number")
 	// ErrInvalidSMSCode indicates sms verification failure.
 	ErrInvalidSMSCode = errors.New("invalid sms code")
 	// ErrSMSServiceUnavailable indicates sms auth is disabled or not configured.
 	ErrSMSServiceUnavailable = errors.New("sms login unavailable")
+	// ErrTooManyVerificationAttempts indicates a one-time code was discarded after repeated wrong guesses.
+	ErrTooManyVerificationAttempts = errors.New("too many verification attempts")
+	// ErrSMSDeliveryFailed indicates the sms provider rejected or failed to send the code.
+	ErrSMSDeliveryFailed = errors.New("sms delivery failed")
 	// ErrQQServiceUnavailable indicates QQ OAuth is disabled or not configured.
 	ErrQQServiceUnavailable = errors.New("qq login unavailable")
 	// ErrInvalidQQState indicates the QQ OAuth state is invalid.
@@ -1,7 +1,10 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"net"
 	"strings"
 	"time"
 
@@ -18,6 +21,9 @@ type Config struct {
 		WriteTimeout      time.Duration
 		IdleTimeout       time.Duration
 		ShutdownTimeout   time.Duration
+		// TrustedProxies lists the proxy IPs or CIDRs whose X-Forwarded-For header is believed when
+		// resolving the client IP. Empty trusts none, so the client IP is the connection's peer address.
+		TrustedProxies []string
 	}
 	Log struct {
 		Level  string
@@ -31,7 +37,21 @@ type Config struct {
 		JWTSecret       string
 		AccessTokenTTL  time.Duration
 		RefreshTokenTTL time.Duration
-		QQ              struct {
+		JWT             struct {
+			Algorithm        string
+			KeysDir          string
+			RotationInterval time.Duration
+			HS256Fallback    bool
+			KeyEncryptionKey string
+		}
+		RefreshCookie struct {
+			Enabled  bool
+			Name     string
+			Domain   string
+			Secure   bool
+			SameSite string
+		}
+		QQ struct {
 			Enabled     bool
 			AppID       string
 			AppSecret   string
@@ -43,10 +63,50 @@ type Config struct {
 			Secret  string
 		}
 		SMS struct {
-			Enabled bool
-			CodeTTL time.Duration
-			DevMode bool
+			Enabled    bool
+			CodeTTL    time.Duration
+			DevMode    bool
+			Provider   string
+			SignName   string
+			TemplateID string
+			Aliyun     struct {
+				AccessKeyID     string
+				AccessKeySecret string
+				RegionID        string
+				Endpoint        string
+			}
+			Tencent struct {
+				SecretID  string
+				SecretKey string
+				SDKAppID  string
+				Region    string
+				Endpoint  string
+			}
+			HTTP struct {
+				URL   string
+				Token string
+			}
+		}
+		MFA struct {
+			Issuer        string
+			RequiredRoles []string
+			ChallengeTTL  time.Duration
+			EncryptionKey string
 		}
+		Password struct {
+			Algorithm         string
+			Argon2Memory      uint32
+			Argon2Iterations  uint32
+			Argon2Parallelism uint8
+			BcryptCost        int
+			MinLength         int
+			MaxLength         int
+			MinClasses        int
+			RejectPersonal    bool
+			BreachedCheck     bool
+			BreachedListFile  string
+		}
+		RefreshSecretKey string
 	}
 	Storage struct {
 		Provider      string
@@ -62,6 +122,13 @@ type Config struct {
 			BaseURL   string
 		}
 	}
+	Upload struct {
+		// ImageFormats lists the accepted image formats: jpeg, png, gif and webp.
+		ImageFormats   []string
+		MaxImagePixels int
+		// MaxImagesPerReview caps how many images one review may carry.
+		MaxImagesPerReview int
+	}
 	Admin struct {
 		Email    string
 		Password string
@@ -69,6 +136,49 @@ type Config struct {
 	CORS struct {
 		AllowOrigins []string
 	}
+	RateLimit struct {
+		Store           string
+		CodeCooldown    time.Duration
+		CodePerTarget   int
+		CodePerIP       int
+		CodeWindow      time.Duration
+		MaxCodeAttempts int
+		Login           struct {
+			MaxFailures int
+			Window      time.Duration
+			Lockout     time.Duration
+			DelayAfter  int
+			BaseDelay   time.Duration
+			MaxDelay    time.Duration
+			PerIP       int
+		}
+	}
+	Webhook struct {
+		MaxAttempts int
+		Timeout     time.Duration
+		BaseBackoff time.Duration
+		MaxBackoff  time.Duration
+		Retention   time.Duration
+	}
+	Campus struct {
+		EmailDomains      []string
+		RequireForReviews bool
+	}
+	Account struct {
+		DeletionGracePeriod   time.Duration
+		DeletionContentPolicy string
+	}
+	Captcha struct {
+		Provider  string
+		SiteKey   string
+		SecretKey string
+		VerifyURL string
+		Endpoints []string
+		// AdaptiveThreshold is how many protected requests an IP may make within AdaptiveWindow before a
+		// captcha is required. Zero requires it on every request.
+		AdaptiveThreshold int
+		AdaptiveWindow    time.Duration
+	}
 }
 
 // Load reads configuration from environment variables with sane defaults.
@@ -84,6 +194,7 @@ func Load() (*Config, error) {
 	v.SetDefault("SERVER_WRITE_TIMEOUT", "30s")
 	v.SetDefault("SERVER_IDLE_TIMEOUT", "60s")
 	v.SetDefault("SERVER_SHUTDOWN_TIMEOUT", "10s")
+	v.SetDefault("SERVER_TRUSTED_PROXIES", "")
 
 	v.SetDefault("LOG_LEVEL", "info")
 	v.SetDefault("LOG_FORMAT", "text")
@@ -93,12 +204,33 @@ func Load() (*Config, error) {
 
 	v.SetDefault("AUTH_ACCESS_TOKEN_TTL", "15m")
 	v.SetDefault("AUTH_REFRESH_TOKEN_TTL", "168h")
+	v.SetDefault("AUTH_JWT_ALGORITHM", "HS256")
+	v.SetDefault("AUTH_JWT_ROTATION_INTERVAL", "720h")
+	v.SetDefault("AUTH_JWT_HS256_FALLBACK", true)
+	v.SetDefault("AUTH_REFRESH_COOKIE_ENABLED", false)
+	v.SetDefault("AUTH_REFRESH_COOKIE_NAME", "refresh_token")
+	v.SetDefault("AUTH_REFRESH_COOKIE_SECURE", true)
+	v.SetDefault("AUTH_REFRESH_COOKIE_SAMESITE", "strict")
 	v.SetDefault("AUTH_QQ_ENABLED", false)
 	v.SetDefault("AUTH_QQ_REDIRECT_URI", "http://localhost:5173/login")
 	v.SetDefault("AUTH_WECHAT_ENABLED", false)
 	v.SetDefault("AUTH_SMS_ENABLED", false)
 	v.SetDefault("AUTH_SMS_CODE_TTL", "10m")
-	v.SetDefault("AUTH_SMS_DEV_MODE", true)
+	v.SetDefault("AUTH_MFA_ISSUER", "杭电点评")
+	v.SetDefault("AUTH_MFA_REQUIRED_ROLES", "")
+	v.SetDefault("AUTH_MFA_CHALLENGE_TTL", "5m")
+	v.SetDefault("AUTH_PASSWORD_HASH_ALGORITHM", "argon2id")
+	v.SetDefault("AUTH_PASSWORD_ARGON2_MEMORY", 19456)
+	v.SetDefault("AUTH_PASSWORD_ARGON2_ITERATIONS", 2)
+	v.SetDefault("AUTH_PASSWORD_ARGON2_PARALLELISM", 1)
+	v.SetDefault("AUTH_PASSWORD_BCRYPT_COST", 10)
+	v.SetDefault("AUTH_PASSWORD_MIN_LENGTH", 8)
+	v.SetDefault("AUTH_PASSWORD_MAX_LENGTH", 128)
+	v.SetDefault("AUTH_PASSWORD_MIN_CLASSES", 2)
+	v.SetDefault("AUTH_PASSWORD_REJECT_PERSONAL_INFO", true)
+	v.SetDefault("AUTH_PASSWORD_BREACHED_CHECK", true)
+	v.SetDefault("AUTH_SMS_DEV_MODE", false)
+	v.SetDefault("AUTH_SMS_PROVIDER", "log")
 
 	v.SetDefault("STORAGE_PROVIDER", "local")
 	v.SetDefault("STORAGE_UPLOAD_DIR", "uploads")
@@ -111,6 +243,42 @@ func Load() (*Config, error) {
 	v.SetDefault("STORAGE_S3_SECRET_KEY", "")
 	v.SetDefault("STORAGE_S3_USE_SSL", true)
 	v.SetDefault("STORAGE_S3_BASE_URL", "")
+
+	v.SetDefault("UPLOAD_IMAGE_FORMATS", "jpeg,png,gif,webp")
+	v.SetDefault("UPLOAD_MAX_IMAGE_PIXELS", 40_000_000)
+	v.SetDefault("UPLOAD_MAX_IMAGES_PER_REVIEW", 9)
+
+	v.SetDefault("RATE_LIMIT_STORE", "memory")
+	v.SetDefault("RATE_LIMIT_CODE_COOLDOWN", "60s")
+	v.SetDefault("RATE_LIMIT_CODE_PER_TARGET", 5)
+	v.SetDefault("RATE_LIMIT_CODE_PER_IP", 20)
+	v.SetDefault("RATE_LIMIT_CODE_WINDOW", "1h")
+	v.SetDefault("RATE_LIMIT_MAX_CODE_ATTEMPTS", 5)
+	v.SetDefault("RATE_LIMIT_LOGIN_MAX_FAILURES", 10)
+	v.SetDefault("RATE_LIMIT_LOGIN_WINDOW", "15m")
+	v.SetDefault("RATE_LIMIT_LOGIN_LOCKOUT", "30m")
+	v.SetDefault("RATE_LIMIT_LOGIN_DELAY_AFTER", 3)
+	v.SetDefault("RATE_LIMIT_LOGIN_BASE_DELAY", "1s")
+	v.SetDefault("RATE_LIMIT_LOGIN_MAX_DELAY", "30s")
+	v.SetDefault("RATE_LIMIT_LOGIN_PER_IP", 50)
+
+	v.SetDefault("WEBHOOK_MAX_ATTEMPTS", 8)
+	v.SetDefault("WEBHOOK_TIMEOUT", "10s")
+	v.SetDefault("WEBHOOK_BASE_BACKOFF", "30s")
+	v.SetDefault("WEBHOOK_MAX_BACKOFF", "6h")
+	v.SetDefault("WEBHOOK_RETENTION", "720h")
+
+	v.SetDefault("CAMPUS_EMAIL_DOMAINS", "hdu.edu.cn")
+	v.SetDefault("CAMPUS_REQUIRE_FOR_REVIEWS", false)
+
+	v.SetDefault("ACCOUNT_DELETION_GRACE_PERIOD", "168h")
+	v.SetDefault("ACCOUNT_DELETION_CONTENT_POLICY", "anonymize")
+
+	v.SetDefault("CAPTCHA_PROVIDER", "none")
+	v.SetDefault("CAPTCHA_ENDPOINTS", "register,sms_code")
+	v.SetDefault("CAPTCHA_ADAPTIVE_THRESHOLD", 5)
+	v.SetDefault("CAPTCHA_ADAPTIVE_WINDOW", "1h")
+
 	v.SetDefault("CORS_ALLOW_ORIGINS", "http://localhost:5173,http://localhost:5174,http://127.0.0.1:5173,http://127.0.0.1:5174,https://hddp.blueloaf.top")
 
 	readHeaderTimeout, err := parseDuration(v, "SERVER_READ_HEADER_TIMEOUT")
@@ -153,6 +321,76 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("invalid SMS_CODE ttl: %w", err)
 	}
 
+	jwtRotationInterval, err := parseDuration(v, "AUTH_JWT_ROTATION_INTERVAL")
+	if err != nil {
+		return nil, fmt.Errorf("invalid AUTH_JWT_ROTATION_INTERVAL: %w", err)
+	}
+
+	mfaChallengeTTL, err := parseDuration(v, "AUTH_MFA_CHALLENGE_TTL")
+	if err != nil {
+		return nil, fmt.Errorf("invalid AUTH_MFA_CHALLENGE_TTL: %w", err)
+	}
+
+	codeCooldown, err := parseDuration(v, "RATE_LIMIT_CODE_COOLDOWN")
+	if err != nil {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_CODE_COOLDOWN: %w", err)
+	}
+
+	codeWindow, err := parseDuration(v, "RATE_LIMIT_CODE_WINDOW")
+	if err != nil {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_CODE_WINDOW: %w", err)
+	}
+
+	loginWindow, err := parseDuration(v, "RATE_LIMIT_LOGIN_WINDOW")
+	if err != nil {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_LOGIN_WINDOW: %w", err)
+	}
+
+	loginLockout, err := parseDuration(v, "RATE_LIMIT_LOGIN_LOCKOUT")
+	if err != nil {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_LOGIN_LOCKOUT: %w", err)
+	}
+
+	loginBaseDelay, err := parseDuration(v, "RATE_LIMIT_LOGIN_BASE_DELAY")
+	if err != nil {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_LOGIN_BASE_DELAY: %w", err)
+	}
+
+	loginMaxDelay, err := parseDuration(v, "RATE_LIMIT_LOGIN_MAX_DELAY")
+	if err != nil {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_LOGIN_MAX_DELAY: %w", err)
+	}
+
+	webhookTimeout, err := parseDuration(v, "WEBHOOK_TIMEOUT")
+	if err != nil {
+		return nil, fmt.Errorf("invalid WEBHOOK_TIMEOUT: %w", err)
+	}
+
+	webhookBaseBackoff, err := parseDuration(v, "WEBHOOK_BASE_BACKOFF")
+	if err != nil {
+		return nil, fmt.Errorf("invalid WEBHOOK_BASE_BACKOFF: %w", err)
+	}
+
+	webhookMaxBackoff, err := parseDuration(v, "WEBHOOK_MAX_BACKOFF")
+	if err != nil {
+		return nil, fmt.Errorf("invalid WEBHOOK_MAX_BACKOFF: %w", err)
+	}
+
+	webhookRetention, err := parseDuration(v, "WEBHOOK_RETENTION")
+	if err != nil {
+		return nil, fmt.Errorf("invalid WEBHOOK_RETENTION: %w", err)
+	}
+
+	deletionGracePeriod, err := parseDuration(v, "ACCOUNT_DELETION_GRACE_PERIOD")
+	if err != nil {
+		return nil, fmt.Errorf("invalid ACCOUNT_DELETION_GRACE_PERIOD: %w", err)
+	}
+
+	captchaAdaptiveWindow, err := parseDuration(v, "CAPTCHA_ADAPTIVE_WINDOW")
+	if err != nil {
+		return nil, fmt.Errorf("invalid CAPTCHA_ADAPTIVE_WINDOW: %w", err)
+	}
+
 	cfg := &Config{}
 	cfg.Server.Port = v.GetString("SERVER_PORT")
 	cfg.Server.Mode = v.GetString("SERVER_MODE")
@@ -161,6 +399,7 @@ func Load() (*Config, error) {
 	cfg.Server.WriteTimeout = writeTimeout
 	cfg.Server.IdleTimeout = idleTimeout
 	cfg.Server.ShutdownTimeout = shutdownTimeout
+	cfg.Server.TrustedProxies = splitAndClean(v.GetString("SERVER_TRUSTED_PROXIES"))
 	cfg.Log.Level = strings.TrimSpace(strings.ToLower(v.GetString("LOG_LEVEL")))
 	cfg.Log.Format = strings.TrimSpace(strings.ToLower(v.GetString("LOG_FORMAT")))
 
@@ -170,6 +409,16 @@ func Load() (*Config, error) {
 	cfg.Auth.JWTSecret = v.GetString("AUTH_JWT_SECRET")
 	cfg.Auth.AccessTokenTTL = accessTTL
 	cfg.Auth.RefreshTokenTTL = refreshTTL
+	cfg.Auth.JWT.Algorithm = normalizeJWTAlgorithm(v.GetString("AUTH_JWT_ALGORITHM"))
+	cfg.Auth.JWT.KeysDir = strings.TrimSpace(v.GetString("AUTH_JWT_KEYS_DIR"))
+	cfg.Auth.JWT.RotationInterval = jwtRotationInterval
+	cfg.Auth.JWT.HS256Fallback = v.GetBool("AUTH_JWT_HS256_FALLBACK")
+	cfg.Auth.JWT.KeyEncryptionKey = v.GetString("AUTH_JWT_KEY_ENCRYPTION_KEY")
+	cfg.Auth.RefreshCookie.Enabled = v.GetBool("AUTH_REFRESH_COOKIE_ENABLED")
+	cfg.Auth.RefreshCookie.Name = strings.TrimSpace(v.GetString("AUTH_REFRESH_COOKIE_NAME"))
+	cfg.Auth.RefreshCookie.Domain = strings.TrimSpace(v.GetString("AUTH_REFRESH_COOKIE_DOMAIN"))
+	cfg.Auth.RefreshCookie.Secure = v.GetBool("AUTH_REFRESH_COOKIE_SECURE")
+	cfg.Auth.RefreshCookie.SameSite = strings.TrimSpace(strings.ToLower(v.GetString("AUTH_REFRESH_COOKIE_SAMESITE")))
 	cfg.Auth.QQ.Enabled = v.GetBool("AUTH_QQ_ENABLED")
 	cfg.Auth.QQ.AppID = strings.TrimSpace(v.GetString("AUTH_QQ_APP_ID"))
 	cfg.Auth.QQ.AppSecret = strings.TrimSpace(v.GetString("AUTH_QQ_APP_SECRET"))
@@ -180,6 +429,40 @@ func Load() (*Config, error) {
 	cfg.Auth.SMS.Enabled = v.GetBool("AUTH_SMS_ENABLED")
 	cfg.Auth.SMS.CodeTTL = smsCodeTTL
 	cfg.Auth.SMS.DevMode = v.GetBool("AUTH_SMS_DEV_MODE")
+	cfg.Auth.SMS.Provider = strings.TrimSpace(strings.ToLower(v.GetString("AUTH_SMS_PROVIDER")))
+	cfg.Auth.SMS.SignName = strings.TrimSpace(v.GetString("AUTH_SMS_SIGN_NAME"))
+	cfg.Auth.SMS.TemplateID = strings.TrimSpace(v.GetString("AUTH_SMS_TEMPLATE_ID"))
+	cfg.Auth.SMS.Aliyun.AccessKeyID = strings.TrimSpace(v.GetString("AUTH_SMS_ALIYUN_ACCESS_KEY_ID"))
+	cfg.Auth.SMS.Aliyun.AccessKeySecret = strings.TrimSpace(v.GetString("AUTH_SMS_ALIYUN_ACCESS_KEY_SECRET"))
+	cfg.Auth.SMS.Aliyun.RegionID = strings.TrimSpace(v.GetString("AUTH_SMS_ALIYUN_REGION_ID"))
+	cfg.Auth.SMS.Aliyun.Endpoint = strings.TrimSpace(v.GetString("AUTH_SMS_ALIYUN_ENDPOINT"))
+	cfg.Auth.SMS.Tencent.SecretID = strings.TrimSpace(v.GetString("AUTH_SMS_TENCENT_SECRET_ID"))
+	cfg.Auth.SMS.Tencent.SecretKey = strings.TrimSpace(v.GetString("AUTH_SMS_TENCENT_SECRET_KEY"))
+	cfg.Auth.SMS.Tencent.SDKAppID = strings.TrimSpace(v.GetString("AUTH_SMS_TENCENT_SDK_APP_ID"))
+	cfg.Auth.SMS.Tencent.Region = strings.TrimSpace(v.GetString("AUTH_SMS_TENCENT_REGION"))
+	cfg.Auth.SMS.Tencent.Endpoint = strings.TrimSpace(v.GetString("AUTH_SMS_TENCENT_ENDPOINT"))
+	cfg.Auth.SMS.HTTP.URL = strings.TrimSpace(v.GetString("AUTH_SMS_HTTP_URL"))
+	cfg.Auth.SMS.HTTP.Token = strings.TrimSpace(v.GetString("AUTH_SMS_HTTP_TOKEN"))
+	cfg.Auth.MFA.Issuer = strings.TrimSpace(v.GetString("AUTH_MFA_ISSUER"))
+	cfg.Auth.MFA.RequiredRoles = splitAndClean(v.GetString("AUTH_MFA_REQUIRED_ROLES"))
+	cfg.Auth.MFA.ChallengeTTL = mfaChallengeTTL
+	cfg.Auth.MFA.EncryptionKey = v.GetString("AUTH_MFA_ENCRYPTION_KEY")
+	cfg.Auth.Password.Algorithm = strings.TrimSpace(strings.ToLower(v.GetString("AUTH_PASSWORD_HASH_ALGORITHM")))
+	cfg.Auth.Password.Argon2Memory = v.GetUint32("AUTH_PASSWORD_ARGON2_MEMORY")
+	cfg.Auth.Password.Argon2Iterations = v.GetUint32("AUTH_PASSWORD_ARGON2_ITERATIONS")
+	argon2Parallelism := v.GetInt("AUTH_PASSWORD_ARGON2_PARALLELISM")
+	if argon2Parallelism < 1 || argon2Parallelism > 255 {
+		return nil, fmt.Errorf("APP_AUTH_PASSWORD_ARGON2_PARALLELISM must be between 1 and 255")
+	}
+	cfg.Auth.Password.Argon2Parallelism = uint8(argon2Parallelism)
+	cfg.Auth.Password.BcryptCost = v.GetInt("AUTH_PASSWORD_BCRYPT_COST")
+	cfg.Auth.Password.MinLength = v.GetInt("AUTH_PASSWORD_MIN_LENGTH")
+	cfg.Auth.Password.MaxLength = v.GetInt("AUTH_PASSWORD_MAX_LENGTH")
+	cfg.Auth.Password.MinClasses = v.GetInt("AUTH_PASSWORD_MIN_CLASSES")
+	cfg.Auth.Password.RejectPersonal = v.GetBool("AUTH_PASSWORD_REJECT_PERSONAL_INFO")
+	cfg.Auth.Password.BreachedCheck = v.GetBool("AUTH_PASSWORD_BREACHED_CHECK")
+	cfg.Auth.Password.BreachedListFile = strings.TrimSpace(v.GetString("AUTH_PASSWORD_BREACHED_LIST_FILE"))
+	cfg.Auth.RefreshSecretKey = v.GetString("AUTH_REFRESH_SECRET_KEY")
 
 	cfg.Storage.Provider = v.GetString("STORAGE_PROVIDER")
 	cfg.Storage.UploadDir = v.GetString("STORAGE_UPLOAD_DIR")
@@ -192,13 +475,148 @@ func Load() (*Config, error) {
 	cfg.Storage.S3.UseSSL = v.GetBool("STORAGE_S3_USE_SSL")
 	cfg.Storage.S3.BaseURL = v.GetString("STORAGE_S3_BASE_URL")
 
+	for _, format := range splitAndClean(v.GetString("UPLOAD_IMAGE_FORMATS")) {
+		format = strings.ToLower(format)
+		if format == "jpg" {
+			format = "jpeg"
+		}
+		cfg.Upload.ImageFormats = append(cfg.Upload.ImageFormats, format)
+	}
+	cfg.Upload.MaxImagePixels = v.GetInt("UPLOAD_MAX_IMAGE_PIXELS")
+	cfg.Upload.MaxImagesPerReview = v.GetInt("UPLOAD_MAX_IMAGES_PER_REVIEW")
+
 	cfg.Admin.Email = strings.TrimSpace(strings.ToLower(v.GetString("ADMIN_EMAIL")))
 	cfg.Admin.Password = strings.TrimSpace(v.GetString("ADMIN_PASSWORD"))
 	cfg.CORS.AllowOrigins = splitAndClean(v.GetString("CORS_ALLOW_ORIGINS"))
 
+	cfg.RateLimit.Store = strings.TrimSpace(strings.ToLower(v.GetString("RATE_LIMIT_STORE")))
+	cfg.RateLimit.CodeCooldown = codeCooldown
+	cfg.RateLimit.CodePerTarget = v.GetInt("RATE_LIMIT_CODE_PER_TARGET")
+	cfg.RateLimit.CodePerIP = v.GetInt("RATE_LIMIT_CODE_PER_IP")
+	cfg.RateLimit.CodeWindow = codeWindow
+	cfg.RateLimit.MaxCodeAttempts = v.GetInt("RATE_LIMIT_MAX_CODE_ATTEMPTS")
+	cfg.RateLimit.Login.MaxFailures = v.GetInt("RATE_LIMIT_LOGIN_MAX_FAILURES")
+	cfg.RateLimit.Login.Window = loginWindow
+	cfg.RateLimit.Login.Lockout = loginLockout
+	cfg.RateLimit.Login.DelayAfter = v.GetInt("RATE_LIMIT_LOGIN_DELAY_AFTER")
+	cfg.RateLimit.Login.BaseDelay = loginBaseDelay
+	cfg.RateLimit.Login.MaxDelay = loginMaxDelay
+	cfg.RateLimit.Login.PerIP = v.GetInt("RATE_LIMIT_LOGIN_PER_IP")
+
+	cfg.Webhook.MaxAttempts = v.GetInt("WEBHOOK_MAX_ATTEMPTS")
+	cfg.Webhook.Timeout = webhookTimeout
+	cfg.Webhook.BaseBackoff = webhookBaseBackoff
+	cfg.Webhook.MaxBackoff = webhookMaxBackoff
+	cfg.Webhook.Retention = webhookRetention
+
+	for _, domain := range splitAndClean(v.GetString("CAMPUS_EMAIL_DOMAINS")) {
+		cfg.Campus.EmailDomains = append(cfg.Campus.EmailDomains, strings.ToLower(strings.TrimPrefix(domain, "@")))
+	}
+	cfg.Campus.RequireForReviews = v.GetBool("CAMPUS_REQUIRE_FOR_REVIEWS")
+
+	cfg.Account.DeletionGracePeriod = deletionGracePeriod
+	cfg.Account.DeletionContentPolicy = strings.TrimSpace(strings.ToLower(v.GetString("ACCOUNT_DELETION_CONTENT_POLICY")))
+
+	cfg.Captcha.Provider = strings.TrimSpace(strings.ToLower(v.GetString("CAPTCHA_PROVIDER")))
+	cfg.Captcha.SiteKey = strings.TrimSpace(v.GetString("CAPTCHA_SITE_KEY"))
+	cfg.Captcha.SecretKey = strings.TrimSpace(v.GetString("CAPTCHA_SECRET_KEY"))
+	cfg.Captcha.VerifyURL = strings.TrimSpace(v.GetString("CAPTCHA_VERIFY_URL"))
+	for _, endpoint := range splitAndClean(v.GetString("CAPTCHA_ENDPOINTS")) {
+		cfg.Captcha.Endpoints = append(cfg.Captcha.Endpoints, strings.ToLower(endpoint))
+	}
+	cfg.Captcha.AdaptiveThreshold = v.GetInt("CAPTCHA_ADAPTIVE_THRESHOLD")
+	cfg.Captcha.AdaptiveWindow = captchaAdaptiveWindow
+
+	for _, proxy := range cfg.Server.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			return nil, fmt.Errorf("invalid APP_SERVER_TRUSTED_PROXIES entry %q: use an IP address or CIDR", proxy)
+		}
+	}
+
 	if cfg.Auth.JWTSecret == "" {
 		return nil, fmt.Errorf("missing auth jwt secret: set APP_AUTH_JWT_SECRET")
 	}
+	if cfg.Auth.MFA.EncryptionKey == "" {
+		cfg.Auth.MFA.EncryptionKey = cfg.Auth.JWTSecret
+	}
+	if cfg.Auth.JWT.KeyEncryptionKey == "" {
+		cfg.Auth.JWT.KeyEncryptionKey = cfg.Auth.JWTSecret
+	}
+	if cfg.Auth.RefreshSecretKey == "" {
+		cfg.Auth.RefreshSecretKey = cfg.Auth.JWTSecret
+	}
+	switch cfg.Auth.Password.Algorithm {
+	case "argon2id", "bcrypt":
+	default:
+		return nil, fmt.Errorf("unsupported APP_AUTH_PASSWORD_HASH_ALGORITHM %q: use argon2id or bcrypt", cfg.Auth.Password.Algorithm)
+	}
+	switch cfg.Auth.JWT.Algorithm {
+	case "HS256", "RS256", "EdDSA":
+	default:
+		return nil, fmt.Errorf("unsupported APP_AUTH_JWT_ALGORITHM %q: use HS256, RS256 or EdDSA", cfg.Auth.JWT.Algorithm)
+	}
+
+	switch cfg.Auth.RefreshCookie.SameSite {
+	case "strict", "lax":
+	case "none":
+		if !cfg.Auth.RefreshCookie.Secure {
+			return nil, fmt.Errorf("APP_AUTH_REFRESH_COOKIE_SAMESITE=none requires APP_AUTH_REFRESH_COOKIE_SECURE=true")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported APP_AUTH_REFRESH_COOKIE_SAMESITE %q: use strict, lax or none", cfg.Auth.RefreshCookie.SameSite)
+	}
+
+	if cfg.Campus.RequireForReviews && len(cfg.Campus.EmailDomains) == 0 {
+		return nil, fmt.Errorf("APP_CAMPUS_REQUIRE_FOR_REVIEWS is on but APP_CAMPUS_EMAIL_DOMAINS is empty")
+	}
+
+	switch cfg.Account.DeletionContentPolicy {
+	case "anonymize", "delete":
+	default:
+		return nil, fmt.Errorf("unsupported APP_ACCOUNT_DELETION_CONTENT_POLICY %q: use anonymize or delete", cfg.Account.DeletionContentPolicy)
+	}
+	if cfg.Account.DeletionGracePeriod < 0 {
+		return nil, fmt.Errorf("APP_ACCOUNT_DELETION_GRACE_PERIOD must not be negative")
+	}
+
+	if len(cfg.Upload.ImageFormats) == 0 {
+		return nil, fmt.Errorf("APP_UPLOAD_IMAGE_FORMATS must list at least one format")
+	}
+	for _, format := range cfg.Upload.ImageFormats {
+		switch format {
+		case "jpeg", "png", "gif", "webp":
+		default:
+			return nil, fmt.Errorf("unsupported APP_UPLOAD_IMAGE_FORMATS entry %q: use jpeg, png, gif or webp", format)
+		}
+	}
+	if cfg.Upload.MaxImagePixels <= 0 {
+		return nil, fmt.Errorf("APP_UPLOAD_MAX_IMAGE_PIXELS must be positive")
+	}
+	if cfg.Upload.MaxImagesPerReview <= 0 {
+		return nil, fmt.Errorf("APP_UPLOAD_MAX_IMAGES_PER_REVIEW must be positive")
+	}
+
+	switch cfg.Captcha.Provider {
+	case "none", "hcaptcha", "recaptcha", "turnstile":
+	case "builtin":
+		if cfg.Captcha.SecretKey == "" {
+			// Derived rather than shared, so a captcha signature never doubles as anything signed with the JWT secret.
+			sum := sha256.Sum256([]byte(cfg.Auth.JWTSecret + ":captcha"))
+			cfg.Captcha.SecretKey = hex.EncodeToString(sum[:])
+		}
+	default:
+		return nil, fmt.Errorf("unsupported APP_CAPTCHA_PROVIDER %q: use none, builtin, hcaptcha, recaptcha or turnstile", cfg.Captcha.Provider)
+	}
+	for _, endpoint := range cfg.Captcha.Endpoints {
+		switch endpoint {
+		case "register", "sms_code", "email_code", "forgot_password":
+		default:
+			return nil, fmt.Errorf("unsupported APP_CAPTCHA_ENDPOINTS entry %q: use register, sms_code, email_code or forgot_password", endpoint)
+		}
+	}
+	if cfg.Captcha.AdaptiveThreshold < 0 {
+		return nil, fmt.Errorf("APP_CAPTCHA_ADAPTIVE_THRESHOLD must not be negative")
+	}
 
 	if cfg.Auth.QQ.Enabled {
 		if cfg.Auth.QQ.AppID == "" || cfg.Auth.QQ.AppSecret == "" || cfg.Auth.QQ.RedirectURI == "" {
@@ -212,6 +630,10 @@ func Load() (*Config, error) {
 		}
 	}
 
+	if cfg.Auth.SMS.Enabled && cfg.Auth.SMS.Provider == "log" && !cfg.Auth.SMS.DevMode {
+		return nil, fmt.Errorf("sms login enabled with log provider but APP_AUTH_SMS_DEV_MODE is off: set APP_AUTH_SMS_PROVIDER to aliyun, tencent or http")
+	}
+
 	return cfg, nil
 }
 
@@ -233,3 +655,18 @@ func splitAndClean(value string) []string {
 func parseDuration(v *viper.Viper, key string) (time.Duration, error) {
 	return time.ParseDuration(v.GetString(key))
 }
+
+// normalizeJWTAlgorithm accepts the algorithm names case-insensitively and returns the JOSE spelling.
+func normalizeJWTAlgorithm(value string) string {
+	value = strings.TrimSpace(value)
+	switch strings.ToUpper(value) {
+	case "HS256":
+		return "HS256"
+	case "RS256":
+		return "RS256"
+	case "EDDSA", "ED25519":
+		return "EdDSA"
+	default:
+		return value
+	}
+}
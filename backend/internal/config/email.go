@@ -3,29 +3,53 @@ package config
 import (
 	"os"
 	"strconv"
+	"time"
 )
 
 // EmailConfig holds email service configuration
 type EmailConfig struct {
-	SMTPHost     string
-	SMTPPort     int
-	SMTPUsername string
-	SMTPPassword string
-	FromEmail    string
-	FromName     string
+	SMTPHost        string
+	SMTPPort        int
+	SMTPUsername    string
+	SMTPPassword    string
+	SMTPTimeout     time.Duration
+	FromEmail       string
+	FromName        string
 	FrontendBaseURL string
+	TemplateDir     string
+	DefaultLocale   string
+	Queue           EmailQueueConfig
+}
+
+// EmailQueueConfig controls the background outbox worker
+type EmailQueueConfig struct {
+	MaxAttempts   int
+	PollInterval  time.Duration
+	BaseBackoff   time.Duration
+	MaxBackoff    time.Duration
+	SentRetention time.Duration
 }
 
 // LoadEmailConfig loads email configuration from environment variables
 func LoadEmailConfig() *EmailConfig {
 	return &EmailConfig{
-		SMTPHost:     getEnv("SMTP_HOST", "smtp.gmail.com"),
-		SMTPPort:     getEnvAsInt("SMTP_PORT", 587),
-		SMTPUsername: getEnv("SMTP_USERNAME", ""),
-		SMTPPassword: getEnv("SMTP_PASSWORD", ""),
-		FromEmail:    getEnv("FROM_EMAIL", "noreply@hdu-food-review.com"),
-		FromName:     getEnv("FROM_NAME", "HDU美食点评"),
+		SMTPHost:        getEnv("SMTP_HOST", "smtp.gmail.com"),
+		SMTPPort:        getEnvAsInt("SMTP_PORT", 587),
+		SMTPUsername:    getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:    getEnv("SMTP_PASSWORD", ""),
+		SMTPTimeout:     getEnvAsDuration("SMTP_TIMEOUT", 15*time.Second),
+		FromEmail:       getEnv("FROM_EMAIL", "noreply@hdu-food-review.com"),
+		FromName:        getEnv("FROM_NAME", "HDU美食点评"),
 		FrontendBaseURL: getEnv("FRONTEND_BASE_URL", "http://localhost:5174"),
+		TemplateDir:     getEnv("EMAIL_TEMPLATE_DIR", ""),
+		DefaultLocale:   getEnv("EMAIL_DEFAULT_LOCALE", "zh-CN"),
+		Queue: EmailQueueConfig{
+			MaxAttempts:   getEnvAsInt("EMAIL_QUEUE_MAX_ATTEMPTS", 6),
+			PollInterval:  getEnvAsDuration("EMAIL_QUEUE_POLL_INTERVAL", 5*time.Second),
+			BaseBackoff:   getEnvAsDuration("EMAIL_QUEUE_BASE_BACKOFF", 30*time.Second),
+			MaxBackoff:    getEnvAsDuration("EMAIL_QUEUE_MAX_BACKOFF", time.Hour),
+			SentRetention: getEnvAsDuration("EMAIL_QUEUE_SENT_RETENTION", 7*24*time.Hour),
+		},
 	}
 }
 
@@ -53,3 +77,15 @@ func getEnvAsInt(key string, defaultValue int) int {
 	}
 	return defaultValue
 }
+
+// getEnvAsDuration gets environment variable as duration with default value
+func getEnvAsDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		result, err := time.ParseDuration(value)
+		if err != nil {
+			return defaultValue
+		}
+		return result
+	}
+	return defaultValue
+}
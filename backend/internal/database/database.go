@@ -34,6 +34,10 @@ func Init(cfg *config.Config) (*gorm.DB, error) {
 		return nil, err
 	}
 
+	if err = dedupeDisplayNames(db); err != nil {
+		return nil, err
+	}
+
 	if err = db.AutoMigrate(
 		&models.User{},
 		&models.EmailVerification{},
@@ -41,9 +45,18 @@ func Init(cfg *config.Config) (*gorm.DB, error) {
 		&models.Review{},
 		&models.ReviewImage{},
 		&models.RefreshToken{},
+		&models.PasswordResetToken{},
+		&models.EmailChange{},
+		&models.UserTOTP{},
+		&models.MFARecoveryCode{},
+		&models.SigningKey{},
+		&models.APIToken{},
 		&models.ReviewStats{},
 		&models.ReviewReaction{},
 		&models.SiteStats{},
+		&models.EmailOutbox{},
+		&models.WebhookEndpoint{},
+		&models.WebhookDelivery{},
 	); err != nil {
 		return nil, fmt.Errorf("auto migrate: %w", err)
 	}
@@ -62,6 +75,43 @@ func Init(cfg *config.Config) (*gorm.DB, error) {
 	return db, nil
 }
 
+// dedupeDisplayNames renames accounts whose display name repeats another one, ignoring case, so that the
+// unique display name index can be added to databases created before it existed. The oldest account keeps
+// the name; later ones get part of their id appended. Anonymised accounts are skipped, as in the index.
+func dedupeDisplayNames(db *gorm.DB) error {
+	migrator := db.Migrator()
+	if !migrator.HasTable(&models.User{}) || migrator.HasIndex(&models.User{}, "idx_users_display_name") {
+		return nil
+	}
+
+	var users []models.User
+	if err := db.Select("id", "display_name").
+		Where("email NOT GLOB ?", "deleted_*@local.invalid").
+		Order("created_at ASC, id ASC").
+		Find(&users).Error; err != nil {
+		return fmt.Errorf("query display names: %w", err)
+	}
+
+	seen := make(map[string]bool, len(users))
+	for _, user := range users {
+		key := strings.ToLower(user.DisplayName)
+		if !seen[key] {
+			seen[key] = true
+			continue
+		}
+		name := user.DisplayName + " " + user.ID.String()[:8]
+		if err := db.Model(&models.User{}).Where("id = ?", user.ID).Update("display_name", name).Error; err != nil {
+			return fmt.Errorf("rename duplicate display name: %w", err)
+		}
+		seen[strings.ToLower(name)] = true
+		slog.Warn("renamed duplicate display name",
+			slog.String("user_id", user.ID.String()),
+			slog.String("from", user.DisplayName),
+			slog.String("to", name))
+	}
+	return nil
+}
+
 func ensureDir(path string) error {
 	if path == "" || path == "." {
 		return nil
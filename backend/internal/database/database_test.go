@@ -0,0 +1,48 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hdu-dp/backend/internal/models"
+)
+
+func TestDedupeDisplayNamesBeforeAddingTheIndex(t *testing.T) {
+	db := newSeedTestDB(t)
+	// Databases from before the index have duplicate names in them.
+	if err := db.Migrator().DropIndex(&models.User{}, "idx_users_display_name"); err != nil {
+		t.Fatalf("drop index: %v", err)
+	}
+	created := time.Now().Add(-time.Hour)
+	users := []*models.User{
+		{Email: "first@example.com", DisplayName: "Sam", CreatedAt: created},
+		{Email: "second@example.com", DisplayName: "SAM", CreatedAt: created.Add(time.Minute)},
+		{Email: "deleted_a@local.invalid", DisplayName: "已注销用户", CreatedAt: created},
+		{Email: "deleted_b@local.invalid", DisplayName: "已注销用户", CreatedAt: created},
+	}
+	for _, user := range users {
+		user.PasswordHash = "x"
+		if err := db.Create(user).Error; err != nil {
+			t.Fatalf("create user: %v", err)
+		}
+	}
+
+	if err := dedupeDisplayNames(db); err != nil {
+		t.Fatalf("dedupeDisplayNames returned error: %v", err)
+	}
+	if err := db.AutoMigrate(&models.User{}); err != nil {
+		t.Fatalf("expected the index to be created after deduplication: %v", err)
+	}
+
+	var first, second models.User
+	db.First(&first, "id = ?", users[0].ID)
+	db.First(&second, "id = ?", users[1].ID)
+	if first.DisplayName != "Sam" || second.DisplayName != "SAM "+users[1].ID.String()[:8] {
+		t.Fatalf("expected the newer account to be renamed, got %q and %q", first.DisplayName, second.DisplayName)
+	}
+	var anonymised int64
+	db.Model(&models.User{}).Where("display_name = ?", "已注销用户").Count(&anonymised)
+	if anonymised != 2 {
+		t.Fatalf("expected anonymised accounts to keep their shared name, got %d", anonymised)
+	}
+}
@@ -33,12 +33,16 @@ func seedAdmin(db *gorm.DB, cfg *config.Config) error {
 			existing.Role = "admin"
 			needsUpdate = true
 		}
-		if err := utils.CheckPassword(existing.PasswordHash, cfg.Admin.Password); err != nil {
+		if err := utils.CheckPassword(existing.PasswordHash, cfg.Admin.Password); err != nil || utils.PasswordNeedsRehash(existing.PasswordHash) {
 			existing.PasswordHash = hashed
 			needsUpdate = true
 		}
 		if existing.DisplayName == "" {
-			existing.DisplayName = "Administrator"
+			name, err := adminDisplayName(db, existing.ID)
+			if err != nil {
+				return err
+			}
+			existing.DisplayName = name
 			needsUpdate = true
 		}
 		if needsUpdate {
@@ -49,11 +53,16 @@ func seedAdmin(db *gorm.DB, cfg *config.Config) error {
 		return nil
 	}
 
+	id := uuid.New()
+	name, err := adminDisplayName(db, id)
+	if err != nil {
+		return err
+	}
 	admin := models.User{
-		ID:           uuid.New(),
+		ID:           id,
 		Email:        cfg.Admin.Email,
 		PasswordHash: hashed,
-		DisplayName:  "Administrator",
+		DisplayName:  name,
 		Role:         "admin",
 	}
 
@@ -63,3 +72,19 @@ func seedAdmin(db *gorm.DB, cfg *config.Config) error {
 
 	return nil
 }
+
+// adminDisplayName names the seeded admin "Administrator", adding part of its id when another account
+// already uses that name, since display names are unique.
+func adminDisplayName(db *gorm.DB, id uuid.UUID) (string, error) {
+	name := "Administrator"
+	var taken int64
+	if err := db.Model(&models.User{}).
+		Where("LOWER(display_name) = LOWER(?) AND id <> ?", name, id).
+		Count(&taken).Error; err != nil {
+		return "", fmt.Errorf("query admin display name: %w", err)
+	}
+	if taken > 0 {
+		name += " " + id.String()[:8]
+	}
+	return name, nil
+}
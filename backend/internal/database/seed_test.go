@@ -96,3 +96,25 @@ func TestSeedAdminUpdatesExistingUser(t *testing.T) {
 		t.Fatalf("expected stored password to be rotated: %v", err)
 	}
 }
+
+func TestSeedAdminAvoidsTakenDisplayName(t *testing.T) {
+	db := newSeedTestDB(t)
+	cfg := &config.Config{}
+	cfg.Admin.Email = "admin@example.com"
+	cfg.Admin.Password = "Admin123!"
+
+	if err := db.Create(&models.User{Email: "someone@example.com", PasswordHash: "x", DisplayName: "administrator"}).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	if err := seedAdmin(db, cfg); err != nil {
+		t.Fatalf("seedAdmin returned error: %v", err)
+	}
+
+	var admin models.User
+	if err := db.Where("email = ?", cfg.Admin.Email).First(&admin).Error; err != nil {
+		t.Fatalf("query created admin: %v", err)
+	}
+	if admin.DisplayName != "Administrator "+admin.ID.String()[:8] {
+		t.Fatalf("expected the admin name to be made unique, got %s", admin.DisplayName)
+	}
+}
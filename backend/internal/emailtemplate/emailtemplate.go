@@ -0,0 +1,259 @@
+// Package emailtemplate renders localised transactional emails from embedded
+// default templates, optionally overridden by files in an operator-supplied directory.
+//
+// Templates live at "<locale>/<name>.<part>.tmpl" where part is one of
+// "subject" (text/template), "html" (html/template) or "txt" (text/template).
+package emailtemplate
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	texttemplate "text/template"
+)
+
+//go:embed templates
+var embedded embed.FS
+
+const (
+	// LocaleZhCN is simplified Chinese, the default locale.
+	LocaleZhCN = "zh-CN"
+	// LocaleEN is English.
+	LocaleEN = "en"
+)
+
+// Template names shipped with the application.
+const (
+	Verification       = "verification"
+	RegistrationCode   = "registration_code"
+	AccountLocked      = "account_locked"
+	PasswordReset      = "password_reset"
+	SessionRevoked     = "session_revoked"
+	EmailChangeConfirm = "email_change_confirm"
+	EmailChanged       = "email_changed"
+)
+
+// ErrTemplateNotFound indicates no template exists for the requested name.
+var ErrTemplateNotFound = errors.New("email template not found")
+
+var supportedLocales = []string{LocaleZhCN, LocaleEN}
+
+// Message is a rendered email ready to be sent as multipart text+HTML.
+type Message struct {
+	Subject string
+	HTML    string
+	Text    string
+}
+
+type templateSet struct {
+	subject *texttemplate.Template
+	html    *htmltemplate.Template
+	text    *texttemplate.Template
+}
+
+// Renderer renders named email templates for a locale.
+type Renderer struct {
+	defaultLocale string
+	sets          map[string]map[string]*templateSet
+}
+
+// New parses every embedded template, preferring same-named files under overrideDir when present.
+func New(overrideDir, defaultLocale string) (*Renderer, error) {
+	locale := NormalizeLocale(defaultLocale)
+	if locale == "" {
+		locale = LocaleZhCN
+	}
+
+	var override fs.FS
+	if dir := strings.TrimSpace(overrideDir); dir != "" {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return nil, fmt.Errorf("email template dir: %w", err)
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("email template dir %s is not a directory", dir)
+		}
+		override = os.DirFS(dir)
+	}
+
+	r := &Renderer{
+		defaultLocale: locale,
+		sets:          make(map[string]map[string]*templateSet, len(supportedLocales)),
+	}
+
+	names, err := embeddedNames()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, loc := range supportedLocales {
+		r.sets[loc] = make(map[string]*templateSet, len(names))
+		for _, name := range names {
+			set, err := loadSet(override, loc, name)
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					continue
+				}
+				return nil, err
+			}
+			r.sets[loc][name] = set
+		}
+	}
+
+	return r, nil
+}
+
+// DefaultLocale returns the locale used when a requested one is unsupported.
+func (r *Renderer) DefaultLocale() string {
+	return r.defaultLocale
+}
+
+// Render executes the named template for the given locale, falling back to the default locale.
+func (r *Renderer) Render(name, locale string, data any) (*Message, error) {
+	set := r.lookup(name, locale)
+	if set == nil {
+		return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
+	}
+
+	var subject, html, text bytes.Buffer
+	if err := set.subject.Execute(&subject, data); err != nil {
+		return nil, fmt.Errorf("render %s subject: %w", name, err)
+	}
+	if err := set.html.Execute(&html, data); err != nil {
+		return nil, fmt.Errorf("render %s html: %w", name, err)
+	}
+	if err := set.text.Execute(&text, data); err != nil {
+		return nil, fmt.Errorf("render %s text: %w", name, err)
+	}
+
+	return &Message{
+		Subject: singleLine(subject.String()),
+		HTML:    html.String(),
+		Text:    text.String(),
+	}, nil
+}
+
+func (r *Renderer) lookup(name, locale string) *templateSet {
+	if loc := NormalizeLocale(locale); loc != "" {
+		if set := r.sets[loc][name]; set != nil {
+			return set
+		}
+	}
+	return r.sets[r.defaultLocale][name]
+}
+
+// NormalizeLocale maps a language tag such as "en-US" or "zh_Hans" onto a supported locale,
+// returning "" when the language is not supported.
+func NormalizeLocale(value string) string {
+	value = strings.ToLower(strings.TrimSpace(strings.ReplaceAll(value, "_", "-")))
+	switch {
+	case value == "":
+		return ""
+	case value == "zh" || strings.HasPrefix(value, "zh-"):
+		return LocaleZhCN
+	case value == "en" || strings.HasPrefix(value, "en-"):
+		return LocaleEN
+	default:
+		return ""
+	}
+}
+
+// MatchLocale returns the first supported locale among the candidates, each of which
+// may be a single tag or an Accept-Language style list. It returns "" when none match.
+func MatchLocale(candidates ...string) string {
+	for _, candidate := range candidates {
+		for _, tag := range strings.Split(candidate, ",") {
+			if idx := strings.Index(tag, ";"); idx >= 0 {
+				tag = tag[:idx]
+			}
+			if locale := NormalizeLocale(tag); locale != "" {
+				return locale
+			}
+		}
+	}
+	return ""
+}
+
+func embeddedNames() ([]string, error) {
+	seen := make(map[string]struct{})
+	var names []string
+	err := fs.WalkDir(embedded, "templates", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		base := path.Base(p)
+		if !strings.HasSuffix(base, ".subject.tmpl") {
+			return nil
+		}
+		name := strings.TrimSuffix(base, ".subject.tmpl")
+		if _, ok := seen[name]; !ok {
+			seen[name] = struct{}{}
+			names = append(names, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list embedded email templates: %w", err)
+	}
+	return names, nil
+}
+
+func loadSet(override fs.FS, locale, name string) (*templateSet, error) {
+	subjectSrc, err := readTemplate(override, locale, name, "subject")
+	if err != nil {
+		return nil, err
+	}
+	htmlSrc, err := readTemplate(override, locale, name, "html")
+	if err != nil {
+		return nil, err
+	}
+	textSrc, err := readTemplate(override, locale, name, "txt")
+	if err != nil {
+		return nil, err
+	}
+
+	id := locale + "/" + name
+	subject, err := texttemplate.New(id + ".subject").Parse(subjectSrc)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s subject: %w", id, err)
+	}
+	html, err := htmltemplate.New(id + ".html").Parse(htmlSrc)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s html: %w", id, err)
+	}
+	text, err := texttemplate.New(id + ".txt").Parse(textSrc)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s text: %w", id, err)
+	}
+
+	return &templateSet{subject: subject, html: html, text: text}, nil
+}
+
+func readTemplate(override fs.FS, locale, name, part string) (string, error) {
+	rel := path.Join(locale, name+"."+part+".tmpl")
+	if override != nil {
+		data, err := fs.ReadFile(override, rel)
+		if err == nil {
+			return string(data), nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", fmt.Errorf("read override template %s: %w", rel, err)
+		}
+	}
+	data, err := fs.ReadFile(embedded, path.Join("templates", rel))
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// singleLine collapses whitespace so a rendered subject can never inject extra headers.
+func singleLine(value string) string {
+	return strings.Join(strings.Fields(value), " ")
+}
@@ -0,0 +1,98 @@
+package emailtemplate
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRenderSelectsLocaleAndFallsBackToDefault(t *testing.T) {
+	renderer, err := New("", LocaleZhCN)
+	if err != nil {
+		t.Fatalf("new renderer failed: %v", err)
+	}
+
+	data := map[string]any{"Code": "123456", "ExpiresInMinutes": 10}
+
+	en, err := renderer.Render(RegistrationCode, "en-US", data)
+	if err != nil {
+		t.Fatalf("render en failed: %v", err)
+	}
+	if en.Subject != "Your registration code" || !strings.Contains(en.Text, "123456") || !strings.Contains(en.HTML, "123456") {
+		t.Fatalf("unexpected english message: %+v", en)
+	}
+
+	fallback, err := renderer.Render(RegistrationCode, "fr", data)
+	if err != nil {
+		t.Fatalf("render fallback failed: %v", err)
+	}
+	if fallback.Subject != "您的注册验证码" {
+		t.Fatalf("expected default zh-CN subject, got %q", fallback.Subject)
+	}
+}
+
+func TestRenderEscapesHTMLButNotText(t *testing.T) {
+	renderer, err := New("", LocaleEN)
+	if err != nil {
+		t.Fatalf("new renderer failed: %v", err)
+	}
+
+	msg, err := renderer.Render(Verification, LocaleEN, map[string]any{
+		"VerificationURL": "https://example.com/verify?token=a&b=<x>",
+		"ExpiresInHours":  24,
+	})
+	if err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	if strings.Contains(msg.HTML, "<x>") {
+		t.Fatalf("expected html body to escape data, got %s", msg.HTML)
+	}
+	if !strings.Contains(msg.Text, "token=a&b=<x>") {
+		t.Fatalf("expected text body to keep data verbatim, got %s", msg.Text)
+	}
+}
+
+func TestOverrideDirTakesPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, LocaleEN), 0o755); err != nil {
+		t.Fatalf("mkdir failed: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, LocaleEN, "registration_code.subject.tmpl"), []byte("Code {{.Code}}\nInjected: header"), 0o644); err != nil {
+		t.Fatalf("write override failed: %v", err)
+	}
+
+	renderer, err := New(dir, LocaleZhCN)
+	if err != nil {
+		t.Fatalf("new renderer failed: %v", err)
+	}
+
+	msg, err := renderer.Render(RegistrationCode, LocaleEN, map[string]any{"Code": "654321", "ExpiresInMinutes": 10})
+	if err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+	if msg.Subject != "Code 654321 Injected: header" {
+		t.Fatalf("expected overridden single-line subject, got %q", msg.Subject)
+	}
+	if !strings.Contains(msg.HTML, "Registration code") {
+		t.Fatalf("expected non-overridden parts to use embedded defaults, got %s", msg.HTML)
+	}
+}
+
+func TestMatchLocale(t *testing.T) {
+	cases := map[string]string{
+		"":                           "",
+		"en":                         LocaleEN,
+		"zh_Hans":                    LocaleZhCN,
+		"fr-FR,en-GB;q=0.8,zh;q=0.5": LocaleEN,
+		"de":                         "",
+	}
+	for input, want := range cases {
+		if got := MatchLocale(input); got != want {
+			t.Errorf("MatchLocale(%q) = %q, want %q", input, got, want)
+		}
+	}
+	if got := MatchLocale("", "zh-TW"); got != LocaleZhCN {
+		t.Errorf("expected later candidate to be used, got %q", got)
+	}
+}
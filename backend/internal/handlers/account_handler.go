@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hdu-dp/backend/internal/common"
+	"github.com/hdu-dp/backend/internal/httpx"
+	"github.com/hdu-dp/backend/internal/services"
+)
+
+// AccountHandler exposes personal data export and account deletion.
+type AccountHandler struct {
+	accounts *services.AccountService
+}
+
+// NewAccountHandler constructs an AccountHandler.
+func NewAccountHandler(accounts *services.AccountService) *AccountHandler {
+	return &AccountHandler{accounts: accounts}
+}
+
+// @Summary      导出个人数据
+// @Description  下载包含个人资料、点评（含图片）与点赞记录的 ZIP 压缩包。每小时最多导出 3 次。
+// @Tags         用户
+// @Produce      application/zip
+// @Success      200 {file} file "ZIP 压缩包"
+// @Failure      401 {object} object{error=string} "未认证"
+// @Failure      429 {object} object{error=string} "请求过于频繁，响应头 Retry-After 为需等待的秒数"
+// @Security     ApiKeyAuth
+// @Router       /users/me/export [get]
+func (h *AccountHandler) Export(c *gin.Context) {
+	userID, ok := httpx.MustContextUUID(c, "user_id", "missing user", "invalid user id")
+	if !ok {
+		return
+	}
+
+	filename := fmt.Sprintf("hdu-comment-export-%s.zip", time.Now().Format("20060102"))
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	if err := h.accounts.Export(c.Request.Context(), userID, c.Writer); err != nil {
+		if c.Writer.Written() {
+			slog.Error("write data export failed", slog.String("user_id", userID.String()), slog.Any("error", err))
+			c.Abort()
+			return
+		}
+		c.Header("Content-Disposition", "")
+		if respondRateLimited(c, err) {
+			return
+		}
+		if errors.Is(err, services.ErrUserNotFound) {
+			httpx.Error(c, http.StatusNotFound, "用户不存在")
+			return
+		}
+		httpx.Error(c, http.StatusInternalServerError, "导出数据失败")
+	}
+}
+
+// @Summary      注销账户
+// @Description  申请注销当前账户。设置了邮箱的账户需提供当前密码；通过 QQ、微信或短信登录的账户需在 10 分钟内重新登录后再操作。申请后所有设备立即退出、访问令牌全部撤销，宽限期内重新登录即撤回申请；密码输错计入登录失败次数；宽限期结束后账户被永久删除，点评与点赞按服务端策略匿名保留或一并删除。
+// @Tags         用户
+// @Accept       json
+// @Produce      json
+// @Param        body body object{password=string} false "当前密码"
+// @Success      202 {object} object{message=string,deletion_scheduled_at=string} "已申请注销"
+// @Failure      400 {object} object{error=string} "密码不正确"
+// @Failure      401 {object} object{error=string} "未认证或需要重新登录"
+// @Failure      429 {object} object{error=string} "密码输错过多被延迟或锁定，响应头 Retry-After 为需等待的秒数"
+// @Security     ApiKeyAuth
+// @Router       /users/me [delete]
+func (h *AccountHandler) RequestDeletion(c *gin.Context) {
+	userID, ok := httpx.MustContextUUID(c, "user_id", "missing user", "invalid user id")
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Password string `json:"password"`
+	}
+	if c.Request.ContentLength != 0 && !httpx.BindJSON(c, &req, "无效的请求参数") {
+		return
+	}
+
+	purgeAt, err := h.accounts.RequestDeletion(c.Request.Context(), userID, currentSessionID(c), req.Password)
+	if err != nil {
+		if respondRateLimited(c, err) {
+			return
+		}
+		switch {
+		case errors.Is(err, common.ErrInvalidCredentials):
+			httpx.Error(c, http.StatusBadRequest, "密码不正确")
+		case errors.Is(err, services.ErrReauthRequired):
+			httpx.Error(c, http.StatusUnauthorized, "请重新登录后再注销账户")
+		case errors.Is(err, services.ErrUserNotFound):
+			httpx.Error(c, http.StatusNotFound, "用户不存在")
+		default:
+			httpx.Error(c, http.StatusInternalServerError, "注销账户失败")
+		}
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":               "账户将在宽限期结束后删除，期间重新登录即可撤回",
+		"deletion_scheduled_at": purgeAt,
+	})
+}
+
+// @Summary      撤回注销
+// @Description  在宽限期内撤回注销申请，账户恢复正常。
+// @Tags         用户
+// @Produce      json
+// @Success      200 {object} object{message=string}
+// @Failure      401 {object} object{error=string} "未认证"
+// @Failure      409 {object} object{error=string} "账户没有待处理的注销申请"
+// @Security     ApiKeyAuth
+// @Router       /users/me/deletion/cancel [post]
+func (h *AccountHandler) CancelDeletion(c *gin.Context) {
+	userID, ok := httpx.MustContextUUID(c, "user_id", "missing user", "invalid user id")
+	if !ok {
+		return
+	}
+
+	if err := h.accounts.CancelDeletion(userID); err != nil {
+		switch {
+		case errors.Is(err, services.ErrDeletionNotScheduled):
+			httpx.Error(c, http.StatusConflict, "账户没有待处理的注销申请")
+		case errors.Is(err, services.ErrUserNotFound):
+			httpx.Error(c, http.StatusNotFound, "用户不存在")
+		default:
+			httpx.Error(c, http.StatusInternalServerError, "撤回注销失败")
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "已撤回注销申请"})
+}
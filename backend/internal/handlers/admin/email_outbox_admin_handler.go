@@ -0,0 +1,103 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hdu-dp/backend/internal/httpx"
+	"github.com/hdu-dp/backend/internal/models"
+	"github.com/hdu-dp/backend/internal/services"
+)
+
+// EmailOutboxAdminHandler exposes the outbound email queue to administrators.
+type EmailOutboxAdminHandler struct {
+	outbox *services.EmailOutboxService
+}
+
+// NewEmailOutboxAdminHandler constructs an EmailOutboxAdminHandler.
+func NewEmailOutboxAdminHandler(outbox *services.EmailOutboxService) *EmailOutboxAdminHandler {
+	return &EmailOutboxAdminHandler{outbox: outbox}
+}
+
+// @Summary      邮件发送队列
+// @Description  按状态查看待发送邮件，默认返回已进入死信状态（多次重试仍失败）的邮件。
+// @Tags         管理
+// @Produce      json
+// @Param        status    query string false "状态 (pending, sending, sent, dead, all)" default(dead)
+// @Param        page      query int    false "页码" default(1)
+// @Param        page_size query int    false "每页数量" default(20)
+// @Success      200 {object} object{data=[]models.EmailOutbox,pagination=object{page=integer,page_size=integer,total=integer}}
+// @Failure      400 {object} object{error=string} "状态参数无效"
+// @Security     ApiKeyAuth
+// @Router       /admin/emails [get]
+func (h *EmailOutboxAdminHandler) List(c *gin.Context) {
+	page := httpx.QueryInt(c, "page", 1, 1, 0)
+	pageSize := httpx.QueryInt(c, "page_size", 20, 1, 100)
+
+	var status models.EmailOutboxStatus
+	switch strings.ToLower(c.DefaultQuery("status", string(models.EmailOutboxStatusDead))) {
+	case "all":
+		status = ""
+	case string(models.EmailOutboxStatusPending):
+		status = models.EmailOutboxStatusPending
+	case string(models.EmailOutboxStatusSending):
+		status = models.EmailOutboxStatusSending
+	case string(models.EmailOutboxStatusSent):
+		status = models.EmailOutboxStatusSent
+	case string(models.EmailOutboxStatusDead):
+		status = models.EmailOutboxStatusDead
+	default:
+		httpx.Error(c, http.StatusBadRequest, "无效的状态参数")
+		return
+	}
+
+	messages, total, err := h.outbox.List(c.Request.Context(), status, page, pageSize)
+	if err != nil {
+		httpx.Error(c, http.StatusInternalServerError, "获取邮件队列失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": messages,
+		"pagination": gin.H{
+			"page":      page,
+			"page_size": pageSize,
+			"total":     total,
+		},
+	})
+}
+
+// @Summary      重新投递邮件
+// @Description  将死信状态的邮件重新放回发送队列。
+// @Tags         管理
+// @Produce      json
+// @Param        id path string true "邮件 ID"
+// @Success      200 {object} models.EmailOutbox
+// @Failure      400 {object} object{error=string} "无效的邮件 ID"
+// @Failure      404 {object} object{error=string} "邮件不存在"
+// @Failure      409 {object} object{error=string} "邮件不处于死信状态"
+// @Security     ApiKeyAuth
+// @Router       /admin/emails/{id}/retry [post]
+func (h *EmailOutboxAdminHandler) Retry(c *gin.Context) {
+	id, ok := httpx.ParamUUID(c, "id", "无效的邮件ID")
+	if !ok {
+		return
+	}
+
+	message, err := h.outbox.Retry(c.Request.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrOutboxMessageNotFound):
+			httpx.Error(c, http.StatusNotFound, "邮件不存在")
+		case errors.Is(err, services.ErrOutboxMessageNotRetryable):
+			httpx.Error(c, http.StatusConflict, "仅死信状态的邮件可以重新投递")
+		default:
+			httpx.Error(c, http.StatusInternalServerError, "重新投递失败")
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, message)
+}
@@ -3,21 +3,32 @@ package admin
 import (
 	"errors"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/hdu-dp/backend/internal/httpx"
 	"github.com/hdu-dp/backend/internal/repository"
+	"github.com/hdu-dp/backend/internal/services"
 	"gorm.io/gorm"
 )
 
 // UserAdminHandler exposes admin operations for user management.
 type UserAdminHandler struct {
-	users *repository.UserRepository
+	users      *repository.UserRepository
+	sessions   *services.SessionService
+	identities *services.IdentityService
+	accounts   *services.AccountService
 }
 
 // NewUserAdminHandler constructs a UserAdminHandler.
-func NewUserAdminHandler(users *repository.UserRepository) *UserAdminHandler {
-	return &UserAdminHandler{users: users}
+func NewUserAdminHandler(
+	users *repository.UserRepository,
+	sessions *services.SessionService,
+	identities *services.IdentityService,
+	accounts *services.AccountService,
+) *UserAdminHandler {
+	return &UserAdminHandler{users: users, sessions: sessions, identities: identities, accounts: accounts}
 }
 
 // List returns paginated users for admin view.
@@ -39,8 +50,13 @@ func (h *UserAdminHandler) List(c *gin.Context) {
 		return
 	}
 
+	now := time.Now()
 	resp := make([]gin.H, 0, len(users))
 	for _, user := range users {
+		var lockedUntil *time.Time
+		if user.LockedUntil != nil && now.Before(*user.LockedUntil) {
+			lockedUntil = user.LockedUntil
+		}
 		resp = append(resp, gin.H{
 			"id":                user.ID,
 			"email":             user.Email,
@@ -48,6 +64,8 @@ func (h *UserAdminHandler) List(c *gin.Context) {
 			"role":              user.Role,
 			"email_verified":    user.EmailVerified,
 			"email_verified_at": user.EmailVerifiedAt,
+			"campus_verified":   user.CampusVerified,
+			"locked_until":      lockedUntil,
 			"created_at":        user.CreatedAt,
 		})
 	}
@@ -62,7 +80,8 @@ func (h *UserAdminHandler) List(c *gin.Context) {
 	})
 }
 
-// Delete removes a user by id.
+// Delete purges a user right away, without a grace period. Their reviews and reactions are anonymised or
+// deleted according to the configured content policy.
 func (h *UserAdminHandler) Delete(c *gin.Context) {
 	userID, ok := httpx.ParamUUID(c, "id", "无效的用户ID")
 	if !ok {
@@ -78,19 +97,142 @@ func (h *UserAdminHandler) Delete(c *gin.Context) {
 		return
 	}
 
-	if _, err := h.users.FindByID(userID); err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
+	if err := h.accounts.Purge(c.Request.Context(), userID); err != nil {
+		if errors.Is(err, services.ErrUserNotFound) {
 			httpx.Error(c, http.StatusNotFound, "用户不存在")
 			return
 		}
-		httpx.Error(c, http.StatusInternalServerError, "查询用户失败")
+		httpx.Error(c, http.StatusInternalServerError, "删除用户失败")
 		return
 	}
 
-	if err := h.users.Delete(userID); err != nil {
-		httpx.Error(c, http.StatusInternalServerError, "删除用户失败")
+	c.JSON(http.StatusOK, gin.H{"message": "用户已删除"})
+}
+
+// Unlock lifts a lockout caused by failed password logins before it expires.
+func (h *UserAdminHandler) Unlock(c *gin.Context) {
+	userID, ok := h.existingUserID(c)
+	if !ok {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "用户已删除"})
+	if err := h.users.ClearLoginFailures(userID); err != nil {
+		httpx.Error(c, http.StatusInternalServerError, "解锁用户失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "用户已解锁"})
+}
+
+// Sessions lists the active sessions of a user.
+func (h *UserAdminHandler) Sessions(c *gin.Context) {
+	userID, ok := h.existingUserID(c)
+	if !ok {
+		return
+	}
+
+	sessions, err := h.sessions.List(userID, uuid.Nil)
+	if err != nil {
+		httpx.Error(c, http.StatusInternalServerError, "获取登录设备失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": sessions})
+}
+
+// RevokeSession signs a user out of one session.
+func (h *UserAdminHandler) RevokeSession(c *gin.Context) {
+	userID, ok := h.existingUserID(c)
+	if !ok {
+		return
+	}
+	sessionID, ok := httpx.ParamUUID(c, "session_id", "无效的会话ID")
+	if !ok {
+		return
+	}
+
+	if err := h.sessions.Revoke(userID, sessionID); err != nil {
+		if errors.Is(err, services.ErrSessionNotFound) {
+			httpx.Error(c, http.StatusNotFound, "会话不存在或已失效")
+			return
+		}
+		httpx.Error(c, http.StatusInternalServerError, "撤销会话失败")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// RevokeSessions signs a user out everywhere.
+func (h *UserAdminHandler) RevokeSessions(c *gin.Context) {
+	userID, ok := h.existingUserID(c)
+	if !ok {
+		return
+	}
+
+	if err := h.sessions.RevokeAll(userID); err != nil {
+		httpx.Error(c, http.StatusInternalServerError, "撤销会话失败")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// Merge folds the user in :id into target_id, moving reviews, reactions and login methods,
+// then deletes the :id account.
+func (h *UserAdminHandler) Merge(c *gin.Context) {
+	sourceID, ok := h.existingUserID(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		TargetID string `json:"target_id" binding:"required,uuid"`
+	}
+	if !httpx.BindJSON(c, &req, "请提供要保留的账号 target_id") {
+		return
+	}
+	targetID := uuid.MustParse(req.TargetID)
+
+	currentUserID, ok := httpx.MustContextUUID(c, "user_id", "missing user", "invalid user id")
+	if !ok {
+		return
+	}
+	if currentUserID == sourceID {
+		httpx.Error(c, http.StatusBadRequest, "不能合并并删除当前登录的账户")
+		return
+	}
+
+	result, err := h.identities.Merge(sourceID, targetID)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrMergeSameUser):
+			httpx.Error(c, http.StatusBadRequest, "不能将账号合并到自身")
+		case errors.Is(err, services.ErrUserNotFound):
+			httpx.Error(c, http.StatusNotFound, "目标用户不存在")
+		default:
+			httpx.Error(c, http.StatusInternalServerError, "合并账号失败")
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "账号已合并", "merged": result})
+}
+
+// existingUserID parses the :id param and writes a 404 when the user does not exist.
+func (h *UserAdminHandler) existingUserID(c *gin.Context) (uuid.UUID, bool) {
+	userID, ok := httpx.ParamUUID(c, "id", "无效的用户ID")
+	if !ok {
+		return uuid.Nil, false
+	}
+
+	if _, err := h.users.FindByID(userID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			httpx.Error(c, http.StatusNotFound, "用户不存在")
+			return uuid.Nil, false
+		}
+		httpx.Error(c, http.StatusInternalServerError, "查询用户失败")
+		return uuid.Nil, false
+	}
+	return userID, true
 }
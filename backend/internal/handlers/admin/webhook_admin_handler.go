@@ -0,0 +1,233 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hdu-dp/backend/internal/httpx"
+	"github.com/hdu-dp/backend/internal/models"
+	"github.com/hdu-dp/backend/internal/services"
+)
+
+// WebhookAdminHandler exposes webhook endpoint management and delivery logs to administrators.
+type WebhookAdminHandler struct {
+	webhooks *services.WebhookService
+}
+
+// NewWebhookAdminHandler constructs a WebhookAdminHandler.
+func NewWebhookAdminHandler(webhooks *services.WebhookService) *WebhookAdminHandler {
+	return &WebhookAdminHandler{webhooks: webhooks}
+}
+
+type webhookRequest struct {
+	Name    string   `json:"name" binding:"max=100"`
+	URL     string   `json:"url" binding:"max=500"`
+	Secret  string   `json:"secret" binding:"max=128"`
+	Events  []string `json:"events"`
+	Enabled *bool    `json:"enabled"`
+}
+
+func (r webhookRequest) input() services.WebhookEndpointInput {
+	return services.WebhookEndpointInput{
+		Name:    r.Name,
+		URL:     r.URL,
+		Secret:  r.Secret,
+		Events:  r.Events,
+		Enabled: r.Enabled,
+	}
+}
+
+// @Summary      Webhook 列表
+// @Description  查看已配置的 Webhook 端点及其订阅的事件。
+// @Tags         管理
+// @Produce      json
+// @Success      200 {object} object{data=[]object{id=string,name=string,url=string,events=[]string,enabled=boolean}}
+// @Security     ApiKeyAuth
+// @Router       /admin/webhooks [get]
+func (h *WebhookAdminHandler) List(c *gin.Context) {
+	endpoints, err := h.webhooks.ListEndpoints(c.Request.Context())
+	if err != nil {
+		httpx.Error(c, http.StatusInternalServerError, "获取 Webhook 列表失败")
+		return
+	}
+
+	resp := make([]gin.H, 0, len(endpoints))
+	for i := range endpoints {
+		resp = append(resp, webhookResponse(&endpoints[i]))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": resp})
+}
+
+// @Summary      创建 Webhook
+// @Description  新增 Webhook 端点。未提供 secret 时自动生成，secret 仅在创建时返回一次，用于校验 X-Webhook-Signature。
+// @Tags         管理
+// @Accept       json
+// @Produce      json
+// @Param        request body object{name=string,url=string,secret=string,events=[]string,enabled=boolean} true "Webhook 配置"
+// @Success      201 {object} object{id=string,name=string,url=string,events=[]string,enabled=boolean,secret=string}
+// @Failure      400 {object} object{error=string} "URL 或事件无效"
+// @Security     ApiKeyAuth
+// @Router       /admin/webhooks [post]
+func (h *WebhookAdminHandler) Create(c *gin.Context) {
+	var req webhookRequest
+	if !httpx.BindJSON(c, &req, "无效的请求参数") {
+		return
+	}
+
+	endpoint, err := h.webhooks.CreateEndpoint(c.Request.Context(), req.input())
+	if err != nil {
+		h.respondError(c, err, "创建 Webhook 失败")
+		return
+	}
+
+	resp := webhookResponse(endpoint)
+	resp["secret"] = endpoint.Secret
+	c.JSON(http.StatusCreated, resp)
+}
+
+// @Summary      更新 Webhook
+// @Description  修改 Webhook 端点，未提供的字段保持不变。
+// @Tags         管理
+// @Accept       json
+// @Produce      json
+// @Param        id      path string true "Webhook ID"
+// @Param        request body object{name=string,url=string,secret=string,events=[]string,enabled=boolean} true "Webhook 配置"
+// @Success      200 {object} object{id=string,name=string,url=string,events=[]string,enabled=boolean}
+// @Failure      400 {object} object{error=string} "URL 或事件无效"
+// @Failure      404 {object} object{error=string} "Webhook 不存在"
+// @Security     ApiKeyAuth
+// @Router       /admin/webhooks/{id} [put]
+func (h *WebhookAdminHandler) Update(c *gin.Context) {
+	id, ok := httpx.ParamUUID(c, "id", "无效的 Webhook ID")
+	if !ok {
+		return
+	}
+
+	var req webhookRequest
+	if !httpx.BindJSON(c, &req, "无效的请求参数") {
+		return
+	}
+
+	endpoint, err := h.webhooks.UpdateEndpoint(c.Request.Context(), id, req.input())
+	if err != nil {
+		h.respondError(c, err, "更新 Webhook 失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, webhookResponse(endpoint))
+}
+
+// @Summary      删除 Webhook
+// @Description  删除 Webhook 端点及其投递记录。
+// @Tags         管理
+// @Param        id path string true "Webhook ID"
+// @Success      204 "删除成功"
+// @Failure      404 {object} object{error=string} "Webhook 不存在"
+// @Security     ApiKeyAuth
+// @Router       /admin/webhooks/{id} [delete]
+func (h *WebhookAdminHandler) Delete(c *gin.Context) {
+	id, ok := httpx.ParamUUID(c, "id", "无效的 Webhook ID")
+	if !ok {
+		return
+	}
+
+	if err := h.webhooks.DeleteEndpoint(c.Request.Context(), id); err != nil {
+		h.respondError(c, err, "删除 Webhook 失败")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// @Summary      Webhook 投递记录
+// @Description  查看某个 Webhook 端点的投递日志（含响应状态码、错误信息与重试次数）。
+// @Tags         管理
+// @Produce      json
+// @Param        id        path  string true  "Webhook ID"
+// @Param        page      query int    false "页码" default(1)
+// @Param        page_size query int    false "每页数量" default(20)
+// @Success      200 {object} object{data=[]models.WebhookDelivery,pagination=object{page=integer,page_size=integer,total=integer}}
+// @Failure      404 {object} object{error=string} "Webhook 不存在"
+// @Security     ApiKeyAuth
+// @Router       /admin/webhooks/{id}/deliveries [get]
+func (h *WebhookAdminHandler) Deliveries(c *gin.Context) {
+	id, ok := httpx.ParamUUID(c, "id", "无效的 Webhook ID")
+	if !ok {
+		return
+	}
+
+	page := httpx.QueryInt(c, "page", 1, 1, 0)
+	pageSize := httpx.QueryInt(c, "page_size", 20, 1, 100)
+
+	deliveries, total, err := h.webhooks.ListDeliveries(c.Request.Context(), id, page, pageSize)
+	if err != nil {
+		h.respondError(c, err, "获取投递记录失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": deliveries,
+		"pagination": gin.H{
+			"page":      page,
+			"page_size": pageSize,
+			"total":     total,
+		},
+	})
+}
+
+// @Summary      重新投递 Webhook
+// @Description  以相同的事件内容重新创建一次投递，原记录保持不变。
+// @Tags         管理
+// @Produce      json
+// @Param        id path string true "投递记录 ID"
+// @Success      202 {object} models.WebhookDelivery
+// @Failure      404 {object} object{error=string} "投递记录不存在"
+// @Security     ApiKeyAuth
+// @Router       /admin/webhooks/deliveries/{id}/redeliver [post]
+func (h *WebhookAdminHandler) Redeliver(c *gin.Context) {
+	id, ok := httpx.ParamUUID(c, "id", "无效的投递记录ID")
+	if !ok {
+		return
+	}
+
+	delivery, err := h.webhooks.Redeliver(c.Request.Context(), id)
+	if err != nil {
+		h.respondError(c, err, "重新投递失败")
+		return
+	}
+
+	c.JSON(http.StatusAccepted, delivery)
+}
+
+func (h *WebhookAdminHandler) respondError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, services.ErrWebhookNotFound):
+		httpx.Error(c, http.StatusNotFound, "Webhook 不存在")
+	case errors.Is(err, services.ErrWebhookDeliveryNotFound):
+		httpx.Error(c, http.StatusNotFound, "投递记录不存在")
+	case errors.Is(err, services.ErrInvalidWebhookURL):
+		httpx.Error(c, http.StatusBadRequest, "Webhook URL 必须是 http 或 https 地址")
+	case errors.Is(err, services.ErrInvalidWebhookEvents):
+		httpx.Error(c, http.StatusBadRequest, "无效的事件类型")
+	default:
+		httpx.Error(c, http.StatusInternalServerError, fallback)
+	}
+}
+
+func webhookResponse(endpoint *models.WebhookEndpoint) gin.H {
+	events := endpoint.EventList()
+	if events == nil {
+		events = []string{}
+	}
+	return gin.H{
+		"id":         endpoint.ID,
+		"name":       endpoint.Name,
+		"url":        endpoint.URL,
+		"events":     events,
+		"enabled":    endpoint.Enabled,
+		"created_at": endpoint.CreatedAt,
+		"updated_at": endpoint.UpdatedAt,
+	}
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hdu-dp/backend/internal/httpx"
+	"github.com/hdu-dp/backend/internal/services"
+)
+
+// APITokenHandler lets users manage personal access tokens for scripts and bots.
+type APITokenHandler struct {
+	tokens *services.APITokenService
+}
+
+// NewAPITokenHandler constructs an APITokenHandler.
+func NewAPITokenHandler(tokens *services.APITokenService) *APITokenHandler {
+	return &APITokenHandler{tokens: tokens}
+}
+
+// @Summary      个人访问令牌列表
+// @Description  列出当前用户未撤销的个人访问令牌（不含令牌明文）。
+// @Tags         用户
+// @Produce      json
+// @Success      200 {object} object{data=[]object{id=string,name=string,prefix=string,scopes=[]string,expires_at=string,last_used_at=string,created_at=string,expired=bool}}
+// @Failure      401 {object} object{error=string} "未认证"
+// @Security     ApiKeyAuth
+// @Router       /users/me/tokens [get]
+func (h *APITokenHandler) List(c *gin.Context) {
+	userID, ok := httpx.MustContextUUID(c, "user_id", "missing user", "invalid user id")
+	if !ok {
+		return
+	}
+
+	tokens, err := h.tokens.List(c.Request.Context(), userID)
+	if err != nil {
+		httpx.Error(c, http.StatusInternalServerError, "获取访问令牌失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": tokens})
+}
+
+// @Summary      创建个人访问令牌
+// @Description  创建带权限范围的个人访问令牌，供脚本以 Authorization: Bearer 调用接口。令牌明文仅在本次响应中返回。可选权限：profile:read、reviews:read、reviews:write、admin（仅管理员）。
+// @Tags         用户
+// @Accept       json
+// @Produce      json
+// @Param        body body object{name=string,scopes=[]string,expires_in_days=integer} true "名称、权限范围与有效天数（省略或 0 表示永不过期）"
+// @Success      201 {object} object{id=string,name=string,prefix=string,scopes=[]string,expires_at=string,created_at=string,token=string}
+// @Failure      400 {object} object{error=string} "参数错误或权限范围无效"
+// @Failure      401 {object} object{error=string} "未认证"
+// @Failure      409 {object} object{error=string} "令牌数量已达上限"
+// @Security     ApiKeyAuth
+// @Router       /users/me/tokens [post]
+func (h *APITokenHandler) Create(c *gin.Context) {
+	userID, ok := httpx.MustContextUUID(c, "user_id", "missing user", "invalid user id")
+	if !ok {
+		return
+	}
+	var req struct {
+		Name          string   `json:"name" binding:"required,max=64"`
+		Scopes        []string `json:"scopes" binding:"required,min=1"`
+		ExpiresInDays int      `json:"expires_in_days" binding:"min=0,max=3650"`
+	}
+	if !httpx.BindJSON(c, &req, "请填写令牌名称（不超过 64 个字符）并至少选择一个权限范围") {
+		return
+	}
+
+	created, err := h.tokens.Create(c.Request.Context(), userID, req.Name, req.Scopes, time.Duration(req.ExpiresInDays)*24*time.Hour)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidAPITokenScope):
+			httpx.Error(c, http.StatusBadRequest, "权限范围无效")
+		case errors.Is(err, services.ErrTooManyAPITokens):
+			httpx.Error(c, http.StatusConflict, "访问令牌数量已达上限，请先撤销不用的令牌")
+		case errors.Is(err, services.ErrUserNotFound):
+			httpx.Error(c, http.StatusNotFound, "用户不存在")
+		default:
+			httpx.Error(c, http.StatusInternalServerError, "创建访问令牌失败")
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, created)
+}
+
+// @Summary      撤销个人访问令牌
+// @Description  立即撤销当前用户的某个个人访问令牌。
+// @Tags         用户
+// @Produce      json
+// @Param        id path string true "令牌ID"
+// @Success      204 "已撤销"
+// @Failure      401 {object} object{error=string} "未认证"
+// @Failure      404 {object} object{error=string} "令牌不存在"
+// @Security     ApiKeyAuth
+// @Router       /users/me/tokens/{id} [delete]
+func (h *APITokenHandler) Revoke(c *gin.Context) {
+	userID, ok := httpx.MustContextUUID(c, "user_id", "missing user", "invalid user id")
+	if !ok {
+		return
+	}
+	tokenID, ok := httpx.ParamUUID(c, "id", "无效的令牌ID")
+	if !ok {
+		return
+	}
+
+	if err := h.tokens.Revoke(c.Request.Context(), userID, tokenID); err != nil {
+		if errors.Is(err, services.ErrAPITokenNotFound) {
+			httpx.Error(c, http.StatusNotFound, "访问令牌不存在或已撤销")
+			return
+		}
+		httpx.Error(c, http.StatusInternalServerError, "撤销访问令牌失败")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
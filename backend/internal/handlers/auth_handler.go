@@ -7,8 +7,10 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/hdu-dp/backend/internal/common"
+	"github.com/hdu-dp/backend/internal/emailtemplate"
 	"github.com/hdu-dp/backend/internal/httpx"
 	"github.com/hdu-dp/backend/internal/models"
+	"github.com/hdu-dp/backend/internal/ratelimit"
 	"github.com/hdu-dp/backend/internal/services"
 )
 
@@ -16,13 +18,15 @@ import (
 type AuthHandler struct {
 	authService              *services.AuthService
 	emailVerificationService *services.EmailVerificationService
+	cookies                  *RefreshCookies
 }
 
 // NewAuthHandler constructs an AuthHandler.
-func NewAuthHandler(authService *services.AuthService, emailVerificationService *services.EmailVerificationService) *AuthHandler {
+func NewAuthHandler(authService *services.AuthService, emailVerificationService *services.EmailVerificationService, cookies *RefreshCookies) *AuthHandler {
 	return &AuthHandler{
 		authService:              authService,
 		emailVerificationService: emailVerificationService,
+		cookies:                  cookies,
 	}
 }
 
@@ -31,17 +35,19 @@ func NewAuthHandler(authService *services.AuthService, emailVerificationService
 // @Tags         认证
 // @Accept       json
 // @Produce      json
-// @Param        body body object{email=string,password=string,display_name=string,code=string} true "注册信息"
+// @Param        body body object{email=string,password=string,display_name=string,code=string,locale=string} true "注册信息（locale 可选，zh-CN 或 en，缺省时按 Accept-Language 选择）"
 // @Success      201  {object} object{access_token=string,refresh_token=string,user=object{id=integer,email=string,display_name=string,role=string,created_at=string,email_verified=bool}} "注册成功"
-// @Failure      400  {object} object{error=string} "请求参数错误"
-// @Failure      409  {object} object{error=string} "邮箱已被占用"
+// @Failure      400  {object} object{error=string,code=string,limit=integer} "请求参数错误或密码不符合要求（code 说明原因）"
+// @Failure      409  {object} object{error=string} "邮箱或昵称已被占用"
+// @Failure      429  {object} object{error=string} "验证码错误次数过多"
 // @Router       /auth/register [post]
 func (h *AuthHandler) Register(c *gin.Context) {
 	var req struct {
 		Email       string `json:"email" binding:"required,email"`
-		Password    string `json:"password" binding:"required,min=6"`
+		Password    string `json:"password" binding:"required"`
 		DisplayName string `json:"display_name" binding:"required,max=64"`
 		Code        string `json:"code" binding:"required,len=6"`
+		Locale      string `json:"locale" binding:"max=16"`
 	}
 
 	if !httpx.BindJSON(c, &req, "请输入完整且有效的注册信息") {
@@ -56,6 +62,9 @@ func (h *AuthHandler) Register(c *gin.Context) {
 	var verification *models.EmailVerification
 	var err error
 	if verification, err = h.emailVerificationService.ValidateRegistrationCode(c.Request.Context(), req.Email, req.Code); err != nil {
+		if respondRateLimited(c, err) {
+			return
+		}
 		switch {
 		case errors.Is(err, services.ErrVerificationCodeRequired):
 			httpx.Error(c, http.StatusBadRequest, "请输入验证码")
@@ -69,11 +78,18 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
-	result, err := h.authService.Register(req.Email, req.Password, req.DisplayName)
+	result, err := h.authService.Register(req.Email, req.Password, req.DisplayName, requestLocale(c, req.Locale), clientInfo(c))
 	if err != nil {
-		switch err {
-		case common.ErrEmailAlreadyUsed:
+		if respondPasswordRejected(c, err) {
+			return
+		}
+		switch {
+		case errors.Is(err, common.ErrEmailAlreadyUsed):
 			httpx.Error(c, http.StatusConflict, err.Error())
+		case errors.Is(err, services.ErrInvalidDisplayName):
+			httpx.Error(c, http.StatusBadRequest, "昵称需为 2-30 个字符，且不能包含控制字符")
+		case errors.Is(err, services.ErrDisplayNameTaken):
+			httpx.Error(c, http.StatusConflict, "该昵称已被使用")
 		default:
 			httpx.Error(c, http.StatusBadRequest, err.Error())
 		}
@@ -92,7 +108,7 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		}
 	}
 
-	respondAuthSuccess(c, http.StatusCreated, result)
+	respondAuthSuccess(c, h.cookies, http.StatusCreated, result)
 }
 
 // @Summary      用户登录
@@ -104,6 +120,7 @@ func (h *AuthHandler) Register(c *gin.Context) {
 // @Success      200  {object} object{access_token=string,refresh_token=string,user=object{id=integer,email=string,display_name=string,role=string,created_at=string,email_verified=bool}} "登录成功"
 // @Failure      400  {object} object{error=string} "请求参数错误"
 // @Failure      401  {object} object{error=string} "邮箱或密码错误"
+// @Failure      429  {object} object{error=string} "登录失败次数过多，请稍后再试"
 // @Router       /auth/login [post]
 func (h *AuthHandler) Login(c *gin.Context) {
 	var req struct {
@@ -115,8 +132,11 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
-	result, err := h.authService.Login(req.Email, req.Password)
+	result, err := h.authService.Login(c.Request.Context(), req.Email, req.Password, clientInfo(c))
 	if err != nil {
+		if respondRateLimited(c, err) {
+			return
+		}
 		switch err {
 		case common.ErrInvalidCredentials:
 			httpx.Error(c, http.StatusUnauthorized, err.Error())
@@ -126,7 +146,7 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
-	respondAuthSuccess(c, http.StatusOK, result)
+	respondAuthSuccess(c, h.cookies, http.StatusOK, result)
 }
 
 // QQAuthURL returns a QQ oauth authorize URL.
@@ -159,7 +179,7 @@ func (h *AuthHandler) QQLogin(c *gin.Context) {
 		return
 	}
 
-	result, err := h.authService.LoginWithQQ(req.Code, req.State)
+	result, err := h.authService.LoginWithQQ(req.Code, req.State, clientInfo(c))
 	if err != nil {
 		switch err {
 		case common.ErrQQServiceUnavailable:
@@ -172,7 +192,7 @@ func (h *AuthHandler) QQLogin(c *gin.Context) {
 		return
 	}
 
-	respondAuthSuccess(c, http.StatusOK, result)
+	respondAuthSuccess(c, h.cookies, http.StatusOK, result)
 }
 
 // WeChatLogin handles login with WeChat code.
@@ -185,7 +205,7 @@ func (h *AuthHandler) WeChatLogin(c *gin.Context) {
 		return
 	}
 
-	result, err := h.authService.LoginWithWeChat(req.Code)
+	result, err := h.authService.LoginWithWeChat(req.Code, clientInfo(c))
 	if err != nil {
 		slog.Error("wechat login failed", slog.Any("error", err))
 
@@ -200,7 +220,7 @@ func (h *AuthHandler) WeChatLogin(c *gin.Context) {
 		return
 	}
 
-	respondAuthSuccess(c, http.StatusOK, result)
+	respondAuthSuccess(c, h.cookies, http.StatusOK, result)
 }
 
 // SendSMSCode sends login verification code to a phone number.
@@ -212,13 +232,18 @@ func (h *AuthHandler) SendSMSCode(c *gin.Context) {
 		return
 	}
 
-	code, err := h.authService.SendSMSLoginCode(req.Phone)
+	code, err := h.authService.SendSMSLoginCode(c.Request.Context(), req.Phone)
 	if err != nil {
+		if respondRateLimited(c, err) {
+			return
+		}
 		switch err {
 		case common.ErrInvalidPhoneNumber:
 			httpx.Error(c, http.StatusBadRequest, "手机号格式不正确")
 		case common.ErrSMSServiceUnavailable:
 			httpx.Error(c, http.StatusServiceUnavailable, "短信登录暂不可用")
+		case common.ErrSMSDeliveryFailed:
+			httpx.Error(c, http.StatusBadGateway, "短信发送失败，请稍后重试")
 		default:
 			httpx.Error(c, http.StatusInternalServerError, "发送短信验证码失败")
 		}
@@ -242,8 +267,11 @@ func (h *AuthHandler) SMSLogin(c *gin.Context) {
 		return
 	}
 
-	result, err := h.authService.LoginWithSMS(req.Phone, req.Code)
+	result, err := h.authService.LoginWithSMS(c.Request.Context(), req.Phone, req.Code, clientInfo(c))
 	if err != nil {
+		if respondRateLimited(c, err) {
+			return
+		}
 		switch err {
 		case common.ErrInvalidPhoneNumber:
 			httpx.Error(c, http.StatusBadRequest, "手机号格式不正确")
@@ -257,29 +285,32 @@ func (h *AuthHandler) SMSLogin(c *gin.Context) {
 		return
 	}
 
-	respondAuthSuccess(c, http.StatusOK, result)
+	respondAuthSuccess(c, h.cookies, http.StatusOK, result)
 }
 
 // @Summary      刷新令牌
-// @Description  使用有效的刷新令牌获取新的访问令牌和刷新令牌。
+// @Description  使用有效的刷新令牌获取新的访问令牌和刷新令牌。浏览器以 Cookie 模式登录时无需请求体，但须在 X-CSRF-Token 头中回传 csrf_token。
 // @Tags         认证
 // @Accept       json
 // @Produce      json
-// @Param        body body object{refresh_token=string} true "刷新令牌"
-// @Success      200  {object} object{access_token=string,refresh_token=string,user=object{id=integer,email=string,display_name=string,role=string,created_at=string,email_verified=bool}} "刷新成功"
+// @Param        body body object{refresh_token=string} false "刷新令牌（Cookie 模式下省略）"
+// @Param        X-CSRF-Token header string false "Cookie 模式下必填，值为 csrf_token Cookie"
+// @Success      200  {object} object{access_token=string,refresh_token=string,csrf_token=string,user=object{id=integer,email=string,display_name=string,role=string,created_at=string,email_verified=bool}} "刷新成功"
 // @Failure      400  {object} object{error=string} "请求参数错误"
 // @Failure      401  {object} object{error=string} "无效的刷新令牌"
+// @Failure      403  {object} object{error=string} "CSRF 校验失败"
 // @Router       /auth/refresh [post]
 func (h *AuthHandler) Refresh(c *gin.Context) {
-	var req struct {
-		RefreshToken string `json:"refresh_token" binding:"required"`
-	}
-	if !httpx.BindJSON(c, &req, "refresh_token 不能为空") {
+	token, fromCookie, ok := h.refreshToken(c)
+	if !ok {
 		return
 	}
 
-	result, err := h.authService.Refresh(req.RefreshToken)
+	result, err := h.authService.Refresh(c.Request.Context(), token, clientInfo(c))
 	if err != nil {
+		if fromCookie {
+			h.cookies.clear(c)
+		}
 		switch err {
 		case common.ErrInvalidRefreshToken:
 			httpx.Error(c, http.StatusUnauthorized, err.Error())
@@ -289,28 +320,31 @@ func (h *AuthHandler) Refresh(c *gin.Context) {
 		return
 	}
 
-	respondAuthSuccess(c, http.StatusOK, result)
+	respondAuthSuccess(c, h.cookies, http.StatusOK, result)
 }
 
 // @Summary      用户登出
-// @Description  接收刷新令牌并使其失效。
+// @Description  接收刷新令牌并使其失效。Cookie 模式下从 Cookie 读取刷新令牌并校验 X-CSRF-Token，同时清除 Cookie。
 // @Tags         认证
 // @Accept       json
 // @Produce      json
-// @Param        body body object{refresh_token=string} true "刷新令牌"
+// @Param        body body object{refresh_token=string} false "刷新令牌（Cookie 模式下省略）"
+// @Param        X-CSRF-Token header string false "Cookie 模式下必填，值为 csrf_token Cookie"
 // @Success      204 "登出成功"
 // @Failure      400  {object} object{error=string} "请求参数错误"
 // @Failure      401  {object} object{error=string} "无效的刷新令牌"
+// @Failure      403  {object} object{error=string} "CSRF 校验失败"
 // @Router       /auth/logout [post]
 func (h *AuthHandler) Logout(c *gin.Context) {
-	var req struct {
-		RefreshToken string `json:"refresh_token" binding:"required"`
-	}
-	if !httpx.BindJSON(c, &req, "refresh_token 不能为空") {
+	token, fromCookie, ok := h.refreshToken(c)
+	if !ok {
 		return
 	}
+	if fromCookie {
+		h.cookies.clear(c)
+	}
 
-	if err := h.authService.Logout(req.RefreshToken); err != nil {
+	if err := h.authService.Logout(token); err != nil {
 		switch err {
 		case common.ErrInvalidRefreshToken:
 			httpx.Error(c, http.StatusUnauthorized, err.Error())
@@ -323,8 +357,52 @@ func (h *AuthHandler) Logout(c *gin.Context) {
 	c.Status(http.StatusNoContent)
 }
 
-func respondAuthSuccess(c *gin.Context, status int, result *services.AuthResult) {
-	c.JSON(status, gin.H{
+// refreshToken reads the refresh token from the cookie, enforcing the CSRF header, or else from the JSON body.
+// fromCookie reports which source was used; ok is false once an error response has been written.
+func (h *AuthHandler) refreshToken(c *gin.Context) (token string, fromCookie bool, ok bool) {
+	if token, found := h.cookies.token(c); found {
+		if !h.cookies.checkCSRF(c) {
+			httpx.Error(c, http.StatusForbidden, "CSRF 校验失败，请刷新页面后重试")
+			return "", true, false
+		}
+		return token, true, true
+	}
+
+	var req struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+	if !httpx.BindJSON(c, &req, "refresh_token 不能为空") {
+		return "", false, false
+	}
+	return req.RefreshToken, false, true
+}
+
+// respondAuthSuccess writes the token pair and user profile.
+// When the user still owes a second factor, only the MFA challenge is returned; the client completes
+// the login through /auth/mfa/verify, or /auth/mfa/enroll when mfa_enrollment_required is set.
+// Browser clients in cookie mode receive the refresh token as an HttpOnly cookie instead of in the body.
+func respondAuthSuccess(c *gin.Context, cookies *RefreshCookies, status int, result *services.AuthResult) {
+	if result.MFAToken != "" {
+		c.JSON(status, gin.H{
+			"mfa_required":            true,
+			"mfa_enrollment_required": result.MFAEnrollmentRequired,
+			"mfa_token":               result.MFAToken,
+			"expires_in":              int(result.MFAExpiresIn.Seconds()),
+		})
+		return
+	}
+
+	body := authSuccessBody(result)
+	if err := cookies.apply(c, body, result.RefreshToken); err != nil {
+		httpx.Error(c, http.StatusInternalServerError, "登录失败，请稍后重试")
+		return
+	}
+	c.JSON(status, body)
+}
+
+// authSuccessBody is the JSON body for an issued token pair.
+func authSuccessBody(result *services.AuthResult) gin.H {
+	return gin.H{
 		"access_token":  result.AccessToken,
 		"refresh_token": result.RefreshToken,
 		"user": gin.H{
@@ -334,10 +412,44 @@ func respondAuthSuccess(c *gin.Context, status int, result *services.AuthResult)
 			"qq_open_id":        result.User.QQOpenID,
 			"wechat_open_id":    result.User.WeChatOpenID,
 			"display_name":      result.User.DisplayName,
+			"avatar_url":        result.User.AvatarURL,
+			"locale":            result.User.Locale,
 			"role":              result.User.Role,
 			"email_verified":    result.User.EmailVerified,
 			"email_verified_at": result.User.EmailVerifiedAt,
+			"campus_verified":   result.User.CampusVerified,
 			"created_at":        result.User.CreatedAt,
 		},
-	})
+	}
+}
+
+// respondRateLimited writes a 429 for rate-limit and attempt-cap errors and reports whether it did.
+func respondRateLimited(c *gin.Context, err error) bool {
+	var limited *ratelimit.LimitedError
+	var locked *services.AccountLockedError
+	switch {
+	case errors.As(err, &locked):
+		httpx.TooManyRequests(c, locked.RetryAfterSeconds(), "密码错误次数过多，账户已被临时锁定，请稍后再试或联系管理员")
+	case errors.As(err, &limited):
+		httpx.TooManyRequests(c, limited.RetryAfterSeconds(), "请求过于频繁，请稍后再试")
+	case errors.Is(err, common.ErrTooManyVerificationAttempts):
+		httpx.Error(c, http.StatusTooManyRequests, "验证码错误次数过多，请重新获取")
+	default:
+		return false
+	}
+	return true
+}
+
+// requestLocale picks the email locale from an explicit value or the Accept-Language header.
+func requestLocale(c *gin.Context, explicit string) string {
+	return emailtemplate.MatchLocale(explicit, c.GetHeader("Accept-Language"))
+}
+
+// clientInfo describes the caller for session tracking. Clients may name the device via X-Device-Name.
+func clientInfo(c *gin.Context) services.ClientInfo {
+	return services.ClientInfo{
+		IP:         c.ClientIP(),
+		UserAgent:  c.GetHeader("User-Agent"),
+		DeviceName: c.GetHeader("X-Device-Name"),
+	}
 }
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hdu-dp/backend/internal/captcha"
+	"github.com/hdu-dp/backend/internal/httpx"
+)
+
+// CaptchaHandler tells clients whether to show a captcha and issues built-in challenges.
+type CaptchaHandler struct {
+	guard *captcha.Guard
+}
+
+// NewCaptchaHandler constructs a CaptchaHandler.
+func NewCaptchaHandler(guard *captcha.Guard) *CaptchaHandler {
+	return &CaptchaHandler{guard: guard}
+}
+
+// @Summary      人机验证配置
+// @Description  返回人机验证的服务商与站点公钥，以及当前 IP 的下一次注册或发送验证码请求是否需要验证。服务商为 builtin 且需要验证时附带一张图片验证码，作答后在 X-Captcha-Token 请求头中提交 "<challenge.id>:<答案>"；第三方服务商则提交其组件返回的令牌。
+// @Tags         认证
+// @Produce      json
+// @Success      200 {object} object{provider=string,site_key=string,required=bool,challenge=object{id=string,image=string,expires_at=string}}
+// @Router       /auth/captcha [get]
+func (h *CaptchaHandler) Get(c *gin.Context) {
+	required, err := h.guard.Required(c.Request.Context(), c.ClientIP())
+	if err != nil {
+		slog.Warn("captcha requirement check failed", slog.Any("error", err))
+	}
+
+	body := gin.H{
+		"provider": h.guard.Provider(),
+		"site_key": h.guard.SiteKey(),
+		"required": required,
+	}
+	if required {
+		challenge, err := h.guard.NewChallenge(c.Request.Context())
+		if err != nil {
+			httpx.Error(c, http.StatusInternalServerError, "生成验证码失败")
+			return
+		}
+		if challenge != nil {
+			body["challenge"] = challenge
+		}
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, body)
+}
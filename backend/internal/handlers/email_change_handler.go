@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hdu-dp/backend/internal/common"
+	"github.com/hdu-dp/backend/internal/httpx"
+	"github.com/hdu-dp/backend/internal/services"
+)
+
+// EmailChangeHandler exposes the change-email flow: request, confirm from the new address, undo from the old one.
+type EmailChangeHandler struct {
+	changes *services.EmailChangeService
+}
+
+// NewEmailChangeHandler constructs an EmailChangeHandler.
+func NewEmailChangeHandler(changes *services.EmailChangeService) *EmailChangeHandler {
+	return &EmailChangeHandler{changes: changes}
+}
+
+// @Summary      更换邮箱
+// @Description  向新邮箱发送确认链接，确认前账户邮箱不变。已有邮箱的账户需提供当前密码；通过 QQ、微信或短信注册的账户在此设置登录密码，确认后与新邮箱一同生效。
+// @Tags         用户
+// @Accept       json
+// @Produce      json
+// @Param        body body object{email=string,password=string} true "新邮箱与密码"
+// @Success      202 {object} object{message=string,new_email=string,expires_at=string}
+// @Failure      400 {object} object{error=string} "参数错误、密码不正确或邮箱未改变"
+// @Failure      401 {object} object{error=string} "未认证"
+// @Failure      409 {object} object{error=string} "邮箱已被其他账户使用"
+// @Failure      429 {object} object{error=string} "请求过于频繁或密码输错过多，响应头 Retry-After 为需等待的秒数"
+// @Security     ApiKeyAuth
+// @Router       /users/me/email [post]
+func (h *EmailChangeHandler) Start(c *gin.Context) {
+	userID, ok := httpx.MustContextUUID(c, "user_id", "missing user", "invalid user id")
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Email    string `json:"email" binding:"required,email"`
+		Password string `json:"password" binding:"required"`
+	}
+	if !httpx.BindJSON(c, &req, "请输入有效的新邮箱和密码") {
+		return
+	}
+
+	pending, err := h.changes.Start(c.Request.Context(), userID, req.Email, req.Password)
+	if err != nil {
+		if respondRateLimited(c, err) || respondPasswordRejected(c, err) {
+			return
+		}
+		switch {
+		case errors.Is(err, common.ErrInvalidCredentials):
+			httpx.Error(c, http.StatusBadRequest, "密码不正确")
+		case errors.Is(err, services.ErrSameEmail):
+			httpx.Error(c, http.StatusBadRequest, "新邮箱与当前邮箱相同")
+		case errors.Is(err, services.ErrEmailAlreadyUsed):
+			httpx.Error(c, http.StatusConflict, "该邮箱已被其他账户使用")
+		case errors.Is(err, services.ErrUserNotFound):
+			httpx.Error(c, http.StatusNotFound, "用户不存在")
+		case errors.Is(err, services.ErrEmailServiceNotConfigured):
+			httpx.Error(c, http.StatusInternalServerError, "邮件服务未配置，请联系管理员")
+		default:
+			httpx.Error(c, http.StatusInternalServerError, "发送确认邮件失败，请稍后重试")
+		}
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":    "确认邮件已发送至新邮箱，请在邮件中完成确认",
+		"new_email":  pending.NewEmail,
+		"expires_at": pending.ExpiresAt,
+	})
+}
+
+// @Summary      确认更换邮箱
+// @Description  使用发送到新邮箱的令牌完成更换，新邮箱同时视为已验证。原邮箱会收到包含撤销链接的通知。
+// @Tags         认证
+// @Accept       json
+// @Produce      json
+// @Param        body body object{token=string} true "确认令牌"
+// @Success      200 {object} object{message=string,email=string,email_verified=bool,campus_verified=bool}
+// @Failure      400 {object} object{error=string} "令牌无效或已过期"
+// @Failure      409 {object} object{error=string} "邮箱已被其他账户使用"
+// @Router       /auth/email-change/confirm [post]
+func (h *EmailChangeHandler) Confirm(c *gin.Context) {
+	var req struct {
+		Token string `json:"token" binding:"required"`
+	}
+	if !httpx.BindJSON(c, &req, "请提供确认令牌") {
+		return
+	}
+
+	user, err := h.changes.Confirm(c.Request.Context(), req.Token)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidEmailChangeToken):
+			httpx.Error(c, http.StatusBadRequest, "确认链接无效或已过期，请重新申请")
+		case errors.Is(err, services.ErrEmailAlreadyUsed):
+			httpx.Error(c, http.StatusConflict, "该邮箱已被其他账户使用")
+		default:
+			httpx.Error(c, http.StatusInternalServerError, "更换邮箱失败")
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":         "邮箱已更换",
+		"email":           user.Email,
+		"email_verified":  user.EmailVerified,
+		"campus_verified": user.CampusVerified,
+	})
+}
+
+// @Summary      撤销更换邮箱
+// @Description  使用发送到原邮箱的令牌恢复原邮箱，并使该用户所有设备上的登录状态失效。
+// @Tags         认证
+// @Accept       json
+// @Produce      json
+// @Param        body body object{token=string} true "撤销令牌"
+// @Success      200 {object} object{message=string}
+// @Failure      400 {object} object{error=string} "令牌无效或已过期"
+// @Failure      409 {object} object{error=string} "原邮箱已被其他账户使用"
+// @Router       /auth/email-change/undo [post]
+func (h *EmailChangeHandler) Undo(c *gin.Context) {
+	var req struct {
+		Token string `json:"token" binding:"required"`
+	}
+	if !httpx.BindJSON(c, &req, "请提供撤销令牌") {
+		return
+	}
+
+	if err := h.changes.Undo(c.Request.Context(), req.Token); err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidEmailChangeToken):
+			httpx.Error(c, http.StatusBadRequest, "撤销链接无效或已过期")
+		case errors.Is(err, services.ErrEmailAlreadyUsed):
+			httpx.Error(c, http.StatusConflict, "原邮箱已被其他账户使用，请联系管理员")
+		default:
+			httpx.Error(c, http.StatusInternalServerError, "撤销更换邮箱失败")
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "已恢复原邮箱，所有设备已退出登录，建议立即重置密码"})
+}
@@ -27,21 +27,26 @@ func NewEmailVerificationHandler(emailVerificationService *services.EmailVerific
 // @Tags         认证
 // @Accept       json
 // @Produce      json
-// @Param        body body object{email=string} true "邮箱地址"
+// @Param        body body object{email=string,locale=string} true "邮箱地址（locale 可选，zh-CN 或 en）"
 // @Success      200 {object} object{message=string}
 // @Failure      400 {object} object{error=string}
 // @Failure      409 {object} object{error=string}
+// @Failure      429 {object} object{error=string} "请求过于频繁，响应头 Retry-After 为需等待的秒数"
 // @Router       /auth/send-code [post]
 func (h *EmailVerificationHandler) SendRegistrationCode(c *gin.Context) {
 	var req struct {
-		Email string `json:"email" binding:"required,email"`
+		Email  string `json:"email" binding:"required,email"`
+		Locale string `json:"locale" binding:"max=16"`
 	}
 
 	if !httpx.BindJSON(c, &req, "请输入有效的邮箱地址") {
 		return
 	}
 
-	if err := h.emailVerificationService.SendRegistrationCode(c.Request.Context(), req.Email); err != nil {
+	if err := h.emailVerificationService.SendRegistrationCode(c.Request.Context(), req.Email, requestLocale(c, req.Locale)); err != nil {
+		if respondRateLimited(c, err) {
+			return
+		}
 		switch {
 		case errors.Is(err, services.ErrEmailAlreadyUsed):
 			httpx.Error(c, http.StatusConflict, "该邮箱已注册")
@@ -64,12 +69,16 @@ func (h *EmailVerificationHandler) SendRegistrationCode(c *gin.Context) {
 // @Failure      400 {object} object{error=string}
 // @Failure      401 {object} object{error=string}
 // @Failure      409 {object} object{error=string}
+// @Failure      429 {object} object{error=string} "请求过于频繁，响应头 Retry-After 为需等待的秒数"
 // @Security     ApiKeyAuth
 // @Router       /auth/send-verification [post]
 func (h *EmailVerificationHandler) SendVerificationEmail(c *gin.Context) {
 	userID := c.MustGet("user_id").(uuid.UUID)
 
 	if err := h.emailVerificationService.ResendVerificationEmail(c.Request.Context(), userID); err != nil {
+		if respondRateLimited(c, err) {
+			return
+		}
 		switch {
 		case errors.Is(err, services.ErrUserNotFound):
 			httpx.Error(c, http.StatusBadRequest, "用户不存在")
@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hdu-dp/backend/internal/common"
+	"github.com/hdu-dp/backend/internal/httpx"
+	"github.com/hdu-dp/backend/internal/services"
+)
+
+// IdentityHandler lets users bind and unbind login methods on their account.
+type IdentityHandler struct {
+	identities *services.IdentityService
+}
+
+// NewIdentityHandler constructs an IdentityHandler.
+func NewIdentityHandler(identities *services.IdentityService) *IdentityHandler {
+	return &IdentityHandler{identities: identities}
+}
+
+// @Summary      已绑定的登录方式
+// @Description  列出当前账号绑定的邮箱、手机号、QQ 与微信。
+// @Tags         用户
+// @Produce      json
+// @Success      200 {object} object{email=string,email_verified=bool,phone=string,qq=bool,wechat=bool}
+// @Failure      401 {object} object{error=string} "未认证"
+// @Security     ApiKeyAuth
+// @Router       /users/me/identities [get]
+func (h *IdentityHandler) List(c *gin.Context) {
+	userID, ok := httpx.MustContextUUID(c, "user_id", "missing user", "invalid user id")
+	if !ok {
+		return
+	}
+
+	identities, err := h.identities.List(userID)
+	if err != nil {
+		respondIdentityError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, identities)
+}
+
+// @Summary      绑定 QQ
+// @Description  使用 QQ 授权回调中的 code 与 state 将 QQ 绑定到当前账号。
+// @Tags         用户
+// @Accept       json
+// @Produce      json
+// @Param        body body object{code=string,state=string} true "QQ 授权参数"
+// @Success      200 {object} object{email=string,email_verified=bool,phone=string,qq=bool,wechat=bool}
+// @Failure      409 {object} object{error=string} "已绑定或已被其他账号使用"
+// @Security     ApiKeyAuth
+// @Router       /users/me/identities/qq [post]
+func (h *IdentityHandler) BindQQ(c *gin.Context) {
+	userID, ok := httpx.MustContextUUID(c, "user_id", "missing user", "invalid user id")
+	if !ok {
+		return
+	}
+	var req struct {
+		Code  string `json:"code" binding:"required"`
+		State string `json:"state" binding:"required"`
+	}
+	if !httpx.BindJSON(c, &req, "缺少 QQ 授权参数") {
+		return
+	}
+
+	identities, err := h.identities.BindQQ(userID, req.Code, req.State)
+	if err != nil {
+		respondIdentityError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, identities)
+}
+
+// @Summary      绑定微信
+// @Description  使用小程序 wx.login 返回的 code 将微信绑定到当前账号。
+// @Tags         用户
+// @Accept       json
+// @Produce      json
+// @Param        body body object{code=string} true "微信登录凭证"
+// @Success      200 {object} object{email=string,email_verified=bool,phone=string,qq=bool,wechat=bool}
+// @Failure      409 {object} object{error=string} "已绑定或已被其他账号使用"
+// @Security     ApiKeyAuth
+// @Router       /users/me/identities/wechat [post]
+func (h *IdentityHandler) BindWeChat(c *gin.Context) {
+	userID, ok := httpx.MustContextUUID(c, "user_id", "missing user", "invalid user id")
+	if !ok {
+		return
+	}
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if !httpx.BindJSON(c, &req, "缺少微信登录凭证") {
+		return
+	}
+
+	identities, err := h.identities.BindWeChat(userID, req.Code)
+	if err != nil {
+		respondIdentityError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, identities)
+}
+
+// @Summary      绑定手机号
+// @Description  先调用 /auth/sms/send-code 获取验证码，再提交手机号与验证码完成绑定。
+// @Tags         用户
+// @Accept       json
+// @Produce      json
+// @Param        body body object{phone=string,code=string} true "手机号与验证码"
+// @Success      200 {object} object{email=string,email_verified=bool,phone=string,qq=bool,wechat=bool}
+// @Failure      401 {object} object{error=string} "验证码错误"
+// @Failure      409 {object} object{error=string} "已绑定或已被其他账号使用"
+// @Security     ApiKeyAuth
+// @Router       /users/me/identities/phone [post]
+func (h *IdentityHandler) BindPhone(c *gin.Context) {
+	userID, ok := httpx.MustContextUUID(c, "user_id", "missing user", "invalid user id")
+	if !ok {
+		return
+	}
+	var req struct {
+		Phone string `json:"phone" binding:"required"`
+		Code  string `json:"code" binding:"required"`
+	}
+	if !httpx.BindJSON(c, &req, "请输入手机号和验证码") {
+		return
+	}
+
+	identities, err := h.identities.BindPhone(c.Request.Context(), userID, req.Phone, req.Code)
+	if err != nil {
+		respondIdentityError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, identities)
+}
+
+// @Summary      绑定邮箱
+// @Description  通过 QQ、微信或短信注册的账号可绑定邮箱并设置密码。先调用 /auth/send-code 获取邮箱验证码。
+// @Tags         用户
+// @Accept       json
+// @Produce      json
+// @Param        body body object{email=string,code=string,password=string} true "邮箱、验证码与登录密码"
+// @Success      200 {object} object{email=string,email_verified=bool,phone=string,qq=bool,wechat=bool}
+// @Failure      400 {object} object{error=string} "验证码错误"
+// @Failure      409 {object} object{error=string} "已绑定或已被其他账号使用"
+// @Security     ApiKeyAuth
+// @Router       /users/me/identities/email [post]
+func (h *IdentityHandler) BindEmail(c *gin.Context) {
+	userID, ok := httpx.MustContextUUID(c, "user_id", "missing user", "invalid user id")
+	if !ok {
+		return
+	}
+	var req struct {
+		Email    string `json:"email" binding:"required,email"`
+		Code     string `json:"code" binding:"required,len=6"`
+		Password string `json:"password" binding:"required"`
+	}
+	if !httpx.BindJSON(c, &req, "请输入有效的邮箱、验证码和密码") {
+		return
+	}
+
+	identities, err := h.identities.BindEmail(c.Request.Context(), userID, req.Email, req.Code, req.Password)
+	if err != nil {
+		respondIdentityError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, identities)
+}
+
+// @Summary      解绑登录方式
+// @Description  解绑 email、phone、qq 或 wechat，账号至少需要保留一种登录方式。解绑邮箱后密码登录随之失效。
+// @Tags         用户
+// @Produce      json
+// @Param        kind path string true "登录方式" Enums(email, phone, qq, wechat)
+// @Success      200 {object} object{email=string,email_verified=bool,phone=string,qq=bool,wechat=bool}
+// @Failure      400 {object} object{error=string} "不能解绑最后一种登录方式"
+// @Failure      404 {object} object{error=string} "未绑定"
+// @Security     ApiKeyAuth
+// @Router       /users/me/identities/{kind} [delete]
+func (h *IdentityHandler) Unbind(c *gin.Context) {
+	userID, ok := httpx.MustContextUUID(c, "user_id", "missing user", "invalid user id")
+	if !ok {
+		return
+	}
+
+	identities, err := h.identities.Unbind(userID, c.Param("kind"))
+	if err != nil {
+		respondIdentityError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, identities)
+}
+
+// respondIdentityError maps binding errors to responses.
+func respondIdentityError(c *gin.Context, err error) {
+	if respondRateLimited(c, err) || respondPasswordRejected(c, err) {
+		return
+	}
+	switch {
+	case errors.Is(err, services.ErrIdentityInUse):
+		httpx.Error(c, http.StatusConflict, "该登录方式已被其他账号使用，如需合并账号请联系管理员")
+	case errors.Is(err, services.ErrIdentityAlreadyBound):
+		httpx.Error(c, http.StatusConflict, "已绑定该类型的登录方式，请先解绑")
+	case errors.Is(err, services.ErrIdentityNotBound):
+		httpx.Error(c, http.StatusNotFound, "未绑定该登录方式")
+	case errors.Is(err, services.ErrUnknownIdentity):
+		httpx.Error(c, http.StatusBadRequest, "不支持的登录方式")
+	case errors.Is(err, services.ErrLastLoginMethod):
+		httpx.Error(c, http.StatusBadRequest, "至少需要保留一种登录方式")
+	case errors.Is(err, services.ErrUserNotFound):
+		httpx.Error(c, http.StatusNotFound, "用户不存在")
+	case errors.Is(err, common.ErrQQServiceUnavailable):
+		httpx.Error(c, http.StatusServiceUnavailable, "QQ 登录暂不可用")
+	case errors.Is(err, common.ErrInvalidQQState):
+		httpx.Error(c, http.StatusBadRequest, "QQ 授权已失效，请重试")
+	case errors.Is(err, common.ErrWeChatServiceUnavailable):
+		httpx.Error(c, http.StatusServiceUnavailable, "微信登录暂不可用")
+	case errors.Is(err, common.ErrInvalidWeChatCode):
+		httpx.Error(c, http.StatusBadRequest, "微信登录凭证无效")
+	case errors.Is(err, common.ErrSMSServiceUnavailable):
+		httpx.Error(c, http.StatusServiceUnavailable, "短信服务暂不可用")
+	case errors.Is(err, common.ErrInvalidPhoneNumber):
+		httpx.Error(c, http.StatusBadRequest, "手机号格式不正确")
+	case errors.Is(err, common.ErrInvalidSMSCode):
+		httpx.Error(c, http.StatusUnauthorized, "验证码错误或已过期")
+	case errors.Is(err, services.ErrEmailServiceNotConfigured):
+		httpx.Error(c, http.StatusServiceUnavailable, "邮件服务未配置，请联系管理员")
+	case errors.Is(err, services.ErrVerificationCodeRequired), errors.Is(err, services.ErrInvalidVerificationToken):
+		httpx.Error(c, http.StatusBadRequest, "验证码不正确")
+	case errors.Is(err, services.ErrVerificationTokenExpired):
+		httpx.Error(c, http.StatusBadRequest, "验证码已过期，请重新获取")
+	default:
+		httpx.Error(c, http.StatusInternalServerError, "更新登录方式失败")
+	}
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hdu-dp/backend/internal/auth"
+)
+
+// JWKSHandler publishes the public keys that verify access tokens.
+type JWKSHandler struct {
+	keys *auth.KeySet
+}
+
+// NewJWKSHandler constructs a JWKSHandler.
+func NewJWKSHandler(keys *auth.KeySet) *JWKSHandler {
+	return &JWKSHandler{keys: keys}
+}
+
+// @Summary      访问令牌公钥
+// @Description  以 JWKS 格式返回当前用于验证访问令牌的公钥，包含轮换后仍在有效期内的旧密钥，以及即将启用、尚未用于签名的新密钥。
+// @Tags         认证
+// @Produce      json
+// @Success      200 {object} object{keys=[]object}
+// @Router       /.well-known/jwks.json [get]
+func (h *JWKSHandler) Keys(c *gin.Context) {
+	c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(auth.JWKSMaxAge.Seconds())))
+	c.JSON(http.StatusOK, gin.H{"keys": h.keys.JWKS()})
+}
@@ -0,0 +1,295 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hdu-dp/backend/internal/common"
+	"github.com/hdu-dp/backend/internal/httpx"
+	"github.com/hdu-dp/backend/internal/models"
+	"github.com/hdu-dp/backend/internal/repository"
+	"github.com/hdu-dp/backend/internal/services"
+)
+
+// MFAHandler exposes the second login step and authenticator management.
+type MFAHandler struct {
+	authService *services.AuthService
+	mfa         *services.MFAService
+	users       *repository.UserRepository
+	cookies     *RefreshCookies
+}
+
+// NewMFAHandler constructs an MFAHandler.
+func NewMFAHandler(authService *services.AuthService, mfa *services.MFAService, users *repository.UserRepository, cookies *RefreshCookies) *MFAHandler {
+	return &MFAHandler{authService: authService, mfa: mfa, users: users, cookies: cookies}
+}
+
+// @Summary      两步验证登录
+// @Description  登录返回 mfa_required 时，提交 mfa_token 与身份验证器中的 6 位验证码（或一个恢复码）完成登录。
+// @Tags         认证
+// @Accept       json
+// @Produce      json
+// @Param        body body object{mfa_token=string,code=string} true "挑战令牌与验证码"
+// @Success      200 {object} object{access_token=string,refresh_token=string,user=object{id=string,email=string,display_name=string,role=string}} "登录成功"
+// @Failure      400 {object} object{error=string} "参数错误"
+// @Failure      401 {object} object{error=string} "挑战令牌无效或验证码错误"
+// @Failure      429 {object} object{error=string} "验证码错误次数过多"
+// @Router       /auth/mfa/verify [post]
+func (h *MFAHandler) Verify(c *gin.Context) {
+	var req struct {
+		MFAToken string `json:"mfa_token" binding:"required"`
+		Code     string `json:"code" binding:"required"`
+	}
+	if !httpx.BindJSON(c, &req, "请提供 mfa_token 和验证码") {
+		return
+	}
+
+	result, err := h.authService.VerifyMFA(c.Request.Context(), req.MFAToken, req.Code, clientInfo(c))
+	if err != nil {
+		respondMFAError(c, err, "两步验证失败")
+		return
+	}
+
+	respondAuthSuccess(c, h.cookies, http.StatusOK, result)
+}
+
+// @Summary      登录时绑定身份验证器
+// @Description  角色要求两步验证但尚未绑定时，使用登录返回的 mfa_token 生成密钥和 otpauth 链接（可生成二维码）。
+// @Tags         认证
+// @Accept       json
+// @Produce      json
+// @Param        body body object{mfa_token=string} true "挑战令牌"
+// @Success      200 {object} object{secret=string,otpauth_uri=string}
+// @Failure      401 {object} object{error=string} "挑战令牌无效"
+// @Failure      409 {object} object{error=string} "已开启两步验证"
+// @Router       /auth/mfa/enroll [post]
+func (h *MFAHandler) BeginLoginEnrollment(c *gin.Context) {
+	var req struct {
+		MFAToken string `json:"mfa_token" binding:"required"`
+	}
+	if !httpx.BindJSON(c, &req, "请提供 mfa_token") {
+		return
+	}
+
+	enrollment, err := h.authService.BeginMFAEnrollment(c.Request.Context(), req.MFAToken)
+	if err != nil {
+		respondMFAError(c, err, "生成两步验证密钥失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// @Summary      登录时确认绑定身份验证器
+// @Description  提交身份验证器生成的验证码完成绑定并登录，响应中的恢复码只会显示这一次。
+// @Tags         认证
+// @Accept       json
+// @Produce      json
+// @Param        body body object{mfa_token=string,code=string} true "挑战令牌与验证码"
+// @Success      200 {object} object{access_token=string,refresh_token=string,recovery_codes=[]string,user=object{id=string,email=string,display_name=string,role=string}} "登录成功"
+// @Failure      401 {object} object{error=string} "挑战令牌无效或验证码错误"
+// @Failure      429 {object} object{error=string} "验证码错误次数过多"
+// @Router       /auth/mfa/enroll/confirm [post]
+func (h *MFAHandler) ConfirmLoginEnrollment(c *gin.Context) {
+	var req struct {
+		MFAToken string `json:"mfa_token" binding:"required"`
+		Code     string `json:"code" binding:"required"`
+	}
+	if !httpx.BindJSON(c, &req, "请提供 mfa_token 和验证码") {
+		return
+	}
+
+	result, codes, err := h.authService.ConfirmMFAEnrollment(c.Request.Context(), req.MFAToken, req.Code, clientInfo(c))
+	if err != nil {
+		respondMFAError(c, err, "绑定身份验证器失败")
+		return
+	}
+
+	body := authSuccessBody(result)
+	body["recovery_codes"] = codes
+	if err := h.cookies.apply(c, body, result.RefreshToken); err != nil {
+		httpx.Error(c, http.StatusInternalServerError, "绑定身份验证器失败")
+		return
+	}
+	c.JSON(http.StatusOK, body)
+}
+
+// @Summary      两步验证状态
+// @Description  返回当前用户是否已开启两步验证、其角色是否强制要求以及剩余恢复码数量。
+// @Tags         用户
+// @Produce      json
+// @Success      200 {object} object{enabled=bool,required=bool,recovery_codes_remaining=integer}
+// @Failure      401 {object} object{error=string} "未认证"
+// @Security     ApiKeyAuth
+// @Router       /users/me/mfa [get]
+func (h *MFAHandler) Status(c *gin.Context) {
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	status, err := h.mfa.Status(c.Request.Context(), user)
+	if err != nil {
+		httpx.Error(c, http.StatusInternalServerError, "获取两步验证状态失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+// @Summary      绑定身份验证器
+// @Description  生成新的 TOTP 密钥和 otpauth 链接，需调用确认接口后才会生效。
+// @Tags         用户
+// @Produce      json
+// @Success      200 {object} object{secret=string,otpauth_uri=string}
+// @Failure      401 {object} object{error=string} "未认证"
+// @Failure      409 {object} object{error=string} "已开启两步验证"
+// @Security     ApiKeyAuth
+// @Router       /users/me/mfa/totp [post]
+func (h *MFAHandler) BeginEnrollment(c *gin.Context) {
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	enrollment, err := h.mfa.BeginEnrollment(c.Request.Context(), user)
+	if err != nil {
+		respondMFAError(c, err, "生成两步验证密钥失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// @Summary      确认绑定身份验证器
+// @Description  提交身份验证器生成的验证码以开启两步验证，响应中的恢复码只会显示这一次。
+// @Tags         用户
+// @Accept       json
+// @Produce      json
+// @Param        body body object{code=string} true "验证码"
+// @Success      200 {object} object{recovery_codes=[]string}
+// @Failure      400 {object} object{error=string} "尚未生成密钥"
+// @Failure      401 {object} object{error=string} "验证码错误"
+// @Failure      429 {object} object{error=string} "验证码错误次数过多"
+// @Security     ApiKeyAuth
+// @Router       /users/me/mfa/totp/confirm [post]
+func (h *MFAHandler) ConfirmEnrollment(c *gin.Context) {
+	userID, ok := httpx.MustContextUUID(c, "user_id", "missing user", "invalid user id")
+	if !ok {
+		return
+	}
+	code, ok := bindMFACode(c)
+	if !ok {
+		return
+	}
+
+	codes, err := h.mfa.ConfirmEnrollment(c.Request.Context(), userID, code)
+	if err != nil {
+		respondMFAError(c, err, "开启两步验证失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// @Summary      关闭两步验证
+// @Description  提交当前验证码或恢复码后解绑身份验证器并作废所有恢复码。角色强制要求两步验证时不可关闭。
+// @Tags         用户
+// @Accept       json
+// @Produce      json
+// @Param        body body object{code=string} true "验证码或恢复码"
+// @Success      204 "已关闭"
+// @Failure      401 {object} object{error=string} "验证码错误"
+// @Failure      403 {object} object{error=string} "当前角色必须开启两步验证"
+// @Security     ApiKeyAuth
+// @Router       /users/me/mfa/disable [post]
+func (h *MFAHandler) Disable(c *gin.Context) {
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+	code, ok := bindMFACode(c)
+	if !ok {
+		return
+	}
+
+	if err := h.mfa.Disable(c.Request.Context(), user, code); err != nil {
+		respondMFAError(c, err, "关闭两步验证失败")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// @Summary      重新生成恢复码
+// @Description  提交当前验证码后作废旧恢复码并生成一组新的恢复码。
+// @Tags         用户
+// @Accept       json
+// @Produce      json
+// @Param        body body object{code=string} true "验证码或恢复码"
+// @Success      200 {object} object{recovery_codes=[]string}
+// @Failure      401 {object} object{error=string} "验证码错误"
+// @Security     ApiKeyAuth
+// @Router       /users/me/mfa/recovery-codes [post]
+func (h *MFAHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	userID, ok := httpx.MustContextUUID(c, "user_id", "missing user", "invalid user id")
+	if !ok {
+		return
+	}
+	code, ok := bindMFACode(c)
+	if !ok {
+		return
+	}
+
+	codes, err := h.mfa.RegenerateRecoveryCodes(c.Request.Context(), userID, code)
+	if err != nil {
+		respondMFAError(c, err, "生成恢复码失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+func (h *MFAHandler) currentUser(c *gin.Context) (*models.User, bool) {
+	userID, ok := httpx.MustContextUUID(c, "user_id", "missing user", "invalid user id")
+	if !ok {
+		return nil, false
+	}
+	user, err := h.users.FindByID(userID)
+	if err != nil {
+		httpx.Error(c, http.StatusNotFound, "用户不存在")
+		return nil, false
+	}
+	return user, true
+}
+
+func bindMFACode(c *gin.Context) (string, bool) {
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if !httpx.BindJSON(c, &req, "请输入验证码") {
+		return "", false
+	}
+	return req.Code, true
+}
+
+// respondMFAError maps two-factor errors to responses, using fallback for unexpected failures.
+func respondMFAError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, common.ErrTooManyVerificationAttempts):
+		httpx.Error(c, http.StatusTooManyRequests, "验证码错误次数过多，请 15 分钟后再试")
+	case errors.Is(err, services.ErrInvalidMFAChallenge):
+		httpx.Error(c, http.StatusUnauthorized, "登录验证已过期，请重新登录")
+	case errors.Is(err, services.ErrInvalidMFACode):
+		httpx.Error(c, http.StatusUnauthorized, "验证码错误")
+	case errors.Is(err, services.ErrMFAAlreadyEnabled):
+		httpx.Error(c, http.StatusConflict, "已开启两步验证")
+	case errors.Is(err, services.ErrMFANotEnabled):
+		httpx.Error(c, http.StatusBadRequest, "尚未开启两步验证")
+	case errors.Is(err, services.ErrMFARequired):
+		httpx.Error(c, http.StatusForbidden, "当前角色必须开启两步验证")
+	default:
+		httpx.Error(c, http.StatusInternalServerError, fallback)
+	}
+}
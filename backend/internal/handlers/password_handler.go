@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hdu-dp/backend/internal/common"
+	"github.com/hdu-dp/backend/internal/httpx"
+	"github.com/hdu-dp/backend/internal/passwordpolicy"
+	"github.com/hdu-dp/backend/internal/services"
+)
+
+// PasswordHandler exposes password change and reset endpoints.
+type PasswordHandler struct {
+	passwords *services.PasswordService
+}
+
+// NewPasswordHandler constructs a PasswordHandler.
+func NewPasswordHandler(passwords *services.PasswordService) *PasswordHandler {
+	return &PasswordHandler{passwords: passwords}
+}
+
+// @Summary      修改密码
+// @Description  已登录用户提供当前密码后设置新密码，并退出除当前设备外的所有设备。当前密码输错计入登录失败次数。
+// @Tags         用户
+// @Accept       json
+// @Produce      json
+// @Param        body body object{current_password=string,new_password=string} true "当前密码与新密码"
+// @Success      200 {object} object{message=string}
+// @Failure      400 {object} object{error=string,code=string,limit=integer} "参数错误、当前密码不正确或新密码不符合要求（code 说明原因）"
+// @Failure      401 {object} object{error=string} "未认证"
+// @Failure      429 {object} object{error=string} "密码输错过多被延迟或锁定，响应头 Retry-After 为需等待的秒数"
+// @Security     ApiKeyAuth
+// @Router       /users/me/password [post]
+func (h *PasswordHandler) ChangePassword(c *gin.Context) {
+	userID, ok := httpx.MustContextUUID(c, "user_id", "missing user", "invalid user id")
+	if !ok {
+		return
+	}
+
+	var req struct {
+		CurrentPassword string `json:"current_password" binding:"required"`
+		NewPassword     string `json:"new_password" binding:"required"`
+	}
+	if !httpx.BindJSON(c, &req, "请输入当前密码和新密码") {
+		return
+	}
+
+	if err := h.passwords.ChangePassword(c.Request.Context(), userID, currentSessionID(c), req.CurrentPassword, req.NewPassword); err != nil {
+		if respondRateLimited(c, err) || respondPasswordRejected(c, err) {
+			return
+		}
+		switch {
+		case errors.Is(err, common.ErrInvalidCredentials):
+			httpx.Error(c, http.StatusBadRequest, "当前密码不正确")
+		case errors.Is(err, services.ErrSamePassword):
+			httpx.Error(c, http.StatusBadRequest, "新密码不能与当前密码相同")
+		case errors.Is(err, services.ErrUserNotFound):
+			httpx.Error(c, http.StatusNotFound, "用户不存在")
+		default:
+			httpx.Error(c, http.StatusInternalServerError, "修改密码失败")
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "密码已修改"})
+}
+
+// @Summary      忘记密码
+// @Description  向邮箱发送一次性的密码重置链接。无论邮箱是否已注册都返回相同结果。
+// @Tags         认证
+// @Accept       json
+// @Produce      json
+// @Param        body body object{email=string} true "注册邮箱"
+// @Success      200 {object} object{message=string}
+// @Failure      400 {object} object{error=string}
+// @Failure      429 {object} object{error=string} "请求过于频繁，响应头 Retry-After 为需等待的秒数"
+// @Router       /auth/forgot-password [post]
+func (h *PasswordHandler) ForgotPassword(c *gin.Context) {
+	var req struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+	if !httpx.BindJSON(c, &req, "请输入有效的邮箱地址") {
+		return
+	}
+
+	if err := h.passwords.RequestReset(c.Request.Context(), req.Email); err != nil {
+		if respondRateLimited(c, err) {
+			return
+		}
+		switch {
+		case errors.Is(err, services.ErrEmailServiceNotConfigured):
+			httpx.Error(c, http.StatusInternalServerError, "邮件服务未配置，请联系管理员")
+		default:
+			httpx.Error(c, http.StatusInternalServerError, "发送重置邮件失败，请稍后重试")
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "如果该邮箱已注册，重置链接已发送，请查收邮箱"})
+}
+
+// @Summary      重置密码
+// @Description  使用邮件中的重置令牌设置新密码，成功后该用户所有设备上的登录状态都会失效。
+// @Tags         认证
+// @Accept       json
+// @Produce      json
+// @Param        body body object{token=string,new_password=string} true "重置令牌与新密码"
+// @Success      200 {object} object{message=string}
+// @Failure      400 {object} object{error=string,code=string,limit=integer} "参数错误、令牌无效或新密码不符合要求（code 说明原因，令牌仍可再次使用）"
+// @Router       /auth/reset-password [post]
+func (h *PasswordHandler) ResetPassword(c *gin.Context) {
+	var req struct {
+		Token       string `json:"token" binding:"required"`
+		NewPassword string `json:"new_password" binding:"required"`
+	}
+	if !httpx.BindJSON(c, &req, "请提供重置令牌和新密码") {
+		return
+	}
+
+	if err := h.passwords.ResetPassword(c.Request.Context(), req.Token, req.NewPassword); err != nil {
+		if respondPasswordRejected(c, err) {
+			return
+		}
+		switch {
+		case errors.Is(err, services.ErrInvalidResetToken):
+			httpx.Error(c, http.StatusBadRequest, "重置链接无效或已过期，请重新申请")
+		default:
+			httpx.Error(c, http.StatusInternalServerError, "重置密码失败")
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "密码已重置，请使用新密码登录"})
+}
+
+// respondPasswordRejected writes a 400 response with the policy violation code when err reports a
+// password the policy refused, and reports whether it did.
+func respondPasswordRejected(c *gin.Context, err error) bool {
+	var violation *passwordpolicy.Violation
+	if !errors.As(err, &violation) {
+		return false
+	}
+
+	var message string
+	switch violation.Code {
+	case passwordpolicy.CodeTooShort:
+		message = fmt.Sprintf("密码至少需要 %d 个字符", violation.Limit)
+	case passwordpolicy.CodeTooLong:
+		message = fmt.Sprintf("密码不能超过 %d 个字符", violation.Limit)
+	case passwordpolicy.CodeTooFewClasses:
+		message = fmt.Sprintf("密码需包含小写字母、大写字母、数字、符号中的至少 %d 种", violation.Limit)
+	case passwordpolicy.CodeContainsIdentity:
+		message = "密码不能包含邮箱或昵称"
+	case passwordpolicy.CodeBreached:
+		message = "该密码过于常见或已在数据泄露中出现，请换一个"
+	default:
+		message = "密码不符合安全要求"
+	}
+	if violation.Limit > 0 {
+		httpx.ErrorWithLimit(c, http.StatusBadRequest, violation.Code, violation.Limit, message)
+	} else {
+		httpx.ErrorWithCode(c, http.StatusBadRequest, violation.Code, message)
+	}
+	return true
+}
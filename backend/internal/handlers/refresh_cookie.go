@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Headers used by browser clients that keep the refresh token in a cookie.
+const (
+	// RefreshTokenModeHeader set to "cookie" on a login request asks for the refresh token as a cookie.
+	RefreshTokenModeHeader = "X-Refresh-Token-Mode"
+	// CSRFTokenHeader carries the value of the CSRF cookie on /auth/refresh and /auth/logout.
+	CSRFTokenHeader = "X-CSRF-Token"
+)
+
+const refreshTokenModeCookie = "cookie"
+
+// RefreshCookieOptions configures delivering refresh tokens to browser clients in an HttpOnly cookie.
+type RefreshCookieOptions struct {
+	Enabled  bool
+	Name     string
+	CSRFName string
+	Path     string
+	Domain   string
+	Secure   bool
+	SameSite http.SameSite
+	MaxAge   time.Duration
+}
+
+// RefreshCookies sets and reads the refresh token cookie together with its double-submit CSRF cookie.
+// Clients that do not opt in, such as the mini-program, keep receiving the refresh token in the JSON body.
+type RefreshCookies struct {
+	options RefreshCookieOptions
+}
+
+// NewRefreshCookies constructs RefreshCookies; when options.Enabled is false only the JSON body is used.
+func NewRefreshCookies(options RefreshCookieOptions) *RefreshCookies {
+	if options.Name == "" {
+		options.Name = "refresh_token"
+	}
+	if options.CSRFName == "" {
+		options.CSRFName = "csrf_token"
+	}
+	if options.Path == "" {
+		options.Path = "/api/v1/auth"
+	}
+	if options.SameSite == 0 {
+		options.SameSite = http.SameSiteStrictMode
+	}
+	return &RefreshCookies{options: options}
+}
+
+func (r *RefreshCookies) enabled() bool {
+	return r != nil && r.options.Enabled
+}
+
+// wanted reports whether the response should carry the refresh token as a cookie: the client asked for it,
+// or it already sent one.
+func (r *RefreshCookies) wanted(c *gin.Context) bool {
+	if !r.enabled() {
+		return false
+	}
+	if strings.EqualFold(c.GetHeader(RefreshTokenModeHeader), refreshTokenModeCookie) {
+		return true
+	}
+	_, ok := r.token(c)
+	return ok
+}
+
+// apply moves the refresh token of body into cookies when the client uses cookie mode and adds the CSRF
+// token the client must echo in CSRFTokenHeader.
+func (r *RefreshCookies) apply(c *gin.Context, body gin.H, refreshToken string) error {
+	if !r.wanted(c) {
+		return nil
+	}
+
+	csrf, err := newCSRFToken()
+	if err != nil {
+		return err
+	}
+	maxAge := int(r.options.MaxAge.Seconds())
+	r.set(c, r.options.Name, refreshToken, r.options.Path, maxAge, true)
+	r.set(c, r.options.CSRFName, csrf, "/", maxAge, false)
+
+	delete(body, "refresh_token")
+	body["csrf_token"] = csrf
+	return nil
+}
+
+// token returns the refresh token cookie.
+func (r *RefreshCookies) token(c *gin.Context) (string, bool) {
+	if !r.enabled() {
+		return "", false
+	}
+	value, err := c.Cookie(r.options.Name)
+	if err != nil || value == "" {
+		return "", false
+	}
+	return value, true
+}
+
+// checkCSRF compares the CSRF header with the CSRF cookie. A cross-site page can make the browser send
+// the cookies but cannot read them to fill in the header.
+func (r *RefreshCookies) checkCSRF(c *gin.Context) bool {
+	cookie, err := c.Cookie(r.options.CSRFName)
+	header := c.GetHeader(CSRFTokenHeader)
+	if err != nil || cookie == "" || header == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(cookie), []byte(header)) == 1
+}
+
+// clear expires both cookies.
+func (r *RefreshCookies) clear(c *gin.Context) {
+	if !r.enabled() {
+		return
+	}
+	r.set(c, r.options.Name, "", r.options.Path, -1, true)
+	r.set(c, r.options.CSRFName, "", "/", -1, false)
+}
+
+func (r *RefreshCookies) set(c *gin.Context, name, value, path string, maxAge int, httpOnly bool) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Domain:   r.options.Domain,
+		MaxAge:   maxAge,
+		Secure:   r.options.Secure,
+		HttpOnly: httpOnly,
+		SameSite: r.options.SameSite,
+	})
+}
+
+func newCSRFToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
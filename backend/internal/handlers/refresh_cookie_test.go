@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hdu-dp/backend/internal/models"
+	"github.com/hdu-dp/backend/internal/services"
+)
+
+func newRefreshCookieTestEngine() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	h := &AuthHandler{cookies: NewRefreshCookies(RefreshCookieOptions{Enabled: true, Secure: true, MaxAge: time.Hour})}
+	engine := gin.New()
+	engine.POST("/api/v1/auth/login", func(c *gin.Context) {
+		respondAuthSuccess(c, h.cookies, http.StatusOK, &services.AuthResult{
+			AccessToken:  "access",
+			RefreshToken: "refresh-secret",
+			User:         &models.User{Email: "user@example.com"},
+		})
+	})
+	engine.POST("/api/v1/auth/refresh", func(c *gin.Context) {
+		token, fromCookie, ok := h.refreshToken(c)
+		if !ok {
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"token": token, "from_cookie": fromCookie})
+	})
+	return engine
+}
+
+func TestRefreshCookieModeIsOptIn(t *testing.T) {
+	engine := newRefreshCookieTestEngine()
+
+	rec := httptest.NewRecorder()
+	engine.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/auth/login", nil))
+	var body map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if body["refresh_token"] != "refresh-secret" || len(rec.Result().Cookies()) != 0 {
+		t.Fatalf("expected clients without the mode header to get the token in the body, got %v", body)
+	}
+
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/refresh", strings.NewReader(`{"refresh_token":"from-body"}`))
+	req.Header.Set("Content-Type", "application/json")
+	engine.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"from-body"`) {
+		t.Fatalf("expected the body token to be accepted without csrf, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRefreshCookieRequiresCSRFHeader(t *testing.T) {
+	engine := newRefreshCookieTestEngine()
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/login", nil)
+	req.Header.Set(RefreshTokenModeHeader, "cookie")
+	engine.ServeHTTP(rec, req)
+
+	var body map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if _, leaked := body["refresh_token"]; leaked {
+		t.Fatalf("expected the refresh token to be left out of the body in cookie mode")
+	}
+	csrf, _ := body["csrf_token"].(string)
+
+	var refreshCookie, csrfCookie *http.Cookie
+	for _, cookie := range rec.Result().Cookies() {
+		switch cookie.Name {
+		case "refresh_token":
+			refreshCookie = cookie
+		case "csrf_token":
+			csrfCookie = cookie
+		}
+	}
+	if refreshCookie == nil || !refreshCookie.HttpOnly || !refreshCookie.Secure ||
+		refreshCookie.SameSite != http.SameSiteStrictMode || refreshCookie.Path != "/api/v1/auth" {
+		t.Fatalf("unexpected refresh cookie: %+v", refreshCookie)
+	}
+	if csrfCookie == nil || csrfCookie.HttpOnly || csrfCookie.Value != csrf || csrf == "" {
+		t.Fatalf("unexpected csrf cookie: %+v", csrfCookie)
+	}
+
+	refresh := func(header string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/refresh", nil)
+		req.AddCookie(refreshCookie)
+		req.AddCookie(csrfCookie)
+		if header != "" {
+			req.Header.Set(CSRFTokenHeader, header)
+		}
+		engine.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := refresh(""); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected a missing csrf header to be refused, got %d", rec.Code)
+	}
+	if rec := refresh("forged"); rec.Code != http.StatusForbidden {
+		t.Fatalf("expected a wrong csrf header to be refused, got %d", rec.Code)
+	}
+	if rec := refresh(csrf); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"refresh-secret"`) {
+		t.Fatalf("expected the cookie token to be used, got %d %s", rec.Code, rec.Body.String())
+	}
+}
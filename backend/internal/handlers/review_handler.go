@@ -1,12 +1,15 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/hdu-dp/backend/internal/httpx"
+	"github.com/hdu-dp/backend/internal/imaging"
 	"github.com/hdu-dp/backend/internal/models"
 	"github.com/hdu-dp/backend/internal/services"
 	"github.com/hdu-dp/backend/internal/storage"
@@ -33,11 +36,13 @@ func NewReviewHandler(reviews *services.ReviewService) *ReviewHandler {
 // @Param        query     query string false "搜索关键词"
 // @Param        sort      query string false "排序字段 (created_at, rating)" enums(created_at, rating) default(created_at)
 // @Param        order     query string false "排序顺序 (asc, desc)" enums(asc, desc) default(desc)
+// @Param        campus_verified query bool false "仅显示已验证校园邮箱的作者发布的点评"
 // @Success      200 {object} services.ReviewListResult
 // @Failure      500 {object} object{error=string} "服务器内部错误"
 // @Router       /reviews [get]
 func (h *ReviewHandler) ListPublic(c *gin.Context) {
 	filters := parseListFilters(c)
+	filters.CampusVerifiedOnly = c.Query("campus_verified") == "true"
 	result, err := h.reviews.ListPublic(filters)
 	if err != nil {
 		httpx.Error(c, http.StatusInternalServerError, err.Error())
@@ -54,11 +59,14 @@ func (h *ReviewHandler) ListPublic(c *gin.Context) {
 // @Param        body body object{title=string,address=string,description=string,rating=number} true "点评内容"
 // @Success      201 {object} models.Review "创建成功"
 // @Failure      400 {object} object{error=string} "请求参数错误"
+// @Failure      403 {object} object{error=string} "需要先验证校园邮箱"
 // @Security     ApiKeyAuth
 // @Router       /reviews [post]
 func (h *ReviewHandler) Submit(c *gin.Context) {
-	userID, ok := httpx.MustContextUUID(c, "user_id", "missing user", "invalid user id")
+	value, _ := c.Get("user")
+	author, ok := value.(*models.User)
 	if !ok {
+		httpx.Error(c, http.StatusUnauthorized, "missing user")
 		return
 	}
 	var req struct {
@@ -72,13 +80,17 @@ func (h *ReviewHandler) Submit(c *gin.Context) {
 		return
 	}
 
-	review, err := h.reviews.Submit(userID, services.CreateReviewInput{
+	review, err := h.reviews.Submit(author, services.CreateReviewInput{
 		Title:       req.Title,
 		Address:     req.Address,
 		Description: req.Description,
 		Rating:      req.Rating,
 	})
 	if err != nil {
+		if errors.Is(err, services.ErrCampusVerificationRequired) {
+			httpx.Error(c, http.StatusForbidden, "请先验证校园邮箱后再发布点评")
+			return
+		}
 		httpx.Error(c, http.StatusBadRequest, err.Error())
 		return
 	}
@@ -155,40 +167,28 @@ func (h *ReviewHandler) MyReviews(c *gin.Context) {
 }
 
 // @Summary      上传点评图片
-// @Description  为指定的点评上传一张图片。用户只能为自己的点评上传。
+// @Description  为指定的点评上传一张图片（JPEG、PNG、GIF 或 WebP，按文件内容而非扩展名或 Content-Type 识别），可附带不超过 200 字的说明文字。用户只能为自己待审核的点评上传，每条点评的图片数量有上限（默认 9 张），新图片排在已有图片之后。服务端将原图转正并去除 EXIF、GPS 等元数据后保存，并生成宽度为 320、800、1600 像素的 JPEG 缩略图（thumbnail、medium、large），不会放大比目标更窄的图片。
 // @Tags         点评
 // @Accept       multipart/form-data
 // @Produce      json
-// @Param        id   path      string true "点评 ID"
-// @Param        file formData  file   true "图片文件"
+// @Param        id      path      string true  "点评 ID"
+// @Param        file    formData  file   true  "图片文件"
+// @Param        caption formData  string false "图片说明"
 // @Success      201  {object}  models.ReviewImage "上传成功"
 // @Failure      400  {object}  object{error=string} "请求错误"
 // @Failure      403  {object}  object{error=string} "无权操作"
 // @Failure      404  {object}  object{error=string} "点评不存在"
-// @Failure      413  {object}  object{error=string} "图片过大"
+// @Failure      409  {object}  object{error=string,code=string,limit=int} "图片数量已达上限"
+// @Failure      413  {object}  object{error=string,code=string} "文件或分辨率过大"
+// @Failure      415  {object}  object{error=string,code=string} "不支持的文件类型"
 // @Failure      500  {object}  object{error=string} "服务器内部错误"
 // @Security     ApiKeyAuth
 // @Router       /reviews/{id}/images [post]
 func (h *ReviewHandler) UploadImage(c *gin.Context) {
-	reviewID, ok := httpx.ParamUUID(c, "id", "invalid review id")
-	if !ok {
-		return
-	}
-
-	review, err := h.reviews.Get(reviewID)
-	if err != nil {
-		httpx.Error(c, http.StatusNotFound, "review not found")
-		return
-	}
-
-	userID, ok := httpx.MustContextUUID(c, "user_id", "missing user", "invalid user id")
+	review, ok := h.ownedReview(c)
 	if !ok {
 		return
 	}
-	if err := services.ValidateOwnership(review, userID); err != nil {
-		httpx.Error(c, http.StatusForbidden, "not owner")
-		return
-	}
 	if review.Status != models.ReviewStatusPending {
 		httpx.Error(c, http.StatusBadRequest, "images can only be uploaded while review is pending")
 		return
@@ -211,14 +211,16 @@ func (h *ReviewHandler) UploadImage(c *gin.Context) {
 	}
 
 	uploadFile := &storage.UploadFile{
-		Reader:      opened,
-		Size:        fileHeader.Size,
-		Filename:    fileHeader.Filename,
-		ContentType: fileHeader.Header.Get("Content-Type"),
+		Reader:   opened,
+		Size:     fileHeader.Size,
+		Filename: fileHeader.Filename,
 	}
 
-	image, err := h.reviews.StoreImage(c.Request.Context(), reviewID, uploadFile)
+	image, err := h.reviews.StoreImage(c.Request.Context(), review.ID, uploadFile, c.PostForm("caption"))
 	if err != nil {
+		if respondInvalidImage(c, err) || h.respondImageError(c, err) {
+			return
+		}
 		httpx.Error(c, http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -226,6 +228,186 @@ func (h *ReviewHandler) UploadImage(c *gin.Context) {
 	c.JSON(http.StatusCreated, image)
 }
 
+// @Summary      删除点评图片
+// @Description  作者删除自己点评中的一张图片，同时删除原图及各尺寸缩略图文件。任何审核状态下都可以删除。
+// @Tags         点评
+// @Produce      json
+// @Param        id       path string true "点评 ID"
+// @Param        image_id path string true "图片 ID"
+// @Success      204 "删除成功"
+// @Failure      400 {object} object{error=string} "无效的 ID"
+// @Failure      403 {object} object{error=string} "无权操作"
+// @Failure      404 {object} object{error=string} "点评或图片不存在"
+// @Failure      500 {object} object{error=string} "服务器内部错误"
+// @Security     ApiKeyAuth
+// @Router       /reviews/{id}/images/{image_id} [delete]
+func (h *ReviewHandler) DeleteImage(c *gin.Context) {
+	review, ok := h.ownedReview(c)
+	if !ok {
+		return
+	}
+	imageID, ok := httpx.ParamUUID(c, "image_id", "invalid image id")
+	if !ok {
+		return
+	}
+
+	if err := h.reviews.DeleteImage(c.Request.Context(), review.ID, imageID); err != nil {
+		if h.respondImageError(c, err) {
+			return
+		}
+		httpx.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// @Summary      修改图片说明
+// @Description  作者修改自己待审核点评中某张图片的说明文字（不超过 200 字），传空字符串表示删除说明。已审核的点评不能再修改说明。
+// @Tags         点评
+// @Accept       json
+// @Produce      json
+// @Param        id       path string true "点评 ID"
+// @Param        image_id path string true "图片 ID"
+// @Param        body     body object{caption=string} true "图片说明"
+// @Success      200 {object} models.ReviewImage
+// @Failure      400 {object} object{error=string} "请求错误"
+// @Failure      403 {object} object{error=string} "无权操作"
+// @Failure      404 {object} object{error=string} "点评或图片不存在"
+// @Failure      500 {object} object{error=string} "服务器内部错误"
+// @Security     ApiKeyAuth
+// @Router       /reviews/{id}/images/{image_id} [patch]
+func (h *ReviewHandler) UpdateImageCaption(c *gin.Context) {
+	review, ok := h.ownedReview(c)
+	if !ok {
+		return
+	}
+	imageID, ok := httpx.ParamUUID(c, "image_id", "invalid image id")
+	if !ok {
+		return
+	}
+	if review.Status != models.ReviewStatusPending {
+		httpx.Error(c, http.StatusBadRequest, "captions can only be changed while review is pending")
+		return
+	}
+
+	var req struct {
+		Caption *string `json:"caption" binding:"required"`
+	}
+	if !httpx.BindJSON(c, &req, "请提供图片说明") {
+		return
+	}
+
+	image, err := h.reviews.SetImageCaption(review.ID, imageID, *req.Caption)
+	if err != nil {
+		if h.respondImageError(c, err) {
+			return
+		}
+		httpx.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, image)
+}
+
+// @Summary      调整图片顺序
+// @Description  作者按给定顺序重新排列自己点评中的图片，image_ids 必须恰好包含该点评的每张图片各一次。任何审核状态下都可以调整。
+// @Tags         点评
+// @Accept       json
+// @Produce      json
+// @Param        id   path string true "点评 ID"
+// @Param        body body object{image_ids=[]string} true "图片 ID，按新的顺序排列"
+// @Success      200 {array}  models.ReviewImage "调整后的图片列表"
+// @Failure      400 {object} object{error=string} "请求错误"
+// @Failure      403 {object} object{error=string} "无权操作"
+// @Failure      404 {object} object{error=string} "点评不存在"
+// @Failure      500 {object} object{error=string} "服务器内部错误"
+// @Security     ApiKeyAuth
+// @Router       /reviews/{id}/images/order [put]
+func (h *ReviewHandler) ReorderImages(c *gin.Context) {
+	review, ok := h.ownedReview(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		ImageIDs []uuid.UUID `json:"image_ids" binding:"required"`
+	}
+	if !httpx.BindJSON(c, &req, "请提供图片顺序") {
+		return
+	}
+
+	images, err := h.reviews.ReorderImages(review, req.ImageIDs)
+	if err != nil {
+		if h.respondImageError(c, err) {
+			return
+		}
+		httpx.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, images)
+}
+
+// ownedReview loads the review named by the id path parameter and checks that the current user wrote it,
+// writing the error response and returning false otherwise.
+func (h *ReviewHandler) ownedReview(c *gin.Context) (*models.Review, bool) {
+	reviewID, ok := httpx.ParamUUID(c, "id", "invalid review id")
+	if !ok {
+		return nil, false
+	}
+
+	review, err := h.reviews.Get(reviewID)
+	if err != nil {
+		httpx.Error(c, http.StatusNotFound, "review not found")
+		return nil, false
+	}
+
+	userID, ok := httpx.MustContextUUID(c, "user_id", "missing user", "invalid user id")
+	if !ok {
+		return nil, false
+	}
+	if err := services.ValidateOwnership(review, userID); err != nil {
+		httpx.Error(c, http.StatusForbidden, "not owner")
+		return nil, false
+	}
+	return review, true
+}
+
+// respondImageError writes the response for errors managing the images of a review. It returns false for
+// other errors.
+func (h *ReviewHandler) respondImageError(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, services.ErrTooManyImages):
+		httpx.ErrorWithLimit(c, http.StatusConflict, "too_many_images", h.reviews.MaxImages(),
+			fmt.Sprintf("每条点评最多上传 %d 张图片", h.reviews.MaxImages()))
+	case errors.Is(err, services.ErrImageNotFound):
+		httpx.Error(c, http.StatusNotFound, "image not found")
+	case errors.Is(err, services.ErrCaptionTooLong):
+		httpx.ErrorWithLimit(c, http.StatusBadRequest, "caption_too_long", services.MaxImageCaptionLength,
+			fmt.Sprintf("图片说明不能超过 %d 字", services.MaxImageCaptionLength))
+	case errors.Is(err, services.ErrInvalidImageOrder):
+		httpx.Error(c, http.StatusBadRequest, "image_ids must list every image of the review exactly once")
+	default:
+		return false
+	}
+	return true
+}
+
+// respondInvalidImage writes the response for uploads rejected by imaging, telling the client whether the file
+// type, the pixel size or the data itself was at fault. It returns false for other errors.
+func respondInvalidImage(c *gin.Context, err error) bool {
+	if !errors.Is(err, services.ErrInvalidImage) {
+		return false
+	}
+	switch {
+	case errors.Is(err, imaging.ErrUnsupportedFormat):
+		httpx.ErrorWithCode(c, http.StatusUnsupportedMediaType, "image_unsupported_format", "不支持的文件类型，请上传 JPEG、PNG、GIF 或 WebP 图片")
+	case errors.Is(err, imaging.ErrTooLarge):
+		httpx.ErrorWithCode(c, http.StatusRequestEntityTooLarge, "image_dimensions_too_large", "图片分辨率过大")
+	default:
+		httpx.ErrorWithCode(c, http.StatusBadRequest, "image_corrupt", "图片已损坏或无法解析")
+	}
+	return true
+}
+
 func parseListFilters(c *gin.Context) services.ListFilters {
 	query := strings.TrimSpace(c.Query("query"))
 	sortBy := c.DefaultQuery("sort", "created_at")
@@ -58,7 +58,7 @@ func TestUploadImageRejectsProcessedReview(t *testing.T) {
 		t.Fatalf("create review failed: %v", err)
 	}
 
-	handler := NewReviewHandler(services.NewReviewService(repository.NewReviewRepository(db), nil))
+	handler := NewReviewHandler(services.NewReviewService(repository.NewReviewRepository(db), nil, nil, services.ReviewOptions{}))
 
 	router := gin.New()
 	router.POST("/reviews/:id/images", func(c *gin.Context) {
@@ -67,7 +67,7 @@ func newReviewStatsHandlerForTest(t *testing.T) (*gorm.DB, *ReviewStatsHandler,
 	}
 
 	reviewRepo := repository.NewReviewRepository(db)
-	reviewService := services.NewReviewService(reviewRepo, nil)
+	reviewService := services.NewReviewService(reviewRepo, nil, nil, services.ReviewOptions{})
 	handler := NewReviewStatsHandler(
 		services.NewReviewStatsService(
 			repository.NewReviewStatsRepository(db),
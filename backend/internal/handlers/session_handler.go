@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/hdu-dp/backend/internal/httpx"
+	"github.com/hdu-dp/backend/internal/services"
+)
+
+// SessionHandler lets users review and revoke where they are signed in.
+type SessionHandler struct {
+	sessions *services.SessionService
+}
+
+// NewSessionHandler constructs a SessionHandler.
+func NewSessionHandler(sessions *services.SessionService) *SessionHandler {
+	return &SessionHandler{sessions: sessions}
+}
+
+// @Summary      登录设备列表
+// @Description  列出当前用户所有有效的登录会话，current 标记发起请求的会话。
+// @Tags         用户
+// @Produce      json
+// @Success      200 {object} object{data=[]object{id=string,device_label=string,user_agent=string,ip=string,created_at=string,last_used_at=string,expires_at=string,current=bool}}
+// @Failure      401 {object} object{error=string} "未认证"
+// @Security     ApiKeyAuth
+// @Router       /users/me/sessions [get]
+func (h *SessionHandler) List(c *gin.Context) {
+	userID, ok := httpx.MustContextUUID(c, "user_id", "missing user", "invalid user id")
+	if !ok {
+		return
+	}
+
+	sessions, err := h.sessions.List(userID, currentSessionID(c))
+	if err != nil {
+		httpx.Error(c, http.StatusInternalServerError, "获取登录设备失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": sessions})
+}
+
+// @Summary      退出指定设备
+// @Description  撤销当前用户的某个登录会话，该设备需重新登录。
+// @Tags         用户
+// @Produce      json
+// @Param        id path string true "会话ID"
+// @Success      204 "已退出"
+// @Failure      401 {object} object{error=string} "未认证"
+// @Failure      404 {object} object{error=string} "会话不存在"
+// @Security     ApiKeyAuth
+// @Router       /users/me/sessions/{id} [delete]
+func (h *SessionHandler) Revoke(c *gin.Context) {
+	userID, ok := httpx.MustContextUUID(c, "user_id", "missing user", "invalid user id")
+	if !ok {
+		return
+	}
+	sessionID, ok := httpx.ParamUUID(c, "id", "无效的会话ID")
+	if !ok {
+		return
+	}
+
+	if err := h.sessions.Revoke(userID, sessionID); err != nil {
+		if errors.Is(err, services.ErrSessionNotFound) {
+			httpx.Error(c, http.StatusNotFound, "会话不存在或已失效")
+			return
+		}
+		httpx.Error(c, http.StatusInternalServerError, "退出登录失败")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// @Summary      退出其他设备
+// @Description  撤销除当前会话以外的所有登录会话。
+// @Tags         用户
+// @Produce      json
+// @Success      200 {object} object{revoked=integer}
+// @Failure      401 {object} object{error=string} "未认证"
+// @Security     ApiKeyAuth
+// @Router       /users/me/sessions/revoke-others [post]
+func (h *SessionHandler) RevokeOthers(c *gin.Context) {
+	userID, ok := httpx.MustContextUUID(c, "user_id", "missing user", "invalid user id")
+	if !ok {
+		return
+	}
+
+	revoked, err := h.sessions.RevokeOthers(userID, currentSessionID(c))
+	if err != nil {
+		httpx.Error(c, http.StatusInternalServerError, "退出其他设备失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"revoked": revoked})
+}
+
+// currentSessionID returns the session bound to the caller's access token, or uuid.Nil for older tokens.
+func currentSessionID(c *gin.Context) uuid.UUID {
+	if value, ok := c.Get("session_id"); ok {
+		if id, ok := value.(uuid.UUID); ok {
+			return id
+		}
+	}
+	return uuid.Nil
+}
@@ -1,28 +1,34 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/hdu-dp/backend/internal/httpx"
+	"github.com/hdu-dp/backend/internal/models"
 	"github.com/hdu-dp/backend/internal/repository"
+	"github.com/hdu-dp/backend/internal/services"
 )
 
+const maxAvatarUploadSize = 5 * 1024 * 1024 // 5MB
+
 // UserHandler exposes user profile endpoints.
 type UserHandler struct {
-	users *repository.UserRepository
+	users    *repository.UserRepository
+	profiles *services.ProfileService
 }
 
 // NewUserHandler constructs a UserHandler.
-func NewUserHandler(users *repository.UserRepository) *UserHandler {
-	return &UserHandler{users: users}
+func NewUserHandler(users *repository.UserRepository, profiles *services.ProfileService) *UserHandler {
+	return &UserHandler{users: users, profiles: profiles}
 }
 
 // @Summary      获取当前用户信息
 // @Description  获取当前已认证用户的详细信息。
 // @Tags         用户
 // @Produce      json
-// @Success      200 {object} object{id=integer,email=string,display_name=string,role=string,created_at=string} "用户信息"
+// @Success      200 {object} object{id=integer,email=string,display_name=string,bio=string,dietary_preferences=[]string,avatar_url=string,avatar_small_url=string,role=string,deletion_scheduled_at=string,created_at=string} "用户信息"
 // @Failure      401 {object} object{error=string} "未认证"
 // @Failure      404 {object} object{error=string} "用户不存在"
 // @Security     ApiKeyAuth
@@ -39,15 +45,137 @@ func (h *UserHandler) Me(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"id":                user.ID,
-		"email":             user.Email,
-		"phone":             user.Phone,
-		"qq_open_id":        user.QQOpenID,
-		"display_name":      user.DisplayName,
-		"role":              user.Role,
-		"email_verified":    user.EmailVerified,
-		"email_verified_at": user.EmailVerifiedAt,
-		"created_at":        user.CreatedAt,
+	c.JSON(http.StatusOK, userProfileBody(user))
+}
+
+// @Summary      修改个人资料
+// @Description  修改昵称、个人简介、饮食偏好与邮件语言，省略的字段保持不变。昵称 2-30 个字符且不能与其他用户重复（不区分大小写），简介不超过 500 个字符。饮食偏好可选：vegetarian、vegan、halal、no_pork、no_spicy、spicy、low_sugar、gluten_free，传空数组表示清空。邮件语言可选 zh-CN 或 en，传空字符串表示使用服务端默认语言。
+// @Tags         用户
+// @Accept       json
+// @Produce      json
+// @Param        body body object{display_name=string,bio=string,dietary_preferences=[]string,locale=string} true "要修改的资料"
+// @Success      200 {object} object{id=string,display_name=string,bio=string,dietary_preferences=[]string,avatar_url=string,locale=string} "更新后的用户信息"
+// @Failure      400 {object} object{error=string} "参数错误"
+// @Failure      401 {object} object{error=string} "未认证"
+// @Failure      409 {object} object{error=string} "昵称已被使用"
+// @Security     ApiKeyAuth
+// @Router       /users/me [patch]
+func (h *UserHandler) UpdateProfile(c *gin.Context) {
+	userID, ok := httpx.MustContextUUID(c, "user_id", "missing user", "invalid user id")
+	if !ok {
+		return
+	}
+
+	var req struct {
+		DisplayName        *string  `json:"display_name"`
+		Bio                *string  `json:"bio"`
+		DietaryPreferences []string `json:"dietary_preferences"`
+		Locale             *string  `json:"locale" binding:"omitempty,max=16"`
+	}
+	if !httpx.BindJSON(c, &req, "无效的请求参数") {
+		return
+	}
+
+	user, err := h.profiles.Update(userID, services.ProfileUpdate{
+		DisplayName:        req.DisplayName,
+		Bio:                req.Bio,
+		DietaryPreferences: req.DietaryPreferences,
+		Locale:             req.Locale,
 	})
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidDisplayName):
+			httpx.Error(c, http.StatusBadRequest, "昵称需为 2-30 个字符，且不能包含控制字符")
+		case errors.Is(err, services.ErrDisplayNameTaken):
+			httpx.Error(c, http.StatusConflict, "该昵称已被使用")
+		case errors.Is(err, services.ErrBioTooLong):
+			httpx.Error(c, http.StatusBadRequest, "个人简介不能超过 500 个字符")
+		case errors.Is(err, services.ErrInvalidDietaryPreference):
+			httpx.Error(c, http.StatusBadRequest, "饮食偏好无效")
+		case errors.Is(err, services.ErrUnsupportedLocale):
+			httpx.Error(c, http.StatusBadRequest, "不支持的语言，可选 zh-CN 或 en")
+		case errors.Is(err, services.ErrUserNotFound):
+			httpx.Error(c, http.StatusNotFound, "用户不存在")
+		default:
+			httpx.Error(c, http.StatusInternalServerError, "修改资料失败")
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, userProfileBody(user))
+}
+
+// @Summary      上传头像
+// @Description  上传 JPEG、PNG、GIF 或 WebP 图片作为头像（不超过 5MB）。服务端居中裁剪为正方形并生成 256 与 64 像素两种尺寸，旧头像随之删除。
+// @Tags         用户
+// @Accept       multipart/form-data
+// @Produce      json
+// @Param        file formData file true "头像图片"
+// @Success      200 {object} object{avatar_url=string,avatar_small_url=string} "更新后的用户信息"
+// @Failure      400 {object} object{error=string,code=string} "图片已损坏"
+// @Failure      401 {object} object{error=string} "未认证"
+// @Failure      413 {object} object{error=string,code=string} "文件或分辨率过大"
+// @Failure      415 {object} object{error=string,code=string} "不支持的文件类型"
+// @Security     ApiKeyAuth
+// @Router       /users/me/avatar [post]
+func (h *UserHandler) UploadAvatar(c *gin.Context) {
+	userID, ok := httpx.MustContextUUID(c, "user_id", "missing user", "invalid user id")
+	if !ok {
+		return
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		httpx.Error(c, http.StatusBadRequest, "请选择头像图片")
+		return
+	}
+	if fileHeader.Size > maxAvatarUploadSize {
+		httpx.Error(c, http.StatusRequestEntityTooLarge, "头像图片不能超过 5MB")
+		return
+	}
+
+	opened, err := fileHeader.Open()
+	if err != nil {
+		httpx.Error(c, http.StatusInternalServerError, "读取上传文件失败")
+		return
+	}
+	defer opened.Close()
+
+	user, err := h.profiles.SetAvatar(c.Request.Context(), userID, opened)
+	if err != nil {
+		if respondInvalidImage(c, err) {
+			return
+		}
+		switch {
+		case errors.Is(err, services.ErrUserNotFound):
+			httpx.Error(c, http.StatusNotFound, "用户不存在")
+		default:
+			httpx.Error(c, http.StatusInternalServerError, "上传头像失败")
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, userProfileBody(user))
+}
+
+// userProfileBody is the JSON body describing the signed-in user.
+func userProfileBody(user *models.User) gin.H {
+	return gin.H{
+		"id":                    user.ID,
+		"email":                 user.Email,
+		"phone":                 user.Phone,
+		"qq_open_id":            user.QQOpenID,
+		"display_name":          user.DisplayName,
+		"bio":                   user.Bio,
+		"dietary_preferences":   user.DietaryPreferenceList(),
+		"avatar_url":            user.AvatarURL,
+		"avatar_small_url":      user.AvatarSmallURL,
+		"locale":                user.Locale,
+		"role":                  user.Role,
+		"email_verified":        user.EmailVerified,
+		"email_verified_at":     user.EmailVerifiedAt,
+		"campus_verified":       user.CampusVerified,
+		"deletion_scheduled_at": user.DeletionScheduledAt,
+		"created_at":            user.CreatedAt,
+	}
 }
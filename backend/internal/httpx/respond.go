@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -15,6 +16,8 @@ const requestIDContextKey = "request_id"
 // ErrorResponse is the standard API error payload.
 type ErrorResponse struct {
 	Error     string `json:"error"`
+	Code      string `json:"code,omitempty"`
+	Limit     int    `json:"limit,omitempty"`
 	RequestID string `json:"request_id,omitempty"`
 }
 
@@ -26,6 +29,32 @@ func Error(c *gin.Context, status int, message string) {
 	})
 }
 
+// ErrorWithCode writes an error response carrying a machine-readable code, so that clients can show their
+// own message.
+func ErrorWithCode(c *gin.Context, status int, code string, message string) {
+	c.JSON(status, ErrorResponse{
+		Error:     message,
+		Code:      code,
+		RequestID: requestID(c),
+	})
+}
+
+// ErrorWithLimit is ErrorWithCode for violations of a numeric limit, which it also reports to the client.
+func ErrorWithLimit(c *gin.Context, status int, code string, limit int, message string) {
+	c.JSON(status, ErrorResponse{
+		Error:     message,
+		Code:      code,
+		Limit:     limit,
+		RequestID: requestID(c),
+	})
+}
+
+// TooManyRequests writes a 429 response with a Retry-After header in whole seconds.
+func TooManyRequests(c *gin.Context, retryAfterSeconds int, message string) {
+	c.Header("Retry-After", strconv.Itoa(retryAfterSeconds))
+	Error(c, http.StatusTooManyRequests, message)
+}
+
 // BindJSON binds request JSON and returns false after writing a 400 response on failure.
 func BindJSON(c *gin.Context, dst any, message string) bool {
 	if err := c.ShouldBindJSON(dst); err != nil {
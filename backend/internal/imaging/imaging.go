@@ -0,0 +1,251 @@
+// Package imaging decodes uploaded pictures (JPEG, PNG, GIF and WebP), re-encodes them without metadata and
+// produces resized JPEG renditions.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"slices"
+
+	_ "golang.org/x/image/webp" // register WebP decoding
+)
+
+// MaxPixels is the default bound on the decoded size of an image, so that a small, highly compressed
+// upload cannot exhaust memory when expanded.
+const MaxPixels = 40_000_000
+
+// DefaultJPEGQuality is used for renditions unless a caller picks another quality.
+const DefaultJPEGQuality = 85
+
+// SanitizedJPEGQuality is used when re-encoding an original, high enough that the copy is hard to tell apart.
+const SanitizedJPEGQuality = 92
+
+var (
+	// ErrUnsupportedFormat indicates the data is not an image in one of the accepted formats.
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+	// ErrTooLarge indicates the image dimensions exceed the pixel limit.
+	ErrTooLarge = errors.New("image dimensions too large")
+	// ErrCorrupt indicates data that starts like an accepted image but cannot be decoded.
+	ErrCorrupt = errors.New("image data is corrupt")
+)
+
+// Limits restricts which uploads are decoded. The format is always taken from the data itself.
+type Limits struct {
+	// Formats lists the accepted formats as returned by Sniff; empty accepts all supported formats.
+	Formats []string
+	// MaxPixels bounds width×height, summed over the frames of an animated GIF.
+	MaxPixels int
+}
+
+// DefaultLimits accepts every supported format up to MaxPixels.
+var DefaultLimits = Limits{MaxPixels: MaxPixels}
+
+// Decode decodes r with DefaultLimits.
+func Decode(r io.Reader) (image.Image, string, error) {
+	return DefaultLimits.Decode(r)
+}
+
+// Sanitize sanitizes r with DefaultLimits.
+func Sanitize(r io.Reader) (*Sanitized, error) {
+	return DefaultLimits.Sanitize(r)
+}
+
+// Decode reads a complete image from r after checking its format and dimensions against l and turns it
+// upright according to its EXIF orientation. It returns the image together with its format name
+// ("jpeg", "png", "gif" or "webp").
+func (l Limits) Decode(r io.Reader) (image.Image, string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, "", fmt.Errorf("read image: %w", err)
+	}
+	return l.decode(data)
+}
+
+// Sanitized is an upload re-encoded from its pixels, which leaves EXIF, XMP, GPS and any other metadata behind.
+type Sanitized struct {
+	// Image is the upright picture, or the first frame of an animated GIF.
+	Image image.Image
+	// Format is the format of the upload.
+	Format      string
+	Data        []byte
+	ContentType string
+	// Extension is the file extension matching ContentType, including the dot.
+	Extension string
+}
+
+// Sanitize decodes r like Decode and re-encodes it without metadata. JPEG, PNG and GIF keep their format and
+// animated GIFs keep their frames; WebP, which the standard library cannot encode, becomes a JPEG, or a PNG
+// when it has transparency.
+func (l Limits) Sanitize(r io.Reader) (*Sanitized, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("read image: %w", err)
+	}
+	img, format, err := l.decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Sanitized{Image: img, Format: format}
+	var buf bytes.Buffer
+	switch {
+	case format == "gif":
+		animation, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+		}
+		err = gif.EncodeAll(&buf, &gif.GIF{
+			Image:           animation.Image,
+			Delay:           animation.Delay,
+			LoopCount:       animation.LoopCount,
+			Disposal:        animation.Disposal,
+			Config:          animation.Config,
+			BackgroundIndex: animation.BackgroundIndex,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("encode gif: %w", err)
+		}
+		result.ContentType, result.Extension = "image/gif", ".gif"
+	case format == "png" || !isOpaque(img):
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, fmt.Errorf("encode png: %w", err)
+		}
+		result.ContentType, result.Extension = "image/png", ".png"
+	default:
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: SanitizedJPEGQuality}); err != nil {
+			return nil, fmt.Errorf("encode jpeg: %w", err)
+		}
+		result.ContentType, result.Extension = "image/jpeg", ".jpg"
+	}
+	result.Data = buf.Bytes()
+	return result, nil
+}
+
+func (l Limits) decode(data []byte) (image.Image, string, error) {
+	format := Sniff(data)
+	if format == "" || (len(l.Formats) > 0 && !slices.Contains(l.Formats, format)) {
+		return nil, "", ErrUnsupportedFormat
+	}
+	maxPixels := l.MaxPixels
+	if maxPixels <= 0 {
+		maxPixels = MaxPixels
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxPixels {
+		return nil, "", ErrTooLarge
+	}
+	if format == "gif" {
+		pixels, err := gifPixels(data)
+		if err != nil {
+			return nil, "", fmt.Errorf("%w: %v", ErrCorrupt, err)
+		}
+		if pixels > maxPixels {
+			return nil, "", ErrTooLarge
+		}
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	return Orient(img, orientation(data, format)), format, nil
+}
+
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
+
+// Square crops the centre square of src and scales it to size×size. Transparent areas are flattened
+// onto white so that the result can be encoded as JPEG.
+func Square(src image.Image, size int) *image.RGBA {
+	b := src.Bounds()
+	side := min(b.Dx(), b.Dy())
+	crop := image.Rect(0, 0, side, side).Add(image.Pt(b.Min.X+(b.Dx()-side)/2, b.Min.Y+(b.Dy()-side)/2))
+	return Resize(src, crop, size, size)
+}
+
+// Fit scales src to width pixels wide, keeping its aspect ratio. Images narrower than width keep their
+// size, so renditions are never upscaled.
+func Fit(src image.Image, width int) *image.RGBA {
+	b := src.Bounds()
+	if width >= b.Dx() {
+		return Resize(src, b, b.Dx(), b.Dy())
+	}
+	height := max(1, (b.Dy()*width+b.Dx()/2)/b.Dx())
+	return Resize(src, b, width, height)
+}
+
+// Resize scales the region rect of src to width×height by averaging the source pixels that each
+// destination pixel covers, which keeps downscaled pictures free of aliasing.
+func Resize(src image.Image, rect image.Rectangle, width, height int) *image.RGBA {
+	flat := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), src, rect.Min, draw.Over)
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	if width == rect.Dx() && height == rect.Dy() {
+		copy(dst.Pix, flat.Pix)
+		return dst
+	}
+
+	scaleX := float64(rect.Dx()) / float64(width)
+	scaleY := float64(rect.Dy()) / float64(height)
+	for y := 0; y < height; y++ {
+		y0, y1 := float64(y)*scaleY, float64(y+1)*scaleY
+		for x := 0; x < width; x++ {
+			x0, x1 := float64(x)*scaleX, float64(x+1)*scaleX
+			var r, g, bl, total float64
+			for sy := int(y0); float64(sy) < y1 && sy < rect.Dy(); sy++ {
+				wy := overlap(y0, y1, sy)
+				for sx := int(x0); float64(sx) < x1 && sx < rect.Dx(); sx++ {
+					w := wy * overlap(x0, x1, sx)
+					i := flat.PixOffset(sx, sy)
+					r += w * float64(flat.Pix[i])
+					g += w * float64(flat.Pix[i+1])
+					bl += w * float64(flat.Pix[i+2])
+					total += w
+				}
+			}
+			i := dst.PixOffset(x, y)
+			dst.Pix[i] = uint8(r/total + 0.5)
+			dst.Pix[i+1] = uint8(g/total + 0.5)
+			dst.Pix[i+2] = uint8(bl/total + 0.5)
+			dst.Pix[i+3] = 0xff
+		}
+	}
+	return dst
+}
+
+// EncodeJPEG encodes img as a baseline JPEG, which carries no metadata from the original upload.
+func EncodeJPEG(img image.Image, quality int) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, fmt.Errorf("encode jpeg: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// overlap returns how much of the unit cell starting at i lies inside [lo, hi).
+func overlap(lo, hi float64, i int) float64 {
+	start := max(lo, float64(i))
+	end := min(hi, float64(i+1))
+	if end <= start {
+		return 0
+	}
+	return end - start
+}
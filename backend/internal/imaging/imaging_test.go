@@ -0,0 +1,197 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// exifWithOrientation builds a big-endian TIFF block with an orientation tag, followed by a fake GPS
+// position that the tests look for after sanitizing.
+func exifWithOrientation(orientation uint16) []byte {
+	var tiff bytes.Buffer
+	tiff.WriteString("MM\x00\x2a")
+	_ = binary.Write(&tiff, binary.BigEndian, uint32(8))
+	_ = binary.Write(&tiff, binary.BigEndian, uint16(1))
+	_ = binary.Write(&tiff, binary.BigEndian, []uint16{exifOrientationTag, 3})
+	_ = binary.Write(&tiff, binary.BigEndian, uint32(1))
+	_ = binary.Write(&tiff, binary.BigEndian, []uint16{orientation, 0})
+	_ = binary.Write(&tiff, binary.BigEndian, uint32(0))
+	tiff.WriteString("GPS 30.3156N 120.3432E")
+	return tiff.Bytes()
+}
+
+func TestSanitizeAppliesOrientationAndDropsExif(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 40; x++ {
+			src.Set(x, y, color.RGBA{R: 0xff, A: 0xff})
+			if x >= 20 {
+				src.Set(x, y, color.RGBA{B: 0xff, A: 0xff})
+			}
+		}
+	}
+
+	var plain bytes.Buffer
+	if err := jpeg.Encode(&plain, src, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatalf("encode jpeg: %v", err)
+	}
+	exif := append([]byte("Exif\x00\x00"), exifWithOrientation(6)...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(exif)+2))
+	upload := append([]byte{}, plain.Bytes()[:2]...)
+	upload = append(upload, segment...)
+	upload = append(upload, exif...)
+	upload = append(upload, plain.Bytes()[2:]...)
+
+	sanitized, err := Sanitize(bytes.NewReader(upload))
+	if err != nil {
+		t.Fatalf("sanitize jpeg: %v", err)
+	}
+	if sanitized.ContentType != "image/jpeg" || sanitized.Extension != ".jpg" {
+		t.Fatalf("expected a jpeg, got %s %s", sanitized.ContentType, sanitized.Extension)
+	}
+	if bytes.Contains(sanitized.Data, []byte("Exif")) || bytes.Contains(sanitized.Data, []byte("GPS")) {
+		t.Fatal("expected the exif block to be removed")
+	}
+	out, err := jpeg.Decode(bytes.NewReader(sanitized.Data))
+	if err != nil {
+		t.Fatalf("decode sanitized jpeg: %v", err)
+	}
+	if b := out.Bounds(); b.Dx() != 20 || b.Dy() != 40 {
+		t.Fatalf("expected the image to be rotated to 20x40, got %v", b)
+	}
+	// Rotating 90° clockwise moves the red left half to the top.
+	if r, _, b, _ := out.At(10, 5).RGBA(); r < 0xc000 || b > 0x4000 {
+		t.Fatalf("expected red at the top, got r=%x b=%x", r, b)
+	}
+}
+
+func TestSanitizePNGDropsTextChunks(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	src.Set(0, 0, color.NRGBA{R: 0xff, A: 0xff})
+
+	var plain bytes.Buffer
+	if err := png.Encode(&plain, src); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	chunk := func(kind string, data []byte) []byte {
+		out := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+		out = append(out, kind...)
+		out = append(out, data...)
+		return binary.BigEndian.AppendUint32(out, crc32.ChecksumIEEE(append([]byte(kind), data...)))
+	}
+	// The IHDR chunk ends 33 bytes in; metadata chunks go right after it.
+	ihdrEnd := len(pngSignature) + 25
+	upload := append([]byte{}, plain.Bytes()[:ihdrEnd]...)
+	upload = append(upload, chunk("eXIf", exifWithOrientation(3))...)
+	upload = append(upload, chunk("tEXt", []byte("XML:com.adobe.xmp\x00<x:xmpmeta/>"))...)
+	upload = append(upload, plain.Bytes()[ihdrEnd:]...)
+
+	sanitized, err := Sanitize(bytes.NewReader(upload))
+	if err != nil {
+		t.Fatalf("sanitize png: %v", err)
+	}
+	if sanitized.ContentType != "image/png" {
+		t.Fatalf("expected a png, got %s", sanitized.ContentType)
+	}
+	for _, marker := range []string{"eXIf", "tEXt", "xmpmeta", "GPS"} {
+		if bytes.Contains(sanitized.Data, []byte(marker)) {
+			t.Fatalf("expected %s to be removed", marker)
+		}
+	}
+	// Orientation 3 is a 180° turn, which moves the red corner to the bottom right.
+	if _, _, _, a := sanitized.Image.At(0, 0).RGBA(); a != 0 {
+		t.Fatalf("expected the top-left pixel to be transparent after rotation")
+	}
+	if r, _, _, _ := sanitized.Image.At(2, 1).RGBA(); r != 0xffff {
+		t.Fatalf("expected red in the bottom-right corner, got r=%x", r)
+	}
+}
+
+func TestOrientRotatesAndMirrors(t *testing.T) {
+	// A 2x1 image: red on the left, blue on the right.
+	src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	red, blue := color.RGBA{R: 0xff, A: 0xff}, color.RGBA{B: 0xff, A: 0xff}
+	src.SetRGBA(0, 0, red)
+	src.SetRGBA(1, 0, blue)
+
+	for orientation, want := range map[int][]color.RGBA{
+		1: {red, blue}, // 2x1, unchanged
+		2: {blue, red}, // 2x1, mirrored
+		3: {blue, red}, // 2x1, turned 180°
+		6: {red, blue}, // 1x2, red on top
+		8: {blue, red}, // 1x2, blue on top
+		5: {red, blue}, // 1x2, transposed
+		7: {blue, red}, // 1x2, transversed
+		4: {red, blue}, // 2x1, flipped vertically
+	} {
+		out := Orient(src, orientation)
+		b := out.Bounds()
+		second := image.Pt(1, 0)
+		if orientation >= 5 {
+			if b.Dx() != 1 || b.Dy() != 2 {
+				t.Fatalf("orientation %d: expected 1x2, got %v", orientation, b)
+			}
+			second = image.Pt(0, 1)
+		}
+		if got := color.RGBAModel.Convert(out.At(0, 0)); got != want[0] {
+			t.Fatalf("orientation %d: first pixel %v, want %v", orientation, got, want[0])
+		}
+		if got := color.RGBAModel.Convert(out.At(second.X, second.Y)); got != want[1] {
+			t.Fatalf("orientation %d: second pixel %v, want %v", orientation, got, want[1])
+		}
+	}
+}
+
+func TestLimitsCheckContentNotClaims(t *testing.T) {
+	var pngData bytes.Buffer
+	if err := png.Encode(&pngData, image.NewRGBA(image.Rect(0, 0, 100, 100))); err != nil {
+		t.Fatalf("encode png: %v", err)
+	}
+	frame := image.NewPaletted(image.Rect(0, 0, 100, 100), palette.Plan9)
+	animation := &gif.GIF{}
+	for i := 0; i < 20; i++ {
+		animation.Image = append(animation.Image, frame)
+		animation.Delay = append(animation.Delay, 10)
+	}
+	var gifData bytes.Buffer
+	if err := gif.EncodeAll(&gifData, animation); err != nil {
+		t.Fatalf("encode gif: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		data   []byte
+		limits Limits
+		want   error
+	}{
+		{"html", []byte("<html><script>alert(1)</script></html>"), DefaultLimits, ErrUnsupportedFormat},
+		{"svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg"/>`), DefaultLimits, ErrUnsupportedFormat},
+		{"format not allowed", pngData.Bytes(), Limits{Formats: []string{"jpeg"}, MaxPixels: MaxPixels}, ErrUnsupportedFormat},
+		{"truncated", pngData.Bytes()[:40], DefaultLimits, ErrCorrupt},
+		{"too many pixels", pngData.Bytes(), Limits{MaxPixels: 99 * 100}, ErrTooLarge},
+		{"too many gif frames", gifData.Bytes(), Limits{MaxPixels: 100 * 100 * 10}, ErrTooLarge},
+		{"gif within limit", gifData.Bytes(), Limits{MaxPixels: 100 * 100 * 20}, nil},
+	} {
+		_, err := tc.limits.Sanitize(bytes.NewReader(tc.data))
+		if !errors.Is(err, tc.want) {
+			t.Fatalf("%s: got %v, want %v", tc.name, err, tc.want)
+		}
+	}
+
+	if got := Sniff(gifData.Bytes()); got != "gif" {
+		t.Fatalf("expected gif, got %q", got)
+	}
+	if got := Sniff([]byte("RIFF\x00\x00\x00\x00WAVEfmt ")); got != "" {
+		t.Fatalf("expected a RIFF audio file not to be taken for webp, got %q", got)
+	}
+}
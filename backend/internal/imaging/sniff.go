@@ -0,0 +1,96 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+)
+
+// Sniff identifies an image format from the leading bytes of data, whatever the file name or declared
+// content type says. It returns "jpeg", "png", "gif", "webp" or "" for anything else.
+func Sniff(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8, 0xFF}):
+		return "jpeg"
+	case bytes.HasPrefix(data, pngSignature):
+		return "png"
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		return "gif"
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return "webp"
+	}
+	return ""
+}
+
+// ContentType returns the MIME type of a format name returned by Sniff.
+func ContentType(format string) string {
+	switch format {
+	case "jpeg", "png", "gif", "webp":
+		return "image/" + format
+	}
+	return "application/octet-stream"
+}
+
+var errMalformedGIF = errors.New("malformed gif")
+
+// gifPixels sums width×height over every frame of a GIF by walking its block structure, so that an
+// animation with thousands of large frames can be refused before any of them is decompressed.
+func gifPixels(data []byte) (int, error) {
+	const headerLength = 13
+	if len(data) < headerLength {
+		return 0, errMalformedGIF
+	}
+	i := headerLength
+	if flags := data[10]; flags&0x80 != 0 {
+		i += 3 << (flags&0x07 + 1)
+	}
+
+	total := 0
+	for i < len(data) {
+		switch data[i] {
+		case 0x21: // extension: label, then data sub-blocks
+			next, err := skipSubBlocks(data, i+2)
+			if err != nil {
+				return 0, err
+			}
+			i = next
+		case 0x2C: // image descriptor
+			if i+10 > len(data) {
+				return 0, errMalformedGIF
+			}
+			width := int(data[i+5]) | int(data[i+6])<<8
+			height := int(data[i+7]) | int(data[i+8])<<8
+			total += width * height
+			flags := data[i+9]
+			i += 10
+			if flags&0x80 != 0 {
+				i += 3 << (flags&0x07 + 1)
+			}
+			// LZW minimum code size, then the compressed pixels as sub-blocks.
+			next, err := skipSubBlocks(data, i+1)
+			if err != nil {
+				return 0, err
+			}
+			i = next
+		case 0x3B: // trailer
+			return total, nil
+		default:
+			return 0, errMalformedGIF
+		}
+	}
+	return total, nil
+}
+
+// skipSubBlocks returns the offset after the sub-block chain starting at i.
+func skipSubBlocks(data []byte, i int) (int, error) {
+	for {
+		if i >= len(data) {
+			return 0, errMalformedGIF
+		}
+		size := int(data[i])
+		i++
+		if size == 0 {
+			return i, nil
+		}
+		i += size
+	}
+}
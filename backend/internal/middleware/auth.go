@@ -1,30 +1,38 @@
 package middleware
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/hdu-dp/backend/internal/auth"
 	"github.com/hdu-dp/backend/internal/httpx"
+	"github.com/hdu-dp/backend/internal/models"
 	"github.com/hdu-dp/backend/internal/repository"
 )
 
-// AuthMiddleware handles JWT extraction and validation.
+// apiTokenTouchInterval limits how often the last-used time of a personal access token is written.
+const apiTokenTouchInterval = time.Minute
+
+// AuthMiddleware handles JWT and personal access token extraction and validation.
 type AuthMiddleware struct {
-	tokens *auth.JWTManager
-	users  *repository.UserRepository
+	tokens    *auth.JWTManager
+	users     *repository.UserRepository
+	apiTokens *repository.APITokenRepository
 }
 
-// NewAuthMiddleware constructs an auth middleware instance.
-func NewAuthMiddleware(tokens *auth.JWTManager, users *repository.UserRepository) *AuthMiddleware {
-	return &AuthMiddleware{tokens: tokens, users: users}
+// NewAuthMiddleware constructs an auth middleware instance. apiTokens may be nil to accept JWTs only.
+func NewAuthMiddleware(tokens *auth.JWTManager, users *repository.UserRepository, apiTokens *repository.APITokenRepository) *AuthMiddleware {
+	return &AuthMiddleware{tokens: tokens, users: users, apiTokens: apiTokens}
 }
 
-// RequireAuth ensures a valid JWT is provided.
-func (m *AuthMiddleware) RequireAuth() gin.HandlerFunc {
+// RequireAuth ensures a valid JWT is provided. When scopes are given, a personal access token holding
+// all of them is accepted as well; routes without scopes are reserved for interactive sessions.
+func (m *AuthMiddleware) RequireAuth(scopes ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		token := extractBearer(c.GetHeader("Authorization"))
 		if token == "" {
@@ -33,6 +41,11 @@ func (m *AuthMiddleware) RequireAuth() gin.HandlerFunc {
 			return
 		}
 
+		if auth.IsAPIToken(token) {
+			m.requireAPIToken(c, token, scopes)
+			return
+		}
+
 		claims, err := m.tokens.Parse(token)
 		if err != nil {
 			c.Abort()
@@ -57,12 +70,14 @@ func (m *AuthMiddleware) RequireAuth() gin.HandlerFunc {
 		c.Set("user_id", userID)
 		c.Set("role", user.Role)
 		c.Set("user", user)
+		setSession(c, claims)
 		c.Next()
 	}
 }
 
-// OptionalAuth attaches user context if a valid JWT is provided, otherwise continues.
-func (m *AuthMiddleware) OptionalAuth() gin.HandlerFunc {
+// OptionalAuth attaches user context if a valid JWT, or a personal access token holding scopes, is
+// provided, otherwise continues.
+func (m *AuthMiddleware) OptionalAuth(scopes ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		token := extractBearer(c.GetHeader("Authorization"))
 		if token == "" {
@@ -70,6 +85,14 @@ func (m *AuthMiddleware) OptionalAuth() gin.HandlerFunc {
 			return
 		}
 
+		if auth.IsAPIToken(token) {
+			if user, apiToken, err := m.authenticateAPIToken(c, token, scopes); err == nil {
+				setAPITokenUser(c, user, apiToken)
+			}
+			c.Next()
+			return
+		}
+
 		claims, err := m.tokens.Parse(token)
 		if err != nil {
 			c.Next()
@@ -91,6 +114,7 @@ func (m *AuthMiddleware) OptionalAuth() gin.HandlerFunc {
 		c.Set("user_id", userID)
 		c.Set("role", user.Role)
 		c.Set("user", user)
+		setSession(c, claims)
 		c.Next()
 	}
 }
@@ -124,6 +148,67 @@ func (m *AuthMiddleware) RequireRoles(roles ...string) gin.HandlerFunc {
 	}
 }
 
+// requireAPIToken authenticates a personal access token for a route that requires scopes.
+func (m *AuthMiddleware) requireAPIToken(c *gin.Context, token string, scopes []string) {
+	user, apiToken, err := m.authenticateAPIToken(c, token, scopes)
+	if err != nil {
+		c.Abort()
+		if errors.Is(err, errAPITokenScope) {
+			httpx.Error(c, http.StatusForbidden, err.Error())
+			return
+		}
+		httpx.Error(c, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	setAPITokenUser(c, user, apiToken)
+	c.Next()
+}
+
+var (
+	errAPITokenInvalid = errors.New("invalid token")
+	errAPITokenScope   = errors.New("token scope does not allow this request")
+)
+
+// authenticateAPIToken resolves a personal access token and checks that it holds every scope.
+// Routes that declare no scopes never accept personal access tokens.
+func (m *AuthMiddleware) authenticateAPIToken(c *gin.Context, token string, scopes []string) (*models.User, *models.APIToken, error) {
+	if m.apiTokens == nil {
+		return nil, nil, errAPITokenInvalid
+	}
+
+	ctx := c.Request.Context()
+	apiToken, err := m.apiTokens.FindByHash(ctx, auth.HashAPIToken(token))
+	now := time.Now()
+	if err != nil || !apiToken.Active(now) {
+		return nil, nil, errAPITokenInvalid
+	}
+	if len(scopes) == 0 {
+		return nil, nil, errAPITokenScope
+	}
+	for _, scope := range scopes {
+		if !apiToken.HasScope(scope) {
+			return nil, nil, errAPITokenScope
+		}
+	}
+
+	user, err := m.users.FindByID(apiToken.UserID)
+	if err != nil {
+		return nil, nil, errAPITokenInvalid
+	}
+	// A lost last-used update must not fail the request.
+	_ = m.apiTokens.TouchLastUsed(ctx, apiToken.ID, now, now.Add(-apiTokenTouchInterval))
+	return user, apiToken, nil
+}
+
+// setAPITokenUser exposes the owner of a personal access token like a signed-in user, plus the token id.
+func setAPITokenUser(c *gin.Context, user *models.User, apiToken *models.APIToken) {
+	c.Set("user_id", user.ID)
+	c.Set("role", user.Role)
+	c.Set("user", user)
+	c.Set("api_token_id", apiToken.ID)
+}
+
 func extractBearer(header string) string {
 	if header == "" {
 		return ""
@@ -137,3 +222,10 @@ func extractBearer(header string) string {
 	}
 	return strings.TrimSpace(parts[1])
 }
+
+// setSession exposes the session the access token belongs to, if the token carries one.
+func setSession(c *gin.Context, claims *auth.Claims) {
+	if sessionID, err := uuid.Parse(claims.SessionID); err == nil {
+		c.Set("session_id", sessionID)
+	}
+}
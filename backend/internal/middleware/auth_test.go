@@ -31,7 +31,7 @@ func newAuthMiddlewareForTest(t *testing.T) (*AuthMiddleware, *models.User, *aut
 	if err != nil {
 		t.Fatalf("open sqlite: %v", err)
 	}
-	if err := db.AutoMigrate(&models.User{}); err != nil {
+	if err := db.AutoMigrate(&models.User{}, &models.APIToken{}); err != nil {
 		t.Fatalf("auto migrate: %v", err)
 	}
 
@@ -47,7 +47,7 @@ func newAuthMiddlewareForTest(t *testing.T) (*AuthMiddleware, *models.User, *aut
 
 	users := repository.NewUserRepository(db)
 	tokens := auth.NewJWTManager("test-secret", time.Hour)
-	return NewAuthMiddleware(tokens, users), user, tokens
+	return NewAuthMiddleware(tokens, users, repository.NewAPITokenRepository(db)), user, tokens
 }
 
 func TestOptionalAuthAllowsAnonymousWhenTokenInvalid(t *testing.T) {
@@ -83,7 +83,7 @@ func TestOptionalAuthAttachesContextWhenTokenValid(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	mw, user, tokens := newAuthMiddlewareForTest(t)
 
-	token, err := tokens.Generate(user)
+	token, err := tokens.Generate(user, "")
 	if err != nil {
 		t.Fatalf("generate token: %v", err)
 	}
@@ -112,3 +112,56 @@ func TestOptionalAuthAttachesContextWhenTokenValid(t *testing.T) {
 		t.Fatalf("expected valid token to attach user context, got %+v", body)
 	}
 }
+
+func TestRequireAuthAcceptsScopedAPITokens(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mw, user, _ := newAuthMiddlewareForTest(t)
+
+	raw, hash, prefix, err := auth.GenerateAPIToken()
+	if err != nil {
+		t.Fatalf("generate api token: %v", err)
+	}
+	apiToken := &models.APIToken{UserID: user.ID, Name: "bot", TokenHash: hash, Prefix: prefix, Scopes: models.ScopeReviewsRead}
+	if err := mw.apiTokens.Create(t.Context(), apiToken); err != nil {
+		t.Fatalf("create api token: %v", err)
+	}
+
+	router := gin.New()
+	ok := func(c *gin.Context) { c.Status(http.StatusNoContent) }
+	router.GET("/reviews/me", mw.RequireAuth(models.ScopeReviewsRead), ok)
+	router.POST("/reviews", mw.RequireAuth(models.ScopeReviewsWrite), ok)
+	router.POST("/users/me/password", mw.RequireAuth(), ok)
+
+	call := func(method, path, token string) int {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := call(http.MethodGet, "/reviews/me", raw); code != http.StatusNoContent {
+		t.Fatalf("expected a read token to read reviews, got %d", code)
+	}
+	if code := call(http.MethodPost, "/reviews", raw); code != http.StatusForbidden {
+		t.Fatalf("expected a read token to be refused for writes, got %d", code)
+	}
+	if code := call(http.MethodPost, "/users/me/password", raw); code != http.StatusForbidden {
+		t.Fatalf("expected api tokens to be refused on account routes, got %d", code)
+	}
+	if code := call(http.MethodGet, "/reviews/me", raw+"x"); code != http.StatusUnauthorized {
+		t.Fatalf("expected an unknown token to be rejected, got %d", code)
+	}
+
+	stored, err := mw.apiTokens.FindByHash(t.Context(), hash)
+	if err != nil || stored.LastUsedAt == nil {
+		t.Fatalf("expected the last used time to be recorded, got %+v (%v)", stored, err)
+	}
+
+	if _, err := mw.apiTokens.Revoke(t.Context(), user.ID, apiToken.ID, time.Now()); err != nil {
+		t.Fatalf("revoke api token: %v", err)
+	}
+	if code := call(http.MethodGet, "/reviews/me", raw); code != http.StatusUnauthorized {
+		t.Fatalf("expected a revoked token to be rejected, got %d", code)
+	}
+}
@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hdu-dp/backend/internal/captcha"
+	"github.com/hdu-dp/backend/internal/httpx"
+)
+
+// CaptchaTokenHeader carries the captcha token, keeping it out of the endpoints' JSON bodies.
+const CaptchaTokenHeader = "X-Captcha-Token"
+
+// RequireCaptcha rejects requests to endpoint with 403 when the guard asks for a captcha that the client did
+// not solve. Like RateLimitByIP, it lets requests through when the provider or store cannot be reached.
+func RequireCaptcha(guard *captcha.Guard, endpoint string) gin.HandlerFunc {
+	if !guard.Protects(endpoint) {
+		return func(c *gin.Context) { c.Next() }
+	}
+	return func(c *gin.Context) {
+		err := guard.Check(c.Request.Context(), endpoint, c.ClientIP(), c.GetHeader(CaptchaTokenHeader))
+		switch {
+		case err == nil:
+			c.Next()
+		case errors.Is(err, captcha.ErrMissing):
+			httpx.ErrorWithCode(c, http.StatusForbidden, "captcha_required", "请先完成人机验证")
+			c.Abort()
+		case errors.Is(err, captcha.ErrFailed):
+			httpx.ErrorWithCode(c, http.StatusForbidden, "captcha_invalid", "人机验证未通过，请重试")
+			c.Abort()
+		default:
+			slog.Warn("captcha check failed", slog.String("path", c.FullPath()), slog.Any("error", err))
+			c.Next()
+		}
+	}
+}
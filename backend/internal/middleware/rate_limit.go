@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"errors"
+	"log/slog"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hdu-dp/backend/internal/httpx"
+	"github.com/hdu-dp/backend/internal/ratelimit"
+)
+
+// RateLimitByIP rejects clients that exceed any of the rules with 429 and a Retry-After header.
+// Store failures are logged and the request is let through rather than locking everyone out.
+func RateLimitByIP(limiter *ratelimit.Limiter, rules ...ratelimit.Rule) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		err := limiter.Allow(c.Request.Context(), c.ClientIP(), rules...)
+		if err == nil {
+			c.Next()
+			return
+		}
+
+		var limited *ratelimit.LimitedError
+		if errors.As(err, &limited) {
+			httpx.TooManyRequests(c, limited.RetryAfterSeconds(), "请求过于频繁，请稍后再试")
+			c.Abort()
+			return
+		}
+
+		slog.Warn("rate limit check failed", slog.String("path", c.FullPath()), slog.Any("error", err))
+		c.Next()
+	}
+}
@@ -0,0 +1,70 @@
+package models
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Scopes a personal access token can be granted.
+const (
+	ScopeProfileRead  = "profile:read"
+	ScopeReviewsRead  = "reviews:read"
+	ScopeReviewsWrite = "reviews:write"
+	ScopeAdmin        = "admin"
+)
+
+// APITokenScopes lists every scope a token may request.
+var APITokenScopes = []string{
+	ScopeProfileRead,
+	ScopeReviewsRead,
+	ScopeReviewsWrite,
+	ScopeAdmin,
+}
+
+// APIToken is a named personal access token for scripts and bots. Only the SHA-256 of the token is
+// stored; Prefix keeps its first characters so users can recognise it.
+type APIToken struct {
+	ID         uuid.UUID  `gorm:"type:char(36);primaryKey"`
+	UserID     uuid.UUID  `gorm:"type:char(36);index;not null"`
+	Name       string     `gorm:"size:64;not null"`
+	TokenHash  string     `gorm:"size:64;not null;uniqueIndex"`
+	Prefix     string     `gorm:"size:16;not null"`
+	Scopes     string     `gorm:"size:255;not null"`
+	ExpiresAt  *time.Time `gorm:"index"`
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+	CreatedAt  time.Time
+}
+
+// BeforeCreate assigns UUIDs automatically.
+func (t *APIToken) BeforeCreate(tx *gorm.DB) error {
+	if t.ID == uuid.Nil {
+		t.ID = uuid.New()
+	}
+	return nil
+}
+
+// ScopeList returns the granted scopes.
+func (t *APIToken) ScopeList() []string {
+	var scopes []string
+	for _, scope := range strings.Split(t.Scopes, ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+// HasScope reports whether the token was granted scope.
+func (t *APIToken) HasScope(scope string) bool {
+	return slices.Contains(t.ScopeList(), scope)
+}
+
+// Active reports whether the token can still authenticate at now.
+func (t *APIToken) Active(now time.Time) bool {
+	return t.RevokedAt == nil && (t.ExpiresAt == nil || t.ExpiresAt.After(now))
+}
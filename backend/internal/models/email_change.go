@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// EmailChange tracks a request to move an account to a new email address. The change only takes effect
+// once the link sent to NewEmail is opened; afterwards the old address may revert it with the undo link
+// until UndoExpiresAt. Only SHA-256 hashes of both tokens are stored.
+type EmailChange struct {
+	ID            uuid.UUID `gorm:"type:char(36);primaryKey"`
+	UserID        uuid.UUID `gorm:"type:char(36);index;not null"`
+	OldEmail      string    `gorm:"size:255;not null"`
+	NewEmail      string    `gorm:"size:255;not null;index"`
+	TokenHash     string    `gorm:"size:64;not null;uniqueIndex"`
+	UndoTokenHash *string   `gorm:"size:64;uniqueIndex"`
+	// PasswordHash is the password chosen by accounts that had only a placeholder email, applied on confirmation.
+	PasswordHash  string    `gorm:"size:255"`
+	ExpiresAt     time.Time `gorm:"not null;index"`
+	ConfirmedAt   *time.Time
+	UndoExpiresAt *time.Time
+	UndoneAt      *time.Time
+	CreatedAt     time.Time
+}
+
+// BeforeCreate assigns UUIDs automatically.
+func (c *EmailChange) BeforeCreate(tx *gorm.DB) error {
+	if c.ID == uuid.Nil {
+		c.ID = uuid.New()
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// EmailOutboxStatus enumerates delivery states of a queued email.
+type EmailOutboxStatus string
+
+const (
+	EmailOutboxStatusPending EmailOutboxStatus = "pending"
+	EmailOutboxStatusSending EmailOutboxStatus = "sending"
+	EmailOutboxStatusSent    EmailOutboxStatus = "sent"
+	EmailOutboxStatusDead    EmailOutboxStatus = "dead"
+)
+
+// EmailOutbox is a persisted outbound email waiting to be delivered by the background worker.
+type EmailOutbox struct {
+	ID            uuid.UUID         `gorm:"type:char(36);primaryKey" json:"id"`
+	Recipient     string            `gorm:"size:255;not null;index" json:"recipient"`
+	Subject       string            `gorm:"size:255;not null" json:"subject"`
+	Body          string            `gorm:"type:text;not null" json:"-"`
+	TextBody      string            `gorm:"type:text" json:"-"`
+	Status        EmailOutboxStatus `gorm:"size:20;not null;default:pending;index" json:"status"`
+	Attempts      int               `gorm:"default:0" json:"attempts"`
+	MaxAttempts   int               `gorm:"default:0" json:"max_attempts"`
+	NextAttemptAt time.Time         `gorm:"index;not null" json:"next_attempt_at"`
+	LastError     string            `gorm:"type:text" json:"last_error"`
+	SentAt        *time.Time        `json:"sent_at"`
+	CreatedAt     time.Time         `json:"created_at"`
+	UpdatedAt     time.Time         `json:"updated_at"`
+}
+
+// BeforeCreate assigns UUIDs automatically.
+func (e *EmailOutbox) BeforeCreate(tx *gorm.DB) error {
+	if e.ID == uuid.Nil {
+		e.ID = uuid.New()
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// UserTOTP holds a user's authenticator enrolment. SecretEnc is the AES-GCM sealed base32 secret.
+// LastCounter is the last accepted time step and prevents a code from being used twice.
+type UserTOTP struct {
+	UserID      uuid.UUID `gorm:"type:char(36);primaryKey"`
+	SecretEnc   string    `gorm:"size:255;not null"`
+	Enabled     bool      `gorm:"not null;default:false"`
+	LastCounter int64     `gorm:"not null;default:0"`
+	ConfirmedAt *time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// TableName keeps the table name readable.
+func (UserTOTP) TableName() string {
+	return "user_totps"
+}
+
+// MFARecoveryCode is a single-use fallback for a lost authenticator. Only the SHA-256 of the code is stored.
+type MFARecoveryCode struct {
+	ID        uuid.UUID `gorm:"type:char(36);primaryKey"`
+	UserID    uuid.UUID `gorm:"type:char(36);index;not null"`
+	CodeHash  string    `gorm:"size:64;not null;index"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// BeforeCreate assigns UUIDs automatically.
+func (c *MFARecoveryCode) BeforeCreate(tx *gorm.DB) error {
+	if c.ID == uuid.Nil {
+		c.ID = uuid.New()
+	}
+	return nil
+}
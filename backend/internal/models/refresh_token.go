@@ -8,14 +8,33 @@ import (
 )
 
 // RefreshToken represents a persistent refresh token for a user session.
+// Rotation replaces the row but carries DeviceLabel and CreatedAt over, so CreatedAt is when
+// the session signed in and LastUsedAt is when it last refreshed. All rows descending from one
+// sign-in share FamilyID; ParentID points at the token that was exchanged for this one and
+// RotatedAt is set once this token has itself been exchanged.
 type RefreshToken struct {
-	ID         uuid.UUID `gorm:"type:char(36);primaryKey"`
-	UserID     uuid.UUID `gorm:"type:char(36);index;not null"`
-	SecretHash string    `gorm:"size:255;not null"`
-	ExpiresAt  time.Time `gorm:"index"`
-	Revoked    bool      `gorm:"default:false"`
-	CreatedAt  time.Time
-	UpdatedAt  time.Time
+	ID          uuid.UUID  `gorm:"type:char(36);primaryKey"`
+	UserID      uuid.UUID  `gorm:"type:char(36);index;not null"`
+	FamilyID    uuid.UUID  `gorm:"type:char(36);index"`
+	ParentID    *uuid.UUID `gorm:"type:char(36)"`
+	SecretHash  string     `gorm:"size:255;not null"`
+	ExpiresAt   time.Time  `gorm:"index"`
+	Revoked     bool       `gorm:"default:false"`
+	DeviceLabel string     `gorm:"size:100"`
+	UserAgent   string     `gorm:"size:512"`
+	IP          string     `gorm:"column:ip;size:64"`
+	RotatedAt   *time.Time
+	LastUsedAt  time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// Family returns the rotation family of the token. Tokens issued before families existed form their own.
+func (t *RefreshToken) Family() uuid.UUID {
+	if t.FamilyID == uuid.Nil {
+		return t.ID
+	}
+	return t.FamilyID
 }
 
 // BeforeCreate assigns UUIDs automatically.
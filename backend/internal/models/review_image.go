@@ -13,7 +13,24 @@ type ReviewImage struct {
 	ReviewID   uuid.UUID `gorm:"type:char(36);index;not null" json:"review_id"`
 	StorageKey string    `gorm:"size:255;not null" json:"storage_key"`
 	URL        string    `gorm:"size:512;not null" json:"url"`
-	CreatedAt  time.Time `json:"created_at"`
+	Width      int       `json:"width"`
+	Height     int       `json:"height"`
+	// Position orders the images of a review, lowest first.
+	Position int    `gorm:"not null;default:0" json:"position"`
+	Caption  string `gorm:"size:200" json:"caption"`
+	// Thumbnail, Medium and Large are resized JPEG renditions; images uploaded before they existed have none.
+	Thumbnail ImageVariant `gorm:"embedded;embeddedPrefix:thumbnail_" json:"thumbnail"`
+	Medium    ImageVariant `gorm:"embedded;embeddedPrefix:medium_" json:"medium"`
+	Large     ImageVariant `gorm:"embedded;embeddedPrefix:large_" json:"large"`
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+// ImageVariant is one stored rendition of an uploaded image.
+type ImageVariant struct {
+	Key    string `gorm:"size:255" json:"-"`
+	URL    string `gorm:"size:512" json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
 }
 
 // BeforeCreate assigns UUIDs automatically.
@@ -23,3 +40,14 @@ func (ri *ReviewImage) BeforeCreate(tx *gorm.DB) error {
 	}
 	return nil
 }
+
+// StorageKeys returns the keys of the original upload and every stored rendition.
+func (ri *ReviewImage) StorageKeys() []string {
+	var keys []string
+	for _, key := range []string{ri.StorageKey, ri.Thumbnail.Key, ri.Medium.Key, ri.Large.Key} {
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// SigningKey is an asymmetric key pair for access tokens, stored when keys are managed in the database.
+// ID is the kid header value. A key is published for verification as soon as it is stored and signs new
+// tokens from ActivatesAt (CreatedAt when unset) until RetiredAt; it still verifies tokens until ExpiresAt.
+// Only the newest key has no RetiredAt. PrivateKeyEnc is the sealed PKCS#8 PEM.
+type SigningKey struct {
+	ID            string `gorm:"size:32;primaryKey"`
+	Algorithm     string `gorm:"size:16;not null"`
+	PrivateKeyEnc string `gorm:"type:text;not null"`
+	CreatedAt     time.Time
+	ActivatesAt   *time.Time
+	RetiredAt     *time.Time `gorm:"index"`
+	ExpiresAt     *time.Time `gorm:"index"`
+}
+
+// Activation returns when the key starts signing.
+func (k *SigningKey) Activation() time.Time {
+	if k.ActivatesAt != nil {
+		return *k.ActivatesAt
+	}
+	return k.CreatedAt
+}
+
+// SignsAt reports whether the key is the one meant to sign tokens at t.
+func (k *SigningKey) SignsAt(t time.Time) bool {
+	return !t.Before(k.Activation()) && (k.RetiredAt == nil || t.Before(*k.RetiredAt))
+}
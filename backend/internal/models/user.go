@@ -1,27 +1,63 @@
 package models
 
 import (
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
+// Dietary preferences a user can list on their profile.
+const (
+	DietVegetarian = "vegetarian"
+	DietVegan      = "vegan"
+	DietHalal      = "halal"
+	DietNoPork     = "no_pork"
+	DietNoSpicy    = "no_spicy"
+	DietSpicy      = "spicy"
+	DietLowSugar   = "low_sugar"
+	DietGlutenFree = "gluten_free"
+)
+
+// DietaryPreferenceOptions enumerates every supported dietary preference.
+var DietaryPreferenceOptions = []string{
+	DietVegetarian,
+	DietVegan,
+	DietHalal,
+	DietNoPork,
+	DietNoSpicy,
+	DietSpicy,
+	DietLowSugar,
+	DietGlutenFree,
+}
+
 // User represents an application account.
 type User struct {
-	ID              uuid.UUID  `gorm:"type:char(36);primaryKey" json:"id"`
-	Email           string     `gorm:"uniqueIndex;size:255;not null" json:"email"`
-	Phone           *string    `gorm:"size:32;uniqueIndex" json:"phone,omitempty"`
-	QQOpenID        *string    `gorm:"column:qq_open_id;size:64;uniqueIndex" json:"qq_open_id,omitempty"`
-	WeChatOpenID    *string    `gorm:"column:we_chat_open_id;size:64;uniqueIndex" json:"wechat_open_id,omitempty"`
-	PasswordHash    string     `gorm:"size:255;not null" json:"-"`
-	DisplayName     string     `gorm:"size:100;not null" json:"display_name"`
-	Role            string     `gorm:"size:20;default:user" json:"role"`
-	EmailVerified   bool       `gorm:"default:false" json:"email_verified"`
-	EmailVerifiedAt *time.Time `json:"email_verified_at"`
-	CreatedAt       time.Time  `json:"created_at"`
-	UpdatedAt       time.Time  `json:"updated_at"`
-	Reviews         []Review   `gorm:"foreignKey:AuthorID" json:"-"`
+	ID                  uuid.UUID  `gorm:"type:char(36);primaryKey" json:"id"`
+	Email               string     `gorm:"uniqueIndex;size:255;not null" json:"email"`
+	Phone               *string    `gorm:"size:32;uniqueIndex" json:"phone,omitempty"`
+	QQOpenID            *string    `gorm:"column:qq_open_id;size:64;uniqueIndex" json:"qq_open_id,omitempty"`
+	WeChatOpenID        *string    `gorm:"column:we_chat_open_id;size:64;uniqueIndex" json:"wechat_open_id,omitempty"`
+	PasswordHash        string     `gorm:"size:255;not null" json:"-"`
+	DisplayName         string     `gorm:"size:100;not null;uniqueIndex:idx_users_display_name,expression:LOWER(display_name),where:email NOT GLOB 'deleted_*@local.invalid'" json:"display_name"` // unique ignoring case, except on anonymised accounts
+	Bio                 string     `gorm:"size:1000" json:"bio"`
+	DietaryPreferences  string     `gorm:"size:255" json:"-"` // comma separated, see DietaryPreferenceList
+	AvatarKey           string     `gorm:"size:512" json:"-"`
+	AvatarURL           string     `gorm:"size:1024" json:"avatar_url"`
+	AvatarSmallURL      string     `gorm:"size:1024" json:"avatar_small_url"`
+	Locale              string     `gorm:"size:16;not null;default:''" json:"-"` // private, shown only in the user's own profile
+	Role                string     `gorm:"size:20;default:user" json:"role"`
+	EmailVerified       bool       `gorm:"default:false" json:"email_verified"`
+	EmailVerifiedAt     *time.Time `json:"email_verified_at"`
+	CampusVerified      bool       `gorm:"not null;default:false;index" json:"campus_verified"`
+	FailedLoginAttempts int        `gorm:"not null;default:0" json:"-"`
+	LastFailedLoginAt   *time.Time `json:"-"`
+	LockedUntil         *time.Time `json:"-"`
+	DeletionScheduledAt *time.Time `gorm:"index" json:"-"` // private, shown only in the user's own profile
+	CreatedAt           time.Time  `json:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at"`
+	Reviews             []Review   `gorm:"foreignKey:AuthorID" json:"-"`
 }
 
 // BeforeCreate hook to set UUIDs automatically.
@@ -31,3 +67,14 @@ func (u *User) BeforeCreate(tx *gorm.DB) error {
 	}
 	return nil
 }
+
+// DietaryPreferenceList returns the stored dietary preferences as a slice.
+func (u *User) DietaryPreferenceList() []string {
+	list := []string{}
+	for _, item := range strings.Split(u.DietaryPreferences, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,104 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Webhook event names published to subscribed endpoints.
+const (
+	WebhookEventReviewApproved = "review.approved"
+	WebhookEventReviewRejected = "review.rejected"
+	WebhookEventReviewDeleted  = "review.deleted"
+	WebhookEventUserRegistered = "user.registered"
+)
+
+// WebhookEvents lists every event an endpoint may subscribe to.
+var WebhookEvents = []string{
+	WebhookEventReviewApproved,
+	WebhookEventReviewRejected,
+	WebhookEventReviewDeleted,
+	WebhookEventUserRegistered,
+}
+
+// WebhookEndpoint is an admin-configured URL that receives signed event payloads.
+type WebhookEndpoint struct {
+	ID        uuid.UUID `gorm:"type:char(36);primaryKey" json:"id"`
+	Name      string    `gorm:"size:100;not null" json:"name"`
+	URL       string    `gorm:"size:500;not null" json:"url"`
+	Secret    string    `gorm:"size:128;not null" json:"-"`
+	Events    string    `gorm:"size:255;not null" json:"-"`
+	Enabled   bool      `gorm:"default:true" json:"enabled"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// BeforeCreate assigns UUIDs automatically.
+func (w *WebhookEndpoint) BeforeCreate(tx *gorm.DB) error {
+	if w.ID == uuid.Nil {
+		w.ID = uuid.New()
+	}
+	return nil
+}
+
+// EventList returns the subscribed events.
+func (w *WebhookEndpoint) EventList() []string {
+	var events []string
+	for _, event := range strings.Split(w.Events, ",") {
+		if event = strings.TrimSpace(event); event != "" {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// Subscribes reports whether the endpoint wants the given event.
+func (w *WebhookEndpoint) Subscribes(event string) bool {
+	for _, subscribed := range w.EventList() {
+		if subscribed == event {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDeliveryStatus enumerates delivery states of a webhook payload.
+type WebhookDeliveryStatus string
+
+const (
+	WebhookDeliveryStatusPending   WebhookDeliveryStatus = "pending"
+	WebhookDeliveryStatusSending   WebhookDeliveryStatus = "sending"
+	WebhookDeliveryStatusSucceeded WebhookDeliveryStatus = "succeeded"
+	WebhookDeliveryStatusFailed    WebhookDeliveryStatus = "failed"
+)
+
+// WebhookDelivery is one queued payload for an endpoint and doubles as its delivery log entry.
+type WebhookDelivery struct {
+	ID             uuid.UUID             `gorm:"type:char(36);primaryKey" json:"id"`
+	EndpointID     uuid.UUID             `gorm:"type:char(36);not null;index" json:"endpoint_id"`
+	Event          string                `gorm:"size:50;not null" json:"event"`
+	Payload        string                `gorm:"type:text;not null" json:"payload"`
+	Status         WebhookDeliveryStatus `gorm:"size:20;not null;default:pending;index" json:"status"`
+	Attempts       int                   `gorm:"default:0" json:"attempts"`
+	MaxAttempts    int                   `gorm:"default:0" json:"max_attempts"`
+	NextAttemptAt  time.Time             `gorm:"index;not null" json:"next_attempt_at"`
+	ResponseStatus int                   `gorm:"default:0" json:"response_status"`
+	ResponseBody   string                `gorm:"type:text" json:"response_body"`
+	LastError      string                `gorm:"type:text" json:"last_error"`
+	DurationMs     int64                 `gorm:"default:0" json:"duration_ms"`
+	DeliveredAt    *time.Time            `json:"delivered_at"`
+	RedeliveryOf   *uuid.UUID            `gorm:"type:char(36)" json:"redelivery_of,omitempty"`
+	CreatedAt      time.Time             `json:"created_at"`
+	UpdatedAt      time.Time             `json:"updated_at"`
+}
+
+// BeforeCreate assigns UUIDs automatically.
+func (d *WebhookDelivery) BeforeCreate(tx *gorm.DB) error {
+	if d.ID == uuid.Nil {
+		d.ID = uuid.New()
+	}
+	return nil
+}
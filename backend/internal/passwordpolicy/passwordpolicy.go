@@ -0,0 +1,218 @@
+// Package passwordpolicy decides whether a new password is acceptable: long enough, mixing enough kinds of
+// characters, unrelated to the account's email and display name, and absent from a list of breached passwords.
+package passwordpolicy
+
+import (
+	"bufio"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Violation codes returned to clients so they can explain what to change.
+const (
+	CodeTooShort         = "password_too_short"
+	CodeTooLong          = "password_too_long"
+	CodeTooFewClasses    = "password_too_few_character_classes"
+	CodeContainsIdentity = "password_contains_personal_info"
+	CodeBreached         = "password_breached"
+)
+
+// hashPrefixLength splits SHA-1 hashes the way the Have I Been Pwned range API does, so that lookups only
+// compare against hashes sharing the first five hex characters.
+const hashPrefixLength = 5
+
+// identityMinLength keeps short names and email local parts from rejecting half of all passwords.
+const identityMinLength = 3
+
+//go:embed breached.txt
+var bundledBreached string
+
+// Violation describes why a password was refused. Limit is the relevant minimum or maximum, if any.
+type Violation struct {
+	Code  string
+	Limit int
+}
+
+func (v *Violation) Error() string {
+	if v.Limit > 0 {
+		return fmt.Sprintf("password rejected: %s (%d)", v.Code, v.Limit)
+	}
+	return "password rejected: " + v.Code
+}
+
+// Options configures a Policy.
+type Options struct {
+	MinLength int
+	MaxLength int
+	// MinClasses is how many of lowercase letters, uppercase letters, digits and other characters are required.
+	MinClasses int
+	// RejectPersonalInfo refuses passwords containing the email local part or display name.
+	RejectPersonalInfo bool
+	// CheckBreached refuses passwords on the bundled list and, when set, BreachedListFile.
+	CheckBreached bool
+	// BreachedListFile adds SHA-1 hashes, one per line, optionally followed by ":count" as in the
+	// downloadable Have I Been Pwned list.
+	BreachedListFile string
+}
+
+// Policy checks new passwords. A nil Policy accepts any non-empty password.
+type Policy struct {
+	opts     Options
+	breached map[string][]string
+}
+
+// New builds a Policy, loading the breached password hashes when the check is enabled.
+func New(opts Options) (*Policy, error) {
+	if opts.MinLength < 1 {
+		opts.MinLength = 1
+	}
+	if opts.MaxLength > 0 && opts.MaxLength < opts.MinLength {
+		return nil, fmt.Errorf("password max length %d is below min length %d", opts.MaxLength, opts.MinLength)
+	}
+	if opts.MinClasses < 0 || opts.MinClasses > 4 {
+		return nil, fmt.Errorf("password min character classes must be between 0 and 4, got %d", opts.MinClasses)
+	}
+
+	p := &Policy{opts: opts}
+	if !opts.CheckBreached {
+		return p, nil
+	}
+
+	p.breached = make(map[string][]string)
+	if err := p.loadBreached(strings.NewReader(bundledBreached)); err != nil {
+		return nil, fmt.Errorf("load bundled breached passwords: %w", err)
+	}
+	if opts.BreachedListFile != "" {
+		file, err := os.Open(opts.BreachedListFile)
+		if err != nil {
+			return nil, fmt.Errorf("open breached password list: %w", err)
+		}
+		defer file.Close()
+		if err := p.loadBreached(file); err != nil {
+			return nil, fmt.Errorf("load breached password list: %w", err)
+		}
+	}
+	return p, nil
+}
+
+// Check returns a *Violation when password breaks the policy. identity lists the email and display name of
+// the account the password is for.
+func (p *Policy) Check(password string, identity ...string) error {
+	if p == nil {
+		if password == "" {
+			return &Violation{Code: CodeTooShort, Limit: 1}
+		}
+		return nil
+	}
+
+	length := utf8.RuneCountInString(password)
+	if length < p.opts.MinLength {
+		return &Violation{Code: CodeTooShort, Limit: p.opts.MinLength}
+	}
+	if p.opts.MaxLength > 0 && length > p.opts.MaxLength {
+		return &Violation{Code: CodeTooLong, Limit: p.opts.MaxLength}
+	}
+	if characterClasses(password) < p.opts.MinClasses {
+		return &Violation{Code: CodeTooFewClasses, Limit: p.opts.MinClasses}
+	}
+	if p.opts.RejectPersonalInfo && containsIdentity(password, identity) {
+		return &Violation{Code: CodeContainsIdentity}
+	}
+	if p.isBreached(password) {
+		return &Violation{Code: CodeBreached}
+	}
+	return nil
+}
+
+// isBreached looks the password up as typed and in lowercase, since lists mostly hold lowercase variants.
+func (p *Policy) isBreached(password string) bool {
+	if p.breached == nil {
+		return false
+	}
+	for _, candidate := range []string{password, strings.ToLower(password)} {
+		sum := sha1.Sum([]byte(candidate))
+		hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+		for _, suffix := range p.breached[hash[:hashPrefixLength]] {
+			if suffix == hash[hashPrefixLength:] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p *Policy) loadBreached(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		hash, _, _ := strings.Cut(text, ":")
+		hash = strings.ToUpper(hash)
+		if len(hash) != sha1.Size*2 {
+			return fmt.Errorf("line %d: not a SHA-1 hash", line)
+		}
+		if _, err := hex.DecodeString(hash); err != nil {
+			return fmt.Errorf("line %d: not a SHA-1 hash", line)
+		}
+		prefix := hash[:hashPrefixLength]
+		p.breached[prefix] = append(p.breached[prefix], hash[hashPrefixLength:])
+	}
+	return scanner.Err()
+}
+
+func characterClasses(password string) int {
+	var lower, upper, digit, other bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+	count := 0
+	for _, present := range []bool{lower, upper, digit, other} {
+		if present {
+			count++
+		}
+	}
+	return count
+}
+
+// containsIdentity reports whether the password contains the local part of an email or a display name,
+// comparing only letters and digits and ignoring case.
+func containsIdentity(password string, identity []string) bool {
+	normalized := normalizeIdentity(password)
+	for _, value := range identity {
+		if local, _, ok := strings.Cut(value, "@"); ok {
+			value = local
+		}
+		value = normalizeIdentity(value)
+		if utf8.RuneCountInString(value) >= identityMinLength && strings.Contains(normalized, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeIdentity(value string) string {
+	return strings.Map(func(r rune) rune {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, value)
+}
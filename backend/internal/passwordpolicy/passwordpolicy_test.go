@@ -0,0 +1,52 @@
+package passwordpolicy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPolicyCheck(t *testing.T) {
+	extra := filepath.Join(t.TempDir(), "pwned.txt")
+	// SHA-1 of "correct horse battery staple", in the format of the downloadable list.
+	if err := os.WriteFile(extra, []byte("ABF7AAD6438836DBE526AA231ABDE2D0EEF74D42:12\n"), 0o600); err != nil {
+		t.Fatalf("write list failed: %v", err)
+	}
+	policy, err := New(Options{
+		MinLength:          8,
+		MaxLength:          64,
+		MinClasses:         3,
+		RejectPersonalInfo: true,
+		CheckBreached:      true,
+		BreachedListFile:   extra,
+	})
+	if err != nil {
+		t.Fatalf("new policy failed: %v", err)
+	}
+
+	for password, want := range map[string]string{
+		"Ab1!":                         CodeTooShort,
+		"alllowercase":                 CodeTooFewClasses,
+		"Zhang.Wei-2024":               CodeContainsIdentity,
+		"my mail is ZW2001@x":          CodeContainsIdentity,
+		"P@ssw0rd":                     CodeBreached,
+		"Correct horse battery staple": CodeBreached,
+		"Lantern-42-orbit":             "",
+		"食堂-Dumpling-7":                "",
+	} {
+		err := policy.Check(password, "zw2001@example.com", "Zhang Wei")
+		var violation *Violation
+		switch {
+		case want == "" && err != nil:
+			t.Fatalf("expected %q to be accepted, got %v", password, err)
+		case want != "" && (!errors.As(err, &violation) || violation.Code != want):
+			t.Fatalf("expected %q to be refused with %s, got %v", password, want, err)
+		}
+	}
+
+	var nilPolicy *Policy
+	if nilPolicy.Check("x") != nil || nilPolicy.Check("") == nil {
+		t.Fatalf("expected a nil policy to only require a password")
+	}
+}
@@ -0,0 +1,129 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const memorySweepEvery = 1024
+
+type memoryEntry struct {
+	hits      []time.Time
+	count     int
+	expiresAt time.Time
+}
+
+// MemoryStore is a process-local Store. State is lost on restart and not shared between instances.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]*memoryEntry
+	ops     int
+}
+
+// NewMemoryStore creates an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]*memoryEntry)}
+}
+
+// Take implements Store using a sliding log of hit timestamps.
+func (s *MemoryStore) Take(_ context.Context, key string, limit int, window time.Duration, now time.Time) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maybeSweep(now)
+
+	entry := s.entries[key]
+	if entry == nil {
+		entry = &memoryEntry{}
+		s.entries[key] = entry
+	}
+
+	if allowed, retryAfter := entry.check(limit, window, now); !allowed {
+		return false, retryAfter, nil
+	}
+
+	entry.hits = append(entry.hits, now)
+	if expires := now.Add(window); expires.After(entry.expiresAt) {
+		entry.expiresAt = expires
+	}
+	return true, 0, nil
+}
+
+// Peek implements Store.
+func (s *MemoryStore) Peek(_ context.Context, key string, limit int, window time.Duration, now time.Time) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry := s.entries[key]
+	if entry == nil {
+		return true, 0, nil
+	}
+	allowed, retryAfter := entry.check(limit, window, now)
+	return allowed, retryAfter, nil
+}
+
+// check drops hits that left the window and reports whether another hit fits under limit.
+func (e *memoryEntry) check(limit int, window time.Duration, now time.Time) (bool, time.Duration) {
+	cutoff := now.Add(-window)
+	kept := e.hits[:0]
+	for _, hit := range e.hits {
+		if hit.After(cutoff) {
+			kept = append(kept, hit)
+		}
+	}
+	e.hits = kept
+
+	if len(e.hits) >= limit {
+		return false, e.hits[0].Add(window).Sub(now)
+	}
+	return true, 0
+}
+
+// Increment implements Store.
+func (s *MemoryStore) Increment(_ context.Context, key string, ttl time.Duration, now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maybeSweep(now)
+
+	entry := s.entries[key]
+	if entry == nil || !now.Before(entry.expiresAt) {
+		entry = &memoryEntry{expiresAt: now.Add(ttl)}
+		s.entries[key] = entry
+	}
+	entry.count++
+	return entry.count, nil
+}
+
+// Count implements Store.
+func (s *MemoryStore) Count(_ context.Context, key string, now time.Time) (int, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry := s.entries[key]
+	if entry == nil || !now.Before(entry.expiresAt) {
+		return 0, 0, nil
+	}
+	return entry.count, entry.expiresAt.Sub(now), nil
+}
+
+// Reset implements Store.
+func (s *MemoryStore) Reset(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+// maybeSweep drops expired entries every so often so idle keys do not accumulate.
+func (s *MemoryStore) maybeSweep(now time.Time) {
+	s.ops++
+	if s.ops < memorySweepEvery {
+		return
+	}
+	s.ops = 0
+	for key, entry := range s.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(s.entries, key)
+		}
+	}
+}
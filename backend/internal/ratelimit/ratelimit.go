@@ -0,0 +1,123 @@
+// Package ratelimit implements sliding-window request limits and failure counters on top of a
+// pluggable Store, so the default in-memory state can later be swapped for a shared backend.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Store keeps rate-limit state. Implementations must make each method atomic per key.
+type Store interface {
+	// Take records a hit for key if fewer than limit hits happened within window before now.
+	// When the limit is reached it records nothing and returns how long until the oldest hit expires.
+	Take(ctx context.Context, key string, limit int, window time.Duration, now time.Time) (allowed bool, retryAfter time.Duration, err error)
+	// Peek reports what Take would answer without recording a hit.
+	Peek(ctx context.Context, key string, limit int, window time.Duration, now time.Time) (allowed bool, retryAfter time.Duration, err error)
+	// Increment bumps a counter that expires ttl after its first increment and returns the new value.
+	Increment(ctx context.Context, key string, ttl time.Duration, now time.Time) (int, error)
+	// Count returns the current value of an Increment counter and how long until it expires.
+	Count(ctx context.Context, key string, now time.Time) (int, time.Duration, error)
+	// Reset clears all state for key.
+	Reset(ctx context.Context, key string) error
+}
+
+// Rule allows at most Limit hits per Window. Name namespaces the keys of different rules.
+type Rule struct {
+	Name   string
+	Limit  int
+	Window time.Duration
+}
+
+// LimitedError is returned when a rule rejects a request.
+type LimitedError struct {
+	Rule       string
+	RetryAfter time.Duration
+}
+
+func (e *LimitedError) Error() string {
+	return fmt.Sprintf("rate limited by %s, retry after %s", e.Rule, e.RetryAfter)
+}
+
+// RetryAfterSeconds rounds the wait up to whole seconds for the Retry-After header.
+func (e *LimitedError) RetryAfterSeconds() int {
+	seconds := int(math.Ceil(e.RetryAfter.Seconds()))
+	if seconds < 1 {
+		return 1
+	}
+	return seconds
+}
+
+// Limiter applies rules against a Store. A nil Limiter allows everything.
+type Limiter struct {
+	store Store
+	now   func() time.Time
+}
+
+// New constructs a Limiter backed by store.
+func New(store Store) *Limiter {
+	return &Limiter{store: store, now: time.Now}
+}
+
+// Allow checks the rules for subject and returns a *LimitedError from the first one that rejects.
+// Hits are recorded only once every rule allows, so a request refused by one rule does not use up
+// the quota of the others. Rules with a non-positive limit or window are skipped.
+func (l *Limiter) Allow(ctx context.Context, subject string, rules ...Rule) error {
+	if l == nil {
+		return nil
+	}
+
+	now := l.now()
+	active := make([]Rule, 0, len(rules))
+	for _, rule := range rules {
+		if rule.Limit <= 0 || rule.Window <= 0 {
+			continue
+		}
+		allowed, retryAfter, err := l.store.Peek(ctx, rule.Name+":"+subject, rule.Limit, rule.Window, now)
+		if err != nil {
+			return fmt.Errorf("rate limit %s: %w", rule.Name, err)
+		}
+		if !allowed {
+			return &LimitedError{Rule: rule.Name, RetryAfter: retryAfter}
+		}
+		active = append(active, rule)
+	}
+
+	for _, rule := range active {
+		// A concurrent request may have taken the last slot since the check above.
+		allowed, retryAfter, err := l.store.Take(ctx, rule.Name+":"+subject, rule.Limit, rule.Window, now)
+		if err != nil {
+			return fmt.Errorf("rate limit %s: %w", rule.Name, err)
+		}
+		if !allowed {
+			return &LimitedError{Rule: rule.Name, RetryAfter: retryAfter}
+		}
+	}
+	return nil
+}
+
+// RecordFailure counts a failed attempt for key and returns the total within ttl.
+func (l *Limiter) RecordFailure(ctx context.Context, key string, ttl time.Duration) (int, error) {
+	if l == nil {
+		return 0, nil
+	}
+	return l.store.Increment(ctx, key, ttl, l.now())
+}
+
+// Failures returns the failure count recorded under key and how long until it expires.
+func (l *Limiter) Failures(ctx context.Context, key string) (int, time.Duration, error) {
+	if l == nil {
+		return 0, 0, nil
+	}
+	return l.store.Count(ctx, key, l.now())
+}
+
+// Reset clears the state stored under key.
+func (l *Limiter) Reset(ctx context.Context, key string) error {
+	if l == nil {
+		return nil
+	}
+	return l.store.Reset(ctx, key)
+}
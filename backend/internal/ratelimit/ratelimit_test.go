@@ -0,0 +1,100 @@
+package ratelimit
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLimiterSlidingWindow(t *testing.T) {
+	limiter := New(NewMemoryStore())
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	limiter.now = func() time.Time { return now }
+
+	rule := Rule{Name: "quota", Limit: 2, Window: time.Minute}
+	for i := 0; i < 2; i++ {
+		if err := limiter.Allow(t.Context(), "13800138000", rule); err != nil {
+			t.Fatalf("hit %d should be allowed: %v", i+1, err)
+		}
+		now = now.Add(20 * time.Second)
+	}
+
+	err := limiter.Allow(t.Context(), "13800138000", rule)
+	var limited *LimitedError
+	if !errors.As(err, &limited) {
+		t.Fatalf("expected third hit to be limited, got %v", err)
+	}
+	if limited.RetryAfter != 20*time.Second || limited.RetryAfterSeconds() != 20 {
+		t.Fatalf("expected retry after the oldest hit leaves the window, got %s", limited.RetryAfter)
+	}
+
+	if err := limiter.Allow(t.Context(), "13900139000", rule); err != nil {
+		t.Fatalf("other subjects must not share the quota: %v", err)
+	}
+
+	now = now.Add(20 * time.Second)
+	if err := limiter.Allow(t.Context(), "13800138000", rule); err != nil {
+		t.Fatalf("expected hit to be allowed once the window slid: %v", err)
+	}
+}
+
+func TestLimiterRejectedRequestDoesNotUseOtherQuotas(t *testing.T) {
+	limiter := New(NewMemoryStore())
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	limiter.now = func() time.Time { return now }
+
+	quota := Rule{Name: "quota", Limit: 2, Window: time.Hour}
+	cooldown := Rule{Name: "cooldown", Limit: 1, Window: time.Minute}
+	if err := limiter.Allow(t.Context(), "a@example.com", quota, cooldown); err != nil {
+		t.Fatalf("first hit should be allowed: %v", err)
+	}
+
+	for i := 0; i < 5; i++ {
+		var limited *LimitedError
+		if err := limiter.Allow(t.Context(), "a@example.com", quota, cooldown); !errors.As(err, &limited) || limited.Rule != "cooldown" {
+			t.Fatalf("attempt %d: expected cooldown to reject, got %v", i+1, err)
+		}
+	}
+
+	now = now.Add(time.Minute)
+	if err := limiter.Allow(t.Context(), "a@example.com", quota, cooldown); err != nil {
+		t.Fatalf("refused attempts must not have used the quota: %v", err)
+	}
+}
+
+func TestLimiterFailureCounterExpiresAndResets(t *testing.T) {
+	limiter := New(NewMemoryStore())
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	limiter.now = func() time.Time { return now }
+
+	for want := 1; want <= 3; want++ {
+		got, err := limiter.RecordFailure(t.Context(), "attempts:a", time.Minute)
+		if err != nil || got != want {
+			t.Fatalf("expected count %d, got %d (%v)", want, got, err)
+		}
+	}
+
+	now = now.Add(20 * time.Second)
+	if count, ttl, err := limiter.Failures(t.Context(), "attempts:a"); err != nil || count != 3 || ttl != 40*time.Second {
+		t.Fatalf("expected 3 failures expiring in 40s, got %d %s (%v)", count, ttl, err)
+	}
+
+	now = now.Add(40 * time.Second)
+	if got, _ := limiter.RecordFailure(t.Context(), "attempts:a", time.Minute); got != 1 {
+		t.Fatalf("expected counter to restart after ttl, got %d", got)
+	}
+
+	if err := limiter.Reset(t.Context(), "attempts:a"); err != nil {
+		t.Fatalf("reset failed: %v", err)
+	}
+	if got, _ := limiter.RecordFailure(t.Context(), "attempts:a", time.Minute); got != 1 {
+		t.Fatalf("expected counter to restart after reset, got %d", got)
+	}
+}
+
+func TestNilLimiterAllowsEverything(t *testing.T) {
+	var limiter *Limiter
+	if err := limiter.Allow(t.Context(), "x", Rule{Name: "r", Limit: 1, Window: time.Hour}); err != nil {
+		t.Fatalf("nil limiter should allow: %v", err)
+	}
+}
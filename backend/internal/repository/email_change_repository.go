@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/hdu-dp/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// errEmailChangeLost rolls back an email change transaction that lost a race.
+var errEmailChangeLost = errors.New("email change no longer applicable")
+
+// EmailChangeRepository persists pending and confirmed email address changes.
+type EmailChangeRepository struct {
+	db *gorm.DB
+}
+
+// NewEmailChangeRepository constructs an EmailChangeRepository.
+func NewEmailChangeRepository(db *gorm.DB) *EmailChangeRepository {
+	return &EmailChangeRepository{db: db}
+}
+
+// Replace drops any unconfirmed change of the user and stores the new one, so only the latest link works.
+func (r *EmailChangeRepository) Replace(ctx context.Context, change *models.EmailChange) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? AND confirmed_at IS NULL", change.UserID).Delete(&models.EmailChange{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(change).Error
+	})
+}
+
+// FindByHash fetches a change by the hash of its confirmation token.
+func (r *EmailChangeRepository) FindByHash(ctx context.Context, hash string) (*models.EmailChange, error) {
+	var change models.EmailChange
+	if err := r.db.WithContext(ctx).Where("token_hash = ?", hash).First(&change).Error; err != nil {
+		return nil, err
+	}
+	return &change, nil
+}
+
+// FindByUndoHash fetches a confirmed change by the hash of its undo token.
+func (r *EmailChangeRepository) FindByUndoHash(ctx context.Context, hash string) (*models.EmailChange, error) {
+	var change models.EmailChange
+	if err := r.db.WithContext(ctx).Where("undo_token_hash = ?", hash).First(&change).Error; err != nil {
+		return nil, err
+	}
+	return &change, nil
+}
+
+// Confirm marks an unconfirmed, unexpired change as confirmed, records its undo token and applies fields to
+// the user in one transaction. It returns false when another request won the race, the link expired or the
+// user no longer has the email the change was requested from.
+func (r *EmailChangeRepository) Confirm(ctx context.Context, change *models.EmailChange, now time.Time, undoHash string, undoExpiresAt time.Time, fields map[string]any) (bool, error) {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		claimed := tx.Model(&models.EmailChange{}).
+			Where("id = ? AND confirmed_at IS NULL AND expires_at > ?", change.ID, now).
+			Updates(map[string]any{
+				"confirmed_at":    now,
+				"undo_token_hash": undoHash,
+				"undo_expires_at": undoExpiresAt,
+			})
+		if claimed.Error != nil {
+			return claimed.Error
+		}
+		if claimed.RowsAffected != 1 {
+			return errEmailChangeLost
+		}
+		return updateUserEmail(tx, change, change.OldEmail, fields)
+	})
+	return claimResult(err)
+}
+
+// Undo marks a confirmed change as undone while its undo link is valid and applies fields to the user in one
+// transaction. It returns false when the link was already used, expired, or the email changed again since.
+func (r *EmailChangeRepository) Undo(ctx context.Context, change *models.EmailChange, now time.Time, fields map[string]any) (bool, error) {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		claimed := tx.Model(&models.EmailChange{}).
+			Where("id = ? AND confirmed_at IS NOT NULL AND undone_at IS NULL AND undo_expires_at > ?", change.ID, now).
+			Update("undone_at", now)
+		if claimed.Error != nil {
+			return claimed.Error
+		}
+		if claimed.RowsAffected != 1 {
+			return errEmailChangeLost
+		}
+		return updateUserEmail(tx, change, change.NewEmail, fields)
+	})
+	return claimResult(err)
+}
+
+// DeleteExpired removes changes that were never confirmed in time and confirmed ones that can no longer be undone.
+func (r *EmailChangeRepository) DeleteExpired(ctx context.Context, now time.Time) error {
+	return r.db.WithContext(ctx).
+		Where("(confirmed_at IS NULL AND expires_at < ?) OR undo_expires_at < ?", now, now).
+		Delete(&models.EmailChange{}).Error
+}
+
+func updateUserEmail(tx *gorm.DB, change *models.EmailChange, currentEmail string, fields map[string]any) error {
+	updated := tx.Model(&models.User{}).Where("id = ? AND email = ?", change.UserID, currentEmail).Updates(fields)
+	if updated.Error != nil {
+		return updated.Error
+	}
+	if updated.RowsAffected != 1 {
+		return errEmailChangeLost
+	}
+	return nil
+}
+
+func claimResult(err error) (bool, error) {
+	if errors.Is(err, errEmailChangeLost) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,151 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hdu-dp/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// EmailOutboxRepository handles persistence of queued outbound emails.
+type EmailOutboxRepository struct {
+	db *gorm.DB
+}
+
+// NewEmailOutboxRepository creates a new EmailOutboxRepository.
+func NewEmailOutboxRepository(db *gorm.DB) *EmailOutboxRepository {
+	return &EmailOutboxRepository{db: db}
+}
+
+// Create inserts a new outbox message.
+func (r *EmailOutboxRepository) Create(ctx context.Context, message *models.EmailOutbox) error {
+	return r.db.WithContext(ctx).Create(message).Error
+}
+
+// FindByID retrieves an outbox message by primary key.
+func (r *EmailOutboxRepository) FindByID(ctx context.Context, id uuid.UUID) (*models.EmailOutbox, error) {
+	var message models.EmailOutbox
+	if err := r.db.WithContext(ctx).First(&message, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// ListDue returns pending messages whose next attempt time has passed, oldest first.
+func (r *EmailOutboxRepository) ListDue(ctx context.Context, now time.Time, limit int) ([]models.EmailOutbox, error) {
+	var messages []models.EmailOutbox
+	query := r.db.WithContext(ctx).
+		Where("status = ? AND next_attempt_at <= ?", models.EmailOutboxStatusPending, now).
+		Order("next_attempt_at asc")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	if err := query.Find(&messages).Error; err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// Claim moves a pending message into the sending state and reports whether this caller won it.
+func (r *EmailOutboxRepository) Claim(ctx context.Context, id uuid.UUID) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&models.EmailOutbox{}).
+		Where("id = ? AND status = ?", id, models.EmailOutboxStatusPending).
+		Update("status", models.EmailOutboxStatusSending)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// MarkSent records a successful delivery.
+func (r *EmailOutboxRepository) MarkSent(ctx context.Context, id uuid.UUID, attempts int, sentAt time.Time) error {
+	return r.db.WithContext(ctx).Model(&models.EmailOutbox{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"status":     models.EmailOutboxStatusSent,
+			"attempts":   attempts,
+			"sent_at":    sentAt,
+			"last_error": "",
+		}).Error
+}
+
+// MarkRetry records a failed attempt and schedules the next one.
+func (r *EmailOutboxRepository) MarkRetry(ctx context.Context, id uuid.UUID, attempts int, nextAttemptAt time.Time, lastError string) error {
+	return r.db.WithContext(ctx).Model(&models.EmailOutbox{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"status":          models.EmailOutboxStatusPending,
+			"attempts":        attempts,
+			"next_attempt_at": nextAttemptAt,
+			"last_error":      lastError,
+		}).Error
+}
+
+// MarkDead moves a message into the dead-letter state after its final failed attempt.
+func (r *EmailOutboxRepository) MarkDead(ctx context.Context, id uuid.UUID, attempts int, lastError string) error {
+	return r.db.WithContext(ctx).Model(&models.EmailOutbox{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"status":     models.EmailOutboxStatusDead,
+			"attempts":   attempts,
+			"last_error": lastError,
+		}).Error
+}
+
+// Requeue resets a dead message so the worker picks it up again.
+func (r *EmailOutboxRepository) Requeue(ctx context.Context, id uuid.UUID, now time.Time) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&models.EmailOutbox{}).
+		Where("id = ? AND status = ?", id, models.EmailOutboxStatusDead).
+		Updates(map[string]interface{}{
+			"status":          models.EmailOutboxStatusPending,
+			"attempts":        0,
+			"next_attempt_at": now,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// ResetSending returns messages stuck in the sending state (e.g. after a crash) to pending.
+func (r *EmailOutboxRepository) ResetSending(ctx context.Context, now time.Time) error {
+	return r.db.WithContext(ctx).Model(&models.EmailOutbox{}).
+		Where("status = ?", models.EmailOutboxStatusSending).
+		Updates(map[string]interface{}{
+			"status":          models.EmailOutboxStatusPending,
+			"next_attempt_at": now,
+		}).Error
+}
+
+// ListByStatus returns messages in the given status, newest first.
+func (r *EmailOutboxRepository) ListByStatus(ctx context.Context, status models.EmailOutboxStatus, offset, limit int) ([]models.EmailOutbox, int64, error) {
+	base := r.db.WithContext(ctx).Model(&models.EmailOutbox{})
+	if status != "" {
+		base = base.Where("status = ?", status)
+	}
+
+	var total int64
+	if err := base.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	query := base.Session(&gorm.Session{}).Order("updated_at desc")
+	if limit > 0 {
+		query = query.Offset(offset).Limit(limit)
+	}
+
+	var messages []models.EmailOutbox
+	if err := query.Find(&messages).Error; err != nil {
+		return nil, 0, err
+	}
+	return messages, total, nil
+}
+
+// DeleteSentBefore removes delivered messages older than the cutoff.
+func (r *EmailOutboxRepository) DeleteSentBefore(ctx context.Context, cutoff time.Time) error {
+	return r.db.WithContext(ctx).
+		Where("status = ? AND sent_at < ?", models.EmailOutboxStatusSent, cutoff).
+		Delete(&models.EmailOutbox{}).Error
+}
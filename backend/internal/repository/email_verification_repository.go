@@ -91,3 +91,10 @@ func (r *EmailVerificationRepository) DeleteByEmail(ctx context.Context, email s
 		Where("email = ?", email).
 		Delete(&models.EmailVerification{}).Error
 }
+
+// DeleteUnclaimedByEmail deletes registration codes for an email that are not yet linked to a user
+func (r *EmailVerificationRepository) DeleteUnclaimedByEmail(ctx context.Context, email string) error {
+	return r.db.WithContext(ctx).
+		Where("email = ? AND user_id IS NULL", email).
+		Delete(&models.EmailVerification{}).Error
+}
@@ -42,6 +42,26 @@ func (r *RefreshTokenRepository) DeleteExpired(now time.Time) error {
 	return r.db.Where("expires_at < ?", now).Delete(&models.RefreshToken{}).Error
 }
 
+// Rotate marks an active token as exchanged for a successor. It returns false when the token was
+// already rotated or revoked, e.g. by a concurrent refresh.
+func (r *RefreshTokenRepository) Rotate(id uuid.UUID, now time.Time) (bool, error) {
+	result := r.db.Model(&models.RefreshToken{}).
+		Where("id = ? AND revoked = ?", id, false).
+		Updates(map[string]any{"revoked": true, "rotated_at": now})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// RevokeFamily revokes every token descending from the same sign-in and returns how many were still active.
+func (r *RefreshTokenRepository) RevokeFamily(familyID uuid.UUID) (int64, error) {
+	result := r.db.Model(&models.RefreshToken{}).
+		Where("(family_id = ? OR id = ?) AND revoked = ?", familyID, familyID, false).
+		Update("revoked", true)
+	return result.RowsAffected, result.Error
+}
+
 // RevokeAllForUser revokes tokens for a user.
 func (r *RefreshTokenRepository) RevokeAllForUser(userID uuid.UUID) error {
 	return r.db.Model(&models.RefreshToken{}).
@@ -54,3 +74,33 @@ func (r *RefreshTokenRepository) RevokeAllForUser(userID uuid.UUID) error {
 func (r *RefreshTokenRepository) DeleteByID(id uuid.UUID) error {
 	return r.db.Delete(&models.RefreshToken{}, "id = ?", id).Error
 }
+
+// ListActiveByUser returns unrevoked, unexpired tokens of a user, most recently used first.
+func (r *RefreshTokenRepository) ListActiveByUser(userID uuid.UUID, now time.Time) ([]models.RefreshToken, error) {
+	var tokens []models.RefreshToken
+	if err := r.db.Where("user_id = ? AND revoked = ? AND expires_at > ?", userID, false, now).
+		Order("last_used_at desc").
+		Find(&tokens).Error; err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// RevokeForUser revokes one active token of the user and reports whether it existed.
+func (r *RefreshTokenRepository) RevokeForUser(userID, id uuid.UUID) (bool, error) {
+	result := r.db.Model(&models.RefreshToken{}).
+		Where("id = ? AND user_id = ? AND revoked = ?", id, userID, false).
+		Update("revoked", true)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// RevokeAllForUserExcept revokes every active token of the user other than keepID and returns how many were revoked.
+func (r *RefreshTokenRepository) RevokeAllForUserExcept(userID, keepID uuid.UUID) (int64, error) {
+	result := r.db.Model(&models.RefreshToken{}).
+		Where("user_id = ? AND id <> ? AND revoked = ?", userID, keepID, false).
+		Update("revoked", true)
+	return result.RowsAffected, result.Error
+}
@@ -1,6 +1,7 @@
 package repository
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 
@@ -28,6 +29,8 @@ type ListOptions struct {
 	SortDir  string
 	Limit    int
 	Offset   int
+	// CampusVerifiedAuthor keeps reviews whose author verified a campus email.
+	CampusVerifiedAuthor bool
 }
 
 // ListResult represents a paginated resultset.
@@ -50,13 +53,16 @@ func (r *ReviewRepository) List(opts ListOptions) (ListResult, error) {
 		like := fmt.Sprintf("%%%s%%", opts.Query)
 		base = base.Where("title LIKE ? OR address LIKE ? OR description LIKE ?", like, like, like)
 	}
+	if opts.CampusVerifiedAuthor {
+		base = base.Where("author_id IN (?)", r.db.Model(&models.User{}).Select("id").Where("campus_verified = ?", true))
+	}
 
 	var total int64
 	if err := base.Session(&gorm.Session{}).Count(&total).Error; err != nil {
 		return ListResult{}, err
 	}
 
-	listQuery := base.Session(&gorm.Session{}).Preload("Images").Preload("Author")
+	listQuery := base.Session(&gorm.Session{}).Preload("Images", orderImages).Preload("Author")
 
 	sortBy := "created_at"
 	switch strings.ToLower(opts.SortBy) {
@@ -101,15 +107,83 @@ func (r *ReviewRepository) Update(review *models.Review) error {
 // FindByID returns a review by UUID including relations.
 func (r *ReviewRepository) FindByID(id uuid.UUID) (*models.Review, error) {
 	var review models.Review
-	if err := r.db.Preload("Images").Preload("Author").First(&review, "id = ?", id).Error; err != nil {
+	if err := r.db.Preload("Images", orderImages).Preload("Author").First(&review, "id = ?", id).Error; err != nil {
 		return nil, err
 	}
 	return &review, nil
 }
 
-// AddImage appends a review image entry.
-func (r *ReviewRepository) AddImage(image *models.ReviewImage) error {
-	return r.db.Create(image).Error
+// ListAllByAuthor returns every review of authorID in any status, with images, oldest first.
+func (r *ReviewRepository) ListAllByAuthor(authorID uuid.UUID) ([]models.Review, error) {
+	var reviews []models.Review
+	err := r.db.Preload("Images", orderImages).Where("author_id = ?", authorID).Order("created_at asc").Find(&reviews).Error
+	return reviews, err
+}
+
+// orderImages sorts preloaded review images by position, then upload time for images sharing one.
+func orderImages(db *gorm.DB) *gorm.DB {
+	return db.Order("position asc").Order("created_at asc")
+}
+
+// CountImages returns how many images reviewID has.
+func (r *ReviewRepository) CountImages(reviewID uuid.UUID) (int64, error) {
+	var count int64
+	err := r.db.Model(&models.ReviewImage{}).Where("review_id = ?", reviewID).Count(&count).Error
+	return count, err
+}
+
+// ErrImageLimit is returned by AddImage when the review already has the maximum number of images.
+var ErrImageLimit = errors.New("review image limit reached")
+
+// AddImage appends a review image entry after the review's existing images. When limit is positive it
+// refuses with ErrImageLimit once the review has that many; the count is read in the same transaction as
+// the insert, so concurrent uploads cannot exceed it.
+func (r *ReviewRepository) AddImage(image *models.ReviewImage, limit int) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var existing struct {
+			Count    int
+			Position *int
+		}
+		if err := tx.Model(&models.ReviewImage{}).Select("COUNT(*) AS count, MAX(position) AS position").
+			Where("review_id = ?", image.ReviewID).Scan(&existing).Error; err != nil {
+			return err
+		}
+		if limit > 0 && existing.Count >= limit {
+			return ErrImageLimit
+		}
+		image.Position = 0
+		if existing.Position != nil {
+			image.Position = *existing.Position + 1
+		}
+		return tx.Create(image).Error
+	})
+}
+
+// FindImage returns the image id of reviewID.
+func (r *ReviewRepository) FindImage(reviewID, id uuid.UUID) (*models.ReviewImage, error) {
+	var image models.ReviewImage
+	if err := r.db.First(&image, "id = ? AND review_id = ?", id, reviewID).Error; err != nil {
+		return nil, err
+	}
+	return &image, nil
+}
+
+// UpdateImageCaption replaces the caption of an image.
+func (r *ReviewRepository) UpdateImageCaption(id uuid.UUID, caption string) error {
+	return r.db.Model(&models.ReviewImage{}).Where("id = ?", id).Update("caption", caption).Error
+}
+
+// ReorderImages numbers the images of reviewID in the order of ids, which must list each of them once.
+func (r *ReviewRepository) ReorderImages(reviewID uuid.UUID, ids []uuid.UUID) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		for position, id := range ids {
+			if err := tx.Model(&models.ReviewImage{}).Where("id = ? AND review_id = ?", id, reviewID).
+				Update("position", position).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
 
 // DeleteImage removes an image by key.
@@ -137,6 +137,13 @@ func (r *ReviewReactionRepository) GetByReviewAndUser(ctx context.Context, revie
 	return &reaction, nil
 }
 
+// ListByUser returns every reaction of userID, oldest first.
+func (r *ReviewReactionRepository) ListByUser(ctx context.Context, userID uuid.UUID) ([]models.ReviewReaction, error) {
+	var reactions []models.ReviewReaction
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Order("created_at asc").Find(&reactions).Error
+	return reactions, err
+}
+
 // Delete deletes a reaction record
 func (r *ReviewReactionRepository) Delete(ctx context.Context, id uuid.UUID) error {
 	return r.db.WithContext(ctx).Delete(&models.ReviewReaction{}, "id = ?", id).Error
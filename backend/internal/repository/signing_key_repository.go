@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/hdu-dp/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// SigningKeyRepository persists access token signing keys.
+type SigningKeyRepository struct {
+	db *gorm.DB
+}
+
+// NewSigningKeyRepository constructs a SigningKeyRepository.
+func NewSigningKeyRepository(db *gorm.DB) *SigningKeyRepository {
+	return &SigningKeyRepository{db: db}
+}
+
+// ListUsable returns keys that still verify tokens at now, oldest first.
+func (r *SigningKeyRepository) ListUsable(ctx context.Context, now time.Time) ([]models.SigningKey, error) {
+	var keys []models.SigningKey
+	err := r.db.WithContext(ctx).
+		Where("expires_at IS NULL OR expires_at > ?", now).
+		Order("created_at asc").
+		Find(&keys).Error
+	return keys, err
+}
+
+// ErrSigningKeyChanged is returned by Rotate when another instance rotated the keys first.
+var ErrSigningKeyChanged = errors.New("signing key changed concurrently")
+
+// Rotate stores next, which starts signing at next.ActivatesAt. currentID is the newest key the caller saw:
+// it stops signing at that time and keeps verifying until verifyUntil. An empty currentID means there is no
+// key yet. Both cases are conditional, so of several instances rotating at once only one succeeds and the
+// others get ErrSigningKeyChanged.
+func (r *SigningKeyRepository) Rotate(ctx context.Context, next *models.SigningKey, currentID string, verifyUntil time.Time) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if currentID == "" {
+			inserted := tx.Exec(`INSERT INTO signing_keys (id, algorithm, private_key_enc, created_at, activates_at)
+				SELECT ?, ?, ?, ?, ? WHERE NOT EXISTS (SELECT 1 FROM signing_keys WHERE retired_at IS NULL)`,
+				next.ID, next.Algorithm, next.PrivateKeyEnc, next.CreatedAt, next.ActivatesAt)
+			if inserted.Error != nil {
+				return inserted.Error
+			}
+			if inserted.RowsAffected == 0 {
+				return ErrSigningKeyChanged
+			}
+			return nil
+		}
+
+		retired := tx.Model(&models.SigningKey{}).Where("id = ? AND retired_at IS NULL", currentID).Updates(map[string]any{
+			"retired_at": next.ActivatesAt,
+			"expires_at": verifyUntil,
+		})
+		if retired.Error != nil {
+			return retired.Error
+		}
+		if retired.RowsAffected == 0 {
+			return ErrSigningKeyChanged
+		}
+		return tx.Create(next).Error
+	})
+}
+
+// DeleteExpired removes keys that no longer verify any token.
+func (r *SigningKeyRepository) DeleteExpired(ctx context.Context, now time.Time) error {
+	return r.db.WithContext(ctx).Where("expires_at IS NOT NULL AND expires_at <= ?", now).Delete(&models.SigningKey{}).Error
+}
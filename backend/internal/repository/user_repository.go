@@ -1,6 +1,11 @@
 package repository
 
 import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
 	"github.com/google/uuid"
 	"github.com/hdu-dp/backend/internal/models"
 	"gorm.io/gorm"
@@ -16,9 +21,21 @@ func NewUserRepository(db *gorm.DB) *UserRepository {
 	return &UserRepository{db: db}
 }
 
+// ErrDisplayNameTaken is returned by Create and UpdateProfile when another account already uses the
+// display name, ignoring case.
+var ErrDisplayNameTaken = errors.New("display name already taken")
+
+// displayNameConflict maps a violation of the unique display name index to ErrDisplayNameTaken.
+func displayNameConflict(err error) error {
+	if err != nil && strings.Contains(err.Error(), "idx_users_display_name") {
+		return ErrDisplayNameTaken
+	}
+	return err
+}
+
 // Create inserts a new user entry.
 func (r *UserRepository) Create(user *models.User) error {
-	return r.db.Create(user).Error
+	return displayNameConflict(r.db.Create(user).Error)
 }
 
 // FindByEmail fetches a user by email.
@@ -71,6 +88,61 @@ func (r *UserRepository) Save(user *models.User) error {
 	return r.db.Save(user).Error
 }
 
+// UpdatePassword stores a new password hash.
+func (r *UserRepository) UpdatePassword(id uuid.UUID, passwordHash string) error {
+	return r.db.Model(&models.User{}).Where("id = ?", id).Update("password_hash", passwordHash).Error
+}
+
+// LoginFailure reports the state of an account after RecordLoginFailure.
+type LoginFailure struct {
+	// Attempts counts the failures within the window, including this one.
+	Attempts int
+	// Locked is set when this failure reached the limit and locked the account.
+	Locked bool
+}
+
+// RecordLoginFailure counts a failed password attempt in one UPDATE, so that concurrent wrong guesses are
+// all counted. Failures older than window start a new count. Reaching maxAttempts resets the count and locks
+// the account until lockedUntil in the same statement.
+func (r *UserRepository) RecordLoginFailure(id uuid.UUID, at time.Time, window time.Duration, maxAttempts int, lockedUntil time.Time) (LoginFailure, error) {
+	attempts := gorm.Expr("CASE WHEN last_failed_login_at IS NOT NULL AND last_failed_login_at >= ? THEN failed_login_attempts + 1 ELSE 1 END", at.Add(-window))
+	var result LoginFailure
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		update := tx.Model(&models.User{}).Where("id = ?", id).Updates(map[string]any{
+			"locked_until":          gorm.Expr("CASE WHEN (?) >= ? THEN ? ELSE locked_until END", attempts, maxAttempts, lockedUntil),
+			"failed_login_attempts": gorm.Expr("CASE WHEN (?) >= ? THEN 0 ELSE (?) END", attempts, maxAttempts, attempts),
+			"last_failed_login_at":  at,
+		})
+		if update.Error != nil {
+			return update.Error
+		}
+		if update.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		// The transaction holds the write lock, so this reads the row exactly as the UPDATE left it.
+		var stored models.User
+		if err := tx.Select("failed_login_attempts", "locked_until").First(&stored, "id = ?", id).Error; err != nil {
+			return err
+		}
+		result.Attempts = stored.FailedLoginAttempts
+		if stored.LockedUntil != nil && stored.LockedUntil.Equal(lockedUntil) {
+			result = LoginFailure{Attempts: maxAttempts, Locked: true}
+		}
+		return nil
+	})
+	return result, err
+}
+
+// ClearLoginFailures resets failed password attempts and lifts any lockout.
+func (r *UserRepository) ClearLoginFailures(id uuid.UUID) error {
+	return r.db.Model(&models.User{}).Where("id = ?", id).Updates(map[string]any{
+		"failed_login_attempts": 0,
+		"last_failed_login_at":  nil,
+		"locked_until":          nil,
+	}).Error
+}
+
 // List returns users ordered by creation time desc with pagination.
 func (r *UserRepository) List(offset, limit int) ([]models.User, error) {
 	var users []models.User
@@ -93,7 +165,243 @@ func (r *UserRepository) Count() (int64, error) {
 	return total, nil
 }
 
-// Delete removes a user by id.
-func (r *UserRepository) Delete(id uuid.UUID) error {
-	return r.db.Delete(&models.User{}, "id = ?", id).Error
+// PurgeResult reports what Purge removed.
+type PurgeResult struct {
+	Reviews   int64
+	Reactions int64
+	// ImageKeys are the storage keys of deleted review images and their renditions; the caller removes the files.
+	ImageKeys []string
+}
+
+// Purge removes a user's sessions and credentials. When anonymize is nil the user is deleted together with
+// their reviews and reactions; otherwise the content is kept and anonymize is applied to the user row, which
+// stays behind as an anonymous author.
+func (r *UserRepository) Purge(id uuid.UUID, anonymize map[string]any) (*PurgeResult, error) {
+	result := &PurgeResult{}
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := deleteCredentials(tx, id); err != nil {
+			return err
+		}
+		if anonymize != nil {
+			return tx.Model(&models.User{}).Where("id = ?", id).Updates(anonymize).Error
+		}
+
+		var reactions []models.ReviewReaction
+		if err := tx.Where("user_id = ?", id).Find(&reactions).Error; err != nil {
+			return err
+		}
+		if err := dropReactions(tx, reactions); err != nil {
+			return err
+		}
+		result.Reactions = int64(len(reactions))
+
+		reviews := tx.Model(&models.Review{}).Select("id").Where("author_id = ?", id)
+		var images []models.ReviewImage
+		if err := tx.Where("review_id IN (?)", reviews).Find(&images).Error; err != nil {
+			return err
+		}
+		for _, image := range images {
+			result.ImageKeys = append(result.ImageKeys, image.StorageKeys()...)
+		}
+		for _, model := range []any{&models.ReviewReaction{}, &models.ReviewStats{}, &models.ReviewImage{}} {
+			if err := tx.Where("review_id IN (?)", reviews).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		deleted := tx.Where("author_id = ?", id).Delete(&models.Review{})
+		if deleted.Error != nil {
+			return deleted.Error
+		}
+		result.Reviews = deleted.RowsAffected
+
+		return tx.Delete(&models.User{}, "id = ?", id).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ScheduleDeletion sets or, with a nil at, clears when the account is due to be purged.
+func (r *UserRepository) ScheduleDeletion(id uuid.UUID, at *time.Time) error {
+	return r.db.Model(&models.User{}).Where("id = ?", id).Update("deletion_scheduled_at", at).Error
+}
+
+// ListDueForDeletion returns up to limit users whose deletion grace period ended before now.
+func (r *UserRepository) ListDueForDeletion(now time.Time, limit int) ([]models.User, error) {
+	var users []models.User
+	err := r.db.Where("deletion_scheduled_at IS NOT NULL AND deletion_scheduled_at <= ?", now).
+		Order("deletion_scheduled_at asc").Limit(limit).Find(&users).Error
+	return users, err
+}
+
+// MergeResult reports what MergeInto moved to the surviving account.
+type MergeResult struct {
+	Reviews          int64 `json:"reviews"`
+	Reactions        int64 `json:"reactions"`
+	DroppedReactions int64 `json:"dropped_reactions"`
+}
+
+// MergeInto moves the reviews and reactions of source to target, deletes source together with its
+// sessions and credentials, then applies identity (for example login methods taken over from source)
+// to target. Where both accounts reacted to the same review, the reaction of target is kept.
+func (r *UserRepository) MergeInto(sourceID, targetID uuid.UUID, identity map[string]any) (*MergeResult, error) {
+	result := &MergeResult{}
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		moved := tx.Model(&models.Review{}).Where("author_id = ?", sourceID).Update("author_id", targetID)
+		if moved.Error != nil {
+			return moved.Error
+		}
+		result.Reviews = moved.RowsAffected
+
+		var duplicates []models.ReviewReaction
+		if err := tx.Where("user_id = ? AND review_id IN (?)", sourceID,
+			tx.Model(&models.ReviewReaction{}).Select("review_id").Where("user_id = ?", targetID),
+		).Find(&duplicates).Error; err != nil {
+			return err
+		}
+		if err := dropReactions(tx, duplicates); err != nil {
+			return err
+		}
+		result.DroppedReactions = int64(len(duplicates))
+
+		moved = tx.Model(&models.ReviewReaction{}).Where("user_id = ?", sourceID).Update("user_id", targetID)
+		if moved.Error != nil {
+			return moved.Error
+		}
+		result.Reactions = moved.RowsAffected
+
+		if err := deleteCredentials(tx, sourceID); err != nil {
+			return err
+		}
+		if err := tx.Delete(&models.User{}, "id = ?", sourceID).Error; err != nil {
+			return err
+		}
+
+		if len(identity) == 0 {
+			return nil
+		}
+		return tx.Model(&models.User{}).Where("id = ?", targetID).Updates(identity).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// deleteCredentials removes every session, token and second factor that belongs to userID.
+func deleteCredentials(tx *gorm.DB, userID uuid.UUID) error {
+	for _, model := range []any{
+		&models.RefreshToken{},
+		&models.PasswordResetToken{},
+		&models.EmailChange{},
+		&models.MFARecoveryCode{},
+		&models.UserTOTP{},
+		&models.EmailVerification{},
+		&models.APIToken{},
+	} {
+		if err := tx.Where("user_id = ?", userID).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropReactions deletes reactions and takes them off the like and dislike counters of their reviews.
+func dropReactions(tx *gorm.DB, reactions []models.ReviewReaction) error {
+	for _, reaction := range reactions {
+		column := "likes"
+		if reaction.Type == models.ReactionTypeDislike {
+			column = "dislikes"
+		}
+		if err := tx.Model(&models.ReviewStats{}).
+			Where("review_id = ? AND "+column+" > 0", reaction.ReviewID).
+			UpdateColumn(column, gorm.Expr(column+" - 1")).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&models.ReviewReaction{}, "id = ?", reaction.ID).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateIdentity sets login identity columns such as phone or qq_open_id on a user.
+func (r *UserRepository) UpdateIdentity(id uuid.UUID, fields map[string]any) error {
+	return r.db.Model(&models.User{}).Where("id = ?", id).Updates(fields).Error
+}
+
+// identityColumns hold the login methods of a user; email counts only when it is not a placeholder.
+var identityColumns = []string{"email", "phone", "qq_open_id", "we_chat_open_id"}
+
+// UnbindIdentity applies fields, which remove the login method stored in column, only while the user keeps
+// another login method. The check is part of the UPDATE, so concurrent unbinds of different methods cannot
+// both pass it. Emails ending in virtualEmailSuffix are placeholders, not a login method. It reports whether
+// the user was updated.
+func (r *UserRepository) UnbindIdentity(id uuid.UUID, column string, fields map[string]any, virtualEmailSuffix string) (bool, error) {
+	var others []string
+	var args []any
+	for _, other := range identityColumns {
+		switch {
+		case other == column:
+		case other == "email":
+			others = append(others, "email NOT LIKE ?")
+			args = append(args, "%"+virtualEmailSuffix)
+		default:
+			others = append(others, other+" IS NOT NULL")
+		}
+	}
+	result := r.db.Model(&models.User{}).
+		Where("id = ?", id).
+		Where("("+strings.Join(others, " OR ")+")", args...).
+		Updates(fields)
+	return result.RowsAffected > 0, result.Error
+}
+
+// UpdateProfile sets profile columns such as display_name, bio or the avatar of a user.
+func (r *UserRepository) UpdateProfile(id uuid.UUID, fields map[string]any) error {
+	return displayNameConflict(r.db.Model(&models.User{}).Where("id = ?", id).Updates(fields).Error)
+}
+
+// DisplayNameTaken reports whether a user other than excludeID already uses name, ignoring case.
+func (r *UserRepository) DisplayNameTaken(name string, excludeID uuid.UUID) (bool, error) {
+	var count int64
+	err := r.db.Model(&models.User{}).
+		Where("LOWER(display_name) = LOWER(?) AND id <> ?", name, excludeID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// SyncCampusVerified recomputes the campus flag of every user from their verified email, so that changes
+// to the configured campus domains also apply to accounts verified earlier. It returns how many users changed.
+func (r *UserRepository) SyncCampusVerified(domains []string) (int64, error) {
+	matches := "1 = 0"
+	args := make([]any, 0, len(domains)*2)
+	for _, domain := range domains {
+		matches += " OR LOWER(email) LIKE ? OR LOWER(email) LIKE ?"
+		args = append(args, "%@"+domain, "%."+domain)
+	}
+	campus := fmt.Sprintf("email_verified = ? AND (%s)", matches)
+	campusArgs := append([]any{true}, args...)
+
+	var changed int64
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		granted := tx.Model(&models.User{}).
+			Where("campus_verified = ?", false).
+			Where(campus, campusArgs...).
+			Update("campus_verified", true)
+		if granted.Error != nil {
+			return granted.Error
+		}
+		revoked := tx.Model(&models.User{}).
+			Where("campus_verified = ?", true).
+			Not(campus, campusArgs...).
+			Update("campus_verified", false)
+		if revoked.Error != nil {
+			return revoked.Error
+		}
+		changed = granted.RowsAffected + revoked.RowsAffected
+		return nil
+	})
+	return changed, err
 }
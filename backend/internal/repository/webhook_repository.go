@@ -0,0 +1,172 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hdu-dp/backend/internal/models"
+	"gorm.io/gorm"
+)
+
+// WebhookRepository handles persistence of webhook endpoints and their deliveries.
+type WebhookRepository struct {
+	db *gorm.DB
+}
+
+// NewWebhookRepository creates a new WebhookRepository.
+func NewWebhookRepository(db *gorm.DB) *WebhookRepository {
+	return &WebhookRepository{db: db}
+}
+
+// CreateEndpoint inserts a new endpoint.
+func (r *WebhookRepository) CreateEndpoint(ctx context.Context, endpoint *models.WebhookEndpoint) error {
+	return r.db.WithContext(ctx).Create(endpoint).Error
+}
+
+// SaveEndpoint persists changes to an endpoint.
+func (r *WebhookRepository) SaveEndpoint(ctx context.Context, endpoint *models.WebhookEndpoint) error {
+	return r.db.WithContext(ctx).Save(endpoint).Error
+}
+
+// FindEndpoint retrieves an endpoint by primary key.
+func (r *WebhookRepository) FindEndpoint(ctx context.Context, id uuid.UUID) (*models.WebhookEndpoint, error) {
+	var endpoint models.WebhookEndpoint
+	if err := r.db.WithContext(ctx).First(&endpoint, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &endpoint, nil
+}
+
+// ListEndpoints returns every endpoint, oldest first.
+func (r *WebhookRepository) ListEndpoints(ctx context.Context) ([]models.WebhookEndpoint, error) {
+	var endpoints []models.WebhookEndpoint
+	if err := r.db.WithContext(ctx).Order("created_at asc").Find(&endpoints).Error; err != nil {
+		return nil, err
+	}
+	return endpoints, nil
+}
+
+// ListEnabledEndpoints returns endpoints that should receive events.
+func (r *WebhookRepository) ListEnabledEndpoints(ctx context.Context) ([]models.WebhookEndpoint, error) {
+	var endpoints []models.WebhookEndpoint
+	if err := r.db.WithContext(ctx).Where("enabled = ?", true).Find(&endpoints).Error; err != nil {
+		return nil, err
+	}
+	return endpoints, nil
+}
+
+// DeleteEndpoint removes an endpoint together with its delivery log.
+func (r *WebhookRepository) DeleteEndpoint(ctx context.Context, id uuid.UUID) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("endpoint_id = ?", id).Delete(&models.WebhookDelivery{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.WebhookEndpoint{}, "id = ?", id).Error
+	})
+}
+
+// CreateDeliveries queues deliveries in a single statement.
+func (r *WebhookRepository) CreateDeliveries(ctx context.Context, deliveries []models.WebhookDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	return r.db.WithContext(ctx).Create(&deliveries).Error
+}
+
+// CreateDelivery queues a single delivery.
+func (r *WebhookRepository) CreateDelivery(ctx context.Context, delivery *models.WebhookDelivery) error {
+	return r.db.WithContext(ctx).Create(delivery).Error
+}
+
+// FindDelivery retrieves a delivery by primary key.
+func (r *WebhookRepository) FindDelivery(ctx context.Context, id uuid.UUID) (*models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+	if err := r.db.WithContext(ctx).First(&delivery, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// ListDueDeliveries returns pending deliveries whose next attempt time has passed, oldest first.
+func (r *WebhookRepository) ListDueDeliveries(ctx context.Context, now time.Time, limit int) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+	query := r.db.WithContext(ctx).
+		Where("status = ? AND next_attempt_at <= ?", models.WebhookDeliveryStatusPending, now).
+		Order("next_attempt_at asc")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	if err := query.Find(&deliveries).Error; err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// ClaimDelivery moves a pending delivery into the sending state and reports whether this caller won it.
+func (r *WebhookRepository) ClaimDelivery(ctx context.Context, id uuid.UUID) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&models.WebhookDelivery{}).
+		Where("id = ? AND status = ?", id, models.WebhookDeliveryStatusPending).
+		Update("status", models.WebhookDeliveryStatusSending)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// RecordAttempt stores the outcome of a delivery attempt.
+func (r *WebhookRepository) RecordAttempt(ctx context.Context, delivery *models.WebhookDelivery) error {
+	return r.db.WithContext(ctx).Model(&models.WebhookDelivery{}).
+		Where("id = ?", delivery.ID).
+		Updates(map[string]interface{}{
+			"status":          delivery.Status,
+			"attempts":        delivery.Attempts,
+			"next_attempt_at": delivery.NextAttemptAt,
+			"response_status": delivery.ResponseStatus,
+			"response_body":   delivery.ResponseBody,
+			"last_error":      delivery.LastError,
+			"duration_ms":     delivery.DurationMs,
+			"delivered_at":    delivery.DeliveredAt,
+		}).Error
+}
+
+// ResetSending returns deliveries stuck in the sending state (e.g. after a crash) to pending.
+func (r *WebhookRepository) ResetSending(ctx context.Context, now time.Time) error {
+	return r.db.WithContext(ctx).Model(&models.WebhookDelivery{}).
+		Where("status = ?", models.WebhookDeliveryStatusSending).
+		Updates(map[string]interface{}{
+			"status":          models.WebhookDeliveryStatusPending,
+			"next_attempt_at": now,
+		}).Error
+}
+
+// ListDeliveries returns the delivery log of an endpoint, newest first.
+func (r *WebhookRepository) ListDeliveries(ctx context.Context, endpointID uuid.UUID, offset, limit int) ([]models.WebhookDelivery, int64, error) {
+	base := r.db.WithContext(ctx).Model(&models.WebhookDelivery{}).Where("endpoint_id = ?", endpointID)
+
+	var total int64
+	if err := base.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	query := base.Session(&gorm.Session{}).Order("created_at desc")
+	if limit > 0 {
+		query = query.Offset(offset).Limit(limit)
+	}
+
+	var deliveries []models.WebhookDelivery
+	if err := query.Find(&deliveries).Error; err != nil {
+		return nil, 0, err
+	}
+	return deliveries, total, nil
+}
+
+// DeleteFinishedBefore removes succeeded or failed deliveries last touched before the cutoff.
+func (r *WebhookRepository) DeleteFinishedBefore(ctx context.Context, cutoff time.Time) error {
+	return r.db.WithContext(ctx).
+		Where("status IN ? AND updated_at < ?", []models.WebhookDeliveryStatus{
+			models.WebhookDeliveryStatusSucceeded,
+			models.WebhookDeliveryStatusFailed,
+		}, cutoff).
+		Delete(&models.WebhookDelivery{}).Error
+}
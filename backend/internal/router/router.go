@@ -2,10 +2,12 @@ package router
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/hdu-dp/backend/internal/captcha"
 	"github.com/hdu-dp/backend/internal/handlers"
 	adminHandlers "github.com/hdu-dp/backend/internal/handlers/admin"
 	"github.com/hdu-dp/backend/internal/httpx"
 	"github.com/hdu-dp/backend/internal/middleware"
+	"github.com/hdu-dp/backend/internal/models"
 
 	_ "github.com/hdu-dp/backend/docs" // docs is generated by Swag CLI
 	swaggerFiles "github.com/swaggo/files"
@@ -19,42 +21,92 @@ type Params struct {
 	AuthHandler              *handlers.AuthHandler
 	EmailVerificationHandler *handlers.EmailVerificationHandler
 	UserHandler              *handlers.UserHandler
+	PasswordHandler          *handlers.PasswordHandler
+	SessionHandler           *handlers.SessionHandler
+	MFAHandler               *handlers.MFAHandler
+	IdentityHandler          *handlers.IdentityHandler
+	EmailChangeHandler       *handlers.EmailChangeHandler
+	AccountHandler           *handlers.AccountHandler
+	APITokenHandler          *handlers.APITokenHandler
+	JWKSHandler              *handlers.JWKSHandler
 	ReviewHandler            *handlers.ReviewHandler
 	ReviewStatsHandler       *handlers.ReviewStatsHandler
 	AdminHandler             *adminHandlers.ReviewAdminHandler
 	AdminUserHandler         *adminHandlers.UserAdminHandler
+	AdminEmailOutboxHandler  *adminHandlers.EmailOutboxAdminHandler
+	AdminWebhookHandler      *adminHandlers.WebhookAdminHandler
+	CaptchaHandler           *handlers.CaptchaHandler
+	CodeRequestLimit         gin.HandlerFunc
+	Captcha                  func(endpoint string) gin.HandlerFunc
 	StaticUploadDir          string
 }
 
 // Register configures API routes on the provided engine.
 func Register(p Params) {
 	p.Engine.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
+	if p.JWKSHandler != nil {
+		p.Engine.GET("/.well-known/jwks.json", p.JWKSHandler.Keys)
+	}
 
 	api := p.Engine.Group("/api/v1")
 
+	// Endpoints that send SMS or email share a per-IP quota on top of the per-target limits in services.
+	codeLimit := p.CodeRequestLimit
+	if codeLimit == nil {
+		codeLimit = func(c *gin.Context) { c.Next() }
+	}
+	// Endpoints that create accounts or send codes may also ask for a captcha, see captcha.Endpoint*.
+	requireCaptcha := p.Captcha
+	if requireCaptcha == nil {
+		requireCaptcha = func(string) gin.HandlerFunc { return func(c *gin.Context) { c.Next() } }
+	}
+
 	auth := api.Group("/auth")
 	{
-		auth.POST("/register", p.AuthHandler.Register)
+		auth.POST("/register", requireCaptcha(captcha.EndpointRegister), p.AuthHandler.Register)
 		auth.POST("/login", p.AuthHandler.Login)
 		auth.GET("/qq/url", p.AuthHandler.QQAuthURL)
 		auth.POST("/qq/login", p.AuthHandler.QQLogin)
 		auth.POST("/wechat/login", p.AuthHandler.WeChatLogin)
-		auth.POST("/sms/send-code", p.AuthHandler.SendSMSCode)
+		auth.POST("/sms/send-code", requireCaptcha(captcha.EndpointSMSCode), codeLimit, p.AuthHandler.SendSMSCode)
 		auth.POST("/sms/login", p.AuthHandler.SMSLogin)
 		auth.POST("/refresh", p.AuthHandler.Refresh)
 		auth.POST("/logout", p.AuthHandler.Logout)
+		if p.CaptchaHandler != nil {
+			auth.GET("/captcha", p.CaptchaHandler.Get)
+		}
+		if p.PasswordHandler != nil {
+			auth.POST("/forgot-password", requireCaptcha(captcha.EndpointForgotPassword), codeLimit, p.PasswordHandler.ForgotPassword)
+			auth.POST("/reset-password", p.PasswordHandler.ResetPassword)
+		}
+		if p.EmailChangeHandler != nil {
+			auth.POST("/email-change/confirm", p.EmailChangeHandler.Confirm)
+			auth.POST("/email-change/undo", p.EmailChangeHandler.Undo)
+		}
+		if p.MFAHandler != nil {
+			auth.POST("/mfa/verify", p.MFAHandler.Verify)
+			auth.POST("/mfa/enroll", p.MFAHandler.BeginLoginEnrollment)
+			auth.POST("/mfa/enroll/confirm", p.MFAHandler.ConfirmLoginEnrollment)
+		}
 		if p.EmailVerificationHandler != nil {
-			auth.POST("/send-code", p.EmailVerificationHandler.SendRegistrationCode)
+			auth.POST("/send-code", requireCaptcha(captcha.EndpointEmailCode), codeLimit, p.EmailVerificationHandler.SendRegistrationCode)
 			auth.POST("/verify-email", p.EmailVerificationHandler.VerifyEmail)
 			if p.AuthMiddleware != nil {
-				auth.POST("/send-verification", p.AuthMiddleware.RequireAuth(), p.EmailVerificationHandler.SendVerificationEmail)
+				auth.POST("/send-verification", p.AuthMiddleware.RequireAuth(), codeLimit, p.EmailVerificationHandler.SendVerificationEmail)
 				auth.GET("/verification-status", p.AuthMiddleware.RequireAuth(), p.EmailVerificationHandler.GetVerificationStatus)
 			}
 		}
 	}
 
 	if p.StaticUploadDir != "" {
-		api.Static("/uploads", p.StaticUploadDir)
+		// Files are served with the type their extension implies; browsers must not sniff another type or
+		// run anything a file might contain.
+		uploads := api.Group("/uploads", func(c *gin.Context) {
+			c.Header("X-Content-Type-Options", "nosniff")
+			c.Header("Content-Security-Policy", "default-src 'none'; sandbox")
+			c.Next()
+		})
+		uploads.Static("", p.StaticUploadDir)
 	}
 
 	p.Engine.NoRoute(func(c *gin.Context) {
@@ -63,7 +115,7 @@ func Register(p Params) {
 
 	api.GET("/reviews", p.ReviewHandler.ListPublic)
 	// Detail endpoint should be accessible to authed/unauthed; optional auth ensures role-based access when provided.
-	api.GET("/reviews/:id", p.AuthMiddleware.OptionalAuth(), p.ReviewHandler.Detail)
+	api.GET("/reviews/:id", p.AuthMiddleware.OptionalAuth(models.ScopeReviewsRead), p.ReviewHandler.Detail)
 
 	// Review statistics endpoints
 	api.GET("/reviews/:id/stats", p.ReviewStatsHandler.GetReviewStats)
@@ -71,22 +123,64 @@ func Register(p Params) {
 	api.GET("/stats/site", p.ReviewStatsHandler.GetSiteStats)
 	api.GET("/stats/total-views", p.ReviewStatsHandler.GetTotalViews)
 
+	// These routes also accept personal access tokens that hold the listed scope.
+	requireAuth := p.AuthMiddleware.RequireAuth
+	api.GET("/users/me", requireAuth(models.ScopeProfileRead), p.UserHandler.Me)
+	api.POST("/reviews", requireAuth(models.ScopeReviewsWrite), p.ReviewHandler.Submit)
+	api.GET("/reviews/me", requireAuth(models.ScopeReviewsRead), p.ReviewHandler.MyReviews)
+	api.POST("/reviews/:id/images", requireAuth(models.ScopeReviewsWrite), p.ReviewHandler.UploadImage)
+	api.PUT("/reviews/:id/images/order", requireAuth(models.ScopeReviewsWrite), p.ReviewHandler.ReorderImages)
+	api.PATCH("/reviews/:id/images/:image_id", requireAuth(models.ScopeReviewsWrite), p.ReviewHandler.UpdateImageCaption)
+	api.DELETE("/reviews/:id/images/:image_id", requireAuth(models.ScopeReviewsWrite), p.ReviewHandler.DeleteImage)
+	api.POST("/reviews/:id/react", requireAuth(models.ScopeReviewsWrite), p.ReviewStatsHandler.ToggleReaction)
+	api.GET("/reviews/:id/user-reaction", requireAuth(models.ScopeReviewsRead), p.ReviewStatsHandler.GetUserReaction)
+
+	// Account management is limited to interactive sessions, so a leaked token cannot take over the account.
 	protected := api.Group("")
 	protected.Use(p.AuthMiddleware.RequireAuth())
 	{
-		protected.GET("/users/me", p.UserHandler.Me)
-
-		protected.POST("/reviews", p.ReviewHandler.Submit)
-		protected.GET("/reviews/me", p.ReviewHandler.MyReviews)
-		protected.POST("/reviews/:id/images", p.ReviewHandler.UploadImage)
-
-		// Review reaction endpoints (require auth)
-		protected.POST("/reviews/:id/react", p.ReviewStatsHandler.ToggleReaction)
-		protected.GET("/reviews/:id/user-reaction", p.ReviewStatsHandler.GetUserReaction)
+		if p.PasswordHandler != nil {
+			protected.POST("/users/me/password", p.PasswordHandler.ChangePassword)
+		}
+		protected.PATCH("/users/me", p.UserHandler.UpdateProfile)
+		protected.POST("/users/me/avatar", p.UserHandler.UploadAvatar)
+		if p.EmailChangeHandler != nil {
+			protected.POST("/users/me/email", codeLimit, p.EmailChangeHandler.Start)
+		}
+		if p.AccountHandler != nil {
+			protected.GET("/users/me/export", p.AccountHandler.Export)
+			protected.DELETE("/users/me", p.AccountHandler.RequestDeletion)
+			protected.POST("/users/me/deletion/cancel", p.AccountHandler.CancelDeletion)
+		}
+		if p.SessionHandler != nil {
+			protected.GET("/users/me/sessions", p.SessionHandler.List)
+			protected.DELETE("/users/me/sessions/:id", p.SessionHandler.Revoke)
+			protected.POST("/users/me/sessions/revoke-others", p.SessionHandler.RevokeOthers)
+		}
+		if p.MFAHandler != nil {
+			protected.GET("/users/me/mfa", p.MFAHandler.Status)
+			protected.POST("/users/me/mfa/totp", p.MFAHandler.BeginEnrollment)
+			protected.POST("/users/me/mfa/totp/confirm", p.MFAHandler.ConfirmEnrollment)
+			protected.POST("/users/me/mfa/disable", p.MFAHandler.Disable)
+			protected.POST("/users/me/mfa/recovery-codes", p.MFAHandler.RegenerateRecoveryCodes)
+		}
+		if p.IdentityHandler != nil {
+			protected.GET("/users/me/identities", p.IdentityHandler.List)
+			protected.POST("/users/me/identities/qq", p.IdentityHandler.BindQQ)
+			protected.POST("/users/me/identities/wechat", p.IdentityHandler.BindWeChat)
+			protected.POST("/users/me/identities/phone", p.IdentityHandler.BindPhone)
+			protected.POST("/users/me/identities/email", p.IdentityHandler.BindEmail)
+			protected.DELETE("/users/me/identities/:kind", p.IdentityHandler.Unbind)
+		}
+		if p.APITokenHandler != nil {
+			protected.GET("/users/me/tokens", p.APITokenHandler.List)
+			protected.POST("/users/me/tokens", p.APITokenHandler.Create)
+			protected.DELETE("/users/me/tokens/:id", p.APITokenHandler.Revoke)
+		}
 	}
 
 	admin := api.Group("/admin")
-	admin.Use(p.AuthMiddleware.RequireAuth(), p.AuthMiddleware.RequireRoles("admin"))
+	admin.Use(p.AuthMiddleware.RequireAuth(models.ScopeAdmin), p.AuthMiddleware.RequireRoles("admin"))
 	{
 		admin.GET("/reviews/pending", p.AdminHandler.Pending)
 		admin.PUT("/reviews/:id/approve", p.AdminHandler.Approve)
@@ -95,6 +189,23 @@ func Register(p Params) {
 		if p.AdminUserHandler != nil {
 			admin.GET("/users", p.AdminUserHandler.List)
 			admin.DELETE("/users/:id", p.AdminUserHandler.Delete)
+			admin.POST("/users/:id/unlock", p.AdminUserHandler.Unlock)
+			admin.GET("/users/:id/sessions", p.AdminUserHandler.Sessions)
+			admin.DELETE("/users/:id/sessions", p.AdminUserHandler.RevokeSessions)
+			admin.DELETE("/users/:id/sessions/:session_id", p.AdminUserHandler.RevokeSession)
+			admin.POST("/users/:id/merge", p.AdminUserHandler.Merge)
+		}
+		if p.AdminEmailOutboxHandler != nil {
+			admin.GET("/emails", p.AdminEmailOutboxHandler.List)
+			admin.POST("/emails/:id/retry", p.AdminEmailOutboxHandler.Retry)
+		}
+		if p.AdminWebhookHandler != nil {
+			admin.GET("/webhooks", p.AdminWebhookHandler.List)
+			admin.POST("/webhooks", p.AdminWebhookHandler.Create)
+			admin.PUT("/webhooks/:id", p.AdminWebhookHandler.Update)
+			admin.DELETE("/webhooks/:id", p.AdminWebhookHandler.Delete)
+			admin.GET("/webhooks/:id/deliveries", p.AdminWebhookHandler.Deliveries)
+			admin.POST("/webhooks/deliveries/:id/redeliver", p.AdminWebhookHandler.Redeliver)
 		}
 	}
 }
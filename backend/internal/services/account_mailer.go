@@ -0,0 +1,108 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/hdu-dp/backend/internal/emailtemplate"
+	"github.com/hdu-dp/backend/internal/models"
+)
+
+// AccountMailer queues security notifications about a user's own account.
+// A nil AccountMailer or one without a configured mailer sends nothing.
+type AccountMailer struct {
+	mailer    *EmailOutboxService
+	templates *emailtemplate.Renderer
+}
+
+// NewAccountMailer constructs an AccountMailer.
+func NewAccountMailer(mailer *EmailOutboxService, templates *emailtemplate.Renderer) *AccountMailer {
+	return &AccountMailer{mailer: mailer, templates: templates}
+}
+
+// IsConfigured reports whether notifications can be delivered at all.
+func (m *AccountMailer) IsConfigured() bool {
+	return m != nil && m.mailer.IsConfigured()
+}
+
+// SendAccountLocked tells the user their account was locked after repeated failed logins.
+func (m *AccountMailer) SendAccountLocked(ctx context.Context, user *models.User, until, now time.Time) {
+	m.send(ctx, user, emailtemplate.AccountLocked, map[string]any{
+		"DisplayName":    user.DisplayName,
+		"LockedUntil":    until.Local().Format("2006-01-02 15:04"),
+		"LockoutMinutes": int(math.Ceil(until.Sub(now).Minutes())),
+	})
+}
+
+// SendPasswordReset emails the link that lets the user choose a new password.
+func (m *AccountMailer) SendPasswordReset(ctx context.Context, user *models.User, resetURL string, ttl time.Duration) {
+	m.send(ctx, user, emailtemplate.PasswordReset, map[string]any{
+		"DisplayName":      user.DisplayName,
+		"ResetURL":         resetURL,
+		"ExpiresInMinutes": int(ttl / time.Minute),
+	})
+}
+
+// SendSessionRevoked warns the user that a stolen refresh token was detected and its session signed out.
+func (m *AccountMailer) SendSessionRevoked(ctx context.Context, user *models.User, deviceLabel, ip string, at time.Time) {
+	m.send(ctx, user, emailtemplate.SessionRevoked, map[string]any{
+		"DisplayName": user.DisplayName,
+		"DeviceLabel": deviceLabel,
+		"IP":          ip,
+		"DetectedAt":  at.Local().Format("2006-01-02 15:04"),
+	})
+}
+
+// SendEmailChangeConfirmation emails the link that confirms newEmail as the user's address to newEmail itself.
+func (m *AccountMailer) SendEmailChangeConfirmation(ctx context.Context, user *models.User, newEmail, confirmURL string, ttl time.Duration) {
+	m.sendTo(ctx, user, newEmail, emailtemplate.EmailChangeConfirm, map[string]any{
+		"DisplayName":    user.DisplayName,
+		"NewEmail":       newEmail,
+		"ConfirmURL":     confirmURL,
+		"ExpiresInHours": int(ttl / time.Hour),
+	})
+}
+
+// SendEmailChanged tells the previous address that the account moved to newEmail and how to revert it.
+func (m *AccountMailer) SendEmailChanged(ctx context.Context, user *models.User, oldEmail, newEmail, undoURL string, undoTTL time.Duration) {
+	m.sendTo(ctx, user, oldEmail, emailtemplate.EmailChanged, map[string]any{
+		"DisplayName":       user.DisplayName,
+		"OldEmail":          oldEmail,
+		"NewEmail":          newEmail,
+		"UndoURL":           undoURL,
+		"UndoExpiresInDays": int(undoTTL / (24 * time.Hour)),
+	})
+}
+
+// send renders and enqueues a notification to the user's own address.
+func (m *AccountMailer) send(ctx context.Context, user *models.User, name string, data map[string]any) {
+	if user == nil {
+		return
+	}
+	m.sendTo(ctx, user, user.Email, name, data)
+}
+
+// sendTo renders a notification in the user's locale and enqueues it for recipient, logging rather than
+// returning failures so that the account operation triggering it is never blocked by email problems.
+func (m *AccountMailer) sendTo(ctx context.Context, user *models.User, recipient, name string, data map[string]any) {
+	if !m.IsConfigured() || user == nil || isVirtualEmail(recipient) {
+		return
+	}
+
+	message, err := m.templates.Render(name, user.Locale, data)
+	if err != nil {
+		slog.Warn("render account email failed", slog.String("template", name), slog.Any("error", err))
+		return
+	}
+	if _, err := m.mailer.Enqueue(ctx, recipient, message); err != nil {
+		slog.Warn("queue account email failed", slog.String("template", name), slog.Any("error", err))
+	}
+}
+
+// isVirtualEmail reports whether email is a placeholder generated for phone or OAuth accounts.
+func isVirtualEmail(email string) bool {
+	return strings.HasSuffix(email, "@"+virtualEmailDomain)
+}
@@ -0,0 +1,385 @@
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hdu-dp/backend/internal/models"
+	"github.com/hdu-dp/backend/internal/ratelimit"
+	"github.com/hdu-dp/backend/internal/repository"
+	"github.com/hdu-dp/backend/internal/storage"
+	"github.com/hdu-dp/backend/internal/utils"
+	"gorm.io/gorm"
+)
+
+// Content policies applied to reviews and reactions when an account is purged.
+const (
+	DeletionPolicyAnonymize = "anonymize"
+	DeletionPolicyDelete    = "delete"
+)
+
+// deletedDisplayName replaces the name of anonymised accounts on the reviews they leave behind.
+const deletedDisplayName = "已注销用户"
+
+var (
+	// ErrReauthRequired indicates the caller must sign in again before deleting the account.
+	ErrReauthRequired = errors.New("recent sign-in required")
+	// ErrDeletionNotScheduled indicates there is no pending deletion to cancel.
+	ErrDeletionNotScheduled = errors.New("account deletion not scheduled")
+)
+
+// AccountOptions configures account deletion.
+type AccountOptions struct {
+	// GracePeriod is how long a requested deletion can still be cancelled; zero purges immediately.
+	GracePeriod time.Duration
+	// ContentPolicy is DeletionPolicyAnonymize or DeletionPolicyDelete.
+	ContentPolicy string
+	// ReauthWindow is how recently a session without a password must have signed in to delete the account.
+	ReauthWindow time.Duration
+	// ExportLimit caps data exports per user within ExportWindow.
+	ExportLimit  int
+	ExportWindow time.Duration
+	// PollInterval is how often Run looks for accounts whose grace period ended.
+	PollInterval time.Duration
+}
+
+func (o AccountOptions) withDefaults() AccountOptions {
+	if o.ContentPolicy == "" {
+		o.ContentPolicy = DeletionPolicyAnonymize
+	}
+	if o.ReauthWindow <= 0 {
+		o.ReauthWindow = 10 * time.Minute
+	}
+	if o.ExportLimit <= 0 {
+		o.ExportLimit = 3
+	}
+	if o.ExportWindow <= 0 {
+		o.ExportWindow = time.Hour
+	}
+	if o.PollInterval <= 0 {
+		o.PollInterval = time.Hour
+	}
+	return o
+}
+
+// AccountService exports a user's personal data and deletes accounts on request.
+type AccountService struct {
+	users         *repository.UserRepository
+	reviews       *repository.ReviewRepository
+	reactions     *repository.ReviewReactionRepository
+	refreshTokens *repository.RefreshTokenRepository
+	apiTokens     *repository.APITokenRepository
+	guard         *PasswordGuard
+	storage       storage.FileStorage
+	limiter       *ratelimit.Limiter
+	opts          AccountOptions
+	now           func() time.Time
+}
+
+// NewAccountService constructs an AccountService.
+func NewAccountService(
+	users *repository.UserRepository,
+	reviews *repository.ReviewRepository,
+	reactions *repository.ReviewReactionRepository,
+	refreshTokens *repository.RefreshTokenRepository,
+	apiTokens *repository.APITokenRepository,
+	guard *PasswordGuard,
+	fileStorage storage.FileStorage,
+	limiter *ratelimit.Limiter,
+	opts AccountOptions,
+) *AccountService {
+	return &AccountService{
+		users:         users,
+		reviews:       reviews,
+		reactions:     reactions,
+		refreshTokens: refreshTokens,
+		apiTokens:     apiTokens,
+		guard:         guard,
+		storage:       fileStorage,
+		limiter:       limiter,
+		opts:          opts.withDefaults(),
+		now:           time.Now,
+	}
+}
+
+// Export writes a ZIP archive of everything stored about userID to w: the profile, reviews with their
+// images and reactions. Image files that cannot be read are left out of the archive.
+func (s *AccountService) Export(ctx context.Context, userID uuid.UUID, w io.Writer) error {
+	if err := s.limiter.Allow(ctx, userID.String(), ratelimit.Rule{
+		Name:   "account_export",
+		Limit:  s.opts.ExportLimit,
+		Window: s.opts.ExportWindow,
+	}); err != nil {
+		return err
+	}
+
+	user, err := s.findUser(userID)
+	if err != nil {
+		return err
+	}
+	reviews, err := s.reviews.ListAllByAuthor(user.ID)
+	if err != nil {
+		return err
+	}
+	reactions, err := s.reactions.ListByUser(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+
+	archive := zip.NewWriter(w)
+	documents := []struct {
+		name string
+		data any
+	}{
+		{"profile.json", exportProfile(user)},
+		{"reviews.json", reviews},
+		{"reactions.json", reactions},
+	}
+	for _, doc := range documents {
+		if err := writeJSONEntry(archive, doc.name, doc.data); err != nil {
+			return err
+		}
+	}
+
+	for _, review := range reviews {
+		for _, image := range review.Images {
+			name := fmt.Sprintf("images/%s/%s%s", review.ID, image.ID, path.Ext(image.StorageKey))
+			s.copyFile(ctx, archive, name, image.StorageKey)
+		}
+	}
+	if user.AvatarKey != "" {
+		s.copyFile(ctx, archive, "avatar.jpg", avatarKey(user.AvatarKey, avatarLargeSize))
+	}
+	return archive.Close()
+}
+
+// RequestDeletion schedules userID for deletion after the grace period and signs the account out everywhere.
+// Accounts with a real email confirm with their password, and wrong guesses count toward the login lockout;
+// accounts that sign in through SMS or OAuth only
+// must use a session that signed in within the reauthentication window. It returns when the account will
+// be purged; with no grace period the account is purged before returning.
+func (s *AccountService) RequestDeletion(ctx context.Context, userID, sessionID uuid.UUID, password string) (time.Time, error) {
+	user, err := s.findUser(userID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if err := s.reauthenticate(ctx, user, sessionID, password); err != nil {
+		return time.Time{}, err
+	}
+
+	now := s.now()
+	purgeAt := now.Add(s.opts.GracePeriod)
+	if s.opts.GracePeriod == 0 {
+		return now, s.Purge(ctx, user.ID)
+	}
+
+	if err := s.users.ScheduleDeletion(user.ID, &purgeAt); err != nil {
+		return time.Time{}, err
+	}
+	if err := s.refreshTokens.RevokeAllForUser(user.ID); err != nil {
+		return time.Time{}, err
+	}
+	if err := s.apiTokens.RevokeAllForUser(ctx, user.ID, now); err != nil {
+		return time.Time{}, err
+	}
+	slog.Info("account deletion scheduled", slog.String("user_id", user.ID.String()), slog.Time("purge_at", purgeAt))
+	return purgeAt, nil
+}
+
+// CancelDeletion keeps an account whose deletion is still in its grace period.
+func (s *AccountService) CancelDeletion(userID uuid.UUID) error {
+	user, err := s.findUser(userID)
+	if err != nil {
+		return err
+	}
+	if user.DeletionScheduledAt == nil {
+		return ErrDeletionNotScheduled
+	}
+	return s.users.ScheduleDeletion(user.ID, nil)
+}
+
+// Purge deletes the account now. Reviews and reactions are kept under an anonymous author or deleted
+// according to the content policy; sessions, tokens and uploaded files of the account are always removed.
+func (s *AccountService) Purge(ctx context.Context, userID uuid.UUID) error {
+	user, err := s.findUser(userID)
+	if err != nil {
+		return err
+	}
+
+	var anonymize map[string]any
+	if s.opts.ContentPolicy == DeletionPolicyAnonymize {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return err
+		}
+		passwordHash, err := utils.HashPassword(hex.EncodeToString(secret))
+		if err != nil {
+			return err
+		}
+		anonymize = map[string]any{
+			"email":                 virtualEmail("deleted", user.ID.String()),
+			"display_name":          deletedDisplayName,
+			"password_hash":         passwordHash,
+			"role":                  "user",
+			"phone":                 nil,
+			"qq_open_id":            nil,
+			"we_chat_open_id":       nil,
+			"bio":                   "",
+			"dietary_preferences":   "",
+			"avatar_key":            "",
+			"avatar_url":            "",
+			"avatar_small_url":      "",
+			"email_verified":        false,
+			"email_verified_at":     nil,
+			"campus_verified":       false,
+			"deletion_scheduled_at": nil,
+		}
+	}
+
+	result, err := s.users.Purge(user.ID, anonymize)
+	if err != nil {
+		return err
+	}
+
+	keys := result.ImageKeys
+	if user.AvatarKey != "" {
+		keys = append(keys, avatarKey(user.AvatarKey, avatarLargeSize), avatarKey(user.AvatarKey, avatarSmallSize))
+	}
+	for _, key := range keys {
+		if err := s.storage.Delete(ctx, key); err != nil {
+			slog.Warn("delete file of purged account failed", slog.String("key", key), slog.Any("error", err))
+		}
+	}
+
+	slog.Info("account purged",
+		slog.String("user_id", user.ID.String()),
+		slog.String("policy", s.opts.ContentPolicy),
+		slog.Int64("reviews", result.Reviews),
+		slog.Int64("reactions", result.Reactions),
+	)
+	return nil
+}
+
+// PurgeDue purges accounts whose grace period has ended and returns how many were purged.
+func (s *AccountService) PurgeDue(ctx context.Context) (int, error) {
+	users, err := s.users.ListDueForDeletion(s.now(), 50)
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	for _, user := range users {
+		if err := s.Purge(ctx, user.ID); err != nil {
+			slog.Error("purge account failed", slog.String("user_id", user.ID.String()), slog.Any("error", err))
+			continue
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// Run purges due accounts every PollInterval until ctx is cancelled.
+func (s *AccountService) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.opts.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.PurgeDue(ctx); err != nil {
+			slog.Error("purge deleted accounts failed", slog.Any("error", err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *AccountService) reauthenticate(ctx context.Context, user *models.User, sessionID uuid.UUID, password string) error {
+	if !isVirtualEmail(user.Email) {
+		return s.guard.Verify(ctx, user, password)
+	}
+
+	if sessionID == uuid.Nil {
+		return ErrReauthRequired
+	}
+	session, err := s.refreshTokens.FindByID(sessionID)
+	if err != nil || session.UserID != user.ID || session.Revoked || s.now().Sub(session.CreatedAt) > s.opts.ReauthWindow {
+		return ErrReauthRequired
+	}
+	return nil
+}
+
+// copyFile adds a stored file to the archive, logging and skipping files that cannot be read.
+func (s *AccountService) copyFile(ctx context.Context, archive *zip.Writer, name, key string) {
+	if s.storage == nil {
+		return
+	}
+	src, err := s.storage.Open(ctx, key)
+	if err != nil {
+		slog.Warn("export file skipped", slog.String("key", key), slog.Any("error", err))
+		return
+	}
+	defer src.Close()
+
+	dst, err := archive.Create(name)
+	if err == nil {
+		_, err = io.Copy(dst, src)
+	}
+	if err != nil {
+		slog.Warn("export file failed", slog.String("key", key), slog.Any("error", err))
+	}
+}
+
+func (s *AccountService) findUser(userID uuid.UUID) (*models.User, error) {
+	user, err := s.users.FindByID(userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+// exportProfile lists the stored account fields, including those hidden from the regular API.
+func exportProfile(user *models.User) map[string]any {
+	return map[string]any{
+		"id":                    user.ID,
+		"email":                 user.Email,
+		"phone":                 user.Phone,
+		"qq_open_id":            user.QQOpenID,
+		"wechat_open_id":        user.WeChatOpenID,
+		"display_name":          user.DisplayName,
+		"bio":                   user.Bio,
+		"dietary_preferences":   user.DietaryPreferenceList(),
+		"avatar_url":            user.AvatarURL,
+		"locale":                user.Locale,
+		"role":                  user.Role,
+		"email_verified":        user.EmailVerified,
+		"email_verified_at":     user.EmailVerifiedAt,
+		"campus_verified":       user.CampusVerified,
+		"deletion_scheduled_at": user.DeletionScheduledAt,
+		"created_at":            user.CreatedAt,
+		"updated_at":            user.UpdatedAt,
+	}
+}
+
+func writeJSONEntry(archive *zip.Writer, name string, data any) error {
+	entry, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(entry)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(data)
+}
@@ -0,0 +1,257 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hdu-dp/backend/internal/common"
+	"github.com/hdu-dp/backend/internal/models"
+	"github.com/hdu-dp/backend/internal/repository"
+	"github.com/hdu-dp/backend/internal/storage"
+	"github.com/hdu-dp/backend/internal/utils"
+	"gorm.io/gorm"
+)
+
+type accountTestEnv struct {
+	db      *gorm.DB
+	users   *repository.UserRepository
+	dir     string
+	storage *storage.Local
+}
+
+func newAccountTestEnv(t *testing.T) *accountTestEnv {
+	t.Helper()
+	db := newServiceTestDB(t,
+		&models.User{}, &models.Review{}, &models.ReviewImage{}, &models.ReviewStats{}, &models.ReviewReaction{},
+		&models.RefreshToken{}, &models.PasswordResetToken{}, &models.EmailChange{}, &models.EmailVerification{}, &models.UserTOTP{}, &models.MFARecoveryCode{}, &models.APIToken{},
+	)
+	dir := t.TempDir()
+	local, err := storage.NewLocal(dir, "/api/v1/uploads")
+	if err != nil {
+		t.Fatalf("init storage failed: %v", err)
+	}
+	return &accountTestEnv{db: db, users: repository.NewUserRepository(db), dir: dir, storage: local}
+}
+
+func (e *accountTestEnv) service(opts AccountOptions) *AccountService {
+	return NewAccountService(
+		e.users,
+		repository.NewReviewRepository(e.db),
+		repository.NewReviewReactionRepository(e.db),
+		repository.NewRefreshTokenRepository(e.db),
+		repository.NewAPITokenRepository(e.db),
+		NewPasswordGuard(e.users, nil, LoginProtection{}),
+		e.storage,
+		nil,
+		opts,
+	)
+}
+
+// seed creates an author with one review, one stored image and a reaction from another user on that review,
+// plus a reaction of the author on the other user's review.
+func (e *accountTestEnv) seed(t *testing.T) (author, other *models.User, review *models.Review, imageKey string) {
+	t.Helper()
+	hash, err := utils.HashPassword("secret123")
+	if err != nil {
+		t.Fatalf("hash password failed: %v", err)
+	}
+	author = &models.User{Email: "author@example.com", PasswordHash: hash, DisplayName: "Author", Bio: "hi"}
+	other = &models.User{Email: "other@example.com", PasswordHash: hash, DisplayName: "Other"}
+	for _, u := range []*models.User{author, other} {
+		if err := e.users.Create(u); err != nil {
+			t.Fatalf("create user failed: %v", err)
+		}
+	}
+
+	review = &models.Review{Title: "A", Address: "x", Rating: 4, AuthorID: author.ID}
+	otherReview := &models.Review{Title: "B", Address: "y", Rating: 5, AuthorID: other.ID}
+	for _, r := range []*models.Review{review, otherReview} {
+		if err := e.db.Create(r).Error; err != nil {
+			t.Fatalf("create review failed: %v", err)
+		}
+	}
+	info, err := e.storage.Save(t.Context(), "reviews/"+review.ID.String()+"/photo.jpg", bytes.NewReader([]byte("jpeg")), 4, "image/jpeg")
+	if err != nil {
+		t.Fatalf("save image failed: %v", err)
+	}
+	if err := e.db.Create(&models.ReviewImage{ReviewID: review.ID, StorageKey: info.Key, URL: info.URL}).Error; err != nil {
+		t.Fatalf("create image failed: %v", err)
+	}
+	if err := e.db.Create(&models.ReviewStats{ReviewID: otherReview.ID, Likes: 1}).Error; err != nil {
+		t.Fatalf("create stats failed: %v", err)
+	}
+	for _, reaction := range []*models.ReviewReaction{
+		{ReviewID: otherReview.ID, UserID: author.ID, Type: models.ReactionTypeLike},
+		{ReviewID: review.ID, UserID: other.ID, Type: models.ReactionTypeLike},
+	} {
+		if err := e.db.Create(reaction).Error; err != nil {
+			t.Fatalf("create reaction failed: %v", err)
+		}
+	}
+	if err := e.db.Create(&models.RefreshToken{UserID: author.ID, SecretHash: "x", ExpiresAt: time.Now().Add(time.Hour)}).Error; err != nil {
+		t.Fatalf("create refresh token failed: %v", err)
+	}
+	return author, other, review, info.Key
+}
+
+func TestExportContainsProfileReviewsAndImages(t *testing.T) {
+	env := newAccountTestEnv(t)
+	author, _, review, _ := env.seed(t)
+
+	var buf bytes.Buffer
+	if err := env.service(AccountOptions{}).Export(t.Context(), author.ID, &buf); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("open archive failed: %v", err)
+	}
+	files := map[string]string{}
+	for _, f := range archive.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("open %s failed: %v", f.Name, err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(data)
+	}
+
+	for _, name := range []string{"profile.json", "reviews.json", "reactions.json"} {
+		if files[name] == "" {
+			t.Fatalf("expected %s in the export, got %v", name, files)
+		}
+	}
+	if !strings.Contains(files["profile.json"], "author@example.com") {
+		t.Fatalf("expected the profile to contain the email, got %s", files["profile.json"])
+	}
+	found := false
+	for name, data := range files {
+		if strings.HasPrefix(name, "images/"+review.ID.String()+"/") && strings.HasSuffix(name, ".jpg") && data == "jpeg" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected the review image in the export, got %v", files)
+	}
+}
+
+func TestDeletionRequiresReauthAndCanBeCancelled(t *testing.T) {
+	env := newAccountTestEnv(t)
+	author, _, _, _ := env.seed(t)
+	svc := env.service(AccountOptions{GracePeriod: 24 * time.Hour})
+
+	if _, err := svc.RequestDeletion(t.Context(), author.ID, uuid.Nil, "wrong"); !errors.Is(err, common.ErrInvalidCredentials) {
+		t.Fatalf("expected a wrong password to be refused, got %v", err)
+	}
+	if guessed, err := env.users.FindByID(author.ID); err != nil || guessed.FailedLoginAttempts != 1 {
+		t.Fatalf("expected the wrong password to count toward the lockout, got %+v (%v)", guessed, err)
+	}
+	purgeAt, err := svc.RequestDeletion(t.Context(), author.ID, uuid.Nil, "secret123")
+	if err != nil {
+		t.Fatalf("request deletion failed: %v", err)
+	}
+	if time.Until(purgeAt) < 23*time.Hour {
+		t.Fatalf("expected a grace period, got %v", purgeAt)
+	}
+	var active int64
+	if err := env.db.Model(&models.RefreshToken{}).Where("user_id = ? AND revoked = ?", author.ID, false).Count(&active).Error; err != nil || active != 0 {
+		t.Fatalf("expected sessions to be revoked, got %d (%v)", active, err)
+	}
+
+	if purged, err := svc.PurgeDue(t.Context()); err != nil || purged != 0 {
+		t.Fatalf("expected nothing to be purged during the grace period, got %d (%v)", purged, err)
+	}
+	if err := svc.CancelDeletion(author.ID); err != nil {
+		t.Fatalf("cancel deletion failed: %v", err)
+	}
+	if err := svc.CancelDeletion(author.ID); !errors.Is(err, ErrDeletionNotScheduled) {
+		t.Fatalf("expected a second cancel to fail, got %v", err)
+	}
+
+	openID := "qq-openid"
+	social := &models.User{Email: virtualEmail("qq", openID), QQOpenID: &openID, PasswordHash: "x", DisplayName: "QQ用户"}
+	if err := env.users.Create(social); err != nil {
+		t.Fatalf("create user failed: %v", err)
+	}
+	stale := &models.RefreshToken{UserID: social.ID, SecretHash: "x", ExpiresAt: time.Now().Add(time.Hour)}
+	fresh := &models.RefreshToken{UserID: social.ID, SecretHash: "y", ExpiresAt: time.Now().Add(time.Hour)}
+	for _, token := range []*models.RefreshToken{stale, fresh} {
+		if err := env.db.Create(token).Error; err != nil {
+			t.Fatalf("create refresh token failed: %v", err)
+		}
+	}
+	if err := env.db.Model(stale).UpdateColumn("created_at", time.Now().Add(-time.Hour)).Error; err != nil {
+		t.Fatalf("age session failed: %v", err)
+	}
+	if _, err := svc.RequestDeletion(t.Context(), social.ID, stale.ID, ""); !errors.Is(err, ErrReauthRequired) {
+		t.Fatalf("expected an old session to need a fresh sign-in, got %v", err)
+	}
+	if _, err := svc.RequestDeletion(t.Context(), social.ID, fresh.ID, ""); err != nil {
+		t.Fatalf("expected a fresh session to be enough, got %v", err)
+	}
+}
+
+func TestPurgeAnonymizesOrDeletesContent(t *testing.T) {
+	for _, policy := range []string{DeletionPolicyAnonymize, DeletionPolicyDelete} {
+		t.Run(policy, func(t *testing.T) {
+			env := newAccountTestEnv(t)
+			author, other, review, imageKey := env.seed(t)
+			svc := env.service(AccountOptions{GracePeriod: time.Hour, ContentPolicy: policy})
+
+			if err := env.db.Model(author).Update("role", "admin").Error; err != nil {
+				t.Fatalf("promote author failed: %v", err)
+			}
+			past := time.Now().Add(-time.Minute)
+			if err := env.users.ScheduleDeletion(author.ID, &past); err != nil {
+				t.Fatalf("schedule deletion failed: %v", err)
+			}
+			if purged, err := svc.PurgeDue(t.Context()); err != nil || purged != 1 {
+				t.Fatalf("expected one account to be purged, got %d (%v)", purged, err)
+			}
+
+			var reviews, reactions, tokens int64
+			env.db.Model(&models.Review{}).Where("author_id = ?", author.ID).Count(&reviews)
+			env.db.Model(&models.ReviewReaction{}).Where("user_id = ? OR review_id = ?", author.ID, review.ID).Count(&reactions)
+			env.db.Model(&models.RefreshToken{}).Where("user_id = ?", author.ID).Count(&tokens)
+			if tokens != 0 {
+				t.Fatalf("expected sessions to be deleted, got %d", tokens)
+			}
+			_, statErr := os.Stat(filepath.Join(env.dir, filepath.FromSlash(imageKey)))
+
+			if policy == DeletionPolicyAnonymize {
+				user, err := env.users.FindByID(author.ID)
+				if err != nil {
+					t.Fatalf("expected the anonymised row to stay, got %v", err)
+				}
+				if user.Email == "author@example.com" || user.DisplayName != deletedDisplayName || user.Bio != "" || user.Role != "user" || user.DeletionScheduledAt != nil {
+					t.Fatalf("expected personal data to be scrubbed, got %+v", user)
+				}
+				if reviews != 1 || reactions != 2 || statErr != nil {
+					t.Fatalf("expected content to be kept, got %d reviews, %d reactions, image %v", reviews, reactions, statErr)
+				}
+				return
+			}
+
+			if _, err := env.users.FindByID(author.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+				t.Fatalf("expected the account to be deleted, got %v", err)
+			}
+			if reviews != 0 || reactions != 0 || !errors.Is(statErr, os.ErrNotExist) {
+				t.Fatalf("expected content to be deleted, got %d reviews, %d reactions, image %v", reviews, reactions, statErr)
+			}
+			var stats models.ReviewStats
+			if err := env.db.Joins("JOIN reviews ON reviews.id = review_stats.review_id").
+				Where("reviews.author_id = ?", other.ID).First(&stats).Error; err != nil || stats.Likes != 0 {
+				t.Fatalf("expected the purged like to be uncounted, got %+v (%v)", stats, err)
+			}
+		})
+	}
+}
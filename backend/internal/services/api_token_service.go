@@ -0,0 +1,166 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hdu-dp/backend/internal/auth"
+	"github.com/hdu-dp/backend/internal/models"
+	"github.com/hdu-dp/backend/internal/repository"
+	"gorm.io/gorm"
+)
+
+// maxAPITokensPerUser caps how many usable personal access tokens one account may hold.
+const maxAPITokensPerUser = 20
+
+var (
+	// ErrAPITokenNotFound indicates the token does not exist, belongs to someone else or was already revoked.
+	ErrAPITokenNotFound = errors.New("api token not found")
+	// ErrInvalidAPITokenScope indicates an unknown scope, or one the account itself does not hold.
+	ErrInvalidAPITokenScope = errors.New("invalid api token scope")
+	// ErrTooManyAPITokens indicates the account already holds the maximum number of tokens.
+	ErrTooManyAPITokens = errors.New("too many api tokens")
+)
+
+// APIToken is the user-facing view of a personal access token; the secret itself is never listed.
+type APIToken struct {
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Expired    bool       `json:"expired"`
+}
+
+// CreatedAPIToken is returned once, right after creation, and is the only time Token is available.
+type CreatedAPIToken struct {
+	APIToken
+	Token string `json:"token"`
+}
+
+// APITokenService manages personal access tokens for scripts and bots.
+type APITokenService struct {
+	tokens *repository.APITokenRepository
+	users  *repository.UserRepository
+	now    func() time.Time
+}
+
+// NewAPITokenService constructs an APITokenService.
+func NewAPITokenService(tokens *repository.APITokenRepository, users *repository.UserRepository) *APITokenService {
+	return &APITokenService{tokens: tokens, users: users, now: time.Now}
+}
+
+// Create issues a named token for userID limited to scopes. ttl of zero means the token never expires.
+func (s *APITokenService) Create(ctx context.Context, userID uuid.UUID, name string, scopes []string, ttl time.Duration) (*CreatedAPIToken, error) {
+	user, err := s.users.FindByID(userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	scopes, err = normalizeScopes(scopes, user.Role)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now()
+	count, err := s.tokens.CountActiveByUser(ctx, userID, now)
+	if err != nil {
+		return nil, err
+	}
+	if count >= maxAPITokensPerUser {
+		return nil, ErrTooManyAPITokens
+	}
+
+	raw, hash, prefix, err := auth.GenerateAPIToken()
+	if err != nil {
+		return nil, err
+	}
+	token := &models.APIToken{
+		UserID:    userID,
+		Name:      strings.TrimSpace(name),
+		TokenHash: hash,
+		Prefix:    prefix,
+		Scopes:    strings.Join(scopes, ","),
+		CreatedAt: now,
+	}
+	if ttl > 0 {
+		expiresAt := now.Add(ttl)
+		token.ExpiresAt = &expiresAt
+	}
+	if err := s.tokens.Create(ctx, token); err != nil {
+		return nil, err
+	}
+
+	return &CreatedAPIToken{APIToken: s.view(token, now), Token: raw}, nil
+}
+
+// List returns the tokens of userID that have not been revoked, including expired ones.
+func (s *APITokenService) List(ctx context.Context, userID uuid.UUID) ([]APIToken, error) {
+	tokens, err := s.tokens.ListByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now()
+	views := make([]APIToken, 0, len(tokens))
+	for i := range tokens {
+		views = append(views, s.view(&tokens[i], now))
+	}
+	return views, nil
+}
+
+// Revoke disables a token of userID immediately.
+func (s *APITokenService) Revoke(ctx context.Context, userID, id uuid.UUID) error {
+	revoked, err := s.tokens.Revoke(ctx, userID, id, s.now())
+	if err != nil {
+		return err
+	}
+	if !revoked {
+		return ErrAPITokenNotFound
+	}
+	return nil
+}
+
+func (s *APITokenService) view(token *models.APIToken, now time.Time) APIToken {
+	return APIToken{
+		ID:         token.ID,
+		Name:       token.Name,
+		Prefix:     token.Prefix,
+		Scopes:     token.ScopeList(),
+		ExpiresAt:  token.ExpiresAt,
+		LastUsedAt: token.LastUsedAt,
+		CreatedAt:  token.CreatedAt,
+		Expired:    token.ExpiresAt != nil && !token.ExpiresAt.After(now),
+	}
+}
+
+// normalizeScopes validates and deduplicates scopes. Only admins may grant the admin scope.
+func normalizeScopes(scopes []string, role string) ([]string, error) {
+	normalized := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		scope = strings.TrimSpace(strings.ToLower(scope))
+		if !slices.Contains(models.APITokenScopes, scope) {
+			return nil, ErrInvalidAPITokenScope
+		}
+		if scope == models.ScopeAdmin && role != "admin" {
+			return nil, ErrInvalidAPITokenScope
+		}
+		if !slices.Contains(normalized, scope) {
+			normalized = append(normalized, scope)
+		}
+	}
+	if len(normalized) == 0 {
+		return nil, ErrInvalidAPITokenScope
+	}
+	slices.Sort(normalized)
+	return normalized, nil
+}
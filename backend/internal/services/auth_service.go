@@ -1,8 +1,11 @@
 package services
 
 import (
+	"context"
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
 	"errors"
@@ -16,41 +19,67 @@ import (
 	"github.com/google/uuid"
 	"github.com/hdu-dp/backend/internal/auth"
 	"github.com/hdu-dp/backend/internal/common"
+	"github.com/hdu-dp/backend/internal/emailtemplate"
 	"github.com/hdu-dp/backend/internal/models"
+	"github.com/hdu-dp/backend/internal/passwordpolicy"
+	"github.com/hdu-dp/backend/internal/ratelimit"
 	"github.com/hdu-dp/backend/internal/repository"
+	"github.com/hdu-dp/backend/internal/sms"
 	"github.com/hdu-dp/backend/internal/utils"
 	"gorm.io/gorm"
 )
 
 // AuthResult captures token issuance results.
+// When a second factor is still owed, the tokens are empty and MFAToken carries the challenge instead;
+// MFAEnrollmentRequired tells the client the user must first set up an authenticator.
 type AuthResult struct {
-	AccessToken  string
-	RefreshToken string
-	User         *models.User
+	AccessToken           string
+	RefreshToken          string
+	User                  *models.User
+	MFAToken              string
+	MFAExpiresIn          time.Duration
+	MFAEnrollmentRequired bool
 }
 
 // AuthService exposes user registration, login, refresh and logout operations.
 type AuthService struct {
-	users         *repository.UserRepository
-	tokens        *auth.JWTManager
-	refreshTokens *repository.RefreshTokenRepository
-	smsCodes      *repository.SMSCodeRepository
-	qqOAuth       *QQOAuthService
-	wechatOAuth   *WeChatOAuthService
-	refreshTTL    time.Duration
-	smsCodeTTL    time.Duration
-	smsEnabled    bool
-	smsDevMode    bool
-	adminEmail    string
+	users          *repository.UserRepository
+	tokens         *auth.JWTManager
+	refreshTokens  *repository.RefreshTokenRepository
+	smsCodes       *repository.SMSCodeRepository
+	smsSender      sms.Sender
+	limiter        *ratelimit.Limiter
+	qqOAuth        *QQOAuthService
+	wechatOAuth    *WeChatOAuthService
+	webhooks       *WebhookService
+	accountMail    *AccountMailer
+	mfa            *MFAService
+	guard          *PasswordGuard
+	login          LoginProtection
+	refreshTTL     time.Duration
+	refreshKey     []byte
+	smsCodeTTL     time.Duration
+	smsEnabled     bool
+	smsDevMode     bool
+	smsLimits      CodeRateLimits
+	adminEmail     string
+	passwordPolicy *passwordpolicy.Policy
+	now            func() time.Time
 }
 
 // AuthServiceOptions groups options for AuthService initialization.
 type AuthServiceOptions struct {
 	RefreshTTL time.Duration
-	SMSCodeTTL time.Duration
-	SMSEnabled bool
-	SMSDevMode bool
-	AdminEmail string
+	// RefreshSecretKey keys the HMAC that stores refresh token secrets.
+	RefreshSecretKey string
+	SMSCodeTTL       time.Duration
+	SMSEnabled       bool
+	SMSDevMode       bool
+	SMSLimits        CodeRateLimits
+	Login            LoginProtection
+	AdminEmail       string
+	// PasswordPolicy checks passwords chosen at registration; nil only requires a non-empty password.
+	PasswordPolicy *passwordpolicy.Policy
 }
 
 // NewAuthService constructs an auth service instance.
@@ -59,80 +88,130 @@ func NewAuthService(
 	tokens *auth.JWTManager,
 	refreshRepo *repository.RefreshTokenRepository,
 	smsCodeRepo *repository.SMSCodeRepository,
+	smsSender sms.Sender,
+	limiter *ratelimit.Limiter,
 	qqOAuth *QQOAuthService,
 	wechatOAuth *WeChatOAuthService,
+	webhooks *WebhookService,
+	accountMail *AccountMailer,
+	mfa *MFAService,
 	options AuthServiceOptions,
 ) *AuthService {
 	if options.SMSCodeTTL <= 0 {
 		options.SMSCodeTTL = 10 * time.Minute
 	}
 	return &AuthService{
-		users:         users,
-		tokens:        tokens,
-		refreshTokens: refreshRepo,
-		smsCodes:      smsCodeRepo,
-		qqOAuth:       qqOAuth,
-		wechatOAuth:   wechatOAuth,
-		refreshTTL:    options.RefreshTTL,
-		smsCodeTTL:    options.SMSCodeTTL,
-		smsEnabled:    options.SMSEnabled,
-		smsDevMode:    options.SMSDevMode,
-		adminEmail:    strings.TrimSpace(strings.ToLower(options.AdminEmail)),
+		users:          users,
+		tokens:         tokens,
+		refreshTokens:  refreshRepo,
+		smsCodes:       smsCodeRepo,
+		smsSender:      smsSender,
+		limiter:        limiter,
+		qqOAuth:        qqOAuth,
+		wechatOAuth:    wechatOAuth,
+		webhooks:       webhooks,
+		accountMail:    accountMail,
+		mfa:            mfa,
+		guard:          NewPasswordGuard(users, accountMail, options.Login),
+		login:          options.Login.withDefaults(),
+		refreshTTL:     options.RefreshTTL,
+		refreshKey:     []byte(options.RefreshSecretKey),
+		smsCodeTTL:     options.SMSCodeTTL,
+		smsEnabled:     options.SMSEnabled,
+		smsDevMode:     options.SMSDevMode,
+		smsLimits:      options.SMSLimits.withDefaults(),
+		adminEmail:     strings.TrimSpace(strings.ToLower(options.AdminEmail)),
+		passwordPolicy: options.PasswordPolicy,
+		now:            time.Now,
 	}
 }
 
 // Register creates a new user account and issues token pair.
-func (s *AuthService) Register(email, password, displayName string) (*AuthResult, error) {
+// locale selects the language of emails sent to the user; when empty the configured default is used.
+func (s *AuthService) Register(email, password, displayName, locale string, client ClientInfo) (*AuthResult, error) {
 	email = strings.TrimSpace(strings.ToLower(email))
 	displayName = strings.TrimSpace(displayName)
 
 	if email == "" || password == "" || displayName == "" {
 		return nil, errors.New("invalid registration input")
 	}
+	if err := validateDisplayName(displayName); err != nil {
+		return nil, err
+	}
+	if err := s.passwordPolicy.Check(password, email, displayName); err != nil {
+		return nil, err
+	}
 
 	if _, err := s.users.FindByEmail(email); err == nil {
 		return nil, common.ErrEmailAlreadyUsed
 	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
 		return nil, err
 	}
+	if taken, err := s.users.DisplayNameTaken(displayName, uuid.Nil); err != nil {
+		return nil, err
+	} else if taken {
+		return nil, ErrDisplayNameTaken
+	}
 
 	hashed, err := utils.HashPassword(password)
 	if err != nil {
 		return nil, err
 	}
 
+	// An unsupported or missing locale stays empty, so emails follow the configured default locale.
+	locale = emailtemplate.NormalizeLocale(locale)
+
 	user := &models.User{
 		ID:           uuid.New(),
 		Email:        email,
 		PasswordHash: hashed,
 		DisplayName:  displayName,
+		Locale:       locale,
 		Role:         "user",
 	}
 
-	if err := s.users.Create(user); err != nil {
+	if err := s.createUser(user); err != nil {
 		return nil, err
 	}
+	s.publishUserRegistered(user)
 
-	return s.issueTokens(user)
+	return s.completeLogin(context.Background(), user, client)
 }
 
 // Login validates credentials and returns access/refresh tokens.
-func (s *AuthService) Login(email, password string) (*AuthResult, error) {
+// Failed attempts are tracked per account and per client IP; see LoginProtection.
+func (s *AuthService) Login(ctx context.Context, email, password string, client ClientInfo) (*AuthResult, error) {
 	email = strings.TrimSpace(strings.ToLower(email))
 
+	if err := s.checkLoginIP(ctx, client.IP); err != nil {
+		return nil, err
+	}
+
 	user, err := s.users.FindByEmail(email)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
+			s.recordLoginIPFailure(ctx, client.IP)
 			return nil, common.ErrInvalidCredentials
 		}
 		return nil, err
 	}
 
+	now := s.now()
+	if err := s.guard.check(user, now); err != nil {
+		return nil, err
+	}
+
 	if err := utils.CheckPassword(user.PasswordHash, password); err != nil {
-		return nil, common.ErrInvalidCredentials
+		s.recordLoginIPFailure(ctx, client.IP)
+		return nil, s.guard.recordFailure(ctx, user, now)
+	}
+	s.upgradePasswordHash(user, password)
+
+	if err := s.guard.clear(user); err != nil {
+		return nil, err
 	}
 
-	return s.issueTokens(user)
+	return s.completeLogin(ctx, user, client)
 }
 
 // GetQQLoginURL builds QQ oauth authorize URL.
@@ -144,7 +223,7 @@ func (s *AuthService) GetQQLoginURL() (string, string, error) {
 }
 
 // LoginWithQQ authenticates/creates account using QQ oauth code.
-func (s *AuthService) LoginWithQQ(code, state string) (*AuthResult, error) {
+func (s *AuthService) LoginWithQQ(code, state string, client ClientInfo) (*AuthResult, error) {
 	if s.qqOAuth == nil || !s.qqOAuth.IsEnabled() {
 		return nil, common.ErrQQServiceUnavailable
 	}
@@ -160,14 +239,14 @@ func (s *AuthService) LoginWithQQ(code, state string) (*AuthResult, error) {
 			return nil, err
 		}
 
-		passwordHash, err := s.hashRandomPassword()
+		passwordHash, err := randomPasswordHash()
 		if err != nil {
 			return nil, err
 		}
 
-		displayName := strings.TrimSpace(profile.Nickname)
-		if displayName == "" {
-			displayName = "QQ用户" + shortSuffix(profile.OpenID)
+		displayName, err := s.newAccountDisplayName(profile.Nickname, "QQ用户", shortSuffix(profile.OpenID))
+		if err != nil {
+			return nil, err
 		}
 
 		openIDCopy := profile.OpenID
@@ -180,16 +259,17 @@ func (s *AuthService) LoginWithQQ(code, state string) (*AuthResult, error) {
 			Role:         "user",
 		}
 
-		if err := s.users.Create(user); err != nil {
+		if err := s.createUser(user); err != nil {
 			return nil, err
 		}
+		s.publishUserRegistered(user)
 	}
 
-	return s.issueTokens(user)
+	return s.completeLogin(context.Background(), user, client)
 }
 
 // LoginWithWeChat authenticates/creates account using WeChat code.
-func (s *AuthService) LoginWithWeChat(code string) (*AuthResult, error) {
+func (s *AuthService) LoginWithWeChat(code string, client ClientInfo) (*AuthResult, error) {
 	if s.wechatOAuth == nil || !s.wechatOAuth.IsEnabled() {
 		return nil, common.ErrWeChatServiceUnavailable
 	}
@@ -205,12 +285,15 @@ func (s *AuthService) LoginWithWeChat(code string) (*AuthResult, error) {
 			return nil, err
 		}
 
-		passwordHash, err := s.hashRandomPassword()
+		passwordHash, err := randomPasswordHash()
 		if err != nil {
 			return nil, err
 		}
 
-		displayName := "微信用户" + shortSuffix(profile.OpenID)
+		displayName, err := s.newAccountDisplayName("", "微信用户", shortSuffix(profile.OpenID))
+		if err != nil {
+			return nil, err
+		}
 
 		openIDCopy := profile.OpenID
 		user = &models.User{
@@ -222,20 +305,19 @@ func (s *AuthService) LoginWithWeChat(code string) (*AuthResult, error) {
 			Role:         "user",
 		}
 
-		if err := s.users.Create(user); err != nil {
+		if err := s.createUser(user); err != nil {
 			return nil, err
 		}
+		s.publishUserRegistered(user)
 	}
 
-	return s.issueTokens(user)
+	return s.completeLogin(context.Background(), user, client)
 }
 
-// SendSMSLoginCode creates and stores one-time login code.
-func (s *AuthService) SendSMSLoginCode(phone string) (string, error) {
-	if !s.smsEnabled || s.smsCodes == nil {
-		return "", common.ErrSMSServiceUnavailable
-	}
-	if !s.smsDevMode {
+// SendSMSLoginCode creates a one-time login code and sends it through the configured provider.
+// The code is returned only in dev mode so it can be surfaced to the client; otherwise it is "".
+func (s *AuthService) SendSMSLoginCode(ctx context.Context, phone string) (string, error) {
+	if !s.smsEnabled || s.smsCodes == nil || s.smsSender == nil {
 		return "", common.ErrSMSServiceUnavailable
 	}
 
@@ -244,6 +326,10 @@ func (s *AuthService) SendSMSLoginCode(phone string) (string, error) {
 		return "", err
 	}
 
+	if err := allowCodeRequest(ctx, s.limiter, s.smsLimits, "sms", normalized); err != nil {
+		return "", err
+	}
+
 	code, err := generateSMSNumericCode(6)
 	if err != nil {
 		return "", err
@@ -269,54 +355,44 @@ func (s *AuthService) SendSMSLoginCode(phone string) (string, error) {
 		return "", err
 	}
 	_ = s.smsCodes.DeleteExpired(time.Now())
+	resetCodeFailures(ctx, s.limiter, "sms", normalized)
+
+	if err := s.smsSender.SendCode(ctx, normalized, code); err != nil {
+		// 发送失败时作废验证码，避免留下用户收不到的有效记录
+		_ = s.smsCodes.DeleteByPhonePurpose(normalized, smsCodePurposeLogin)
+		slog.Error("send sms code failed", slog.String("phone", normalized), slog.Any("error", err))
+		return "", common.ErrSMSDeliveryFailed
+	}
 
-	slog.Info("sms dev code generated",
-		slog.String("phone", normalized),
-		slog.String("code", code),
-	)
+	if !s.smsDevMode {
+		return "", nil
+	}
 	return code, nil
 }
 
-// LoginWithSMS verifies code and returns token pair.
-func (s *AuthService) LoginWithSMS(phone, code string) (*AuthResult, error) {
+// LoginWithSMS verifies code and returns token pair. A code is discarded after too many wrong guesses.
+func (s *AuthService) LoginWithSMS(ctx context.Context, phone, code string, client ClientInfo) (*AuthResult, error) {
 	if !s.smsEnabled || s.smsCodes == nil {
 		return nil, common.ErrSMSServiceUnavailable
 	}
 
-	normalized, err := normalizeChinaPhone(phone)
-	if err != nil {
-		return nil, err
-	}
-
-	code = strings.TrimSpace(code)
-	if !numericCodePattern.MatchString(code) {
-		return nil, common.ErrInvalidSMSCode
-	}
-
-	record, err := s.smsCodes.FindLatestActive(normalized, smsCodePurposeLogin, time.Now())
+	normalized, err := consumeSMSCode(ctx, s.smsCodes, s.limiter, s.smsLimits, phone, code)
 	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, common.ErrInvalidSMSCode
-		}
 		return nil, err
 	}
 
-	if err := utils.CheckPassword(record.CodeHash, code); err != nil {
-		return nil, common.ErrInvalidSMSCode
-	}
-
-	if err := s.smsCodes.MarkUsed(record.ID); err != nil {
-		return nil, err
-	}
-	_ = s.smsCodes.DeleteExpired(time.Now())
-
 	user, err := s.users.FindByPhone(normalized)
 	if err != nil {
 		if !errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, err
 		}
 
-		passwordHash, err := s.hashRandomPassword()
+		passwordHash, err := randomPasswordHash()
+		if err != nil {
+			return nil, err
+		}
+
+		displayName, err := s.newAccountDisplayName("", "手机用户", shortSuffix(normalized))
 		if err != nil {
 			return nil, err
 		}
@@ -327,19 +403,22 @@ func (s *AuthService) LoginWithSMS(phone, code string) (*AuthResult, error) {
 			Email:        virtualEmail("phone", normalized),
 			Phone:        &phoneCopy,
 			PasswordHash: passwordHash,
-			DisplayName:  "手机用户" + shortSuffix(normalized),
+			DisplayName:  displayName,
 			Role:         "user",
 		}
-		if err := s.users.Create(user); err != nil {
+		if err := s.createUser(user); err != nil {
 			return nil, err
 		}
+		s.publishUserRegistered(user)
 	}
 
-	return s.issueTokens(user)
+	return s.completeLogin(ctx, user, client)
 }
 
 // Refresh validates an existing refresh token and rotates it.
-func (s *AuthService) Refresh(token string) (*AuthResult, error) {
+// The new token continues the same session, keeping its device label and start time.
+// Presenting a token that was already rotated is treated as theft: the whole family is revoked.
+func (s *AuthService) Refresh(ctx context.Context, token string, client ClientInfo) (*AuthResult, error) {
 	tokenID, secret, err := parseRefreshToken(token)
 	if err != nil {
 		return nil, common.ErrInvalidRefreshToken
@@ -353,11 +432,15 @@ func (s *AuthService) Refresh(token string) (*AuthResult, error) {
 		return nil, err
 	}
 
-	if stored.Revoked || time.Now().After(stored.ExpiresAt) {
+	if !s.checkRefreshSecret(stored.SecretHash, secret) {
 		return nil, common.ErrInvalidRefreshToken
 	}
 
-	if err := utils.CheckPassword(stored.SecretHash, secret); err != nil {
+	now := s.now()
+	if stored.RotatedAt != nil {
+		return nil, s.handleRefreshReuse(ctx, stored, client, now)
+	}
+	if stored.Revoked || now.After(stored.ExpiresAt) {
 		return nil, common.ErrInvalidRefreshToken
 	}
 
@@ -366,13 +449,16 @@ func (s *AuthService) Refresh(token string) (*AuthResult, error) {
 		return nil, err
 	}
 
-	stored.Revoked = true
-	if err := s.refreshTokens.Save(stored); err != nil {
+	rotated, err := s.refreshTokens.Rotate(stored.ID, now)
+	if err != nil {
 		return nil, err
 	}
-	_ = s.refreshTokens.DeleteExpired(time.Now())
+	if !rotated {
+		return nil, common.ErrInvalidRefreshToken
+	}
+	_ = s.refreshTokens.DeleteExpired(now)
 
-	return s.issueTokens(user)
+	return s.issueTokens(user, rotateSession(stored, client, now))
 }
 
 // Logout revokes the provided refresh token without issuing a new one.
@@ -394,7 +480,7 @@ func (s *AuthService) Logout(token string) error {
 		return nil
 	}
 
-	if err := utils.CheckPassword(stored.SecretHash, secret); err != nil {
+	if !s.checkRefreshSecret(stored.SecretHash, secret) {
 		return common.ErrInvalidRefreshToken
 	}
 
@@ -402,7 +488,56 @@ func (s *AuthService) Logout(token string) error {
 	return s.refreshTokens.Save(stored)
 }
 
-func (s *AuthService) issueTokens(user *models.User) (*AuthResult, error) {
+// publishUserRegistered notifies webhook subscribers about a newly created account.
+func (s *AuthService) publishUserRegistered(user *models.User) {
+	if err := s.webhooks.Publish(context.Background(), models.WebhookEventUserRegistered, userWebhookData(user)); err != nil {
+		slog.Warn("publish user webhook failed", slog.String("user_id", user.ID.String()), slog.Any("error", err))
+	}
+}
+
+// VerifyMFA completes a login that returned an MFA challenge by checking a TOTP or recovery code.
+func (s *AuthService) VerifyMFA(ctx context.Context, challenge, code string, client ClientInfo) (*AuthResult, error) {
+	user, err := s.challengedUser(challenge)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.mfa.Verify(ctx, user.ID, code); err != nil {
+		return nil, err
+	}
+	return s.issueTokens(user, newSession(client, s.now()))
+}
+
+// BeginMFAEnrollment starts authenticator setup for a user whose role requires it but who has none yet.
+func (s *AuthService) BeginMFAEnrollment(ctx context.Context, challenge string) (*MFAEnrollment, error) {
+	user, err := s.challengedUser(challenge)
+	if err != nil {
+		return nil, err
+	}
+	return s.mfa.BeginEnrollment(ctx, user)
+}
+
+// ConfirmMFAEnrollment finishes authenticator setup started by BeginMFAEnrollment, signs the user in
+// and returns the recovery codes alongside the token pair.
+func (s *AuthService) ConfirmMFAEnrollment(ctx context.Context, challenge, code string, client ClientInfo) (*AuthResult, []string, error) {
+	user, err := s.challengedUser(challenge)
+	if err != nil {
+		return nil, nil, err
+	}
+	codes, err := s.mfa.ConfirmEnrollment(ctx, user.ID, code)
+	if err != nil {
+		return nil, nil, err
+	}
+	result, err := s.issueTokens(user, newSession(client, s.now()))
+	if err != nil {
+		return nil, nil, err
+	}
+	return result, codes, nil
+}
+
+// completeLogin finishes a successful first factor: it applies the configured admin promotion and then
+// either issues the token pair or, when the user has an authenticator or their role requires one,
+// an MFA challenge in its place.
+func (s *AuthService) completeLogin(ctx context.Context, user *models.User, client ClientInfo) (*AuthResult, error) {
 	if s.adminEmail != "" && strings.EqualFold(user.Email, s.adminEmail) && user.Role != "admin" {
 		user.Role = "admin"
 		if err := s.users.Save(user); err != nil {
@@ -410,12 +545,65 @@ func (s *AuthService) issueTokens(user *models.User) (*AuthResult, error) {
 		}
 	}
 
-	accessToken, err := s.tokens.Generate(user)
+	if s.mfa != nil {
+		enabled, err := s.mfa.Enabled(ctx, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		if enabled || s.mfa.Required(user) {
+			challenge, err := s.mfa.IssueChallenge(user.ID)
+			if err != nil {
+				return nil, err
+			}
+			return &AuthResult{
+				User:                  user,
+				MFAToken:              challenge,
+				MFAExpiresIn:          s.mfa.ChallengeTTL(),
+				MFAEnrollmentRequired: !enabled,
+			}, nil
+		}
+	}
+
+	return s.issueTokens(user, newSession(client, s.now()))
+}
+
+// challengedUser loads the user an MFA challenge token was issued for.
+func (s *AuthService) challengedUser(challenge string) (*models.User, error) {
+	if s.mfa == nil {
+		return nil, ErrInvalidMFAChallenge
+	}
+	userID, err := s.mfa.ParseChallenge(challenge)
 	if err != nil {
 		return nil, err
 	}
+	user, err := s.users.FindByID(userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidMFAChallenge
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+// issueTokens persists session as a new refresh token and signs an access token bound to it.
+// Signing in during the grace period of a requested deletion cancels the deletion: requesting it signed
+// every session out, so a new sign-in means the owner is back.
+func (s *AuthService) issueTokens(user *models.User, session *models.RefreshToken) (*AuthResult, error) {
+	if user.DeletionScheduledAt != nil {
+		if err := s.users.ScheduleDeletion(user.ID, nil); err != nil {
+			return nil, err
+		}
+		slog.Info("account deletion cancelled by sign-in", slog.String("user_id", user.ID.String()))
+		user.DeletionScheduledAt = nil
+	}
 
-	refreshToken, err := s.createRefreshToken(user.ID)
+	refreshToken, err := s.createRefreshToken(user.ID, session)
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := s.tokens.Generate(user, session.ID.String())
 	if err != nil {
 		return nil, err
 	}
@@ -423,31 +611,26 @@ func (s *AuthService) issueTokens(user *models.User) (*AuthResult, error) {
 	return &AuthResult{AccessToken: accessToken, RefreshToken: refreshToken, User: user}, nil
 }
 
-func (s *AuthService) createRefreshToken(userID uuid.UUID) (string, error) {
-	tokenID := uuid.New()
+func (s *AuthService) createRefreshToken(userID uuid.UUID, session *models.RefreshToken) (string, error) {
 	secret, err := randomSecret()
 	if err != nil {
 		return "", err
 	}
 
-	secretHash, err := utils.HashPassword(secret)
-	if err != nil {
-		return "", err
-	}
-
-	refresh := &models.RefreshToken{
-		ID:         tokenID,
-		UserID:     userID,
-		SecretHash: secretHash,
-		ExpiresAt:  time.Now().Add(s.refreshTTL),
-		Revoked:    false,
+	session.ID = uuid.New()
+	if session.FamilyID == uuid.Nil {
+		session.FamilyID = session.ID
 	}
+	session.UserID = userID
+	session.SecretHash = s.hashRefreshSecret(secret)
+	session.ExpiresAt = s.now().Add(s.refreshTTL)
+	session.Revoked = false
 
-	if err := s.refreshTokens.Create(refresh); err != nil {
+	if err := s.refreshTokens.Create(session); err != nil {
 		return "", err
 	}
 
-	return fmt.Sprintf("%s.%s", tokenID.String(), secret), nil
+	return fmt.Sprintf("%s.%s", session.ID.String(), secret), nil
 }
 
 func randomSecret() (string, error) {
@@ -478,6 +661,46 @@ const smsCodePurposeLogin = "login"
 var numericCodePattern = regexp.MustCompile(`^\d{6}$`)
 var chinaPhonePattern = regexp.MustCompile(`^1[3-9]\d{9}$`)
 
+// consumeSMSCode checks code against the latest login code sent to phone and marks it used.
+// A code is discarded after too many wrong guesses. It returns the normalized phone number.
+func consumeSMSCode(ctx context.Context, codes *repository.SMSCodeRepository, limiter *ratelimit.Limiter, limits CodeRateLimits, phone, code string) (string, error) {
+	normalized, err := normalizeChinaPhone(phone)
+	if err != nil {
+		return "", err
+	}
+
+	code = strings.TrimSpace(code)
+	if !numericCodePattern.MatchString(code) {
+		return "", common.ErrInvalidSMSCode
+	}
+
+	record, err := codes.FindLatestActive(normalized, smsCodePurposeLogin, time.Now())
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", common.ErrInvalidSMSCode
+		}
+		return "", err
+	}
+
+	if err := utils.CheckPassword(record.CodeHash, code); err != nil {
+		if recordCodeFailure(ctx, limiter, limits, "sms", normalized, time.Until(record.ExpiresAt)) {
+			if err := codes.MarkUsed(record.ID); err != nil {
+				return "", err
+			}
+			return "", common.ErrTooManyVerificationAttempts
+		}
+		return "", common.ErrInvalidSMSCode
+	}
+
+	if err := codes.MarkUsed(record.ID); err != nil {
+		return "", err
+	}
+	resetCodeFailures(ctx, limiter, "sms", normalized)
+	_ = codes.DeleteExpired(time.Now())
+
+	return normalized, nil
+}
+
 func normalizeChinaPhone(phone string) (string, error) {
 	phone = strings.TrimSpace(phone)
 	phone = strings.ReplaceAll(phone, " ", "")
@@ -514,7 +737,44 @@ func generateSMSNumericCode(digits int) (string, error) {
 	return fmt.Sprintf("%0*d", digits, n.Int64()), nil
 }
 
-func (s *AuthService) hashRandomPassword() (string, error) {
+// refreshSecretHashPrefix marks refresh secrets stored as HMAC-SHA256. Tokens issued before it existed
+// carry bcrypt hashes, which are still accepted until the tokens rotate or expire.
+const refreshSecretHashPrefix = "hmac-sha256:"
+
+// hashRefreshSecret keys a hash of a refresh token secret. The secrets are random, so a fast HMAC is enough
+// and refreshing does not pay the cost of a password hash.
+func (s *AuthService) hashRefreshSecret(secret string) string {
+	mac := hmac.New(sha256.New, s.refreshKey)
+	mac.Write([]byte(secret))
+	return refreshSecretHashPrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *AuthService) checkRefreshSecret(stored, secret string) bool {
+	if !strings.HasPrefix(stored, refreshSecretHashPrefix) {
+		return utils.CheckPassword(stored, secret) == nil
+	}
+	return hmac.Equal([]byte(stored), []byte(s.hashRefreshSecret(secret)))
+}
+
+// upgradePasswordHash replaces a verified password hash that is weaker than the current policy. Failures are
+// only logged since the login itself succeeded.
+func (s *AuthService) upgradePasswordHash(user *models.User, password string) {
+	if !utils.PasswordNeedsRehash(user.PasswordHash) {
+		return
+	}
+	hashed, err := utils.HashPassword(password)
+	if err == nil {
+		err = s.users.UpdatePassword(user.ID, hashed)
+	}
+	if err != nil {
+		slog.Warn("upgrade password hash failed", slog.String("user_id", user.ID.String()), slog.Any("error", err))
+		return
+	}
+	user.PasswordHash = hashed
+}
+
+// randomPasswordHash returns the hash of an unguessable password for accounts that do not sign in with one.
+func randomPasswordHash() (string, error) {
 	secret, err := randomSecret()
 	if err != nil {
 		return "", err
@@ -522,9 +782,54 @@ func (s *AuthService) hashRandomPassword() (string, error) {
 	return utils.HashPassword(secret)
 }
 
+// virtualEmailDomain is the reserved domain of placeholder emails for accounts without a real address.
+const virtualEmailDomain = "local.invalid"
+
 func virtualEmail(kind, raw string) string {
 	sum := sha1.Sum([]byte(kind + ":" + raw))
-	return fmt.Sprintf("%s_%s@local.invalid", kind, hex.EncodeToString(sum[:12]))
+	return fmt.Sprintf("%s_%s@%s", kind, hex.EncodeToString(sum[:12]), virtualEmailDomain)
+}
+
+// createUser inserts user, reporting ErrDisplayNameTaken when another account took its name concurrently.
+func (s *AuthService) createUser(user *models.User) error {
+	if err := s.users.Create(user); err != nil {
+		if errors.Is(err, repository.ErrDisplayNameTaken) {
+			return ErrDisplayNameTaken
+		}
+		return err
+	}
+	return nil
+}
+
+// newAccountDisplayName picks the display name of an account created by a QQ, WeChat or SMS sign-in. It
+// tries preferred, then prefix followed by suffix, skipping names that are invalid or already used, and
+// falls back to prefix followed by random digits.
+func (s *AuthService) newAccountDisplayName(preferred, prefix, suffix string) (string, error) {
+	const randomAttempts = 5
+	candidates := []string{strings.TrimSpace(preferred), prefix + suffix}
+	for attempt := 0; attempt < len(candidates)+randomAttempts; attempt++ {
+		name := prefix
+		if attempt < len(candidates) {
+			name = candidates[attempt]
+		} else {
+			digits, err := generateSMSNumericCode(6)
+			if err != nil {
+				return "", err
+			}
+			name += digits
+		}
+		if validateDisplayName(name) != nil {
+			continue
+		}
+		taken, err := s.users.DisplayNameTaken(name, uuid.Nil)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return name, nil
+		}
+	}
+	return "", ErrDisplayNameTaken
 }
 
 func shortSuffix(value string) string {
@@ -0,0 +1,395 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hdu-dp/backend/internal/auth"
+	"github.com/hdu-dp/backend/internal/common"
+	"github.com/hdu-dp/backend/internal/models"
+	"github.com/hdu-dp/backend/internal/ratelimit"
+	"github.com/hdu-dp/backend/internal/repository"
+	"github.com/hdu-dp/backend/internal/utils"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+func newTestAuthService(db *gorm.DB, accountMail *AccountMailer, options AuthServiceOptions) *AuthService {
+	return NewAuthService(
+		repository.NewUserRepository(db),
+		auth.NewJWTManager("secret", time.Minute),
+		repository.NewRefreshTokenRepository(db),
+		nil,
+		nil,
+		ratelimit.New(ratelimit.NewMemoryStore()),
+		nil,
+		nil,
+		nil,
+		accountMail,
+		nil,
+		options,
+	)
+}
+
+func TestLoginDelaysThenLocksAndNotifies(t *testing.T) {
+	smtpServer := newFakeSMTPServer(t)
+	db := newServiceTestDB(t, &models.User{}, &models.EmailOutbox{}, &models.RefreshToken{})
+
+	users := repository.NewUserRepository(db)
+	hash, err := utils.HashPassword("correct-horse")
+	if err != nil {
+		t.Fatalf("hash password failed: %v", err)
+	}
+	user := &models.User{Email: "alice@example.com", PasswordHash: hash, DisplayName: "Alice", Locale: "en"}
+	if err := users.Create(user); err != nil {
+		t.Fatalf("create user failed: %v", err)
+	}
+
+	outbox := NewEmailOutboxService(repository.NewEmailOutboxRepository(db), NewEmailService(smtpServer.emailConfig()), EmailOutboxOptions{})
+	svc := newTestAuthService(db, NewAccountMailer(outbox, newTestEmailTemplates(t)), AuthServiceOptions{
+		RefreshTTL: time.Hour,
+		Login:      LoginProtection{MaxFailures: 4, DelayAfter: 2, BaseDelay: 10 * time.Second, Lockout: 30 * time.Minute},
+	})
+	now := time.Now()
+	svc.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if _, err := svc.Login(t.Context(), user.Email, "wrong", ClientInfo{IP: "10.0.0.1"}); !errors.Is(err, common.ErrInvalidCredentials) {
+			t.Fatalf("attempt %d: expected invalid credentials, got %v", i+1, err)
+		}
+	}
+
+	var limited *ratelimit.LimitedError
+	if _, err := svc.Login(t.Context(), user.Email, "correct-horse", ClientInfo{IP: "10.0.0.1"}); !errors.As(err, &limited) || limited.RetryAfter != 10*time.Second {
+		t.Fatalf("expected a 10s delay after two failures, got %v", err)
+	}
+
+	now = now.Add(10 * time.Second)
+	if _, err := svc.Login(t.Context(), user.Email, "wrong", ClientInfo{IP: "10.0.0.1"}); !errors.Is(err, common.ErrInvalidCredentials) {
+		t.Fatalf("expected invalid credentials once the delay passed, got %v", err)
+	}
+	now = now.Add(20 * time.Second)
+	_, err = svc.Login(t.Context(), user.Email, "wrong", ClientInfo{IP: "10.0.0.1"})
+	var locked *AccountLockedError
+	if !errors.As(err, &locked) || !errors.Is(err, common.ErrAccountLocked) || locked.RetryAfterSeconds() != 1800 {
+		t.Fatalf("expected the fourth failure to lock the account, got %v", err)
+	}
+
+	var queued int64
+	if err := db.Model(&models.EmailOutbox{}).Where("recipient = ?", user.Email).Count(&queued).Error; err != nil {
+		t.Fatalf("count queued emails failed: %v", err)
+	}
+	if queued != 1 {
+		t.Fatalf("expected one lockout notification, got %d", queued)
+	}
+
+	now = now.Add(time.Minute)
+	if _, err := svc.Login(t.Context(), user.Email, "correct-horse", ClientInfo{IP: "10.0.0.1"}); !errors.Is(err, common.ErrAccountLocked) {
+		t.Fatalf("expected the correct password to be refused while locked, got %v", err)
+	}
+
+	if err := users.ClearLoginFailures(user.ID); err != nil {
+		t.Fatalf("clear login failures failed: %v", err)
+	}
+	if _, err := svc.Login(t.Context(), user.Email, "correct-horse", ClientInfo{IP: "10.0.0.1"}); err != nil {
+		t.Fatalf("expected login to succeed after unlock, got %v", err)
+	}
+}
+
+func TestLoginFailuresCountFromStoredRow(t *testing.T) {
+	db := newServiceTestDB(t, &models.User{})
+	users := repository.NewUserRepository(db)
+	user := &models.User{Email: "bob@example.com", PasswordHash: "x", DisplayName: "Bob"}
+	if err := users.Create(user); err != nil {
+		t.Fatalf("create user failed: %v", err)
+	}
+	svc := newTestAuthService(db, nil, AuthServiceOptions{Login: LoginProtection{MaxFailures: 3, Window: time.Minute}})
+	now := time.Now()
+
+	// Concurrent logins all load the user before any of them records a failure; every failure must still count.
+	stale := *user
+	for i := 0; i < 2; i++ {
+		if err := svc.guard.recordFailure(t.Context(), &stale, now); !errors.Is(err, common.ErrInvalidCredentials) {
+			t.Fatalf("failure %d: expected invalid credentials, got %v", i+1, err)
+		}
+	}
+	if err := svc.guard.recordFailure(t.Context(), &stale, now); !errors.Is(err, common.ErrAccountLocked) {
+		t.Fatalf("expected the third failure to lock the account, got %v", err)
+	}
+	stored, err := users.FindByID(user.ID)
+	if err != nil {
+		t.Fatalf("find user failed: %v", err)
+	}
+	if stored.LockedUntil == nil || stored.FailedLoginAttempts != 0 {
+		t.Fatalf("expected a lockout with the count reset, got %+v", stored)
+	}
+
+	// Failures outside the window start a new count.
+	if _, err := users.RecordLoginFailure(user.ID, now.Add(2*time.Minute), time.Minute, 3, now.Add(time.Hour)); err != nil {
+		t.Fatalf("record failure failed: %v", err)
+	}
+	failure, err := users.RecordLoginFailure(user.ID, now.Add(4*time.Minute), time.Minute, 3, now.Add(time.Hour))
+	if err != nil || failure.Attempts != 1 || failure.Locked {
+		t.Fatalf("expected an expired count to restart at 1, got %+v (%v)", failure, err)
+	}
+}
+
+func TestLoginBlocksIPAfterTooManyFailures(t *testing.T) {
+	db := newServiceTestDB(t, &models.User{})
+	svc := newTestAuthService(db, nil, AuthServiceOptions{Login: LoginProtection{PerIP: 3}})
+
+	for i := 0; i < 3; i++ {
+		if _, err := svc.Login(t.Context(), "nobody@example.com", "guess", ClientInfo{IP: "10.0.0.2"}); !errors.Is(err, common.ErrInvalidCredentials) {
+			t.Fatalf("attempt %d: expected invalid credentials, got %v", i+1, err)
+		}
+	}
+
+	var limited *ratelimit.LimitedError
+	if _, err := svc.Login(t.Context(), "other@example.com", "guess", ClientInfo{IP: "10.0.0.2"}); !errors.As(err, &limited) {
+		t.Fatalf("expected the ip to be blocked, got %v", err)
+	}
+	if _, err := svc.Login(t.Context(), "other@example.com", "guess", ClientInfo{IP: "10.0.0.3"}); !errors.Is(err, common.ErrInvalidCredentials) {
+		t.Fatalf("expected other ips to be unaffected, got %v", err)
+	}
+}
+
+func TestLoginCancelsPendingDeletion(t *testing.T) {
+	db := newServiceTestDB(t, &models.User{}, &models.RefreshToken{})
+	users := repository.NewUserRepository(db)
+	hash, err := utils.HashPassword("correct-horse")
+	if err != nil {
+		t.Fatalf("hash password failed: %v", err)
+	}
+	user := &models.User{Email: "dana@example.com", PasswordHash: hash, DisplayName: "Dana"}
+	if err := users.Create(user); err != nil {
+		t.Fatalf("create user failed: %v", err)
+	}
+	purgeAt := time.Now().Add(24 * time.Hour)
+	if err := users.ScheduleDeletion(user.ID, &purgeAt); err != nil {
+		t.Fatalf("schedule deletion failed: %v", err)
+	}
+
+	svc := newTestAuthService(db, nil, AuthServiceOptions{RefreshTTL: time.Hour})
+	result, err := svc.Login(t.Context(), "dana@example.com", "correct-horse", ClientInfo{IP: "10.0.0.3"})
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	if result.User.DeletionScheduledAt != nil {
+		t.Fatalf("expected the returned user to have no pending deletion, got %v", result.User.DeletionScheduledAt)
+	}
+	stored, err := users.FindByID(user.ID)
+	if err != nil || stored.DeletionScheduledAt != nil {
+		t.Fatalf("expected signing in to cancel the deletion, got %+v (%v)", stored, err)
+	}
+}
+
+func TestNewAccountsNeedAUniqueDisplayName(t *testing.T) {
+	db := newServiceTestDB(t, &models.User{}, &models.RefreshToken{})
+	users := repository.NewUserRepository(db)
+	svc := newTestAuthService(db, nil, AuthServiceOptions{RefreshTTL: time.Hour})
+
+	if _, err := svc.Register("erin@example.com", "password", "Erin", "", ClientInfo{}); err != nil {
+		t.Fatalf("register failed: %v", err)
+	}
+	if _, err := svc.Register("erin2@example.com", "password", " ERIN ", "", ClientInfo{}); !errors.Is(err, ErrDisplayNameTaken) {
+		t.Fatalf("expected a taken display name to be refused, got %v", err)
+	}
+	if _, err := svc.Register("erin3@example.com", "password", "E\u202erin", "", ClientInfo{}); !errors.Is(err, ErrInvalidDisplayName) {
+		t.Fatalf("expected an invalid display name to be refused, got %v", err)
+	}
+
+	// A name taken between the check and the insert is caught by the unique index.
+	if err := svc.createUser(&models.User{Email: "race@example.com", PasswordHash: "x", DisplayName: "eRiN"}); !errors.Is(err, ErrDisplayNameTaken) {
+		t.Fatalf("expected the unique index to refuse the name, got %v", err)
+	}
+	for i := range 2 {
+		deleted := &models.User{Email: virtualEmail("deleted", strings.Repeat("x", i+1)), PasswordHash: "x", DisplayName: deletedDisplayName}
+		if err := users.Create(deleted); err != nil {
+			t.Fatalf("expected anonymised accounts to share a name, got %v", err)
+		}
+	}
+
+	generated, err := svc.newAccountDisplayName("erin", "QQ用户", "1234")
+	if err != nil || generated != "QQ用户1234" {
+		t.Fatalf("expected a taken nickname to fall back to the generated name, got %q (%v)", generated, err)
+	}
+	if err := users.Create(&models.User{Email: "qq@example.com", PasswordHash: "x", DisplayName: generated}); err != nil {
+		t.Fatalf("create user failed: %v", err)
+	}
+	random, err := svc.newAccountDisplayName("", "QQ用户", "1234")
+	if err != nil || random == generated || !strings.HasPrefix(random, "QQ用户") {
+		t.Fatalf("expected a random name once the generated one is taken, got %q (%v)", random, err)
+	}
+}
+
+func TestSessionsSurviveRotationAndCanBeRevoked(t *testing.T) {
+	db := newServiceTestDB(t, &models.User{}, &models.RefreshToken{})
+	svc := newTestAuthService(db, nil, AuthServiceOptions{RefreshTTL: time.Hour})
+	sessions := NewSessionService(repository.NewRefreshTokenRepository(db))
+
+	phone, err := svc.Register("carol@example.com", "password", "Carol", "", ClientInfo{
+		IP:        "10.0.0.1",
+		UserAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 Version/17.0 Mobile/15E148 Safari/604.1",
+	})
+	if err != nil {
+		t.Fatalf("register failed: %v", err)
+	}
+	if stored, err := repository.NewUserRepository(db).FindByEmail("carol@example.com"); err != nil || stored.Locale != "" {
+		t.Fatalf("expected no locale to be stored so emails use the configured default, got %+v (%v)", stored, err)
+	}
+	laptop, err := svc.Login(t.Context(), "carol@example.com", "password", ClientInfo{IP: "10.0.0.2", DeviceName: "Work laptop"})
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+
+	rotated, err := svc.Refresh(t.Context(), phone.RefreshToken, ClientInfo{IP: "10.0.0.3"})
+	if err != nil {
+		t.Fatalf("refresh failed: %v", err)
+	}
+
+	current, _, err := parseRefreshToken(laptop.RefreshToken)
+	if err != nil {
+		t.Fatalf("parse refresh token failed: %v", err)
+	}
+	list, err := sessions.List(phone.User.ID, current)
+	if err != nil {
+		t.Fatalf("list sessions failed: %v", err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("expected two active sessions, got %+v", list)
+	}
+
+	labels := map[string]Session{}
+	for _, session := range list {
+		labels[session.DeviceLabel] = session
+	}
+	if got, ok := labels["Safari on iPhone"]; !ok || got.IP != "10.0.0.3" || got.Current {
+		t.Fatalf("expected rotated phone session to keep its label and record the new ip, got %+v", list)
+	}
+	if got, ok := labels["Work laptop"]; !ok || !got.Current {
+		t.Fatalf("expected the laptop session to be current, got %+v", list)
+	}
+
+	revoked, err := sessions.RevokeOthers(phone.User.ID, current)
+	if err != nil || revoked != 1 {
+		t.Fatalf("expected one other session to be revoked, got %d (%v)", revoked, err)
+	}
+	if _, err := svc.Refresh(t.Context(), rotated.RefreshToken, ClientInfo{}); !errors.Is(err, common.ErrInvalidRefreshToken) {
+		t.Fatalf("expected revoked session to stop refreshing, got %v", err)
+	}
+	if err := sessions.Revoke(phone.User.ID, current); err != nil {
+		t.Fatalf("revoke current session failed: %v", err)
+	}
+	if err := sessions.Revoke(phone.User.ID, current); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatalf("expected revoking twice to report not found, got %v", err)
+	}
+}
+
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	smtpServer := newFakeSMTPServer(t)
+	db := newServiceTestDB(t, &models.User{}, &models.EmailOutbox{}, &models.RefreshToken{})
+	outbox := NewEmailOutboxService(repository.NewEmailOutboxRepository(db), NewEmailService(smtpServer.emailConfig()), EmailOutboxOptions{})
+	svc := newTestAuthService(db, NewAccountMailer(outbox, newTestEmailTemplates(t)), AuthServiceOptions{RefreshTTL: time.Hour})
+	now := time.Now()
+	svc.now = func() time.Time { return now }
+
+	first, err := svc.Register("dave@example.com", "password", "Dave", "en", ClientInfo{DeviceName: "Phone"})
+	if err != nil {
+		t.Fatalf("register failed: %v", err)
+	}
+	other, err := svc.Login(t.Context(), "dave@example.com", "password", ClientInfo{DeviceName: "Laptop"})
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+
+	second, err := svc.Refresh(t.Context(), first.RefreshToken, ClientInfo{})
+	if err != nil {
+		t.Fatalf("refresh failed: %v", err)
+	}
+
+	now = now.Add(5 * time.Second)
+	if _, err := svc.Refresh(t.Context(), first.RefreshToken, ClientInfo{}); !errors.Is(err, common.ErrInvalidRefreshToken) {
+		t.Fatalf("expected rotated token to be rejected, got %v", err)
+	}
+
+	now = now.Add(time.Minute)
+	third, err := svc.Refresh(t.Context(), second.RefreshToken, ClientInfo{})
+	if err != nil {
+		t.Fatalf("expected the current token to keep working after a retry within the grace period, got %v", err)
+	}
+
+	if _, err := svc.Refresh(t.Context(), first.RefreshToken, ClientInfo{IP: "203.0.113.9"}); !errors.Is(err, common.ErrInvalidRefreshToken) {
+		t.Fatalf("expected reused token to be rejected, got %v", err)
+	}
+	if _, err := svc.Refresh(t.Context(), third.RefreshToken, ClientInfo{}); !errors.Is(err, common.ErrInvalidRefreshToken) {
+		t.Fatalf("expected the latest token of the family to be revoked, got %v", err)
+	}
+	if _, err := svc.Refresh(t.Context(), other.RefreshToken, ClientInfo{}); err != nil {
+		t.Fatalf("expected other sessions to be unaffected, got %v", err)
+	}
+
+	var queued []models.EmailOutbox
+	if err := db.Find(&queued).Error; err != nil {
+		t.Fatalf("list outbox failed: %v", err)
+	}
+	if len(queued) != 1 || !strings.Contains(queued[0].TextBody, "203.0.113.9") || !strings.Contains(queued[0].TextBody, "Phone") {
+		t.Fatalf("expected one alert naming the session and ip, got %+v", queued)
+	}
+}
+
+func TestLoginUpgradesBcryptHashAndRefreshUsesHMAC(t *testing.T) {
+	db := newServiceTestDB(t, &models.User{}, &models.RefreshToken{})
+
+	users := repository.NewUserRepository(db)
+	legacy, err := bcrypt.GenerateFromPassword([]byte("correct-horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("hash password failed: %v", err)
+	}
+	user := &models.User{Email: "legacy@example.com", PasswordHash: string(legacy), DisplayName: "Legacy"}
+	if err := users.Create(user); err != nil {
+		t.Fatalf("create user failed: %v", err)
+	}
+
+	svc := newTestAuthService(db, nil, AuthServiceOptions{RefreshTTL: time.Hour, RefreshSecretKey: "refresh-key"})
+	result, err := svc.Login(t.Context(), user.Email, "correct-horse", ClientInfo{})
+	if err != nil {
+		t.Fatalf("login with a bcrypt hash failed: %v", err)
+	}
+
+	upgraded, err := users.FindByID(user.ID)
+	if err != nil {
+		t.Fatalf("load user failed: %v", err)
+	}
+	if !strings.HasPrefix(upgraded.PasswordHash, "$argon2id$") || utils.PasswordNeedsRehash(upgraded.PasswordHash) {
+		t.Fatalf("expected the hash to be upgraded to argon2id, got %q", upgraded.PasswordHash)
+	}
+	if _, err := svc.Login(t.Context(), user.Email, "correct-horse", ClientInfo{}); err != nil {
+		t.Fatalf("login with the upgraded hash failed: %v", err)
+	}
+
+	var stored models.RefreshToken
+	if err := db.First(&stored, "id = ?", strings.SplitN(result.RefreshToken, ".", 2)[0]).Error; err != nil {
+		t.Fatalf("load refresh token failed: %v", err)
+	}
+	if !strings.HasPrefix(stored.SecretHash, refreshSecretHashPrefix) {
+		t.Fatalf("expected an hmac refresh secret, got %q", stored.SecretHash)
+	}
+
+	secret, err := randomSecret()
+	if err != nil {
+		t.Fatalf("generate secret failed: %v", err)
+	}
+	legacySecret, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("hash secret failed: %v", err)
+	}
+	old := &models.RefreshToken{UserID: user.ID, SecretHash: string(legacySecret), ExpiresAt: time.Now().Add(time.Hour)}
+	if err := db.Create(old).Error; err != nil {
+		t.Fatalf("create legacy refresh token failed: %v", err)
+	}
+	if _, err := svc.Refresh(t.Context(), old.ID.String()+"."+secret, ClientInfo{}); err != nil {
+		t.Fatalf("expected a bcrypt refresh secret to keep working, got %v", err)
+	}
+}
@@ -0,0 +1,19 @@
+package services
+
+import "strings"
+
+// isCampusEmail reports whether email is on one of the campus domains or a subdomain of one,
+// e.g. "hdu.edu.cn" matches "alice@hdu.edu.cn" and "bob@stu.hdu.edu.cn".
+func isCampusEmail(email string, domains []string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	host := strings.ToLower(strings.TrimSpace(email[at+1:]))
+	for _, domain := range domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
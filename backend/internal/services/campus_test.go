@@ -0,0 +1,67 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hdu-dp/backend/internal/models"
+	"github.com/hdu-dp/backend/internal/repository"
+)
+
+func TestCampusVerification(t *testing.T) {
+	domains := []string{"hdu.edu.cn"}
+	for email, want := range map[string]bool{
+		"alice@hdu.edu.cn":     true,
+		"bob@STU.hdu.edu.cn":   true,
+		"eve@nothdu.edu.cn":    false,
+		"mallory@hdu.edu.cn.x": false,
+	} {
+		if got := isCampusEmail(email, domains); got != want {
+			t.Fatalf("isCampusEmail(%q) = %v, want %v", email, got, want)
+		}
+	}
+
+	db := newServiceTestDB(t, &models.User{}, &models.Review{}, &models.ReviewImage{})
+	users := repository.NewUserRepository(db)
+	student := &models.User{Email: "alice@hdu.edu.cn", PasswordHash: "x", DisplayName: "Alice", EmailVerified: true}
+	unverified := &models.User{Email: "bob@hdu.edu.cn", PasswordHash: "x", DisplayName: "Bob"}
+	outsider := &models.User{Email: "carol@example.com", PasswordHash: "x", DisplayName: "Carol", EmailVerified: true, CampusVerified: true}
+	for _, u := range []*models.User{student, unverified, outsider} {
+		if err := users.Create(u); err != nil {
+			t.Fatalf("create user failed: %v", err)
+		}
+	}
+
+	changed, err := users.SyncCampusVerified(domains)
+	if err != nil || changed != 2 {
+		t.Fatalf("expected two users to change, got %d (%v)", changed, err)
+	}
+	for _, u := range []*models.User{student, unverified, outsider} {
+		reloaded, err := users.FindByID(u.ID)
+		if err != nil {
+			t.Fatalf("reload user failed: %v", err)
+		}
+		if want := u == student; reloaded.CampusVerified != want {
+			t.Fatalf("unexpected campus flag for %s: %v", u.Email, reloaded.CampusVerified)
+		}
+		*u = *reloaded
+	}
+
+	reviews := NewReviewService(repository.NewReviewRepository(db), nil, nil, ReviewOptions{RequireCampusVerified: true})
+	input := CreateReviewInput{Title: "食堂", Address: "一餐", Rating: 4}
+	if _, err := reviews.Submit(outsider, input); !errors.Is(err, ErrCampusVerificationRequired) {
+		t.Fatalf("expected authors without a campus email to be refused, got %v", err)
+	}
+	review, err := reviews.Submit(student, input)
+	if err != nil {
+		t.Fatalf("submit review failed: %v", err)
+	}
+	if err := reviews.Approve(review); err != nil {
+		t.Fatalf("approve review failed: %v", err)
+	}
+
+	result, err := reviews.ListPublic(ListFilters{CampusVerifiedOnly: true})
+	if err != nil || result.Pagination.Total != 1 || !result.Data[0].Author.CampusVerified {
+		t.Fatalf("expected the campus review with its badge, got %+v (%v)", result, err)
+	}
+}
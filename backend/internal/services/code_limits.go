@@ -0,0 +1,66 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/hdu-dp/backend/internal/ratelimit"
+)
+
+const (
+	defaultCodeCooldown    = time.Minute
+	defaultCodePerTarget   = 5
+	defaultCodeWindow      = time.Hour
+	defaultMaxCodeAttempts = 5
+)
+
+// CodeRateLimits controls how often a one-time code may be requested for one phone or email
+// and how many wrong guesses a single code tolerates.
+type CodeRateLimits struct {
+	Cooldown    time.Duration
+	PerTarget   int
+	Window      time.Duration
+	MaxAttempts int
+}
+
+func (l CodeRateLimits) withDefaults() CodeRateLimits {
+	if l.Cooldown <= 0 {
+		l.Cooldown = defaultCodeCooldown
+	}
+	if l.PerTarget <= 0 {
+		l.PerTarget = defaultCodePerTarget
+	}
+	if l.Window <= 0 {
+		l.Window = defaultCodeWindow
+	}
+	if l.MaxAttempts <= 0 {
+		l.MaxAttempts = defaultMaxCodeAttempts
+	}
+	return l
+}
+
+// allowCodeRequest enforces the resend cooldown and the sliding-window quota for target.
+func allowCodeRequest(ctx context.Context, limiter *ratelimit.Limiter, limits CodeRateLimits, kind, target string) error {
+	return limiter.Allow(ctx, target,
+		ratelimit.Rule{Name: kind + "_cooldown", Limit: 1, Window: limits.Cooldown},
+		ratelimit.Rule{Name: kind + "_quota", Limit: limits.PerTarget, Window: limits.Window},
+	)
+}
+
+// recordCodeFailure counts a wrong guess and reports whether the code must now be discarded.
+func recordCodeFailure(ctx context.Context, limiter *ratelimit.Limiter, limits CodeRateLimits, kind, target string, ttl time.Duration) bool {
+	failures, err := limiter.RecordFailure(ctx, kind+"_attempts:"+target, ttl)
+	if err != nil {
+		slog.Warn("record verification failure failed", slog.String("kind", kind), slog.Any("error", err))
+		return false
+	}
+	return failures >= limits.MaxAttempts
+}
+
+// resetCodeFailures clears the wrong-guess counter when a new code is issued or one is accepted.
+func resetCodeFailures(ctx context.Context, limiter *ratelimit.Limiter, kind, target string) {
+	if err := limiter.Reset(ctx, kind+"_attempts:"+target); err != nil {
+		slog.Warn("reset verification failures failed", slog.String("kind", kind), slog.Any("error", err))
+	}
+}
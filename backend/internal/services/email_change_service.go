@@ -0,0 +1,271 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hdu-dp/backend/internal/models"
+	"github.com/hdu-dp/backend/internal/passwordpolicy"
+	"github.com/hdu-dp/backend/internal/ratelimit"
+	"github.com/hdu-dp/backend/internal/repository"
+	"github.com/hdu-dp/backend/internal/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	emailChangeTTL     = 24 * time.Hour
+	emailChangeUndoTTL = 7 * 24 * time.Hour
+)
+
+var (
+	// ErrInvalidEmailChangeToken indicates the confirm or undo token is unknown, expired or already used.
+	ErrInvalidEmailChangeToken = errors.New("invalid or expired email change token")
+	// ErrSameEmail indicates the requested address is already the account's email.
+	ErrSameEmail = errors.New("new email must differ from the current one")
+)
+
+// PendingEmailChange describes a change waiting for the new address to be confirmed.
+type PendingEmailChange struct {
+	NewEmail  string    `json:"new_email"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// EmailChangeService moves accounts to a new email address. The new address must be confirmed through an
+// emailed link, after which the previous address is notified and can revert the change for a while.
+type EmailChangeService struct {
+	users         *repository.UserRepository
+	changes       *repository.EmailChangeRepository
+	refreshTokens *repository.RefreshTokenRepository
+	apiTokens     *repository.APITokenRepository
+	accountMail   *AccountMailer
+	guard         *PasswordGuard
+	limiter       *ratelimit.Limiter
+	limits        CodeRateLimits
+	policy        *passwordpolicy.Policy
+	baseURL       string
+	campusDomains []string
+	now           func() time.Time
+}
+
+// NewEmailChangeService constructs an EmailChangeService. baseURL is the frontend origin that serves the
+// /confirm-email-change and /undo-email-change pages linked from the emails.
+func NewEmailChangeService(
+	users *repository.UserRepository,
+	changes *repository.EmailChangeRepository,
+	refreshTokens *repository.RefreshTokenRepository,
+	apiTokens *repository.APITokenRepository,
+	accountMail *AccountMailer,
+	guard *PasswordGuard,
+	limiter *ratelimit.Limiter,
+	limits CodeRateLimits,
+	policy *passwordpolicy.Policy,
+	baseURL string,
+	campusDomains []string,
+) *EmailChangeService {
+	baseURL = strings.TrimRight(baseURL, "/")
+	if baseURL == "" {
+		baseURL = "http://localhost:5174"
+	}
+	return &EmailChangeService{
+		users:         users,
+		changes:       changes,
+		refreshTokens: refreshTokens,
+		apiTokens:     apiTokens,
+		accountMail:   accountMail,
+		guard:         guard,
+		limiter:       limiter,
+		limits:        limits.withDefaults(),
+		policy:        policy,
+		baseURL:       baseURL,
+		campusDomains: campusDomains,
+		now:           time.Now,
+	}
+}
+
+// Start emails a confirmation link to newEmail. Accounts with a real email must prove it is them with their
+// current password, and wrong guesses count toward the login lockout; accounts that only had a placeholder
+// email choose their password here instead, and it takes effect together with the new address.
+func (s *EmailChangeService) Start(ctx context.Context, userID uuid.UUID, newEmail, password string) (*PendingEmailChange, error) {
+	if !s.accountMail.IsConfigured() {
+		return nil, ErrEmailServiceNotConfigured
+	}
+
+	user, err := s.users.FindByID(userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	newEmail = strings.TrimSpace(strings.ToLower(newEmail))
+	if newEmail == strings.ToLower(user.Email) {
+		return nil, ErrSameEmail
+	}
+
+	var passwordHash string
+	if isVirtualEmail(user.Email) {
+		if err := s.policy.Check(password, newEmail, user.DisplayName); err != nil {
+			return nil, err
+		}
+		if passwordHash, err = utils.HashPassword(password); err != nil {
+			return nil, err
+		}
+	} else if err := s.guard.Verify(ctx, user, password); err != nil {
+		return nil, err
+	}
+
+	if err := allowCodeRequest(ctx, s.limiter, s.limits, "email_change", user.ID.String()); err != nil {
+		return nil, err
+	}
+	if err := s.ensureAvailable(user.ID, newEmail); err != nil {
+		return nil, err
+	}
+
+	now := s.now()
+	_ = s.changes.DeleteExpired(ctx, now)
+
+	token, hash, err := newEmailChangeToken()
+	if err != nil {
+		return nil, err
+	}
+	change := &models.EmailChange{
+		UserID:       user.ID,
+		OldEmail:     user.Email,
+		NewEmail:     newEmail,
+		TokenHash:    hash,
+		PasswordHash: passwordHash,
+		ExpiresAt:    now.Add(emailChangeTTL),
+	}
+	if err := s.changes.Replace(ctx, change); err != nil {
+		return nil, fmt.Errorf("store email change: %w", err)
+	}
+
+	confirmURL := fmt.Sprintf("%s/confirm-email-change?token=%s", s.baseURL, token)
+	s.accountMail.SendEmailChangeConfirmation(ctx, user, newEmail, confirmURL, emailChangeTTL)
+	return &PendingEmailChange{NewEmail: newEmail, ExpiresAt: change.ExpiresAt}, nil
+}
+
+// Confirm switches the account to the new address of the change behind token. The email, its verified state
+// and the campus flag are updated in one transaction, then the previous address receives an undo link.
+func (s *EmailChangeService) Confirm(ctx context.Context, token string) (*models.User, error) {
+	change, err := s.findChange(ctx, token, s.changes.FindByHash)
+	if err != nil {
+		return nil, err
+	}
+	if change.ConfirmedAt != nil {
+		return nil, ErrInvalidEmailChangeToken
+	}
+	if err := s.ensureAvailable(change.UserID, change.NewEmail); err != nil {
+		return nil, err
+	}
+
+	undoToken, undoHash, err := newEmailChangeToken()
+	if err != nil {
+		return nil, err
+	}
+	now := s.now()
+	fields := s.emailFields(change.NewEmail, now)
+	if change.PasswordHash != "" {
+		fields["password_hash"] = change.PasswordHash
+	}
+
+	confirmed, err := s.changes.Confirm(ctx, change, now, undoHash, now.Add(emailChangeUndoTTL), fields)
+	if err != nil {
+		return nil, err
+	}
+	if !confirmed {
+		return nil, ErrInvalidEmailChangeToken
+	}
+
+	user, err := s.users.FindByID(change.UserID)
+	if err != nil {
+		return nil, err
+	}
+	undoURL := fmt.Sprintf("%s/undo-email-change?token=%s", s.baseURL, undoToken)
+	s.accountMail.SendEmailChanged(ctx, user, change.OldEmail, change.NewEmail, undoURL, emailChangeUndoTTL)
+	return user, nil
+}
+
+// Undo restores the address a change replaced, signs the account out everywhere and revokes its personal
+// access tokens, in case the change was made by someone who took over the account.
+func (s *EmailChangeService) Undo(ctx context.Context, token string) error {
+	change, err := s.findChange(ctx, token, s.changes.FindByUndoHash)
+	if err != nil {
+		return err
+	}
+	if change.UndoneAt != nil {
+		return ErrInvalidEmailChangeToken
+	}
+	if err := s.ensureAvailable(change.UserID, change.OldEmail); err != nil {
+		return err
+	}
+
+	now := s.now()
+	undone, err := s.changes.Undo(ctx, change, now, s.emailFields(change.OldEmail, now))
+	if err != nil {
+		return err
+	}
+	if !undone {
+		return ErrInvalidEmailChangeToken
+	}
+
+	if err := s.refreshTokens.RevokeAllForUser(change.UserID); err != nil {
+		slog.Warn("revoke sessions after email change undo failed", slog.String("user_id", change.UserID.String()), slog.Any("error", err))
+	}
+	if err := s.apiTokens.RevokeAllForUser(ctx, change.UserID, now); err != nil {
+		slog.Warn("revoke api tokens after email change undo failed", slog.String("user_id", change.UserID.String()), slog.Any("error", err))
+	}
+	return nil
+}
+
+func (s *EmailChangeService) findChange(ctx context.Context, token string, find func(context.Context, string) (*models.EmailChange, error)) (*models.EmailChange, error) {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return nil, ErrInvalidEmailChangeToken
+	}
+	change, err := find(ctx, hashResetToken(token))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidEmailChangeToken
+		}
+		return nil, err
+	}
+	return change, nil
+}
+
+// ensureAvailable fails with ErrEmailAlreadyUsed when email belongs to an account other than userID.
+func (s *EmailChangeService) ensureAvailable(userID uuid.UUID, email string) error {
+	err := ensureUnclaimed(userID, func() (*models.User, error) { return s.users.FindByEmail(email) })
+	if errors.Is(err, ErrIdentityInUse) {
+		return ErrEmailAlreadyUsed
+	}
+	return err
+}
+
+// emailFields sets email as the account's verified address. Opening a link sent to it proves it is reachable.
+func (s *EmailChangeService) emailFields(email string, now time.Time) map[string]any {
+	return map[string]any{
+		"email":             email,
+		"email_verified":    true,
+		"email_verified_at": now,
+		"campus_verified":   isCampusEmail(email, s.campusDomains),
+	}
+}
+
+// newEmailChangeToken returns a random URL-safe token and the hash stored in its place.
+func newEmailChangeToken() (string, string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", fmt.Errorf("generate email change token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	return token, hashResetToken(token), nil
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/hdu-dp/backend/internal/common"
+	"github.com/hdu-dp/backend/internal/models"
+	"github.com/hdu-dp/backend/internal/repository"
+	"github.com/hdu-dp/backend/internal/utils"
+)
+
+func TestEmailChangeConfirmAndUndo(t *testing.T) {
+	smtpServer := newFakeSMTPServer(t)
+	db := newServiceTestDB(t, &models.User{}, &models.EmailOutbox{}, &models.RefreshToken{}, &models.EmailChange{}, &models.APIToken{})
+
+	users := repository.NewUserRepository(db)
+	refreshTokens := repository.NewRefreshTokenRepository(db)
+	apiTokens := repository.NewAPITokenRepository(db)
+	hash, err := utils.HashPassword("secret-password")
+	if err != nil {
+		t.Fatalf("hash password failed: %v", err)
+	}
+	user := &models.User{Email: "bob@example.com", PasswordHash: hash, DisplayName: "Bob", Locale: "en", EmailVerified: true}
+	other := &models.User{Email: "taken@example.com", PasswordHash: hash, DisplayName: "Carol"}
+	for _, u := range []*models.User{user, other} {
+		if err := users.Create(u); err != nil {
+			t.Fatalf("create user failed: %v", err)
+		}
+	}
+	if err := apiTokens.Create(t.Context(), &models.APIToken{UserID: user.ID, Name: "script", TokenHash: "hash", Prefix: "pat", Scopes: "reviews:read"}); err != nil {
+		t.Fatalf("create api token failed: %v", err)
+	}
+	if err := refreshTokens.Create(&models.RefreshToken{UserID: user.ID, SecretHash: "x", ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatalf("create refresh token failed: %v", err)
+	}
+
+	outbox := NewEmailOutboxService(repository.NewEmailOutboxRepository(db), NewEmailService(smtpServer.emailConfig()), EmailOutboxOptions{})
+	accountMail := NewAccountMailer(outbox, newTestEmailTemplates(t))
+	svc := NewEmailChangeService(
+		users,
+		repository.NewEmailChangeRepository(db),
+		refreshTokens,
+		apiTokens,
+		accountMail,
+		NewPasswordGuard(users, accountMail, LoginProtection{}),
+		nil,
+		CodeRateLimits{},
+		nil,
+		"https://app.example.com",
+		[]string{"hdu.edu.cn"},
+	)
+
+	if _, err := svc.Start(t.Context(), user.ID, "bob@hdu.edu.cn", "wrong-password"); !errors.Is(err, common.ErrInvalidCredentials) {
+		t.Fatalf("expected the current password to be required, got %v", err)
+	}
+	if guessed, err := users.FindByID(user.ID); err != nil || guessed.FailedLoginAttempts != 1 {
+		t.Fatalf("expected the wrong password to count toward the lockout, got %+v (%v)", guessed, err)
+	}
+	if _, err := svc.Start(t.Context(), user.ID, "Taken@example.com", "secret-password"); !errors.Is(err, ErrEmailAlreadyUsed) {
+		t.Fatalf("expected an address of another account to be refused, got %v", err)
+	}
+	if _, err := svc.Start(t.Context(), user.ID, "Bob@HDU.edu.cn", "secret-password"); err != nil {
+		t.Fatalf("start email change failed: %v", err)
+	}
+
+	unchanged, err := users.FindByID(user.ID)
+	if err != nil || unchanged.Email != "bob@example.com" {
+		t.Fatalf("expected the email to stay until confirmed, got %+v (%v)", unchanged, err)
+	}
+
+	link := func(recipient, path string) string {
+		t.Helper()
+		var queued []models.EmailOutbox
+		if err := db.Where("recipient = ?", recipient).Order("created_at desc").Find(&queued).Error; err != nil {
+			t.Fatalf("list outbox failed: %v", err)
+		}
+		if len(queued) == 0 {
+			t.Fatalf("no email queued for %s", recipient)
+		}
+		match := regexp.MustCompile(`https://app\.example\.com/` + path + `\?token=([A-Za-z0-9_-]+)`).FindStringSubmatch(queued[0].TextBody)
+		if match == nil {
+			t.Fatalf("%s link not found in %q", path, queued[0].TextBody)
+		}
+		return match[1]
+	}
+
+	confirmToken := link("bob@hdu.edu.cn", "confirm-email-change")
+	changed, err := svc.Confirm(t.Context(), confirmToken)
+	if err != nil {
+		t.Fatalf("confirm email change failed: %v", err)
+	}
+	if changed.Email != "bob@hdu.edu.cn" || !changed.EmailVerified || changed.EmailVerifiedAt == nil || !changed.CampusVerified {
+		t.Fatalf("expected the new campus address to be verified, got %+v", changed)
+	}
+	if _, err := svc.Confirm(t.Context(), confirmToken); !errors.Is(err, ErrInvalidEmailChangeToken) {
+		t.Fatalf("expected the confirm link to be single-use, got %v", err)
+	}
+
+	if err := svc.Undo(t.Context(), link("bob@example.com", "undo-email-change")); err != nil {
+		t.Fatalf("undo email change failed: %v", err)
+	}
+	restored, err := users.FindByID(user.ID)
+	if err != nil {
+		t.Fatalf("reload user failed: %v", err)
+	}
+	if restored.Email != "bob@example.com" || restored.CampusVerified {
+		t.Fatalf("expected the old address to be restored, got %+v", restored)
+	}
+
+	var active int64
+	if err := db.Model(&models.RefreshToken{}).Where("user_id = ? AND revoked = ?", user.ID, false).Count(&active).Error; err != nil {
+		t.Fatalf("count refresh tokens failed: %v", err)
+	}
+	if active != 0 {
+		t.Fatalf("expected undo to sign the account out everywhere, %d sessions active", active)
+	}
+
+	var activeTokens int64
+	if err := db.Model(&models.APIToken{}).Where("user_id = ? AND revoked_at IS NULL", user.ID).Count(&activeTokens).Error; err != nil {
+		t.Fatalf("count api tokens failed: %v", err)
+	}
+	if activeTokens != 0 {
+		t.Fatalf("expected undo to revoke personal access tokens, %d active", activeTokens)
+	}
+}
@@ -0,0 +1,284 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hdu-dp/backend/internal/emailtemplate"
+	"github.com/hdu-dp/backend/internal/models"
+	"github.com/hdu-dp/backend/internal/repository"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrOutboxMessageNotFound indicates the queued email cannot be found.
+	ErrOutboxMessageNotFound = errors.New("outbox message not found")
+	// ErrOutboxMessageNotRetryable indicates only dead-lettered messages can be retried.
+	ErrOutboxMessageNotRetryable = errors.New("outbox message is not in dead-letter state")
+)
+
+const (
+	defaultOutboxMaxAttempts   = 6
+	defaultOutboxPollInterval  = 5 * time.Second
+	defaultOutboxBaseBackoff   = 30 * time.Second
+	defaultOutboxMaxBackoff    = time.Hour
+	defaultOutboxBatchSize     = 20
+	defaultOutboxSentRetention = 7 * 24 * time.Hour
+	outboxCleanupInterval      = time.Hour
+)
+
+// EmailOutboxOptions groups tuning options for the outbox worker.
+type EmailOutboxOptions struct {
+	MaxAttempts   int
+	PollInterval  time.Duration
+	BaseBackoff   time.Duration
+	MaxBackoff    time.Duration
+	BatchSize     int
+	SentRetention time.Duration
+}
+
+// EmailOutboxService persists outbound emails and delivers them from a background worker.
+type EmailOutboxService struct {
+	outbox        *repository.EmailOutboxRepository
+	sender        *EmailService
+	maxAttempts   int
+	pollInterval  time.Duration
+	baseBackoff   time.Duration
+	maxBackoff    time.Duration
+	batchSize     int
+	sentRetention time.Duration
+	wake          chan struct{}
+	now           func() time.Time
+}
+
+// NewEmailOutboxService constructs an outbox service.
+func NewEmailOutboxService(outbox *repository.EmailOutboxRepository, sender *EmailService, options EmailOutboxOptions) *EmailOutboxService {
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = defaultOutboxMaxAttempts
+	}
+	if options.PollInterval <= 0 {
+		options.PollInterval = defaultOutboxPollInterval
+	}
+	if options.BaseBackoff <= 0 {
+		options.BaseBackoff = defaultOutboxBaseBackoff
+	}
+	if options.MaxBackoff <= 0 {
+		options.MaxBackoff = defaultOutboxMaxBackoff
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = defaultOutboxBatchSize
+	}
+	if options.SentRetention <= 0 {
+		options.SentRetention = defaultOutboxSentRetention
+	}
+
+	return &EmailOutboxService{
+		outbox:        outbox,
+		sender:        sender,
+		maxAttempts:   options.MaxAttempts,
+		pollInterval:  options.PollInterval,
+		baseBackoff:   options.BaseBackoff,
+		maxBackoff:    options.MaxBackoff,
+		batchSize:     options.BatchSize,
+		sentRetention: options.SentRetention,
+		wake:          make(chan struct{}, 1),
+		now:           time.Now,
+	}
+}
+
+// IsConfigured reports whether queued emails can actually be delivered.
+func (s *EmailOutboxService) IsConfigured() bool {
+	return s != nil && s.sender.IsConfigured()
+}
+
+// Enqueue persists an email for asynchronous delivery and nudges the worker.
+func (s *EmailOutboxService) Enqueue(ctx context.Context, to string, content *emailtemplate.Message) (*models.EmailOutbox, error) {
+	to = strings.TrimSpace(to)
+	if to == "" {
+		return nil, errors.New("recipient is required")
+	}
+	if content == nil {
+		return nil, errors.New("email content is required")
+	}
+
+	message := &models.EmailOutbox{
+		Recipient:     to,
+		Subject:       content.Subject,
+		Body:          content.HTML,
+		TextBody:      content.Text,
+		Status:        models.EmailOutboxStatusPending,
+		MaxAttempts:   s.maxAttempts,
+		NextAttemptAt: s.now(),
+	}
+	if err := s.outbox.Create(ctx, message); err != nil {
+		return nil, fmt.Errorf("enqueue email: %w", err)
+	}
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+
+	return message, nil
+}
+
+// Run processes the outbox until ctx is cancelled.
+func (s *EmailOutboxService) Run(ctx context.Context) {
+	if err := s.outbox.ResetSending(ctx, s.now()); err != nil {
+		slog.Error("reset in-flight outbox emails failed", slog.Any("error", err))
+	}
+
+	ticker := time.NewTicker(s.pollInterval)
+	defer ticker.Stop()
+
+	var lastCleanup time.Time
+	for {
+		if _, err := s.ProcessDue(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("process email outbox failed", slog.Any("error", err))
+		}
+
+		if now := s.now(); now.Sub(lastCleanup) >= outboxCleanupInterval {
+			if err := s.outbox.DeleteSentBefore(ctx, now.Add(-s.sentRetention)); err != nil && ctx.Err() == nil {
+				slog.Warn("clean sent outbox emails failed", slog.Any("error", err))
+			}
+			lastCleanup = now
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+	}
+}
+
+// ProcessDue delivers every message whose next attempt is due and returns how many were attempted.
+func (s *EmailOutboxService) ProcessDue(ctx context.Context) (int, error) {
+	messages, err := s.outbox.ListDue(ctx, s.now(), s.batchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	attempted := 0
+	for i := range messages {
+		if ctx.Err() != nil {
+			return attempted, ctx.Err()
+		}
+
+		claimed, err := s.outbox.Claim(ctx, messages[i].ID)
+		if err != nil {
+			return attempted, err
+		}
+		if !claimed {
+			continue
+		}
+
+		attempted++
+		if err := s.deliver(ctx, &messages[i]); err != nil {
+			return attempted, err
+		}
+	}
+
+	return attempted, nil
+}
+
+func (s *EmailOutboxService) deliver(ctx context.Context, message *models.EmailOutbox) error {
+	attempts := message.Attempts + 1
+
+	sendErr := s.sender.SendEmail(ctx, message.Recipient, &emailtemplate.Message{
+		Subject: message.Subject,
+		HTML:    message.Body,
+		Text:    message.TextBody,
+	})
+	if sendErr == nil {
+		return s.outbox.MarkSent(ctx, message.ID, attempts, s.now())
+	}
+
+	maxAttempts := message.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = s.maxAttempts
+	}
+
+	if attempts >= maxAttempts {
+		slog.Error("email delivery failed permanently",
+			slog.String("outbox_id", message.ID.String()),
+			slog.Int("attempts", attempts),
+			slog.Any("error", sendErr),
+		)
+		return s.outbox.MarkDead(ctx, message.ID, attempts, sendErr.Error())
+	}
+
+	next := s.now().Add(s.backoff(attempts))
+	slog.Warn("email delivery failed, will retry",
+		slog.String("outbox_id", message.ID.String()),
+		slog.Int("attempts", attempts),
+		slog.Time("next_attempt_at", next),
+		slog.Any("error", sendErr),
+	)
+	return s.outbox.MarkRetry(ctx, message.ID, attempts, next, sendErr.Error())
+}
+
+// backoff returns the exponential delay after the given number of failed attempts.
+func (s *EmailOutboxService) backoff(attempts int) time.Duration {
+	return exponentialBackoff(s.baseBackoff, s.maxBackoff, attempts)
+}
+
+// exponentialBackoff returns base*2^(attempts-1), capped at max.
+func exponentialBackoff(base, max time.Duration, attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	delay := base
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= max || delay <= 0 {
+			return max
+		}
+	}
+	if delay > max {
+		return max
+	}
+	return delay
+}
+
+// List returns outbox messages filtered by status for the admin view.
+func (s *EmailOutboxService) List(ctx context.Context, status models.EmailOutboxStatus, page, pageSize int) ([]models.EmailOutbox, int64, error) {
+	if page <= 0 {
+		page = 1
+	}
+	if pageSize <= 0 {
+		pageSize = 20
+	}
+	return s.outbox.ListByStatus(ctx, status, (page-1)*pageSize, pageSize)
+}
+
+// Retry moves a dead-lettered message back into the queue.
+func (s *EmailOutboxService) Retry(ctx context.Context, id uuid.UUID) (*models.EmailOutbox, error) {
+	if _, err := s.outbox.FindByID(ctx, id); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrOutboxMessageNotFound
+		}
+		return nil, err
+	}
+
+	requeued, err := s.outbox.Requeue(ctx, id, s.now())
+	if err != nil {
+		return nil, err
+	}
+	if !requeued {
+		return nil, ErrOutboxMessageNotRetryable
+	}
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+
+	return s.outbox.FindByID(ctx, id)
+}
@@ -0,0 +1,158 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hdu-dp/backend/internal/emailtemplate"
+	"github.com/hdu-dp/backend/internal/models"
+	"github.com/hdu-dp/backend/internal/repository"
+	"gorm.io/gorm"
+)
+
+func newEmailOutboxTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	return newServiceTestDB(t, &models.User{}, &models.EmailVerification{}, &models.EmailOutbox{})
+}
+
+func TestEmailOutboxDeliversQueuedMessage(t *testing.T) {
+	smtpServer := newFakeSMTPServer(t)
+	db := newEmailOutboxTestDB(t)
+	outbox := NewEmailOutboxService(
+		repository.NewEmailOutboxRepository(db),
+		NewEmailService(smtpServer.emailConfig()),
+		EmailOutboxOptions{},
+	)
+
+	message, err := outbox.Enqueue(t.Context(), "student@example.com", &emailtemplate.Message{Subject: "hello", HTML: "<p>body</p>"})
+	if err != nil {
+		t.Fatalf("enqueue failed: %v", err)
+	}
+
+	attempted, err := outbox.ProcessDue(t.Context())
+	if err != nil {
+		t.Fatalf("process outbox failed: %v", err)
+	}
+	if attempted != 1 {
+		t.Fatalf("expected 1 attempted message, got %d", attempted)
+	}
+
+	var stored models.EmailOutbox
+	if err := db.First(&stored, "id = ?", message.ID).Error; err != nil {
+		t.Fatalf("load outbox message failed: %v", err)
+	}
+	if stored.Status != models.EmailOutboxStatusSent || stored.Attempts != 1 || stored.SentAt == nil {
+		t.Fatalf("unexpected outbox state after delivery: %+v", stored)
+	}
+
+	received := smtpServer.received()
+	if len(received) != 1 || !strings.Contains(received[0], "Subject: hello") {
+		t.Fatalf("unexpected messages received by smtp server: %v", received)
+	}
+}
+
+func TestEmailOutboxRetriesWithBackoffThenDeadLetters(t *testing.T) {
+	smtpServer := newFakeSMTPServer(t)
+	smtpServer.setReject(true)
+
+	db := newEmailOutboxTestDB(t)
+	outbox := NewEmailOutboxService(
+		repository.NewEmailOutboxRepository(db),
+		NewEmailService(smtpServer.emailConfig()),
+		EmailOutboxOptions{MaxAttempts: 2, BaseBackoff: time.Minute, MaxBackoff: time.Hour},
+	)
+
+	now := time.Now()
+	outbox.now = func() time.Time { return now }
+
+	message, err := outbox.Enqueue(t.Context(), "student@example.com", &emailtemplate.Message{Subject: "hello", HTML: "<p>body</p>"})
+	if err != nil {
+		t.Fatalf("enqueue failed: %v", err)
+	}
+
+	if _, err := outbox.ProcessDue(t.Context()); err != nil {
+		t.Fatalf("first attempt failed: %v", err)
+	}
+
+	var stored models.EmailOutbox
+	if err := db.First(&stored, "id = ?", message.ID).Error; err != nil {
+		t.Fatalf("load outbox message failed: %v", err)
+	}
+	if stored.Status != models.EmailOutboxStatusPending || stored.Attempts != 1 || stored.LastError == "" {
+		t.Fatalf("expected message to be rescheduled, got %+v", stored)
+	}
+	if got := stored.NextAttemptAt.Sub(now); got != time.Minute {
+		t.Fatalf("expected next attempt one backoff later, got %v", got)
+	}
+
+	// Not due yet: nothing should be attempted.
+	if attempted, err := outbox.ProcessDue(t.Context()); err != nil || attempted != 0 {
+		t.Fatalf("expected no attempt before backoff elapsed, got %d (%v)", attempted, err)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, err := outbox.ProcessDue(t.Context()); err != nil {
+		t.Fatalf("second attempt failed: %v", err)
+	}
+
+	if err := db.First(&stored, "id = ?", message.ID).Error; err != nil {
+		t.Fatalf("load outbox message failed: %v", err)
+	}
+	if stored.Status != models.EmailOutboxStatusDead || stored.Attempts != 2 {
+		t.Fatalf("expected message to be dead-lettered, got %+v", stored)
+	}
+
+	smtpServer.setReject(false)
+	if _, err := outbox.Retry(t.Context(), message.ID); err != nil {
+		t.Fatalf("retry dead message failed: %v", err)
+	}
+	if _, err := outbox.ProcessDue(t.Context()); err != nil {
+		t.Fatalf("redelivery failed: %v", err)
+	}
+	if err := db.First(&stored, "id = ?", message.ID).Error; err != nil {
+		t.Fatalf("load outbox message failed: %v", err)
+	}
+	if stored.Status != models.EmailOutboxStatusSent {
+		t.Fatalf("expected retried message to be sent, got %+v", stored)
+	}
+}
+
+func TestSendRegistrationCodeOnlyEnqueues(t *testing.T) {
+	smtpServer := newFakeSMTPServer(t)
+	db := newEmailOutboxTestDB(t)
+	outbox := NewEmailOutboxService(
+		repository.NewEmailOutboxRepository(db),
+		NewEmailService(smtpServer.emailConfig()),
+		EmailOutboxOptions{},
+	)
+	verification := NewEmailVerificationService(
+		repository.NewEmailVerificationRepository(db),
+		repository.NewUserRepository(db),
+		outbox,
+		newTestEmailTemplates(t),
+		nil,
+		CodeRateLimits{},
+		"http://localhost:5174",
+		nil,
+	)
+
+	if err := verification.SendRegistrationCode(t.Context(), "new@example.com", emailtemplate.LocaleEN); err != nil {
+		t.Fatalf("send registration code failed: %v", err)
+	}
+
+	if received := smtpServer.received(); len(received) != 0 {
+		t.Fatalf("expected no synchronous smtp delivery, got %d messages", len(received))
+	}
+
+	var queued []models.EmailOutbox
+	if err := db.Where("recipient = ?", "new@example.com").Find(&queued).Error; err != nil {
+		t.Fatalf("load outbox failed: %v", err)
+	}
+	if len(queued) != 1 || queued[0].Status != models.EmailOutboxStatusPending {
+		t.Fatalf("expected one pending outbox message, got %+v", queued)
+	}
+	if queued[0].Subject != "Your registration code" || queued[0].TextBody == "" {
+		t.Fatalf("expected english multipart content, got subject %q", queued[0].Subject)
+	}
+}
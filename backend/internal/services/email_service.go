@@ -1,13 +1,26 @@
 package services
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
 	"net/smtp"
+	"net/textproto"
+	"time"
 
 	"github.com/hdu-dp/backend/internal/config"
+	"github.com/hdu-dp/backend/internal/emailtemplate"
 )
 
+const defaultSMTPTimeout = 15 * time.Second
+
 // EmailService handles email sending functionality
 type EmailService struct {
 	config *config.EmailConfig
@@ -18,29 +31,22 @@ func NewEmailService(config *config.EmailConfig) *EmailService {
 	return &EmailService{config: config}
 }
 
-// SendEmail sends an email to the specified recipient
-func (s *EmailService) SendEmail(ctx context.Context, to, subject, body string) error {
+// SendEmail sends a multipart text+HTML email to the specified recipient
+func (s *EmailService) SendEmail(ctx context.Context, to string, message *emailtemplate.Message) error {
 	if !s.config.IsValid() {
 		return fmt.Errorf("email service not properly configured")
 	}
+	if message == nil {
+		return errors.New("email message is required")
+	}
 
-	from := s.config.FromEmail
-	password := s.config.SMTPPassword
-
-	// 构建邮件内容
-	msg := fmt.Sprintf("From: %s <%s>\r\n", s.config.FromName, from)
-	msg += fmt.Sprintf("To: %s\r\n", to)
-	msg += fmt.Sprintf("Subject: %s\r\n", subject)
-	msg += "MIME-Version: 1.0\r\n"
-	msg += "Content-Type: text/html; charset=UTF-8\r\n"
-	msg += "\r\n"
-	msg += body
-
-	// 发送邮件
-	addr := fmt.Sprintf("%s:%d", s.config.SMTPHost, s.config.SMTPPort)
-	auth := smtp.PlainAuth("", s.config.SMTPUsername, password, s.config.SMTPHost)
+	from := mail.Address{Name: s.config.FromName, Address: s.config.FromEmail}
+	msg, err := buildMIMEMessage(from, to, message, time.Now())
+	if err != nil {
+		return fmt.Errorf("build email: %w", err)
+	}
 
-	return smtp.SendMail(addr, auth, from, []string{to}, []byte(msg))
+	return s.deliver(ctx, from.Address, to, msg)
 }
 
 // IsConfigured checks if email service is properly configured
@@ -50,3 +56,119 @@ func (s *EmailService) IsConfigured() bool {
 	}
 	return s.config.IsValid()
 }
+
+// deliver mirrors smtp.SendMail but bounds the whole conversation by a timeout
+// so that a slow server cannot stall the caller indefinitely.
+func (s *EmailService) deliver(ctx context.Context, from, to string, msg []byte) error {
+	timeout := s.config.SMTPTimeout
+	if timeout <= 0 {
+		timeout = defaultSMTPTimeout
+	}
+
+	addr := fmt.Sprintf("%s:%d", s.config.SMTPHost, s.config.SMTPPort)
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return err
+	}
+
+	client, err := smtp.NewClient(conn, s.config.SMTPHost)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: s.config.SMTPHost}); err != nil {
+			return err
+		}
+	}
+
+	if ok, _ := client.Extension("AUTH"); !ok {
+		return errors.New("smtp: server doesn't support AUTH")
+	}
+	auth := smtp.PlainAuth("", s.config.SMTPUsername, s.config.SMTPPassword, s.config.SMTPHost)
+	if err := client.Auth(auth); err != nil {
+		return err
+	}
+
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to); err != nil {
+		return err
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+// buildMIMEMessage assembles a multipart/alternative message with RFC 2047 encoded headers.
+func buildMIMEMessage(from mail.Address, to string, message *emailtemplate.Message, now time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	parts := multipart.NewWriter(&buf)
+
+	// 构建邮件头，非 ASCII 的主题与发件人名称按 RFC 2047 编码
+	header := fmt.Sprintf("From: %s\r\n", from.String())
+	header += fmt.Sprintf("To: %s\r\n", (&mail.Address{Address: to}).String())
+	header += fmt.Sprintf("Subject: %s\r\n", mime.BEncoding.Encode("UTF-8", message.Subject))
+	header += fmt.Sprintf("Date: %s\r\n", now.Format(time.RFC1123Z))
+	header += "MIME-Version: 1.0\r\n"
+	header += fmt.Sprintf("Content-Type: multipart/alternative; boundary=%q\r\n", parts.Boundary())
+	header += "\r\n"
+
+	var out bytes.Buffer
+	out.WriteString(header)
+
+	if message.Text != "" {
+		if err := writeQuotedPrintablePart(parts, "text/plain; charset=UTF-8", message.Text); err != nil {
+			return nil, err
+		}
+	}
+	if err := writeQuotedPrintablePart(parts, "text/html; charset=UTF-8", message.HTML); err != nil {
+		return nil, err
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+
+	out.Write(buf.Bytes())
+	return out.Bytes(), nil
+}
+
+func writeQuotedPrintablePart(parts *multipart.Writer, contentType, body string) error {
+	partHeader := textproto.MIMEHeader{}
+	partHeader.Set("Content-Type", contentType)
+	partHeader.Set("Content-Transfer-Encoding", "quoted-printable")
+
+	w, err := parts.CreatePart(partHeader)
+	if err != nil {
+		return err
+	}
+
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(body)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
@@ -0,0 +1,72 @@
+package services
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hdu-dp/backend/internal/emailtemplate"
+)
+
+func TestBuildMIMEMessageEncodesHeadersAndParts(t *testing.T) {
+	raw, err := buildMIMEMessage(
+		mail.Address{Name: "HDU美食点评", Address: "noreply@example.com"},
+		"student@example.com",
+		&emailtemplate.Message{Subject: "您的注册验证码", HTML: "<p>验证码 123456</p>", Text: "验证码 123456"},
+		time.Now(),
+	)
+	if err != nil {
+		t.Fatalf("build message failed: %v", err)
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(string(raw)))
+	if err != nil {
+		t.Fatalf("parse message failed: %v", err)
+	}
+
+	for _, header := range []string{"From", "Subject"} {
+		value := msg.Header.Get(header)
+		if !strings.Contains(strings.ToUpper(value), "=?UTF-8?") {
+			t.Fatalf("expected %s header to be RFC 2047 encoded, got %q", header, value)
+		}
+	}
+
+	decoder := new(mime.WordDecoder)
+	subject, err := decoder.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != "您的注册验证码" {
+		t.Fatalf("unexpected decoded subject %q (%v)", subject, err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("unexpected content type %q (%v)", mediaType, err)
+	}
+
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	var types []string
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("read part failed: %v", err)
+		}
+		body, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatalf("read part body failed: %v", err)
+		}
+		if !strings.Contains(string(body), "123456") {
+			t.Fatalf("expected part to contain code, got %q", body)
+		}
+		types = append(types, part.Header.Get("Content-Type"))
+	}
+
+	if len(types) != 2 || !strings.HasPrefix(types[0], "text/plain") || !strings.HasPrefix(types[1], "text/html") {
+		t.Fatalf("unexpected parts: %v", types)
+	}
+}
@@ -11,7 +11,10 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/hdu-dp/backend/internal/common"
+	"github.com/hdu-dp/backend/internal/emailtemplate"
 	"github.com/hdu-dp/backend/internal/models"
+	"github.com/hdu-dp/backend/internal/ratelimit"
 	"github.com/hdu-dp/backend/internal/repository"
 	"gorm.io/gorm"
 )
@@ -35,18 +38,31 @@ var (
 
 // EmailVerificationService handles email verification business logic.
 type EmailVerificationService struct {
-	verificationRepo   *repository.EmailVerificationRepository
-	userRepo           *repository.UserRepository
-	emailService       *EmailService
+	verificationRepo    *repository.EmailVerificationRepository
+	userRepo            *repository.UserRepository
+	mailer              *EmailOutboxService
+	templates           *emailtemplate.Renderer
+	limiter             *ratelimit.Limiter
+	limits              CodeRateLimits
 	verificationBaseURL string
+	campusDomains       []string
 }
 
+const (
+	verificationLinkTTL = 24 * time.Hour
+	registrationCodeTTL = 10 * time.Minute
+)
+
 // NewEmailVerificationService creates a new EmailVerificationService.
 func NewEmailVerificationService(
 	emailVerificationRepo *repository.EmailVerificationRepository,
 	userRepo *repository.UserRepository,
-	emailService *EmailService,
+	mailer *EmailOutboxService,
+	templates *emailtemplate.Renderer,
+	limiter *ratelimit.Limiter,
+	limits CodeRateLimits,
 	verificationBaseURL string,
+	campusDomains []string,
 ) *EmailVerificationService {
 	baseURL := strings.TrimRight(verificationBaseURL, "/")
 	if baseURL == "" {
@@ -54,10 +70,14 @@ func NewEmailVerificationService(
 	}
 
 	return &EmailVerificationService{
-		verificationRepo:   emailVerificationRepo,
-		userRepo:           userRepo,
-		emailService:       emailService,
+		verificationRepo:    emailVerificationRepo,
+		userRepo:            userRepo,
+		mailer:              mailer,
+		templates:           templates,
+		limiter:             limiter,
+		limits:              limits.withDefaults(),
 		verificationBaseURL: baseURL,
+		campusDomains:       campusDomains,
 	}
 }
 
@@ -89,9 +109,9 @@ func generateNumericCode(digits int) (string, error) {
 	return fmt.Sprintf(format, n.Int64()), nil
 }
 
-// SendVerificationEmail sends a verification email to the user.
-func (s *EmailVerificationService) SendVerificationEmail(ctx context.Context, userID uuid.UUID, email string) error {
-	if s.emailService == nil || !s.emailService.IsConfigured() {
+// SendVerificationEmail queues a verification email to the user in the given locale.
+func (s *EmailVerificationService) SendVerificationEmail(ctx context.Context, userID uuid.UUID, email, locale string) error {
+	if !s.mailer.IsConfigured() {
 		return ErrEmailServiceNotConfigured
 	}
 
@@ -105,28 +125,23 @@ func (s *EmailVerificationService) SendVerificationEmail(ctx context.Context, us
 		UserID:    &userIDCopy,
 		Email:     email,
 		Token:     token,
-		ExpiresAt: time.Now().Add(24 * time.Hour),
+		ExpiresAt: time.Now().Add(verificationLinkTTL),
 	}
 
 	if err := s.verificationRepo.Create(ctx, verification); err != nil {
 		return fmt.Errorf("create verification record: %w", err)
 	}
 
-	verificationURL := fmt.Sprintf("%s/verify-email?token=%s", s.verificationBaseURL, token)
-	subject := "请验证您的邮箱地址"
-	body := fmt.Sprintf(`
-		<h1>邮箱验证</h1>
-		<p>您好！感谢您注册我们的服务。</p>
-		<p>请点击下面的链接验证您的邮箱地址：</p>
-		<p><a href="%s" style="background-color: #2563eb; color: white; padding: 12px 24px; text-decoration: none; border-radius: 4px;">验证邮箱</a></p>
-		<p>如果链接无法点击，请复制以下地址到浏览器：</p>
-		<p>%s</p>
-		<p>此链接将在24小时后过期。</p>
-		<p>如果您没有注册我们的服务，请忽略此邮件。</p>
-	`, verificationURL, verificationURL)
+	message, err := s.templates.Render(emailtemplate.Verification, locale, map[string]any{
+		"VerificationURL": fmt.Sprintf("%s/verify-email?token=%s", s.verificationBaseURL, token),
+		"ExpiresInHours":  int(verificationLinkTTL / time.Hour),
+	})
+	if err != nil {
+		return fmt.Errorf("render verification email: %w", err)
+	}
 
-	if err := s.emailService.SendEmail(ctx, email, subject, body); err != nil {
-		return fmt.Errorf("send verification email: %w", err)
+	if _, err := s.mailer.Enqueue(ctx, email, message); err != nil {
+		return fmt.Errorf("queue verification email: %w", err)
 	}
 
 	return nil
@@ -161,6 +176,7 @@ func (s *EmailVerificationService) VerifyEmail(ctx context.Context, token string
 	now := time.Now()
 	user.EmailVerified = true
 	user.EmailVerifiedAt = &now
+	user.CampusVerified = isCampusEmail(user.Email, s.campusDomains)
 
 	if err := s.userRepo.Save(user); err != nil {
 		return fmt.Errorf("update user verification status: %w", err)
@@ -173,9 +189,9 @@ func (s *EmailVerificationService) VerifyEmail(ctx context.Context, token string
 	return nil
 }
 
-// SendRegistrationCode sends a verification code to an email before registration.
-func (s *EmailVerificationService) SendRegistrationCode(ctx context.Context, email string) error {
-	if s.emailService == nil || !s.emailService.IsConfigured() {
+// SendRegistrationCode queues a verification code to an email before registration.
+func (s *EmailVerificationService) SendRegistrationCode(ctx context.Context, email, locale string) error {
+	if !s.mailer.IsConfigured() {
 		return ErrEmailServiceNotConfigured
 	}
 
@@ -184,6 +200,10 @@ func (s *EmailVerificationService) SendRegistrationCode(ctx context.Context, ema
 		return fmt.Errorf("email is required")
 	}
 
+	if err := allowCodeRequest(ctx, s.limiter, s.limits, "email_code", email); err != nil {
+		return err
+	}
+
 	if _, err := s.userRepo.FindByEmail(email); err == nil {
 		return ErrEmailAlreadyUsed
 	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
@@ -202,31 +222,31 @@ func (s *EmailVerificationService) SendRegistrationCode(ctx context.Context, ema
 	verification := &models.EmailVerification{
 		Email:     email,
 		Token:     code,
-		ExpiresAt: time.Now().Add(10 * time.Minute),
+		ExpiresAt: time.Now().Add(registrationCodeTTL),
 	}
 
 	if err := s.verificationRepo.Create(ctx, verification); err != nil {
 		return fmt.Errorf("create verification record: %w", err)
 	}
+	resetCodeFailures(ctx, s.limiter, "email_code", email)
 
-	subject := "您的注册验证码"
-	body := fmt.Sprintf(`
-		<h1>注册验证码</h1>
-		<p>您好！您正在注册我们的服务。</p>
-		<p>请使用以下验证码完成注册：</p>
-		<p style="font-size: 24px; font-weight: bold;">%s</p>
-		<p>验证码有效期为10分钟。请勿泄露给他人。</p>
-		<p>如果您未发起此请求，请忽略本邮件。</p>
-	`, code)
+	message, err := s.templates.Render(emailtemplate.RegistrationCode, locale, map[string]any{
+		"Code":             code,
+		"ExpiresInMinutes": int(registrationCodeTTL / time.Minute),
+	})
+	if err != nil {
+		return fmt.Errorf("render registration code email: %w", err)
+	}
 
-	if err := s.emailService.SendEmail(ctx, email, subject, body); err != nil {
-		return fmt.Errorf("send verification email: %w", err)
+	if _, err := s.mailer.Enqueue(ctx, email, message); err != nil {
+		return fmt.Errorf("queue verification email: %w", err)
 	}
 
 	return nil
 }
 
 // ValidateRegistrationCode validates a registration verification code without consuming it.
+// After too many wrong guesses the outstanding code is discarded and a new one must be requested.
 func (s *EmailVerificationService) ValidateRegistrationCode(ctx context.Context, email, code string) (*models.EmailVerification, error) {
 	email = strings.TrimSpace(strings.ToLower(email))
 	code = strings.TrimSpace(code)
@@ -238,6 +258,12 @@ func (s *EmailVerificationService) ValidateRegistrationCode(ctx context.Context,
 	verification, err := s.verificationRepo.GetByEmailAndToken(ctx, email, code)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
+			if recordCodeFailure(ctx, s.limiter, s.limits, "email_code", email, registrationCodeTTL) {
+				if err := s.verificationRepo.DeleteUnclaimedByEmail(ctx, email); err != nil {
+					return nil, fmt.Errorf("discard verification code: %w", err)
+				}
+				return nil, common.ErrTooManyVerificationAttempts
+			}
 			return nil, ErrInvalidVerificationToken
 		}
 		return nil, fmt.Errorf("get verification token: %w", err)
@@ -251,6 +277,7 @@ func (s *EmailVerificationService) ValidateRegistrationCode(ctx context.Context,
 		return nil, ErrInvalidVerificationToken
 	}
 
+	resetCodeFailures(ctx, s.limiter, "email_code", email)
 	return verification, nil
 }
 
@@ -272,6 +299,7 @@ func (s *EmailVerificationService) CompleteRegistrationVerification(ctx context.
 
 	user.EmailVerified = true
 	user.EmailVerifiedAt = &now
+	user.CampusVerified = isCampusEmail(user.Email, s.campusDomains)
 
 	if err := s.userRepo.Save(user); err != nil {
 		return nil, fmt.Errorf("update user verification status: %w", err)
@@ -302,11 +330,15 @@ func (s *EmailVerificationService) ResendVerificationEmail(ctx context.Context,
 		return ErrEmailAlreadyVerified
 	}
 
+	if err := allowCodeRequest(ctx, s.limiter, s.limits, "verify_email", user.ID.String()); err != nil {
+		return err
+	}
+
 	if err := s.verificationRepo.DeleteByUserID(ctx, userID); err != nil {
 		return fmt.Errorf("delete existing tokens: %w", err)
 	}
 
-	return s.SendVerificationEmail(ctx, user.ID, user.Email)
+	return s.SendVerificationEmail(ctx, user.ID, user.Email, user.Locale)
 }
 
 // GetVerificationStatus returns the email verification status for the user.
@@ -0,0 +1,57 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hdu-dp/backend/internal/common"
+	"github.com/hdu-dp/backend/internal/models"
+	"github.com/hdu-dp/backend/internal/ratelimit"
+	"github.com/hdu-dp/backend/internal/repository"
+)
+
+func TestRegistrationCodeCooldownAndAttemptCap(t *testing.T) {
+	smtpServer := newFakeSMTPServer(t)
+	db := newServiceTestDB(t, &models.User{}, &models.EmailVerification{}, &models.EmailOutbox{})
+	outbox := NewEmailOutboxService(
+		repository.NewEmailOutboxRepository(db),
+		NewEmailService(smtpServer.emailConfig()),
+		EmailOutboxOptions{},
+	)
+	verification := NewEmailVerificationService(
+		repository.NewEmailVerificationRepository(db),
+		repository.NewUserRepository(db),
+		outbox,
+		newTestEmailTemplates(t),
+		ratelimit.New(ratelimit.NewMemoryStore()),
+		CodeRateLimits{Cooldown: time.Minute, MaxAttempts: 2},
+		"http://localhost:5174",
+		nil,
+	)
+
+	if err := verification.SendRegistrationCode(t.Context(), "new@example.com", ""); err != nil {
+		t.Fatalf("send registration code failed: %v", err)
+	}
+
+	err := verification.SendRegistrationCode(t.Context(), "new@example.com", "")
+	var limited *ratelimit.LimitedError
+	if !errors.As(err, &limited) || limited.RetryAfterSeconds() <= 0 {
+		t.Fatalf("expected resend within cooldown to be limited, got %v", err)
+	}
+
+	if _, err := verification.ValidateRegistrationCode(t.Context(), "new@example.com", "000000x"); !errors.Is(err, ErrInvalidVerificationToken) {
+		t.Fatalf("expected first wrong guess to be rejected, got %v", err)
+	}
+	if _, err := verification.ValidateRegistrationCode(t.Context(), "new@example.com", "000000y"); !errors.Is(err, common.ErrTooManyVerificationAttempts) {
+		t.Fatalf("expected attempt cap to trigger, got %v", err)
+	}
+
+	var remaining int64
+	if err := db.Model(&models.EmailVerification{}).Where("email = ?", "new@example.com").Count(&remaining).Error; err != nil {
+		t.Fatalf("count verification codes failed: %v", err)
+	}
+	if remaining != 0 {
+		t.Fatalf("expected outstanding code to be discarded, %d left", remaining)
+	}
+}
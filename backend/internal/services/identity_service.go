@@ -0,0 +1,354 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/hdu-dp/backend/internal/common"
+	"github.com/hdu-dp/backend/internal/models"
+	"github.com/hdu-dp/backend/internal/passwordpolicy"
+	"github.com/hdu-dp/backend/internal/ratelimit"
+	"github.com/hdu-dp/backend/internal/repository"
+	"github.com/hdu-dp/backend/internal/utils"
+	"gorm.io/gorm"
+)
+
+// Login identity kinds that can be bound to and unbound from an account.
+const (
+	IdentityEmail  = "email"
+	IdentityPhone  = "phone"
+	IdentityQQ     = "qq"
+	IdentityWeChat = "wechat"
+)
+
+var (
+	// ErrIdentityInUse indicates the identity already signs in to another account; an admin can merge the two.
+	ErrIdentityInUse = errors.New("identity bound to another account")
+	// ErrIdentityAlreadyBound indicates the account already has an identity of that kind.
+	ErrIdentityAlreadyBound = errors.New("identity already bound")
+	// ErrIdentityNotBound indicates the account has no identity of that kind.
+	ErrIdentityNotBound = errors.New("identity not bound")
+	// ErrUnknownIdentity indicates an unsupported identity kind.
+	ErrUnknownIdentity = errors.New("unknown identity kind")
+	// ErrLastLoginMethod indicates unbinding would leave the account without any way to sign in.
+	ErrLastLoginMethod = errors.New("cannot remove the last login method")
+	// ErrMergeSameUser indicates an account cannot be merged into itself.
+	ErrMergeSameUser = errors.New("cannot merge an account into itself")
+)
+
+// Identities lists the login methods bound to an account.
+type Identities struct {
+	Email         *string `json:"email"`
+	EmailVerified bool    `json:"email_verified"`
+	Phone         *string `json:"phone"`
+	QQ            bool    `json:"qq"`
+	WeChat        bool    `json:"wechat"`
+}
+
+// IdentityOptions groups options for IdentityService initialization.
+type IdentityOptions struct {
+	SMSEnabled     bool
+	SMSLimits      CodeRateLimits
+	PasswordPolicy *passwordpolicy.Policy
+}
+
+// IdentityService binds additional login methods to an account and merges duplicate accounts.
+type IdentityService struct {
+	users             *repository.UserRepository
+	smsCodes          *repository.SMSCodeRepository
+	limiter           *ratelimit.Limiter
+	qqOAuth           *QQOAuthService
+	wechatOAuth       *WeChatOAuthService
+	emailVerification *EmailVerificationService
+	smsEnabled        bool
+	smsLimits         CodeRateLimits
+	passwordPolicy    *passwordpolicy.Policy
+}
+
+// NewIdentityService constructs an IdentityService.
+func NewIdentityService(
+	users *repository.UserRepository,
+	smsCodes *repository.SMSCodeRepository,
+	limiter *ratelimit.Limiter,
+	qqOAuth *QQOAuthService,
+	wechatOAuth *WeChatOAuthService,
+	emailVerification *EmailVerificationService,
+	options IdentityOptions,
+) *IdentityService {
+	return &IdentityService{
+		users:             users,
+		smsCodes:          smsCodes,
+		limiter:           limiter,
+		qqOAuth:           qqOAuth,
+		wechatOAuth:       wechatOAuth,
+		emailVerification: emailVerification,
+		smsEnabled:        options.SMSEnabled,
+		smsLimits:         options.SMSLimits.withDefaults(),
+		passwordPolicy:    options.PasswordPolicy,
+	}
+}
+
+// List returns the login methods bound to userID.
+func (s *IdentityService) List(userID uuid.UUID) (*Identities, error) {
+	user, err := s.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	return identitiesOf(user), nil
+}
+
+// BindQQ attaches the QQ account behind an OAuth code to userID.
+func (s *IdentityService) BindQQ(userID uuid.UUID, code, state string) (*Identities, error) {
+	if s.qqOAuth == nil || !s.qqOAuth.IsEnabled() {
+		return nil, common.ErrQQServiceUnavailable
+	}
+	user, err := s.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.QQOpenID != nil {
+		return nil, ErrIdentityAlreadyBound
+	}
+
+	profile, err := s.qqOAuth.ExchangeCode(code, state)
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureUnclaimed(user.ID, func() (*models.User, error) { return s.users.FindByQQOpenID(profile.OpenID) }); err != nil {
+		return nil, err
+	}
+
+	return s.bind(user, map[string]any{"qq_open_id": profile.OpenID})
+}
+
+// BindWeChat attaches the WeChat account behind a mini-program login code to userID.
+func (s *IdentityService) BindWeChat(userID uuid.UUID, code string) (*Identities, error) {
+	if s.wechatOAuth == nil || !s.wechatOAuth.IsEnabled() {
+		return nil, common.ErrWeChatServiceUnavailable
+	}
+	user, err := s.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.WeChatOpenID != nil {
+		return nil, ErrIdentityAlreadyBound
+	}
+
+	profile, err := s.wechatOAuth.CodeToSession(code)
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureUnclaimed(user.ID, func() (*models.User, error) { return s.users.FindByWeChatOpenID(profile.OpenID) }); err != nil {
+		return nil, err
+	}
+
+	return s.bind(user, map[string]any{"we_chat_open_id": profile.OpenID})
+}
+
+// BindPhone attaches a phone number to userID after checking a code sent by /auth/sms/send-code.
+func (s *IdentityService) BindPhone(ctx context.Context, userID uuid.UUID, phone, code string) (*Identities, error) {
+	if !s.smsEnabled || s.smsCodes == nil {
+		return nil, common.ErrSMSServiceUnavailable
+	}
+	user, err := s.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.Phone != nil {
+		return nil, ErrIdentityAlreadyBound
+	}
+
+	normalized, err := consumeSMSCode(ctx, s.smsCodes, s.limiter, s.smsLimits, phone, code)
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureUnclaimed(user.ID, func() (*models.User, error) { return s.users.FindByPhone(normalized) }); err != nil {
+		return nil, err
+	}
+
+	return s.bind(user, map[string]any{"phone": normalized})
+}
+
+// BindEmail gives an account that signed up through QQ, WeChat or SMS a real email and password.
+// code is a registration code sent by /auth/send-code, so the address is verified on success.
+func (s *IdentityService) BindEmail(ctx context.Context, userID uuid.UUID, email, code, password string) (*Identities, error) {
+	if s.emailVerification == nil {
+		return nil, ErrEmailServiceNotConfigured
+	}
+	user, err := s.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if !isVirtualEmail(user.Email) {
+		return nil, ErrIdentityAlreadyBound
+	}
+	if err := s.passwordPolicy.Check(password, email, user.DisplayName); err != nil {
+		return nil, err
+	}
+
+	verification, err := s.emailVerification.ValidateRegistrationCode(ctx, email, code)
+	if err != nil {
+		return nil, err
+	}
+	email = strings.TrimSpace(strings.ToLower(email))
+	if err := ensureUnclaimed(user.ID, func() (*models.User, error) { return s.users.FindByEmail(email) }); err != nil {
+		return nil, err
+	}
+
+	hash, err := utils.HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.users.UpdateIdentity(user.ID, map[string]any{"email": email, "password_hash": hash}); err != nil {
+		return nil, err
+	}
+	if _, err := s.emailVerification.CompleteRegistrationVerification(ctx, verification, user.ID); err != nil {
+		return nil, err
+	}
+	return s.List(user.ID)
+}
+
+// Unbind removes the identity of kind from userID. The last remaining login method cannot be removed.
+// Removing the email replaces it with a placeholder and discards the password, since both sign in together.
+func (s *IdentityService) Unbind(userID uuid.UUID, kind string) (*Identities, error) {
+	user, err := s.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	current := identitiesOf(user)
+	var (
+		column string
+		fields map[string]any
+	)
+	switch kind {
+	case IdentityEmail:
+		if current.Email == nil {
+			return nil, ErrIdentityNotBound
+		}
+		hash, err := randomPasswordHash()
+		if err != nil {
+			return nil, err
+		}
+		column = "email"
+		fields = map[string]any{
+			"email":             virtualEmail("user", user.ID.String()),
+			"password_hash":     hash,
+			"email_verified":    false,
+			"email_verified_at": nil,
+		}
+	case IdentityPhone:
+		if current.Phone == nil {
+			return nil, ErrIdentityNotBound
+		}
+		column, fields = "phone", map[string]any{"phone": nil}
+	case IdentityQQ:
+		if !current.QQ {
+			return nil, ErrIdentityNotBound
+		}
+		column, fields = "qq_open_id", map[string]any{"qq_open_id": nil}
+	case IdentityWeChat:
+		if !current.WeChat {
+			return nil, ErrIdentityNotBound
+		}
+		column, fields = "we_chat_open_id", map[string]any{"we_chat_open_id": nil}
+	default:
+		return nil, ErrUnknownIdentity
+	}
+
+	// The remaining login methods are checked by the UPDATE itself; the user loaded above may be out of date
+	// if another unbind runs concurrently.
+	unbound, err := s.users.UnbindIdentity(user.ID, column, fields, "@"+virtualEmailDomain)
+	if err != nil {
+		return nil, err
+	}
+	if !unbound {
+		return nil, ErrLastLoginMethod
+	}
+	return s.List(user.ID)
+}
+
+// Merge folds sourceID into targetID: reviews and reactions move to target, login methods that target
+// lacks are taken over, and source is deleted along with its sessions.
+func (s *IdentityService) Merge(sourceID, targetID uuid.UUID) (*repository.MergeResult, error) {
+	if sourceID == targetID {
+		return nil, ErrMergeSameUser
+	}
+	source, err := s.findUser(sourceID)
+	if err != nil {
+		return nil, err
+	}
+	target, err := s.findUser(targetID)
+	if err != nil {
+		return nil, err
+	}
+
+	identity := map[string]any{}
+	if source.Phone != nil && target.Phone == nil {
+		identity["phone"] = *source.Phone
+	}
+	if source.QQOpenID != nil && target.QQOpenID == nil {
+		identity["qq_open_id"] = *source.QQOpenID
+	}
+	if source.WeChatOpenID != nil && target.WeChatOpenID == nil {
+		identity["we_chat_open_id"] = *source.WeChatOpenID
+	}
+	if !isVirtualEmail(source.Email) && isVirtualEmail(target.Email) {
+		identity["email"] = source.Email
+		identity["password_hash"] = source.PasswordHash
+		identity["email_verified"] = source.EmailVerified
+		identity["email_verified_at"] = source.EmailVerifiedAt
+		identity["campus_verified"] = source.CampusVerified
+	}
+
+	return s.users.MergeInto(source.ID, target.ID, identity)
+}
+
+func (s *IdentityService) bind(user *models.User, fields map[string]any) (*Identities, error) {
+	if err := s.users.UpdateIdentity(user.ID, fields); err != nil {
+		return nil, err
+	}
+	return s.List(user.ID)
+}
+
+func (s *IdentityService) findUser(userID uuid.UUID) (*models.User, error) {
+	user, err := s.users.FindByID(userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+// ensureUnclaimed fails with ErrIdentityInUse when lookup finds an account other than userID.
+func ensureUnclaimed(userID uuid.UUID, lookup func() (*models.User, error)) error {
+	owner, err := lookup()
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	if owner.ID != userID {
+		return ErrIdentityInUse
+	}
+	return nil
+}
+
+func identitiesOf(user *models.User) *Identities {
+	identities := &Identities{
+		Phone:  user.Phone,
+		QQ:     user.QQOpenID != nil,
+		WeChat: user.WeChatOpenID != nil,
+	}
+	if !isVirtualEmail(user.Email) {
+		email := user.Email
+		identities.Email = &email
+		identities.EmailVerified = user.EmailVerified
+	}
+	return identities
+}
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hdu-dp/backend/internal/models"
+	"github.com/hdu-dp/backend/internal/repository"
+	"github.com/hdu-dp/backend/internal/utils"
+)
+
+func TestBindPhoneAndKeepLastLoginMethod(t *testing.T) {
+	db := newServiceTestDB(t, &models.User{}, &models.SMSCode{})
+
+	users := repository.NewUserRepository(db)
+	smsCodes := repository.NewSMSCodeRepository(db)
+	openID := "qq-openid"
+	user := &models.User{Email: virtualEmail("qq", openID), QQOpenID: &openID, PasswordHash: "x", DisplayName: "QQ用户"}
+	other := &models.User{Email: "other@example.com", PasswordHash: "x", DisplayName: "Other"}
+	for _, u := range []*models.User{user, other} {
+		if err := users.Create(u); err != nil {
+			t.Fatalf("create user failed: %v", err)
+		}
+	}
+
+	svc := NewIdentityService(users, smsCodes, nil, nil, nil, nil, IdentityOptions{SMSEnabled: true})
+
+	if _, err := svc.Unbind(user.ID, IdentityQQ); !errors.Is(err, ErrLastLoginMethod) {
+		t.Fatalf("expected the only login method to be kept, got %v", err)
+	}
+
+	issueCode := func(phone string) {
+		hash, err := utils.HashPassword("123456")
+		if err != nil {
+			t.Fatalf("hash code failed: %v", err)
+		}
+		if err := smsCodes.Create(&models.SMSCode{Phone: phone, Purpose: smsCodePurposeLogin, CodeHash: hash, ExpiresAt: time.Now().Add(time.Minute)}); err != nil {
+			t.Fatalf("create sms code failed: %v", err)
+		}
+	}
+
+	phone := "13800000001"
+	other.Phone = &phone
+	if err := users.Save(other); err != nil {
+		t.Fatalf("save other user failed: %v", err)
+	}
+	issueCode(phone)
+	if _, err := svc.BindPhone(t.Context(), user.ID, phone, "123456"); !errors.Is(err, ErrIdentityInUse) {
+		t.Fatalf("expected a phone of another account to be refused, got %v", err)
+	}
+
+	issueCode("13800000002")
+	identities, err := svc.BindPhone(t.Context(), user.ID, "+86 138-0000-0002", "123456")
+	if err != nil {
+		t.Fatalf("bind phone failed: %v", err)
+	}
+	if identities.Phone == nil || *identities.Phone != "13800000002" || !identities.QQ || identities.Email != nil {
+		t.Fatalf("unexpected identities after binding: %+v", identities)
+	}
+
+	if identities, err = svc.Unbind(user.ID, IdentityQQ); err != nil || identities.QQ {
+		t.Fatalf("expected qq to be unbound once a phone is bound, got %+v (%v)", identities, err)
+	}
+	if _, err := svc.Unbind(user.ID, IdentityPhone); !errors.Is(err, ErrLastLoginMethod) {
+		t.Fatalf("expected the phone to be kept as the last login method, got %v", err)
+	}
+
+	// Two concurrent unbinds both load the user while it still has two methods; only the first may succeed.
+	unbound, err := users.UnbindIdentity(other.ID, "phone", map[string]any{"phone": nil}, "@"+virtualEmailDomain)
+	if err != nil || !unbound {
+		t.Fatalf("expected the phone of the two-method account to be unbound, got %v (%v)", unbound, err)
+	}
+	unbound, err = users.UnbindIdentity(other.ID, "email", map[string]any{"email": virtualEmail("user", other.ID.String())}, "@"+virtualEmailDomain)
+	if err != nil || unbound {
+		t.Fatalf("expected the email to be kept once the phone is gone, got %v (%v)", unbound, err)
+	}
+}
+
+func TestMergeMovesContentAndLoginMethods(t *testing.T) {
+	db := newServiceTestDB(t,
+		&models.User{}, &models.Review{}, &models.ReviewStats{}, &models.ReviewReaction{},
+		&models.RefreshToken{}, &models.PasswordResetToken{}, &models.EmailChange{}, &models.EmailVerification{}, &models.UserTOTP{}, &models.MFARecoveryCode{}, &models.APIToken{},
+	)
+
+	users := repository.NewUserRepository(db)
+	openID := "wechat-openid"
+	source := &models.User{Email: virtualEmail("wechat", openID), WeChatOpenID: &openID, PasswordHash: "x", DisplayName: "微信用户"}
+	target := &models.User{Email: "student@example.com", PasswordHash: "y", DisplayName: "Student"}
+	for _, u := range []*models.User{source, target} {
+		if err := users.Create(u); err != nil {
+			t.Fatalf("create user failed: %v", err)
+		}
+	}
+
+	first := &models.Review{Title: "A", Address: "x", Rating: 4, AuthorID: source.ID}
+	second := &models.Review{Title: "B", Address: "y", Rating: 5, AuthorID: target.ID}
+	for _, review := range []*models.Review{first, second} {
+		if err := db.Create(review).Error; err != nil {
+			t.Fatalf("create review failed: %v", err)
+		}
+	}
+	if err := db.Create(&models.ReviewStats{ReviewID: second.ID, Likes: 2}).Error; err != nil {
+		t.Fatalf("create stats failed: %v", err)
+	}
+	for _, reaction := range []*models.ReviewReaction{
+		{ReviewID: second.ID, UserID: source.ID, Type: models.ReactionTypeLike},
+		{ReviewID: second.ID, UserID: target.ID, Type: models.ReactionTypeLike},
+		{ReviewID: first.ID, UserID: source.ID, Type: models.ReactionTypeDislike},
+	} {
+		if err := db.Create(reaction).Error; err != nil {
+			t.Fatalf("create reaction failed: %v", err)
+		}
+	}
+	if err := db.Create(&models.RefreshToken{UserID: source.ID, SecretHash: "x", ExpiresAt: time.Now().Add(time.Hour)}).Error; err != nil {
+		t.Fatalf("create refresh token failed: %v", err)
+	}
+
+	svc := NewIdentityService(users, nil, nil, nil, nil, nil, IdentityOptions{})
+	if _, err := svc.Merge(target.ID, target.ID); !errors.Is(err, ErrMergeSameUser) {
+		t.Fatalf("expected merging into itself to fail, got %v", err)
+	}
+
+	result, err := svc.Merge(source.ID, target.ID)
+	if err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	if result.Reviews != 1 || result.Reactions != 1 || result.DroppedReactions != 1 {
+		t.Fatalf("unexpected merge result: %+v", result)
+	}
+
+	if _, err := users.FindByID(source.ID); err == nil {
+		t.Fatalf("expected the source account to be deleted")
+	}
+	merged, err := users.FindByWeChatOpenID(openID)
+	if err != nil || merged.ID != target.ID || merged.Email != "student@example.com" {
+		t.Fatalf("expected wechat to move to the target account, got %+v (%v)", merged, err)
+	}
+
+	var stats models.ReviewStats
+	if err := db.First(&stats, "review_id = ?", second.ID).Error; err != nil {
+		t.Fatalf("load stats failed: %v", err)
+	}
+	if stats.Likes != 1 {
+		t.Fatalf("expected the duplicate like to be removed from the count, got %d", stats.Likes)
+	}
+	var leftovers int64
+	if err := db.Model(&models.RefreshToken{}).Where("user_id = ?", source.ID).Count(&leftovers).Error; err != nil || leftovers != 0 {
+		t.Fatalf("expected source sessions to be removed, got %d (%v)", leftovers, err)
+	}
+}
@@ -0,0 +1,198 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"time"
+
+	"github.com/hdu-dp/backend/internal/common"
+	"github.com/hdu-dp/backend/internal/models"
+	"github.com/hdu-dp/backend/internal/ratelimit"
+	"github.com/hdu-dp/backend/internal/repository"
+	"github.com/hdu-dp/backend/internal/utils"
+)
+
+const (
+	defaultLoginMaxFailures = 10
+	defaultLoginWindow      = 15 * time.Minute
+	defaultLoginLockout     = 30 * time.Minute
+	defaultLoginDelayAfter  = 3
+	defaultLoginBaseDelay   = time.Second
+	defaultLoginMaxDelay    = 30 * time.Second
+	defaultLoginPerIP       = 50
+
+	loginDelayRule     = "login_delay"
+	loginIPFailuresKey = "login_ip_failures:"
+)
+
+// LoginProtection throttles password guessing. Once an account has DelayAfter failures within
+// Window, each further attempt must wait BaseDelay doubled per failure (capped at MaxDelay);
+// MaxFailures locks the account for Lockout. Independently, a client IP is blocked for the rest
+// of Window after PerIP failures against any account.
+type LoginProtection struct {
+	MaxFailures int
+	Window      time.Duration
+	Lockout     time.Duration
+	DelayAfter  int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	PerIP       int
+}
+
+func (p LoginProtection) withDefaults() LoginProtection {
+	if p.MaxFailures <= 0 {
+		p.MaxFailures = defaultLoginMaxFailures
+	}
+	if p.Window <= 0 {
+		p.Window = defaultLoginWindow
+	}
+	if p.Lockout <= 0 {
+		p.Lockout = defaultLoginLockout
+	}
+	if p.DelayAfter <= 0 {
+		p.DelayAfter = defaultLoginDelayAfter
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = defaultLoginBaseDelay
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = defaultLoginMaxDelay
+	}
+	if p.PerIP <= 0 {
+		p.PerIP = defaultLoginPerIP
+	}
+	return p
+}
+
+// delayFor returns how long to wait after the given number of recent failures.
+func (p LoginProtection) delayFor(failures int) time.Duration {
+	if failures < p.DelayAfter {
+		return 0
+	}
+	return exponentialBackoff(p.BaseDelay, p.MaxDelay, failures-p.DelayAfter+1)
+}
+
+// AccountLockedError is returned while an account is locked after too many failed password logins.
+type AccountLockedError struct {
+	Until      time.Time
+	RetryAfter time.Duration
+}
+
+func (e *AccountLockedError) Error() string {
+	return common.ErrAccountLocked.Error() + " until " + e.Until.Format(time.RFC3339)
+}
+
+// Unwrap lets callers match the error with errors.Is(err, common.ErrAccountLocked).
+func (e *AccountLockedError) Unwrap() error {
+	return common.ErrAccountLocked
+}
+
+// RetryAfterSeconds rounds the remaining lockout up to whole seconds for the Retry-After header.
+func (e *AccountLockedError) RetryAfterSeconds() int {
+	seconds := int(math.Ceil(e.RetryAfter.Seconds()))
+	if seconds < 1 {
+		return 1
+	}
+	return seconds
+}
+
+// checkLoginIP rejects clients that already failed too many logins within the window.
+func (s *AuthService) checkLoginIP(ctx context.Context, clientIP string) error {
+	if clientIP == "" {
+		return nil
+	}
+	failures, retryAfter, err := s.limiter.Failures(ctx, loginIPFailuresKey+clientIP)
+	if err != nil {
+		slog.Warn("read login failures failed", slog.Any("error", err))
+		return nil
+	}
+	if failures >= s.login.PerIP {
+		return &ratelimit.LimitedError{Rule: "login_ip", RetryAfter: retryAfter}
+	}
+	return nil
+}
+
+func (s *AuthService) recordLoginIPFailure(ctx context.Context, clientIP string) {
+	if clientIP == "" {
+		return
+	}
+	if _, err := s.limiter.RecordFailure(ctx, loginIPFailuresKey+clientIP, s.login.Window); err != nil {
+		slog.Warn("record login failure failed", slog.Any("error", err))
+	}
+}
+
+// PasswordGuard checks passwords against the failure counter stored on the account, so every endpoint that
+// accepts the current password shares the progressive delay and lockout of the login form.
+type PasswordGuard struct {
+	users       *repository.UserRepository
+	accountMail *AccountMailer
+	login       LoginProtection
+	now         func() time.Time
+}
+
+// NewPasswordGuard constructs a PasswordGuard. Only the per-account settings of login are used.
+func NewPasswordGuard(users *repository.UserRepository, accountMail *AccountMailer, login LoginProtection) *PasswordGuard {
+	return &PasswordGuard{users: users, accountMail: accountMail, login: login.withDefaults(), now: time.Now}
+}
+
+// Verify checks password for user. A locked or delayed account is refused before the password is compared,
+// a wrong password counts toward the lockout and a correct one clears the counter.
+func (g *PasswordGuard) Verify(ctx context.Context, user *models.User, password string) error {
+	now := g.now()
+	if err := g.check(user, now); err != nil {
+		return err
+	}
+	if err := utils.CheckPassword(user.PasswordHash, password); err != nil {
+		return g.recordFailure(ctx, user, now)
+	}
+	return g.clear(user)
+}
+
+// recentFailures ignores failures older than the window.
+func (g *PasswordGuard) recentFailures(user *models.User, now time.Time) int {
+	if user.LastFailedLoginAt == nil || now.Sub(*user.LastFailedLoginAt) > g.login.Window {
+		return 0
+	}
+	return user.FailedLoginAttempts
+}
+
+// check refuses the attempt while the account is locked or still inside its progressive delay.
+func (g *PasswordGuard) check(user *models.User, now time.Time) error {
+	if user.LockedUntil != nil && now.Before(*user.LockedUntil) {
+		return &AccountLockedError{Until: *user.LockedUntil, RetryAfter: user.LockedUntil.Sub(now)}
+	}
+	if delay := g.login.delayFor(g.recentFailures(user, now)); delay > 0 {
+		if next := user.LastFailedLoginAt.Add(delay); now.Before(next) {
+			return &ratelimit.LimitedError{Rule: loginDelayRule, RetryAfter: next.Sub(now)}
+		}
+	}
+	return nil
+}
+
+// recordFailure counts a wrong password and locks the account once the threshold is hit. The decision uses
+// the counter as stored by the database rather than the user loaded at the start of the attempt, which
+// concurrent attempts may have changed since.
+func (g *PasswordGuard) recordFailure(ctx context.Context, user *models.User, now time.Time) error {
+	until := now.Add(g.login.Lockout)
+	failure, err := g.users.RecordLoginFailure(user.ID, now, g.login.Window, g.login.MaxFailures, until)
+	if err != nil {
+		return err
+	}
+	if !failure.Locked {
+		// The progressive delay is enforced on the next attempt from the stored count and time.
+		return common.ErrInvalidCredentials
+	}
+
+	slog.Warn("account locked after failed logins", slog.String("user_id", user.ID.String()), slog.Int("failures", failure.Attempts))
+	g.accountMail.SendAccountLocked(ctx, user, until, now)
+	return &AccountLockedError{Until: until, RetryAfter: g.login.Lockout}
+}
+
+// clear resets the failure counter after a correct password.
+func (g *PasswordGuard) clear(user *models.User) error {
+	if user.FailedLoginAttempts == 0 && user.LockedUntil == nil {
+		return nil
+	}
+	return g.users.ClearLoginFailures(user.ID)
+}
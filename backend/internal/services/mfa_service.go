@@ -0,0 +1,358 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hdu-dp/backend/internal/auth"
+	"github.com/hdu-dp/backend/internal/common"
+	"github.com/hdu-dp/backend/internal/models"
+	"github.com/hdu-dp/backend/internal/ratelimit"
+	"github.com/hdu-dp/backend/internal/repository"
+	"github.com/hdu-dp/backend/internal/totp"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultMFAIssuer       = "杭电点评"
+	defaultMFAChallengeTTL = 5 * time.Minute
+
+	// mfaAttemptWindow is how long wrong second-factor guesses are remembered per user.
+	mfaAttemptWindow = 15 * time.Minute
+	// mfaClockSkew accepts codes from one step before or after the current one.
+	mfaClockSkew          = 1
+	recoveryCodeCount     = 10
+	recoveryCodeAlphabet  = "abcdefghjkmnpqrstuvwxyz23456789"
+	recoveryCodeHalfWidth = 5
+)
+
+var (
+	// ErrMFAAlreadyEnabled indicates the user already has a confirmed authenticator.
+	ErrMFAAlreadyEnabled = errors.New("two-factor authentication already enabled")
+	// ErrMFANotEnabled indicates the user has no confirmed authenticator (or no pending enrolment to confirm).
+	ErrMFANotEnabled = errors.New("two-factor authentication not enabled")
+	// ErrMFARequired indicates two-factor authentication cannot be turned off for the user's role.
+	ErrMFARequired = errors.New("two-factor authentication required for this role")
+	// ErrInvalidMFACode indicates a wrong, expired or replayed authenticator or recovery code.
+	ErrInvalidMFACode = errors.New("invalid two-factor code")
+	// ErrInvalidMFAChallenge indicates the MFA challenge token is malformed or expired.
+	ErrInvalidMFAChallenge = errors.New("invalid mfa challenge")
+)
+
+// MFAOptions configures two-factor authentication.
+type MFAOptions struct {
+	// Issuer is shown as the account name prefix in authenticator apps.
+	Issuer string
+	// RequiredRoles lists roles that must use two-factor authentication to sign in.
+	RequiredRoles []string
+	// ChallengeTTL bounds the time between the password step and the second factor.
+	ChallengeTTL time.Duration
+	// EncryptionKey seals stored TOTP secrets.
+	EncryptionKey string
+	// MaxAttempts caps wrong codes per user within mfaAttemptWindow.
+	MaxAttempts int
+}
+
+func (o MFAOptions) withDefaults() MFAOptions {
+	if strings.TrimSpace(o.Issuer) == "" {
+		o.Issuer = defaultMFAIssuer
+	}
+	if o.ChallengeTTL <= 0 {
+		o.ChallengeTTL = defaultMFAChallengeTTL
+	}
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = defaultMaxCodeAttempts
+	}
+	return o
+}
+
+// MFAStatus summarizes the two-factor state of a user.
+type MFAStatus struct {
+	Enabled                bool  `json:"enabled"`
+	Required               bool  `json:"required"`
+	RecoveryCodesRemaining int64 `json:"recovery_codes_remaining"`
+}
+
+// MFAEnrollment carries what an authenticator app needs to add the account.
+type MFAEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+// MFAService manages TOTP enrolment, recovery codes and second-factor verification.
+type MFAService struct {
+	repo     *repository.MFARepository
+	tokens   *auth.JWTManager
+	limiter  *ratelimit.Limiter
+	box      *secretBox
+	options  MFAOptions
+	required map[string]bool
+	now      func() time.Time
+}
+
+// NewMFAService constructs an MFAService.
+func NewMFAService(repo *repository.MFARepository, tokens *auth.JWTManager, limiter *ratelimit.Limiter, options MFAOptions) (*MFAService, error) {
+	options = options.withDefaults()
+	box, err := newSecretBox(options.EncryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("mfa: %w", err)
+	}
+
+	required := make(map[string]bool, len(options.RequiredRoles))
+	for _, role := range options.RequiredRoles {
+		if role = strings.TrimSpace(role); role != "" {
+			required[role] = true
+		}
+	}
+
+	return &MFAService{
+		repo:     repo,
+		tokens:   tokens,
+		limiter:  limiter,
+		box:      box,
+		options:  options,
+		required: required,
+		now:      time.Now,
+	}, nil
+}
+
+// Required reports whether the role of user must sign in with a second factor.
+func (s *MFAService) Required(user *models.User) bool {
+	return s.required[user.Role]
+}
+
+// Enabled reports whether userID has a confirmed authenticator.
+func (s *MFAService) Enabled(ctx context.Context, userID uuid.UUID) (bool, error) {
+	record, err := s.repo.FindTOTP(ctx, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return record.Enabled, nil
+}
+
+// Status summarizes the two-factor state of user.
+func (s *MFAService) Status(ctx context.Context, user *models.User) (*MFAStatus, error) {
+	enabled, err := s.Enabled(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	status := &MFAStatus{Enabled: enabled, Required: s.Required(user)}
+	if enabled {
+		if status.RecoveryCodesRemaining, err = s.repo.CountUnusedRecoveryCodes(ctx, user.ID); err != nil {
+			return nil, err
+		}
+	}
+	return status, nil
+}
+
+// BeginEnrollment generates a new secret for user. It stays pending until ConfirmEnrollment
+// receives a valid code, and replaces any earlier pending secret.
+func (s *MFAService) BeginEnrollment(ctx context.Context, user *models.User) (*MFAEnrollment, error) {
+	enabled, err := s.Enabled(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := s.box.seal([]byte(secret))
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.ReplaceTOTP(ctx, &models.UserTOTP{UserID: user.ID, SecretEnc: sealed}); err != nil {
+		return nil, err
+	}
+
+	account := user.Email
+	if isVirtualEmail(account) {
+		account = user.DisplayName
+	}
+	return &MFAEnrollment{Secret: secret, URI: totp.ProvisioningURI(s.options.Issuer, account, secret)}, nil
+}
+
+// ConfirmEnrollment enables the pending secret of userID once code proves the authenticator works,
+// and returns the recovery codes. They are shown only this once.
+func (s *MFAService) ConfirmEnrollment(ctx context.Context, userID uuid.UUID, code string) ([]string, error) {
+	record, err := s.repo.FindTOTP(ctx, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrMFANotEnabled
+		}
+		return nil, err
+	}
+	if record.Enabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if err := s.checkAttempts(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	secret, err := s.box.open(record.SecretEnc)
+	if err != nil {
+		return nil, err
+	}
+	counter, ok := totp.Validate(string(secret), code, s.now(), mfaClockSkew)
+	if !ok {
+		return nil, s.recordFailure(ctx, userID)
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.Enable(ctx, userID, counter, s.now(), hashes); err != nil {
+		return nil, err
+	}
+	resetCodeFailures(ctx, s.limiter, "mfa", userID.String())
+	return codes, nil
+}
+
+// Verify checks a TOTP code or an unused recovery code of userID. Each TOTP step and each
+// recovery code is accepted at most once.
+func (s *MFAService) Verify(ctx context.Context, userID uuid.UUID, code string) error {
+	record, err := s.repo.FindTOTP(ctx, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrMFANotEnabled
+		}
+		return err
+	}
+	if !record.Enabled {
+		return ErrMFANotEnabled
+	}
+	if err := s.checkAttempts(ctx, userID); err != nil {
+		return err
+	}
+
+	code = strings.ToLower(strings.TrimSpace(code))
+	var accepted bool
+	if len(code) == totp.Digits {
+		secret, err := s.box.open(record.SecretEnc)
+		if err != nil {
+			return err
+		}
+		if counter, ok := totp.Validate(string(secret), code, s.now(), mfaClockSkew); ok {
+			if accepted, err = s.repo.AdvanceCounter(ctx, userID, counter); err != nil {
+				return err
+			}
+		}
+	} else if code != "" {
+		if accepted, err = s.repo.UseRecoveryCode(ctx, userID, hashRecoveryCode(code), s.now()); err != nil {
+			return err
+		}
+	}
+
+	if !accepted {
+		return s.recordFailure(ctx, userID)
+	}
+	resetCodeFailures(ctx, s.limiter, "mfa", userID.String())
+	return nil
+}
+
+// Disable removes the authenticator and recovery codes of user after checking a current code.
+func (s *MFAService) Disable(ctx context.Context, user *models.User, code string) error {
+	if s.Required(user) {
+		return ErrMFARequired
+	}
+	if err := s.Verify(ctx, user.ID, code); err != nil {
+		return err
+	}
+	return s.repo.Delete(ctx, user.ID)
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes of userID after checking a current code.
+func (s *MFAService) RegenerateRecoveryCodes(ctx context.Context, userID uuid.UUID, code string) ([]string, error) {
+	if err := s.Verify(ctx, userID, code); err != nil {
+		return nil, err
+	}
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.ReplaceRecoveryCodes(ctx, userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// ChallengeTTL is how long a challenge token from IssueChallenge stays valid.
+func (s *MFAService) ChallengeTTL() time.Duration {
+	return s.options.ChallengeTTL
+}
+
+// IssueChallenge returns a challenge token that stands in for the token pair until the second factor passes.
+func (s *MFAService) IssueChallenge(userID uuid.UUID) (string, error) {
+	return s.tokens.GenerateChallenge(userID, s.options.ChallengeTTL)
+}
+
+// ParseChallenge returns the user a challenge token was issued for.
+func (s *MFAService) ParseChallenge(token string) (uuid.UUID, error) {
+	userID, err := s.tokens.ParseChallenge(token)
+	if err != nil {
+		return uuid.Nil, ErrInvalidMFAChallenge
+	}
+	return userID, nil
+}
+
+// checkAttempts refuses verification while the user has used up the wrong-guess allowance.
+func (s *MFAService) checkAttempts(ctx context.Context, userID uuid.UUID) error {
+	failures, _, err := s.limiter.Failures(ctx, "mfa_attempts:"+userID.String())
+	if err != nil {
+		return err
+	}
+	if failures >= s.options.MaxAttempts {
+		return common.ErrTooManyVerificationAttempts
+	}
+	return nil
+}
+
+func (s *MFAService) recordFailure(ctx context.Context, userID uuid.UUID) error {
+	limits := CodeRateLimits{MaxAttempts: s.options.MaxAttempts}
+	if recordCodeFailure(ctx, s.limiter, limits, "mfa", userID.String(), mfaAttemptWindow) {
+		return common.ErrTooManyVerificationAttempts
+	}
+	return ErrInvalidMFACode
+}
+
+// generateRecoveryCodes returns recoveryCodeCount codes like "k7m2p-x9qrt" and their hashes.
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	buf := make([]byte, recoveryCodeHalfWidth*2)
+	for i := 0; i < recoveryCodeCount; i++ {
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, err
+		}
+		var b strings.Builder
+		for j, v := range buf {
+			if j == recoveryCodeHalfWidth {
+				b.WriteByte('-')
+			}
+			b.WriteByte(recoveryCodeAlphabet[int(v)%len(recoveryCodeAlphabet)])
+		}
+		code := b.String()
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(code))))
+	return hex.EncodeToString(sum[:])
+}
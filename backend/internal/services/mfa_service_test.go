@@ -0,0 +1,105 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hdu-dp/backend/internal/auth"
+	"github.com/hdu-dp/backend/internal/common"
+	"github.com/hdu-dp/backend/internal/models"
+	"github.com/hdu-dp/backend/internal/ratelimit"
+	"github.com/hdu-dp/backend/internal/repository"
+	"github.com/hdu-dp/backend/internal/totp"
+	"github.com/hdu-dp/backend/internal/utils"
+)
+
+func TestAdminLoginRequiresTOTPEnrollmentAndVerification(t *testing.T) {
+	db := newServiceTestDB(t, &models.User{}, &models.RefreshToken{}, &models.UserTOTP{}, &models.MFARecoveryCode{})
+
+	hash, err := utils.HashPassword("password")
+	if err != nil {
+		t.Fatalf("hash password failed: %v", err)
+	}
+	admin := &models.User{Email: "admin@example.com", PasswordHash: hash, DisplayName: "Admin", Role: "admin"}
+	if err := repository.NewUserRepository(db).Create(admin); err != nil {
+		t.Fatalf("create user failed: %v", err)
+	}
+
+	jwtManager := auth.NewJWTManager("secret", time.Minute)
+	mfa, err := NewMFAService(repository.NewMFARepository(db), jwtManager, ratelimit.New(ratelimit.NewMemoryStore()), MFAOptions{
+		RequiredRoles: []string{"admin"},
+		EncryptionKey: "encryption-key",
+		MaxAttempts:   3,
+	})
+	if err != nil {
+		t.Fatalf("init mfa failed: %v", err)
+	}
+	svc := newTestAuthService(db, nil, AuthServiceOptions{RefreshTTL: time.Hour})
+	svc.mfa = mfa
+	now := time.Now()
+	mfa.now = func() time.Time { return now }
+
+	challenge, err := svc.Login(t.Context(), admin.Email, "password", ClientInfo{})
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	if challenge.AccessToken != "" || challenge.MFAToken == "" || !challenge.MFAEnrollmentRequired {
+		t.Fatalf("expected an enrollment challenge instead of tokens, got %+v", challenge)
+	}
+	if _, err := jwtManager.Parse(challenge.MFAToken); err == nil {
+		t.Fatalf("challenge token must not be accepted as an access token")
+	}
+
+	enrollment, err := svc.BeginMFAEnrollment(t.Context(), challenge.MFAToken)
+	if err != nil {
+		t.Fatalf("begin enrollment failed: %v", err)
+	}
+	code, err := totp.Code(enrollment.Secret, totp.Counter(now))
+	if err != nil {
+		t.Fatalf("compute code failed: %v", err)
+	}
+	result, recoveryCodes, err := svc.ConfirmMFAEnrollment(t.Context(), challenge.MFAToken, code, ClientInfo{})
+	if err != nil {
+		t.Fatalf("confirm enrollment failed: %v", err)
+	}
+	if result.AccessToken == "" || len(recoveryCodes) != recoveryCodeCount {
+		t.Fatalf("expected tokens and %d recovery codes, got %+v %v", recoveryCodeCount, result, recoveryCodes)
+	}
+
+	challenge, err = svc.Login(t.Context(), admin.Email, "password", ClientInfo{})
+	if err != nil || challenge.MFAToken == "" || challenge.MFAEnrollmentRequired {
+		t.Fatalf("expected a verification challenge, got %+v (%v)", challenge, err)
+	}
+	if _, err := svc.VerifyMFA(t.Context(), challenge.MFAToken, code, ClientInfo{}); !errors.Is(err, ErrInvalidMFACode) {
+		t.Fatalf("expected the enrollment code to be rejected as a replay, got %v", err)
+	}
+
+	now = now.Add(totp.Period)
+	code, _ = totp.Code(enrollment.Secret, totp.Counter(now))
+	if result, err := svc.VerifyMFA(t.Context(), challenge.MFAToken, code, ClientInfo{}); err != nil || result.AccessToken == "" {
+		t.Fatalf("expected the next code to complete the login, got %v", err)
+	}
+
+	if _, err := svc.VerifyMFA(t.Context(), challenge.MFAToken, recoveryCodes[0], ClientInfo{}); err != nil {
+		t.Fatalf("expected a recovery code to complete the login, got %v", err)
+	}
+	if _, err := svc.VerifyMFA(t.Context(), challenge.MFAToken, recoveryCodes[0], ClientInfo{}); !errors.Is(err, ErrInvalidMFACode) {
+		t.Fatalf("expected a recovery code to be single-use, got %v", err)
+	}
+	if _, err := svc.VerifyMFA(t.Context(), challenge.MFAToken, "000000", ClientInfo{}); !errors.Is(err, ErrInvalidMFACode) {
+		t.Fatalf("expected a wrong code to be rejected, got %v", err)
+	}
+	if _, err := svc.VerifyMFA(t.Context(), challenge.MFAToken, "000000", ClientInfo{}); !errors.Is(err, common.ErrTooManyVerificationAttempts) {
+		t.Fatalf("expected the third wrong code to hit the attempt cap, got %v", err)
+	}
+	now = now.Add(totp.Period)
+	code, _ = totp.Code(enrollment.Secret, totp.Counter(now))
+	if _, err := svc.VerifyMFA(t.Context(), challenge.MFAToken, code, ClientInfo{}); !errors.Is(err, common.ErrTooManyVerificationAttempts) {
+		t.Fatalf("expected verification to stay blocked after the cap, got %v", err)
+	}
+
+	if err := mfa.Disable(t.Context(), admin, code); !errors.Is(err, ErrMFARequired) {
+		t.Fatalf("expected admins to be unable to disable mfa, got %v", err)
+	}
+}
@@ -0,0 +1,215 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hdu-dp/backend/internal/models"
+	"github.com/hdu-dp/backend/internal/passwordpolicy"
+	"github.com/hdu-dp/backend/internal/ratelimit"
+	"github.com/hdu-dp/backend/internal/repository"
+	"github.com/hdu-dp/backend/internal/utils"
+	"gorm.io/gorm"
+)
+
+const passwordResetTTL = 30 * time.Minute
+
+var (
+	// ErrInvalidResetToken indicates the reset token is unknown, expired or already used.
+	ErrInvalidResetToken = errors.New("invalid or expired password reset token")
+	// ErrSamePassword indicates the new password equals the current one.
+	ErrSamePassword = errors.New("new password must differ from the current one")
+)
+
+// PasswordService handles password changes and the forgot/reset flow.
+type PasswordService struct {
+	users         *repository.UserRepository
+	refreshTokens *repository.RefreshTokenRepository
+	apiTokens     *repository.APITokenRepository
+	resets        *repository.PasswordResetRepository
+	accountMail   *AccountMailer
+	guard         *PasswordGuard
+	limiter       *ratelimit.Limiter
+	limits        CodeRateLimits
+	policy        *passwordpolicy.Policy
+	resetBaseURL  string
+	now           func() time.Time
+}
+
+// NewPasswordService constructs a PasswordService. resetBaseURL is the frontend origin
+// that serves the /reset-password page linked from reset emails.
+func NewPasswordService(
+	users *repository.UserRepository,
+	refreshTokens *repository.RefreshTokenRepository,
+	apiTokens *repository.APITokenRepository,
+	resets *repository.PasswordResetRepository,
+	accountMail *AccountMailer,
+	guard *PasswordGuard,
+	limiter *ratelimit.Limiter,
+	limits CodeRateLimits,
+	policy *passwordpolicy.Policy,
+	resetBaseURL string,
+) *PasswordService {
+	baseURL := strings.TrimRight(resetBaseURL, "/")
+	if baseURL == "" {
+		baseURL = "http://localhost:5174"
+	}
+	return &PasswordService{
+		users:         users,
+		refreshTokens: refreshTokens,
+		apiTokens:     apiTokens,
+		resets:        resets,
+		accountMail:   accountMail,
+		guard:         guard,
+		limiter:       limiter,
+		limits:        limits.withDefaults(),
+		policy:        policy,
+		resetBaseURL:  baseURL,
+		now:           time.Now,
+	}
+}
+
+// ChangePassword replaces the password of a signed-in user after checking the current one and signs out
+// every session other than sessionID. Wrong current passwords count toward the login lockout.
+func (s *PasswordService) ChangePassword(ctx context.Context, userID, sessionID uuid.UUID, current, next string) error {
+	user, err := s.users.FindByID(userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+
+	if err := s.guard.Verify(ctx, user, current); err != nil {
+		return err
+	}
+	if current == next {
+		return ErrSamePassword
+	}
+	if err := s.policy.Check(next, user.Email, user.DisplayName); err != nil {
+		return err
+	}
+
+	if err := s.setPassword(user.ID, next); err != nil {
+		return err
+	}
+	_, err = s.refreshTokens.RevokeAllForUserExcept(user.ID, sessionID)
+	return err
+}
+
+// RequestReset emails a reset link when email belongs to an account. Unknown addresses are
+// accepted silently so the endpoint cannot be used to discover registered emails.
+func (s *PasswordService) RequestReset(ctx context.Context, email string) error {
+	if !s.accountMail.IsConfigured() {
+		return ErrEmailServiceNotConfigured
+	}
+
+	email = strings.TrimSpace(strings.ToLower(email))
+	if err := allowCodeRequest(ctx, s.limiter, s.limits, "password_reset", email); err != nil {
+		return err
+	}
+
+	user, err := s.users.FindByEmail(email)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	if isVirtualEmail(user.Email) {
+		return nil
+	}
+
+	now := s.now()
+	_ = s.resets.DeleteExpired(ctx, now)
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return fmt.Errorf("generate reset token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	if err := s.resets.Replace(ctx, &models.PasswordResetToken{
+		UserID:    user.ID,
+		TokenHash: hashResetToken(token),
+		ExpiresAt: now.Add(passwordResetTTL),
+	}); err != nil {
+		return fmt.Errorf("store reset token: %w", err)
+	}
+
+	s.accountMail.SendPasswordReset(ctx, user, fmt.Sprintf("%s/reset-password?token=%s", s.resetBaseURL, token), passwordResetTTL)
+	return nil
+}
+
+// ResetPassword consumes a reset token, sets the new password and signs the user out everywhere, revoking
+// personal access tokens as well.
+// A password refused by the policy leaves the token unused so the user can try another one.
+func (s *PasswordService) ResetPassword(ctx context.Context, token, next string) error {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return ErrInvalidResetToken
+	}
+
+	reset, err := s.resets.FindByHash(ctx, hashResetToken(token))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidResetToken
+		}
+		return err
+	}
+	if reset.UsedAt != nil || !s.now().Before(reset.ExpiresAt) {
+		return ErrInvalidResetToken
+	}
+
+	user, err := s.users.FindByID(reset.UserID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidResetToken
+		}
+		return err
+	}
+	if err := s.policy.Check(next, user.Email, user.DisplayName); err != nil {
+		return err
+	}
+
+	consumed, err := s.resets.Consume(ctx, reset.ID, s.now())
+	if err != nil {
+		return err
+	}
+	if !consumed {
+		return ErrInvalidResetToken
+	}
+
+	if err := s.setPassword(reset.UserID, next); err != nil {
+		return err
+	}
+	if err := s.users.ClearLoginFailures(reset.UserID); err != nil {
+		slog.Warn("clear login failures after reset failed", slog.String("user_id", reset.UserID.String()), slog.Any("error", err))
+	}
+	if err := s.refreshTokens.RevokeAllForUser(reset.UserID); err != nil {
+		return err
+	}
+	return s.apiTokens.RevokeAllForUser(ctx, reset.UserID, s.now())
+}
+
+func (s *PasswordService) setPassword(userID uuid.UUID, password string) error {
+	hashed, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	return s.users.UpdatePassword(userID, hashed)
+}
+
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,180 @@
+package services
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hdu-dp/backend/internal/common"
+	"github.com/hdu-dp/backend/internal/models"
+	"github.com/hdu-dp/backend/internal/passwordpolicy"
+	"github.com/hdu-dp/backend/internal/repository"
+	"github.com/hdu-dp/backend/internal/utils"
+)
+
+func TestPasswordResetFlowRevokesSessions(t *testing.T) {
+	smtpServer := newFakeSMTPServer(t)
+	db := newServiceTestDB(t, &models.User{}, &models.EmailOutbox{}, &models.RefreshToken{}, &models.PasswordResetToken{}, &models.APIToken{})
+
+	users := repository.NewUserRepository(db)
+	refreshTokens := repository.NewRefreshTokenRepository(db)
+	apiTokens := repository.NewAPITokenRepository(db)
+	hash, err := utils.HashPassword("old-password")
+	if err != nil {
+		t.Fatalf("hash password failed: %v", err)
+	}
+	user := &models.User{Email: "bob@example.com", PasswordHash: hash, DisplayName: "Bob", Locale: "en"}
+	if err := users.Create(user); err != nil {
+		t.Fatalf("create user failed: %v", err)
+	}
+	if err := apiTokens.Create(t.Context(), &models.APIToken{UserID: user.ID, Name: "script", TokenHash: "hash", Prefix: "pat", Scopes: "reviews:read"}); err != nil {
+		t.Fatalf("create api token failed: %v", err)
+	}
+	if err := refreshTokens.Create(&models.RefreshToken{UserID: user.ID, SecretHash: "x", ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatalf("create refresh token failed: %v", err)
+	}
+
+	policy, err := passwordpolicy.New(passwordpolicy.Options{MinLength: 8, MinClasses: 2, RejectPersonalInfo: true, CheckBreached: true})
+	if err != nil {
+		t.Fatalf("init password policy failed: %v", err)
+	}
+
+	outbox := NewEmailOutboxService(repository.NewEmailOutboxRepository(db), NewEmailService(smtpServer.emailConfig()), EmailOutboxOptions{})
+	accountMail := NewAccountMailer(outbox, newTestEmailTemplates(t))
+	svc := NewPasswordService(
+		users,
+		refreshTokens,
+		apiTokens,
+		repository.NewPasswordResetRepository(db),
+		accountMail,
+		NewPasswordGuard(users, accountMail, LoginProtection{}),
+		nil,
+		CodeRateLimits{},
+		policy,
+		"https://app.example.com",
+	)
+
+	if err := svc.RequestReset(t.Context(), "nobody@example.com"); err != nil {
+		t.Fatalf("unknown email should be accepted silently, got %v", err)
+	}
+	if err := svc.RequestReset(t.Context(), "BOB@example.com"); err != nil {
+		t.Fatalf("request reset failed: %v", err)
+	}
+
+	var queued []models.EmailOutbox
+	if err := db.Find(&queued).Error; err != nil {
+		t.Fatalf("list outbox failed: %v", err)
+	}
+	if len(queued) != 1 || queued[0].Recipient != user.Email {
+		t.Fatalf("expected a single reset email to bob, got %+v", queued)
+	}
+	match := regexp.MustCompile(`https://app\.example\.com/reset-password\?token=([A-Za-z0-9_-]+)`).FindStringSubmatch(queued[0].TextBody)
+	if match == nil {
+		t.Fatalf("reset link not found in %q", queued[0].TextBody)
+	}
+
+	for password, code := range map[string]string{
+		"bob-12345": passwordpolicy.CodeContainsIdentity,
+		"Password1": passwordpolicy.CodeBreached,
+		"short-1":   passwordpolicy.CodeTooShort,
+	} {
+		var violation *passwordpolicy.Violation
+		if err := svc.ResetPassword(t.Context(), match[1], password); !errors.As(err, &violation) || violation.Code != code {
+			t.Fatalf("expected %q to be refused with %s, got %v", password, code, err)
+		}
+	}
+	if err := svc.ResetPassword(t.Context(), match[1], "new-password"); err != nil {
+		t.Fatalf("reset password failed: %v", err)
+	}
+	if err := svc.ResetPassword(t.Context(), match[1], "another-password"); !errors.Is(err, ErrInvalidResetToken) {
+		t.Fatalf("expected token to be single-use, got %v", err)
+	}
+
+	updated, err := users.FindByID(user.ID)
+	if err != nil {
+		t.Fatalf("reload user failed: %v", err)
+	}
+	if utils.CheckPassword(updated.PasswordHash, "new-password") != nil {
+		t.Fatalf("expected the new password to be stored")
+	}
+
+	var active int64
+	if err := db.Model(&models.RefreshToken{}).Where("user_id = ? AND revoked = ?", user.ID, false).Count(&active).Error; err != nil {
+		t.Fatalf("count refresh tokens failed: %v", err)
+	}
+	if active != 0 {
+		t.Fatalf("expected all refresh tokens to be revoked, %d active", active)
+	}
+
+	var activeTokens int64
+	if err := db.Model(&models.APIToken{}).Where("user_id = ? AND revoked_at IS NULL", user.ID).Count(&activeTokens).Error; err != nil {
+		t.Fatalf("count api tokens failed: %v", err)
+	}
+	if activeTokens != 0 {
+		t.Fatalf("expected reset to revoke personal access tokens, %d active", activeTokens)
+	}
+
+	current := &models.RefreshToken{UserID: user.ID, SecretHash: "x", ExpiresAt: time.Now().Add(time.Hour)}
+	other := &models.RefreshToken{UserID: user.ID, SecretHash: "x", ExpiresAt: time.Now().Add(time.Hour)}
+	for _, token := range []*models.RefreshToken{current, other} {
+		if err := refreshTokens.Create(token); err != nil {
+			t.Fatalf("create refresh token failed: %v", err)
+		}
+	}
+
+	if err := svc.ChangePassword(t.Context(), user.ID, current.ID, "old-password", "third-password"); !errors.Is(err, common.ErrInvalidCredentials) {
+		t.Fatalf("expected stale current password to be rejected, got %v", err)
+	}
+	if err := svc.ChangePassword(t.Context(), user.ID, current.ID, "new-password", "third-password"); err != nil {
+		t.Fatalf("change password failed: %v", err)
+	}
+
+	var remaining []models.RefreshToken
+	if err := db.Where("user_id = ? AND revoked = ?", user.ID, false).Find(&remaining).Error; err != nil {
+		t.Fatalf("list refresh tokens failed: %v", err)
+	}
+	if len(remaining) != 1 || remaining[0].ID != current.ID {
+		t.Fatalf("expected only the current session to survive a password change, got %+v", remaining)
+	}
+}
+
+func TestChangePasswordCountsTowardLockout(t *testing.T) {
+	db := newServiceTestDB(t, &models.User{}, &models.RefreshToken{})
+
+	users := repository.NewUserRepository(db)
+	hash, err := utils.HashPassword("old-password")
+	if err != nil {
+		t.Fatalf("hash password failed: %v", err)
+	}
+	user := &models.User{Email: "carol@example.com", PasswordHash: hash, DisplayName: "Carol"}
+	if err := users.Create(user); err != nil {
+		t.Fatalf("create user failed: %v", err)
+	}
+
+	svc := NewPasswordService(
+		users,
+		repository.NewRefreshTokenRepository(db),
+		repository.NewAPITokenRepository(db),
+		repository.NewPasswordResetRepository(db),
+		nil,
+		NewPasswordGuard(users, nil, LoginProtection{MaxFailures: 3, DelayAfter: 10}),
+		nil,
+		CodeRateLimits{},
+		nil,
+		"",
+	)
+
+	for i := 0; i < 2; i++ {
+		if err := svc.ChangePassword(t.Context(), user.ID, uuid.Nil, "guess", "new-password"); !errors.Is(err, common.ErrInvalidCredentials) {
+			t.Fatalf("attempt %d: expected invalid credentials, got %v", i+1, err)
+		}
+	}
+	if err := svc.ChangePassword(t.Context(), user.ID, uuid.Nil, "guess", "new-password"); !errors.Is(err, common.ErrAccountLocked) {
+		t.Fatalf("expected the third wrong password to lock the account, got %v", err)
+	}
+	if err := svc.ChangePassword(t.Context(), user.ID, uuid.Nil, "old-password", "new-password"); !errors.Is(err, common.ErrAccountLocked) {
+		t.Fatalf("expected the locked account to refuse the correct password, got %v", err)
+	}
+}
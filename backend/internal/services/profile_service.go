@@ -0,0 +1,243 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/hdu-dp/backend/internal/emailtemplate"
+	"github.com/hdu-dp/backend/internal/imaging"
+	"github.com/hdu-dp/backend/internal/models"
+	"github.com/hdu-dp/backend/internal/repository"
+	"github.com/hdu-dp/backend/internal/storage"
+	"gorm.io/gorm"
+)
+
+const (
+	minDisplayNameLength = 2
+	maxDisplayNameLength = 30
+	maxBioLength         = 500
+)
+
+// Avatar renditions; every upload is cropped to a square and stored at both sizes.
+const (
+	avatarLargeSize = 256
+	avatarSmallSize = 64
+)
+
+var (
+	// ErrInvalidDisplayName indicates a display name of the wrong length or with control characters.
+	ErrInvalidDisplayName = errors.New("invalid display name")
+	// ErrDisplayNameTaken indicates another account already uses the display name.
+	ErrDisplayNameTaken = errors.New("display name already taken")
+	// ErrBioTooLong indicates the bio exceeds maxBioLength characters.
+	ErrBioTooLong = errors.New("bio too long")
+	// ErrInvalidDietaryPreference indicates an unknown dietary preference.
+	ErrInvalidDietaryPreference = errors.New("invalid dietary preference")
+	// ErrUnsupportedLocale indicates a locale without email templates.
+	ErrUnsupportedLocale = errors.New("unsupported locale")
+	// ErrInvalidImage indicates an upload that is not an accepted image, is corrupt or is too large to process.
+	// It wraps imaging.ErrUnsupportedFormat, imaging.ErrCorrupt or imaging.ErrTooLarge.
+	ErrInvalidImage = errors.New("invalid image")
+)
+
+// ProfileUpdate lists the profile fields to change. Nil fields are left as they are; an empty, non-nil
+// DietaryPreferences clears them, and an empty Locale returns to the default email language.
+type ProfileUpdate struct {
+	DisplayName        *string
+	Bio                *string
+	DietaryPreferences []string
+	Locale             *string
+}
+
+// ProfileService lets users edit their public profile and avatar.
+type ProfileService struct {
+	users       *repository.UserRepository
+	storage     storage.FileStorage
+	imageLimits imaging.Limits
+	now         func() time.Time
+}
+
+// NewProfileService constructs a ProfileService. imageLimits restricts avatar uploads.
+func NewProfileService(users *repository.UserRepository, fileStorage storage.FileStorage, imageLimits imaging.Limits) *ProfileService {
+	return &ProfileService{users: users, storage: fileStorage, imageLimits: imageLimits, now: time.Now}
+}
+
+// Update validates and applies the requested profile changes of userID.
+func (s *ProfileService) Update(userID uuid.UUID, update ProfileUpdate) (*models.User, error) {
+	user, err := s.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string]any{}
+	if update.DisplayName != nil {
+		name := strings.TrimSpace(*update.DisplayName)
+		if err := validateDisplayName(name); err != nil {
+			return nil, err
+		}
+		if name != user.DisplayName {
+			taken, err := s.users.DisplayNameTaken(name, user.ID)
+			if err != nil {
+				return nil, err
+			}
+			if taken {
+				return nil, ErrDisplayNameTaken
+			}
+			fields["display_name"] = name
+		}
+	}
+	if update.Bio != nil {
+		bio := strings.TrimSpace(*update.Bio)
+		if utf8.RuneCountInString(bio) > maxBioLength {
+			return nil, ErrBioTooLong
+		}
+		fields["bio"] = bio
+	}
+	if update.DietaryPreferences != nil {
+		preferences, err := normalizeDietaryPreferences(update.DietaryPreferences)
+		if err != nil {
+			return nil, err
+		}
+		fields["dietary_preferences"] = strings.Join(preferences, ",")
+	}
+	if update.Locale != nil {
+		locale := emailtemplate.NormalizeLocale(*update.Locale)
+		if locale == "" && strings.TrimSpace(*update.Locale) != "" {
+			return nil, ErrUnsupportedLocale
+		}
+		fields["locale"] = locale
+	}
+
+	if len(fields) > 0 {
+		// The check above answers most conflicts early; the unique index catches a concurrent rename.
+		if err := s.users.UpdateProfile(user.ID, fields); err != nil {
+			if errors.Is(err, repository.ErrDisplayNameTaken) {
+				return nil, ErrDisplayNameTaken
+			}
+			return nil, err
+		}
+	}
+	return s.findUser(user.ID)
+}
+
+// SetAvatar decodes the uploaded image, stores square JPEG renditions of it and removes the previous avatar.
+func (s *ProfileService) SetAvatar(ctx context.Context, userID uuid.UUID, upload io.Reader) (*models.User, error) {
+	user, err := s.findUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	img, _, err := s.imageLimits.Decode(upload)
+	if err != nil {
+		return nil, invalidImage(err)
+	}
+
+	baseKey := fmt.Sprintf("avatars/%s/%d", user.ID, s.now().UnixNano())
+	urls := make(map[int]string, 2)
+	var saved []string
+	for _, size := range []int{avatarLargeSize, avatarSmallSize} {
+		data, err := imaging.EncodeJPEG(imaging.Square(img, size), imaging.DefaultJPEGQuality)
+		if err != nil {
+			s.deleteKeys(ctx, saved)
+			return nil, err
+		}
+		info, err := s.storage.Save(ctx, avatarKey(baseKey, size), bytes.NewReader(data), int64(len(data)), "image/jpeg")
+		if err != nil {
+			s.deleteKeys(ctx, saved)
+			return nil, fmt.Errorf("store avatar: %w", err)
+		}
+		saved = append(saved, info.Key)
+		urls[size] = info.URL
+	}
+
+	if err := s.users.UpdateProfile(user.ID, map[string]any{
+		"avatar_key":       baseKey,
+		"avatar_url":       urls[avatarLargeSize],
+		"avatar_small_url": urls[avatarSmallSize],
+	}); err != nil {
+		s.deleteKeys(ctx, saved)
+		return nil, err
+	}
+
+	if user.AvatarKey != "" {
+		s.deleteKeys(ctx, []string{avatarKey(user.AvatarKey, avatarLargeSize), avatarKey(user.AvatarKey, avatarSmallSize)})
+	}
+	return s.findUser(user.ID)
+}
+
+// deleteKeys removes stored files, logging failures since the account change already succeeded or failed.
+func (s *ProfileService) deleteKeys(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		if err := s.storage.Delete(ctx, key); err != nil {
+			slog.Warn("delete avatar file failed", slog.String("key", key), slog.Any("error", err))
+		}
+	}
+}
+
+func (s *ProfileService) findUser(userID uuid.UUID) (*models.User, error) {
+	user, err := s.users.FindByID(userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+// invalidImage marks errors caused by the uploaded data with ErrInvalidImage, keeping the imaging error visible.
+func invalidImage(err error) error {
+	if errors.Is(err, imaging.ErrUnsupportedFormat) || errors.Is(err, imaging.ErrCorrupt) || errors.Is(err, imaging.ErrTooLarge) {
+		return fmt.Errorf("%w: %w", ErrInvalidImage, err)
+	}
+	return err
+}
+
+func avatarKey(baseKey string, size int) string {
+	return fmt.Sprintf("%s_%d.jpg", baseKey, size)
+}
+
+// validateDisplayName enforces the length limits and rejects control and formatting characters,
+// which could be used to make a name look like someone else's.
+func validateDisplayName(name string) error {
+	length := utf8.RuneCountInString(name)
+	if length < minDisplayNameLength || length > maxDisplayNameLength {
+		return ErrInvalidDisplayName
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) || unicode.Is(unicode.Cf, r) {
+			return ErrInvalidDisplayName
+		}
+	}
+	return nil
+}
+
+// normalizeDietaryPreferences validates and deduplicates preferences, keeping the order of DietaryPreferenceOptions.
+func normalizeDietaryPreferences(preferences []string) ([]string, error) {
+	selected := make([]string, 0, len(preferences))
+	for _, preference := range preferences {
+		preference = strings.TrimSpace(strings.ToLower(preference))
+		if !slices.Contains(models.DietaryPreferenceOptions, preference) {
+			return nil, ErrInvalidDietaryPreference
+		}
+		selected = append(selected, preference)
+	}
+
+	normalized := make([]string, 0, len(selected))
+	for _, option := range models.DietaryPreferenceOptions {
+		if slices.Contains(selected, option) {
+			normalized = append(normalized, option)
+		}
+	}
+	return normalized, nil
+}
@@ -0,0 +1,145 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/hdu-dp/backend/internal/imaging"
+	"github.com/hdu-dp/backend/internal/models"
+	"github.com/hdu-dp/backend/internal/repository"
+	"github.com/hdu-dp/backend/internal/storage"
+)
+
+func TestProfileUpdateRules(t *testing.T) {
+	db := newServiceTestDB(t, &models.User{})
+	users := repository.NewUserRepository(db)
+	alice := &models.User{Email: "alice@example.com", PasswordHash: "x", DisplayName: "Alice"}
+	bob := &models.User{Email: "bob@example.com", PasswordHash: "x", DisplayName: "Bob"}
+	for _, u := range []*models.User{alice, bob} {
+		if err := users.Create(u); err != nil {
+			t.Fatalf("create user failed: %v", err)
+		}
+	}
+	svc := NewProfileService(users, nil, imaging.DefaultLimits)
+
+	name := func(value string) ProfileUpdate { return ProfileUpdate{DisplayName: &value} }
+	locale := func(value string) ProfileUpdate { return ProfileUpdate{Locale: &value} }
+	for _, tc := range []struct {
+		update ProfileUpdate
+		want   error
+	}{
+		{name("A"), ErrInvalidDisplayName},
+		{name(strings.Repeat("名", 31)), ErrInvalidDisplayName},
+		{name("Al\u202eice"), ErrInvalidDisplayName},
+		{name(" BOB "), ErrDisplayNameTaken},
+		{ProfileUpdate{DietaryPreferences: []string{"carnivore"}}, ErrInvalidDietaryPreference},
+		{locale("fr"), ErrUnsupportedLocale},
+	} {
+		if _, err := svc.Update(alice.ID, tc.update); !errors.Is(err, tc.want) {
+			t.Fatalf("expected %v for %+v, got %v", tc.want, tc.update, err)
+		}
+	}
+
+	if err := users.UpdateProfile(bob.ID, map[string]any{"display_name": "ALICE"}); !errors.Is(err, repository.ErrDisplayNameTaken) {
+		t.Fatalf("expected the unique index to refuse a concurrent rename, got %v", err)
+	}
+
+	bio := "  爱吃辣，常去东区食堂  "
+	updated, err := svc.Update(alice.ID, ProfileUpdate{
+		DisplayName:        name("alice").DisplayName,
+		Bio:                &bio,
+		DietaryPreferences: []string{"Spicy", "halal", "spicy"},
+	})
+	if err != nil {
+		t.Fatalf("update profile failed: %v", err)
+	}
+	if updated.DisplayName != "alice" || updated.Bio != strings.TrimSpace(bio) ||
+		!slices.Equal(updated.DietaryPreferenceList(), []string{models.DietHalal, models.DietSpicy}) {
+		t.Fatalf("unexpected profile after update: %+v", updated)
+	}
+
+	cleared, err := svc.Update(alice.ID, ProfileUpdate{DietaryPreferences: []string{}})
+	if err != nil || len(cleared.DietaryPreferenceList()) != 0 || cleared.Bio == "" {
+		t.Fatalf("expected only the preferences to be cleared, got %+v (%v)", cleared, err)
+	}
+
+	english, err := svc.Update(alice.ID, locale("en_US"))
+	if err != nil || english.Locale != "en" {
+		t.Fatalf("expected the locale to be normalised to en, got %q (%v)", english.Locale, err)
+	}
+	reset, err := svc.Update(alice.ID, locale(""))
+	if err != nil || reset.Locale != "" {
+		t.Fatalf("expected an empty locale to restore the default, got %q (%v)", reset.Locale, err)
+	}
+}
+
+func TestSetAvatarResizesAndReplaces(t *testing.T) {
+	db := newServiceTestDB(t, &models.User{})
+	users := repository.NewUserRepository(db)
+	user := &models.User{Email: "carol@example.com", PasswordHash: "x", DisplayName: "Carol"}
+	if err := users.Create(user); err != nil {
+		t.Fatalf("create user failed: %v", err)
+	}
+
+	dir := t.TempDir()
+	local, err := storage.NewLocal(dir, "/api/v1/uploads")
+	if err != nil {
+		t.Fatalf("init storage failed: %v", err)
+	}
+	svc := NewProfileService(users, local, imaging.DefaultLimits)
+
+	if _, err := svc.SetAvatar(t.Context(), user.ID, strings.NewReader("not an image")); !errors.Is(err, ErrInvalidImage) {
+		t.Fatalf("expected a non-image upload to be refused, got %v", err)
+	}
+
+	upload := func() *models.User {
+		t.Helper()
+		src := image.NewRGBA(image.Rect(0, 0, 300, 200))
+		for i := range src.Pix {
+			src.Pix[i] = 0x80
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, src); err != nil {
+			t.Fatalf("encode png failed: %v", err)
+		}
+		updated, err := svc.SetAvatar(t.Context(), user.ID, &buf)
+		if err != nil {
+			t.Fatalf("set avatar failed: %v", err)
+		}
+		return updated
+	}
+
+	first := upload()
+	for size, url := range map[int]string{avatarLargeSize: first.AvatarURL, avatarSmallSize: first.AvatarSmallURL} {
+		path := filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(url, "/api/v1/uploads/")))
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("read avatar %d failed: %v", size, err)
+		}
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("decode avatar %d failed: %v", size, err)
+		}
+		if b := img.Bounds(); b.Dx() != size || b.Dy() != size {
+			t.Fatalf("expected a %dx%d avatar, got %v", size, size, b)
+		}
+	}
+
+	second := upload()
+	if second.AvatarKey == first.AvatarKey {
+		t.Fatalf("expected a new avatar key")
+	}
+	for _, size := range []int{avatarLargeSize, avatarSmallSize} {
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(avatarKey(first.AvatarKey, size)))); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("expected the previous %d avatar to be deleted, got %v", size, err)
+		}
+	}
+}
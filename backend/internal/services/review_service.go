@@ -1,29 +1,80 @@
 package services
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"image"
+	"log/slog"
+	"path"
 	"path/filepath"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 	"github.com/hdu-dp/backend/internal/common"
+	"github.com/hdu-dp/backend/internal/imaging"
 	"github.com/hdu-dp/backend/internal/models"
 	"github.com/hdu-dp/backend/internal/repository"
 	"github.com/hdu-dp/backend/internal/storage"
+	"gorm.io/gorm"
 )
 
+var (
+	// ErrCampusVerificationRequired indicates only authors with a verified campus email may submit reviews.
+	ErrCampusVerificationRequired = errors.New("campus email verification required")
+	// ErrTooManyImages indicates a review already carries the maximum number of images.
+	ErrTooManyImages = errors.New("too many images")
+	// ErrImageNotFound indicates the image does not exist or belongs to another review.
+	ErrImageNotFound = errors.New("image not found")
+	// ErrCaptionTooLong indicates an image caption exceeds MaxImageCaptionLength characters.
+	ErrCaptionTooLong = errors.New("caption too long")
+	// ErrInvalidImageOrder indicates a reorder request does not list each image of the review exactly once.
+	ErrInvalidImageOrder = errors.New("image order must list every image of the review once")
+)
+
+// MaxImageCaptionLength is the longest image caption accepted, in characters.
+const MaxImageCaptionLength = 200
+
+// Widths of the renditions stored for every review image. Narrower uploads keep their own width.
+const (
+	reviewImageThumbnailWidth = 320
+	reviewImageMediumWidth    = 800
+	reviewImageLargeWidth     = 1600
+)
+
+// ReviewOptions groups options for ReviewService initialization.
+type ReviewOptions struct {
+	// RequireCampusVerified restricts submitting reviews to users who verified a campus email.
+	RequireCampusVerified bool
+	// ImageLimits restricts uploaded images; the zero value accepts every supported format.
+	ImageLimits imaging.Limits
+	// MaxImages caps the images of one review; zero means no limit.
+	MaxImages int
+}
+
 // ReviewService contains business logic around review workflows.
 type ReviewService struct {
-	reviews *repository.ReviewRepository
-	storage storage.FileStorage
+	reviews               *repository.ReviewRepository
+	storage               storage.FileStorage
+	webhooks              *WebhookService
+	requireCampusVerified bool
+	imageLimits           imaging.Limits
+	maxImages             int
 }
 
-// NewReviewService constructs a review service instance.
-func NewReviewService(reviews *repository.ReviewRepository, fileStorage storage.FileStorage) *ReviewService {
-	return &ReviewService{reviews: reviews, storage: fileStorage}
+// NewReviewService constructs a review service instance. webhooks may be nil.
+func NewReviewService(reviews *repository.ReviewRepository, fileStorage storage.FileStorage, webhooks *WebhookService, options ReviewOptions) *ReviewService {
+	return &ReviewService{
+		reviews:               reviews,
+		storage:               fileStorage,
+		webhooks:              webhooks,
+		requireCampusVerified: options.RequireCampusVerified,
+		imageLimits:           options.ImageLimits,
+		maxImages:             options.MaxImages,
+	}
 }
 
 // CreateReviewInput bundles parameters for a new review.
@@ -41,6 +92,8 @@ type ListFilters struct {
 	Query    string
 	SortBy   string
 	SortDir  string
+	// CampusVerifiedOnly keeps reviews whose author verified a campus email.
+	CampusVerifiedOnly bool
 }
 
 // Pagination metadata for list responses.
@@ -58,7 +111,11 @@ type ReviewListResult struct {
 }
 
 // Submit creates a new review in pending state.
-func (s *ReviewService) Submit(authorID uuid.UUID, input CreateReviewInput) (*models.Review, error) {
+func (s *ReviewService) Submit(author *models.User, input CreateReviewInput) (*models.Review, error) {
+	if s.requireCampusVerified && !author.CampusVerified {
+		return nil, ErrCampusVerificationRequired
+	}
+
 	title := strings.TrimSpace(input.Title)
 	address := strings.TrimSpace(input.Address)
 	description := strings.TrimSpace(input.Description)
@@ -77,7 +134,7 @@ func (s *ReviewService) Submit(authorID uuid.UUID, input CreateReviewInput) (*mo
 		Description: description,
 		Rating:      input.Rating,
 		Status:      models.ReviewStatusPending,
-		AuthorID:    authorID,
+		AuthorID:    author.ID,
 	}
 
 	if err := s.reviews.Create(review); err != nil {
@@ -119,11 +176,12 @@ func buildListOptions(filters ListFilters) repository.ListOptions {
 	offset := (page - 1) * limit
 
 	return repository.ListOptions{
-		Query:   filters.Query,
-		SortBy:  filters.SortBy,
-		SortDir: filters.SortDir,
-		Limit:   limit,
-		Offset:  offset,
+		Query:                filters.Query,
+		CampusVerifiedAuthor: filters.CampusVerifiedOnly,
+		SortBy:               filters.SortBy,
+		SortDir:              filters.SortDir,
+		Limit:                limit,
+		Offset:               offset,
 	}
 }
 
@@ -155,6 +213,11 @@ func (s *ReviewService) listWithPagination(opts repository.ListOptions, filters
 	}, nil
 }
 
+// MaxImages returns how many images one review may carry, or zero when there is no limit.
+func (s *ReviewService) MaxImages() int {
+	return s.maxImages
+}
+
 // Get returns a review by ID.
 func (s *ReviewService) Get(id uuid.UUID) (*models.Review, error) {
 	return s.reviews.FindByID(id)
@@ -167,7 +230,11 @@ func (s *ReviewService) Approve(review *models.Review) error {
 	}
 	review.Status = models.ReviewStatusApproved
 	review.RejectionReason = ""
-	return s.reviews.Update(review)
+	if err := s.reviews.Update(review); err != nil {
+		return err
+	}
+	s.publish(context.Background(), models.WebhookEventReviewApproved, review)
+	return nil
 }
 
 // Reject marks a review as rejected with reason.
@@ -177,35 +244,178 @@ func (s *ReviewService) Reject(review *models.Review, reason string) error {
 	}
 	review.Status = models.ReviewStatusRejected
 	review.RejectionReason = strings.TrimSpace(reason)
-	return s.reviews.Update(review)
+	if err := s.reviews.Update(review); err != nil {
+		return err
+	}
+	s.publish(context.Background(), models.WebhookEventReviewRejected, review)
+	return nil
 }
 
-// StoreImage saves the uploaded file via storage provider and records metadata.
-func (s *ReviewService) StoreImage(ctx context.Context, reviewID uuid.UUID, file *storage.UploadFile) (*models.ReviewImage, error) {
+// StoreImage decodes the uploaded image and stores a copy re-encoded without metadata, such as the GPS
+// position of phone photos, together with resized JPEG renditions. It records their keys and dimensions and
+// places the image after the review's existing ones.
+func (s *ReviewService) StoreImage(ctx context.Context, reviewID uuid.UUID, file *storage.UploadFile, caption string) (*models.ReviewImage, error) {
 	if file == nil {
 		return nil, errors.New("file payload required")
 	}
 
 	defer file.Reader.Close()
 
-	key := filepath.ToSlash(filepath.Join(reviewID.String(), fmt.Sprintf("%d_%s", time.Now().UnixNano(), sanitizeFilename(file.Filename))))
+	caption, err := cleanCaption(caption)
+	if err != nil {
+		return nil, err
+	}
+	// Refuse full reviews before the costly decoding; AddImage checks the limit again when recording the image.
+	if s.maxImages > 0 {
+		count, err := s.reviews.CountImages(reviewID)
+		if err != nil {
+			return nil, err
+		}
+		if count >= int64(s.maxImages) {
+			return nil, ErrTooManyImages
+		}
+	}
+
+	sanitized, err := s.imageLimits.Sanitize(file.Reader)
+	if err != nil {
+		return nil, invalidImage(err)
+	}
+	img := sanitized.Image
+
+	name := sanitizeFilename(file.Filename)
+	name = strings.TrimSuffix(name, filepath.Ext(name)) + sanitized.Extension
+	key := filepath.ToSlash(filepath.Join(reviewID.String(), fmt.Sprintf("%d_%s", time.Now().UnixNano(), name)))
 
-	info, err := s.storage.Save(ctx, key, file.Reader, file.Size, file.ContentType)
+	info, err := s.storage.Save(ctx, key, bytes.NewReader(sanitized.Data), int64(len(sanitized.Data)), sanitized.ContentType)
 	if err != nil {
 		return nil, err
 	}
 
-	image := &models.ReviewImage{
+	record := &models.ReviewImage{
 		ReviewID:   reviewID,
 		StorageKey: info.Key,
 		URL:        info.URL,
+		Width:      img.Bounds().Dx(),
+		Height:     img.Bounds().Dy(),
+		Caption:    caption,
 	}
 
-	if err := s.reviews.AddImage(image); err != nil {
+	// Renditions are made from the next larger one, which is much cheaper than rescaling the original each time.
+	var source image.Image = img
+	for _, variant := range []struct {
+		name   string
+		width  int
+		target *models.ImageVariant
+	}{
+		{"large", reviewImageLargeWidth, &record.Large},
+		{"medium", reviewImageMediumWidth, &record.Medium},
+		{"thumb", reviewImageThumbnailWidth, &record.Thumbnail},
+	} {
+		resized := imaging.Fit(source, variant.width)
+		stored, err := s.storeVariant(ctx, variantKey(key, variant.name), resized)
+		if err != nil {
+			s.deleteFiles(ctx, record.StorageKeys())
+			return nil, fmt.Errorf("store %s image: %w", variant.name, err)
+		}
+		*variant.target = stored
+		source = resized
+	}
+
+	if err := s.reviews.AddImage(record, s.maxImages); err != nil {
+		s.deleteFiles(ctx, record.StorageKeys())
+		if errors.Is(err, repository.ErrImageLimit) {
+			return nil, ErrTooManyImages
+		}
 		return nil, err
 	}
 
-	return image, nil
+	return record, nil
+}
+
+func (s *ReviewService) storeVariant(ctx context.Context, key string, img *image.RGBA) (models.ImageVariant, error) {
+	data, err := imaging.EncodeJPEG(img, imaging.DefaultJPEGQuality)
+	if err != nil {
+		return models.ImageVariant{}, err
+	}
+	info, err := s.storage.Save(ctx, key, bytes.NewReader(data), int64(len(data)), "image/jpeg")
+	if err != nil {
+		return models.ImageVariant{}, err
+	}
+	return models.ImageVariant{Key: info.Key, URL: info.URL, Width: img.Rect.Dx(), Height: img.Rect.Dy()}, nil
+}
+
+// DeleteImage removes one image of a review together with its stored files.
+func (s *ReviewService) DeleteImage(ctx context.Context, reviewID, imageID uuid.UUID) error {
+	img, err := s.findImage(reviewID, imageID)
+	if err != nil {
+		return err
+	}
+	if err := s.reviews.DeleteImage(img.ID); err != nil {
+		return err
+	}
+	s.deleteFiles(ctx, img.StorageKeys())
+	return nil
+}
+
+// SetImageCaption replaces the caption of an image; an empty caption removes it.
+func (s *ReviewService) SetImageCaption(reviewID, imageID uuid.UUID, caption string) (*models.ReviewImage, error) {
+	caption, err := cleanCaption(caption)
+	if err != nil {
+		return nil, err
+	}
+	img, err := s.findImage(reviewID, imageID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.reviews.UpdateImageCaption(img.ID, caption); err != nil {
+		return nil, err
+	}
+	img.Caption = caption
+	return img, nil
+}
+
+// ReorderImages arranges the images of review in the order of ids and returns them in that order.
+func (s *ReviewService) ReorderImages(review *models.Review, ids []uuid.UUID) ([]models.ReviewImage, error) {
+	if len(ids) != len(review.Images) {
+		return nil, ErrInvalidImageOrder
+	}
+	current := make(map[uuid.UUID]bool, len(review.Images))
+	for _, img := range review.Images {
+		current[img.ID] = true
+	}
+	for _, id := range ids {
+		if !current[id] {
+			return nil, ErrInvalidImageOrder
+		}
+		// Dropping each id once seen also catches duplicates.
+		delete(current, id)
+	}
+
+	if err := s.reviews.ReorderImages(review.ID, ids); err != nil {
+		return nil, err
+	}
+	updated, err := s.reviews.FindByID(review.ID)
+	if err != nil {
+		return nil, err
+	}
+	return updated.Images, nil
+}
+
+func (s *ReviewService) findImage(reviewID, imageID uuid.UUID) (*models.ReviewImage, error) {
+	img, err := s.reviews.FindImage(reviewID, imageID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrImageNotFound
+	}
+	return img, err
+}
+
+// cleanCaption trims an image caption and checks its length.
+func cleanCaption(caption string) (string, error) {
+	caption = strings.TrimSpace(caption)
+	if utf8.RuneCountInString(caption) > MaxImageCaptionLength {
+		return "", ErrCaptionTooLong
+	}
+	return caption, nil
 }
 
 // DeleteReview removes a review and attempts to clean up related assets.
@@ -221,13 +431,8 @@ func (s *ReviewService) DeleteReview(ctx context.Context, review *models.Review)
 	}
 
 	var keys []string
-	if len(fullReview.Images) > 0 {
-		keys = make([]string, 0, len(fullReview.Images))
-		for _, image := range fullReview.Images {
-			if image.StorageKey != "" {
-				keys = append(keys, image.StorageKey)
-			}
-		}
+	for _, img := range fullReview.Images {
+		keys = append(keys, img.StorageKeys()...)
 	}
 
 	if err := s.reviews.Delete(review.ID); err != nil {
@@ -235,11 +440,35 @@ func (s *ReviewService) DeleteReview(ctx context.Context, review *models.Review)
 	}
 
 	// 清理存储中的图片文件，但忽略错误以避免删除失败
+	s.deleteFiles(ctx, keys)
+
+	s.publish(ctx, models.WebhookEventReviewDeleted, fullReview)
+	return nil
+}
+
+// publish notifies webhook subscribers; failures are logged and never undo the state change.
+func (s *ReviewService) publish(ctx context.Context, event string, review *models.Review) {
+	if err := s.webhooks.Publish(ctx, event, reviewWebhookData(review)); err != nil {
+		slog.Warn("publish review webhook failed",
+			slog.String("event", event),
+			slog.String("review_id", review.ID.String()),
+			slog.Any("error", err),
+		)
+	}
+}
+
+// deleteFiles removes stored images, logging failures since the database change already succeeded or failed.
+func (s *ReviewService) deleteFiles(ctx context.Context, keys []string) {
 	for _, key := range keys {
-		_ = s.storage.Delete(ctx, key)
+		if err := s.storage.Delete(ctx, key); err != nil {
+			slog.Warn("delete review image failed", slog.String("key", key), slog.Any("error", err))
+		}
 	}
+}
 
-	return nil
+// variantKey derives the key of a rendition from the original's key, e.g. "a/1_b.png" becomes "a/1_b_thumb.jpg".
+func variantKey(key, name string) string {
+	return strings.TrimSuffix(key, path.Ext(key)) + "_" + name + ".jpg"
 }
 
 func sanitizeFilename(name string) string {
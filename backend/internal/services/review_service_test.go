@@ -0,0 +1,223 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/hdu-dp/backend/internal/models"
+	"github.com/hdu-dp/backend/internal/repository"
+	"github.com/hdu-dp/backend/internal/storage"
+)
+
+func TestStoreImageCreatesVariantsAndDeleteRemovesThem(t *testing.T) {
+	db := newServiceTestDB(t, &models.User{}, &models.Review{}, &models.ReviewImage{}, &models.ReviewStats{}, &models.ReviewReaction{})
+	users := repository.NewUserRepository(db)
+	author := &models.User{Email: "dave@example.com", PasswordHash: "x", DisplayName: "Dave"}
+	if err := users.Create(author); err != nil {
+		t.Fatalf("create user failed: %v", err)
+	}
+
+	dir := t.TempDir()
+	local, err := storage.NewLocal(dir, "/api/v1/uploads")
+	if err != nil {
+		t.Fatalf("init storage failed: %v", err)
+	}
+	svc := NewReviewService(repository.NewReviewRepository(db), local, nil, ReviewOptions{})
+	review, err := svc.Submit(author, CreateReviewInput{Title: "东区食堂", Address: "东区一楼", Rating: 4})
+	if err != nil {
+		t.Fatalf("submit review failed: %v", err)
+	}
+
+	upload := func(name string, width, height int) (*models.ReviewImage, error) {
+		t.Helper()
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+			t.Fatalf("encode png failed: %v", err)
+		}
+		return svc.StoreImage(t.Context(), review.ID, &storage.UploadFile{
+			Reader:   io.NopCloser(&buf),
+			Size:     int64(buf.Len()),
+			Filename: name,
+		}, "")
+	}
+	stored := func(url string) string {
+		return filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(url, "/api/v1/uploads/")))
+	}
+
+	if _, err := svc.StoreImage(t.Context(), review.ID, &storage.UploadFile{
+		Reader:   io.NopCloser(strings.NewReader("not an image")),
+		Filename: "notes.txt",
+	}, ""); !errors.Is(err, ErrInvalidImage) {
+		t.Fatalf("expected a non-image upload to be refused, got %v", err)
+	}
+
+	wide, err := upload("wide.png", 2000, 1000)
+	if err != nil {
+		t.Fatalf("store image failed: %v", err)
+	}
+	if wide.Width != 2000 || wide.Height != 1000 {
+		t.Fatalf("expected the original dimensions, got %dx%d", wide.Width, wide.Height)
+	}
+	for _, tc := range []struct {
+		variant       models.ImageVariant
+		width, height int
+	}{
+		{wide.Large, 1600, 800},
+		{wide.Medium, 800, 400},
+		{wide.Thumbnail, 320, 160},
+	} {
+		if tc.variant.Width != tc.width || tc.variant.Height != tc.height {
+			t.Fatalf("expected a %dx%d variant, got %+v", tc.width, tc.height, tc.variant)
+		}
+		data, err := os.ReadFile(stored(tc.variant.URL))
+		if err != nil {
+			t.Fatalf("read variant failed: %v", err)
+		}
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("decode variant failed: %v", err)
+		}
+		if b := img.Bounds(); b.Dx() != tc.width || b.Dy() != tc.height {
+			t.Fatalf("expected a %dx%d file, got %v", tc.width, tc.height, b)
+		}
+	}
+
+	small, err := upload("small.png", 200, 100)
+	if err != nil {
+		t.Fatalf("store small image failed: %v", err)
+	}
+	if small.Thumbnail.Width != 200 || small.Large.Height != 100 {
+		t.Fatalf("expected small images not to be upscaled, got %+v / %+v", small.Thumbnail, small.Large)
+	}
+
+	listed, err := svc.ListByAuthor(author.ID, ListFilters{})
+	if err != nil || len(listed.Data) != 1 || len(listed.Data[0].Images) != 2 || listed.Data[0].Images[0].Thumbnail.URL == "" {
+		t.Fatalf("expected the list to carry thumbnail urls, got %+v (%v)", listed.Data, err)
+	}
+
+	keys := append(wide.StorageKeys(), small.StorageKeys()...)
+	if len(keys) != 8 {
+		t.Fatalf("expected an original and three variants per image, got %v", keys)
+	}
+	if err := svc.DeleteReview(t.Context(), review); err != nil {
+		t.Fatalf("delete review failed: %v", err)
+	}
+	for _, key := range keys {
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(key))); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("expected %s to be deleted, got %v", key, err)
+		}
+	}
+}
+
+func TestReviewImagesLimitCaptionsOrderAndDelete(t *testing.T) {
+	db := newServiceTestDB(t, &models.User{}, &models.Review{}, &models.ReviewImage{}, &models.ReviewStats{}, &models.ReviewReaction{})
+	users := repository.NewUserRepository(db)
+	author := &models.User{Email: "erin@example.com", PasswordHash: "x", DisplayName: "Erin"}
+	if err := users.Create(author); err != nil {
+		t.Fatalf("create user failed: %v", err)
+	}
+
+	dir := t.TempDir()
+	local, err := storage.NewLocal(dir, "/api/v1/uploads")
+	if err != nil {
+		t.Fatalf("init storage failed: %v", err)
+	}
+	svc := NewReviewService(repository.NewReviewRepository(db), local, nil, ReviewOptions{MaxImages: 3})
+	review, err := svc.Submit(author, CreateReviewInput{Title: "西区咖啡", Address: "西区二楼", Rating: 5})
+	if err != nil {
+		t.Fatalf("submit review failed: %v", err)
+	}
+
+	upload := func(caption string) (*models.ReviewImage, error) {
+		t.Helper()
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 10, 10))); err != nil {
+			t.Fatalf("encode png failed: %v", err)
+		}
+		return svc.StoreImage(t.Context(), review.ID, &storage.UploadFile{
+			Reader:   io.NopCloser(&buf),
+			Size:     int64(buf.Len()),
+			Filename: "photo.png",
+		}, caption)
+	}
+
+	if _, err := upload(strings.Repeat("长", MaxImageCaptionLength+1)); !errors.Is(err, ErrCaptionTooLong) {
+		t.Fatalf("expected an overlong caption to be refused, got %v", err)
+	}
+	var uploaded []*models.ReviewImage
+	for _, caption := range []string{" 招牌拿铁 ", "", "店内环境"} {
+		img, err := upload(caption)
+		if err != nil {
+			t.Fatalf("store image failed: %v", err)
+		}
+		uploaded = append(uploaded, img)
+	}
+	if uploaded[0].Caption != "招牌拿铁" || uploaded[2].Position != 2 {
+		t.Fatalf("expected a trimmed caption and appended positions, got %+v / %+v", uploaded[0], uploaded[2])
+	}
+	if _, err := upload(""); !errors.Is(err, ErrTooManyImages) {
+		t.Fatalf("expected the fourth image to be refused, got %v", err)
+	}
+	// An upload that passed the early count while another one was stored is refused when recorded.
+	late := &models.ReviewImage{ReviewID: review.ID, StorageKey: "late.png", URL: "/late.png"}
+	if err := repository.NewReviewRepository(db).AddImage(late, 3); !errors.Is(err, repository.ErrImageLimit) {
+		t.Fatalf("expected the repository to enforce the limit, got %v", err)
+	}
+
+	if _, err := svc.SetImageCaption(review.ID, uploaded[1].ID, "菜单"); err != nil {
+		t.Fatalf("set caption failed: %v", err)
+	}
+
+	review, _ = svc.Get(review.ID)
+	for _, ids := range [][]uuid.UUID{
+		{uploaded[0].ID, uploaded[1].ID},
+		{uploaded[0].ID, uploaded[0].ID, uploaded[1].ID},
+		{uploaded[0].ID, uploaded[1].ID, uuid.New()},
+	} {
+		if _, err := svc.ReorderImages(review, ids); !errors.Is(err, ErrInvalidImageOrder) {
+			t.Fatalf("expected %v to be refused, got %v", ids, err)
+		}
+	}
+	ordered, err := svc.ReorderImages(review, []uuid.UUID{uploaded[2].ID, uploaded[0].ID, uploaded[1].ID})
+	if err != nil {
+		t.Fatalf("reorder failed: %v", err)
+	}
+	if ordered[0].ID != uploaded[2].ID || ordered[2].ID != uploaded[1].ID || ordered[2].Caption != "菜单" {
+		t.Fatalf("expected the new order with captions, got %+v", ordered)
+	}
+
+	other, err := svc.Submit(author, CreateReviewInput{Title: "南区面馆", Address: "南区", Rating: 3})
+	if err != nil {
+		t.Fatalf("submit review failed: %v", err)
+	}
+	if err := svc.DeleteImage(t.Context(), other.ID, uploaded[0].ID); !errors.Is(err, ErrImageNotFound) {
+		t.Fatalf("expected an image of another review not to be found, got %v", err)
+	}
+	if err := svc.DeleteImage(t.Context(), review.ID, uploaded[0].ID); err != nil {
+		t.Fatalf("delete image failed: %v", err)
+	}
+	for _, key := range uploaded[0].StorageKeys() {
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(key))); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("expected %s to be deleted, got %v", key, err)
+		}
+	}
+
+	// The freed slot can be used again, and the new image goes last.
+	last, err := upload("")
+	if err != nil {
+		t.Fatalf("store image after delete failed: %v", err)
+	}
+	review, _ = svc.Get(review.ID)
+	if len(review.Images) != 3 || review.Images[0].ID != uploaded[2].ID || review.Images[2].ID != last.ID {
+		t.Fatalf("expected the detail to list images by position, got %+v", review.Images)
+	}
+}
@@ -0,0 +1,57 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// secretBox seals small secrets, such as TOTP seeds and signing keys, before they are stored.
+// It uses AES-256-GCM with a key derived from a configured passphrase.
+type secretBox struct {
+	aead cipher.AEAD
+}
+
+func newSecretBox(passphrase string) (*secretBox, error) {
+	if strings.TrimSpace(passphrase) == "" {
+		return nil, errors.New("encryption key is required")
+	}
+
+	key := sha256.Sum256([]byte(passphrase))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &secretBox{aead: aead}, nil
+}
+
+// seal encrypts plain and returns base64(nonce || ciphertext).
+func (b *secretBox) seal(plain []byte) (string, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := b.aead.Seal(nonce, nonce, plain, nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// open reverses seal.
+func (b *secretBox) open(sealed string) ([]byte, error) {
+	raw, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, err
+	}
+	size := b.aead.NonceSize()
+	if len(raw) < size {
+		return nil, errors.New("sealed secret too short")
+	}
+	return b.aead.Open(nil, raw[:size], raw[size:], nil)
+}
@@ -0,0 +1,223 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hdu-dp/backend/internal/common"
+	"github.com/hdu-dp/backend/internal/models"
+	"github.com/hdu-dp/backend/internal/repository"
+)
+
+const (
+	maxDeviceLabelLength = 100
+	maxUserAgentLength   = 512
+
+	// refreshReuseGrace tolerates a rotated token being presented again shortly after rotation,
+	// which happens when a client retries or two tabs refresh at once, without treating it as theft.
+	refreshReuseGrace = 10 * time.Second
+)
+
+// ErrSessionNotFound indicates the session does not exist, belongs to someone else or was already revoked.
+var ErrSessionNotFound = errors.New("session not found")
+
+// ClientInfo describes the client that signs in or refreshes a session.
+// DeviceName is an optional label chosen by the client; otherwise one is derived from UserAgent.
+type ClientInfo struct {
+	IP         string
+	UserAgent  string
+	DeviceName string
+}
+
+// Session is the user-facing view of an active refresh token.
+type Session struct {
+	ID          uuid.UUID `json:"id"`
+	DeviceLabel string    `json:"device_label"`
+	UserAgent   string    `json:"user_agent"`
+	IP          string    `json:"ip"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastUsedAt  time.Time `json:"last_used_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	Current     bool      `json:"current"`
+}
+
+// SessionService lists and revokes the signed-in sessions of a user.
+type SessionService struct {
+	refreshTokens *repository.RefreshTokenRepository
+	now           func() time.Time
+}
+
+// NewSessionService constructs a SessionService.
+func NewSessionService(refreshTokens *repository.RefreshTokenRepository) *SessionService {
+	return &SessionService{refreshTokens: refreshTokens, now: time.Now}
+}
+
+// List returns the active sessions of userID and flags currentID, if any, as the caller's own.
+func (s *SessionService) List(userID, currentID uuid.UUID) ([]Session, error) {
+	tokens, err := s.refreshTokens.ListActiveByUser(userID, s.now())
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]Session, 0, len(tokens))
+	for _, token := range tokens {
+		sessions = append(sessions, Session{
+			ID:          token.ID,
+			DeviceLabel: token.DeviceLabel,
+			UserAgent:   token.UserAgent,
+			IP:          token.IP,
+			CreatedAt:   token.CreatedAt,
+			LastUsedAt:  token.LastUsedAt,
+			ExpiresAt:   token.ExpiresAt,
+			Current:     currentID != uuid.Nil && token.ID == currentID,
+		})
+	}
+	return sessions, nil
+}
+
+// Revoke signs out one session of userID.
+func (s *SessionService) Revoke(userID, sessionID uuid.UUID) error {
+	revoked, err := s.refreshTokens.RevokeForUser(userID, sessionID)
+	if err != nil {
+		return err
+	}
+	if !revoked {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// RevokeOthers signs out every session of userID except currentID and returns how many were revoked.
+func (s *SessionService) RevokeOthers(userID, currentID uuid.UUID) (int64, error) {
+	return s.refreshTokens.RevokeAllForUserExcept(userID, currentID)
+}
+
+// RevokeAll signs out every session of userID.
+func (s *SessionService) RevokeAll(userID uuid.UUID) error {
+	return s.refreshTokens.RevokeAllForUser(userID)
+}
+
+// newSession prepares the refresh token row for a fresh sign-in.
+func newSession(client ClientInfo, now time.Time) *models.RefreshToken {
+	label := truncate(strings.TrimSpace(client.DeviceName), maxDeviceLabelLength)
+	if label == "" {
+		label = deviceLabel(client.UserAgent)
+	}
+	return &models.RefreshToken{
+		DeviceLabel: label,
+		UserAgent:   truncate(client.UserAgent, maxUserAgentLength),
+		IP:          client.IP,
+		LastUsedAt:  now,
+	}
+}
+
+// rotateSession prepares the refresh token row that replaces previous, keeping the session identity.
+func rotateSession(previous *models.RefreshToken, client ClientInfo, now time.Time) *models.RefreshToken {
+	parentID := previous.ID
+	next := newSession(client, now)
+	next.FamilyID = previous.Family()
+	next.ParentID = &parentID
+	next.DeviceLabel = previous.DeviceLabel
+	next.CreatedAt = previous.CreatedAt
+	if next.UserAgent == "" {
+		next.UserAgent = previous.UserAgent
+	}
+	if next.IP == "" {
+		next.IP = previous.IP
+	}
+	return next
+}
+
+// handleRefreshReuse reacts to an already rotated refresh token being presented again. Outside the
+// grace period this means a copy of the token is in someone else's hands, so every token of the family
+// is revoked and the user is told which session was affected.
+func (s *AuthService) handleRefreshReuse(ctx context.Context, reused *models.RefreshToken, client ClientInfo, now time.Time) error {
+	if now.Sub(*reused.RotatedAt) <= refreshReuseGrace {
+		return common.ErrInvalidRefreshToken
+	}
+
+	revoked, err := s.refreshTokens.RevokeFamily(reused.Family())
+	if err != nil {
+		return err
+	}
+
+	slog.Warn("refresh token reuse detected",
+		slog.String("event", "security.refresh_token_reuse"),
+		slog.String("user_id", reused.UserID.String()),
+		slog.String("family_id", reused.Family().String()),
+		slog.String("token_id", reused.ID.String()),
+		slog.String("ip", client.IP),
+		slog.String("user_agent", client.UserAgent),
+		slog.Int64("revoked", revoked),
+	)
+
+	if revoked > 0 {
+		if user, err := s.users.FindByID(reused.UserID); err == nil {
+			s.accountMail.SendSessionRevoked(ctx, user, reused.DeviceLabel, client.IP, now)
+		}
+	}
+	return common.ErrInvalidRefreshToken
+}
+
+// deviceLabel derives a short human readable label such as "Chrome on Windows" from a User-Agent.
+func deviceLabel(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	if ua == "" {
+		return "未知设备"
+	}
+
+	var app string
+	switch {
+	case strings.Contains(ua, "micromessenger"):
+		app = "WeChat"
+	case strings.Contains(ua, " qq/"):
+		app = "QQ"
+	case strings.Contains(ua, "edg/"):
+		app = "Edge"
+	case strings.Contains(ua, "firefox/"):
+		app = "Firefox"
+	case strings.Contains(ua, "chrome/") || strings.Contains(ua, "crios/"):
+		app = "Chrome"
+	case strings.Contains(ua, "safari/"):
+		app = "Safari"
+	}
+
+	var platform string
+	switch {
+	case strings.Contains(ua, "iphone"):
+		platform = "iPhone"
+	case strings.Contains(ua, "ipad"):
+		platform = "iPad"
+	case strings.Contains(ua, "android"):
+		platform = "Android"
+	case strings.Contains(ua, "windows"):
+		platform = "Windows"
+	case strings.Contains(ua, "mac os"):
+		platform = "macOS"
+	case strings.Contains(ua, "linux"):
+		platform = "Linux"
+	}
+
+	switch {
+	case app != "" && platform != "":
+		return app + " on " + platform
+	case platform != "":
+		return platform
+	case app != "":
+		return app
+	default:
+		return truncate(userAgent, 40)
+	}
+}
+
+func truncate(value string, limit int) string {
+	runes := []rune(value)
+	if len(runes) <= limit {
+		return value
+	}
+	return string(runes[:limit])
+}
@@ -0,0 +1,227 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/hdu-dp/backend/internal/auth"
+	"github.com/hdu-dp/backend/internal/models"
+	"github.com/hdu-dp/backend/internal/repository"
+)
+
+const defaultSigningKeyRefresh = time.Minute
+
+// SigningKeyOptions configures asymmetric signing of access tokens.
+type SigningKeyOptions struct {
+	// Algorithm is RS256 or EdDSA for keys generated here; HS256 or empty keeps the shared secret.
+	Algorithm string
+	// KeysDir loads keys from *.pem files instead of the database; the last file by name signs.
+	KeysDir string
+	// RotationInterval is how long a database key signs before it is replaced; zero disables rotation.
+	RotationInterval time.Duration
+	// RefreshInterval is how often keys are reloaded, so every instance picks up rotations.
+	RefreshInterval time.Duration
+	// PublishDelay is how long a new database key is published for verification only before it signs, so
+	// that other instances and JWKS clients know it first. Defaults to RefreshInterval plus JWKS caching.
+	PublishDelay time.Duration
+	// AccessTokenTTL bounds how long a retired key must keep verifying tokens it signed.
+	AccessTokenTTL time.Duration
+	// EncryptionKey seals private keys stored in the database.
+	EncryptionKey string
+}
+
+func (o SigningKeyOptions) withDefaults() SigningKeyOptions {
+	if o.RefreshInterval <= 0 {
+		o.RefreshInterval = defaultSigningKeyRefresh
+	}
+	if o.PublishDelay <= 0 {
+		o.PublishDelay = o.RefreshInterval + auth.JWKSMaxAge
+	}
+	return o
+}
+
+// SigningKeyService keeps the key set of the JWT manager up to date from files or the database
+// and rotates database keys on schedule.
+type SigningKeyService struct {
+	repo    *repository.SigningKeyRepository
+	keys    *auth.KeySet
+	box     *secretBox
+	options SigningKeyOptions
+	now     func() time.Time
+}
+
+// NewSigningKeyService constructs a SigningKeyService that installs keys into keys.
+func NewSigningKeyService(repo *repository.SigningKeyRepository, keys *auth.KeySet, options SigningKeyOptions) (*SigningKeyService, error) {
+	options = options.withDefaults()
+	s := &SigningKeyService{repo: repo, keys: keys, options: options, now: time.Now}
+	if !s.Enabled() {
+		return s, nil
+	}
+
+	if options.KeysDir == "" {
+		if options.Algorithm != auth.AlgorithmRS256 && options.Algorithm != auth.AlgorithmEdDSA {
+			return nil, fmt.Errorf("unsupported jwt signing algorithm %q", options.Algorithm)
+		}
+		box, err := newSecretBox(options.EncryptionKey)
+		if err != nil {
+			return nil, fmt.Errorf("signing keys: %w", err)
+		}
+		s.box = box
+	}
+	return s, nil
+}
+
+// Enabled reports whether asymmetric signing is configured.
+func (s *SigningKeyService) Enabled() bool {
+	return s.options.KeysDir != "" || (s.options.Algorithm != "" && s.options.Algorithm != auth.AlgorithmHS256)
+}
+
+// Refresh reloads the keys, rotating the database key first when it is missing or due.
+func (s *SigningKeyService) Refresh(ctx context.Context) error {
+	if !s.Enabled() {
+		return nil
+	}
+	if s.options.KeysDir != "" {
+		return s.loadDir()
+	}
+
+	now := s.now()
+	stored, err := s.repo.ListUsable(ctx, now)
+	if err != nil {
+		return err
+	}
+	newest := newestSigningKey(stored)
+	if newest == nil || (s.options.RotationInterval > 0 && now.Sub(newest.Activation()) >= s.options.RotationInterval) {
+		if err := s.rotate(ctx, newest, now); err != nil && !errors.Is(err, repository.ErrSigningKeyChanged) {
+			return err
+		}
+		// Whether this instance or another one rotated, the new key is in the database now.
+		if stored, err = s.repo.ListUsable(ctx, now); err != nil {
+			return err
+		}
+	}
+	if err := s.repo.DeleteExpired(ctx, now); err != nil {
+		slog.Warn("delete expired signing keys failed", slog.Any("error", err))
+	}
+
+	var signing *auth.SigningKey
+	verify := make([]*auth.SigningKey, 0, len(stored))
+	for i := range stored {
+		key, err := s.decode(&stored[i])
+		if err != nil {
+			slog.Error("load signing key failed", slog.String("kid", stored[i].ID), slog.Any("error", err))
+			continue
+		}
+		verify = append(verify, key)
+		if stored[i].SignsAt(now) {
+			signing = key
+		}
+	}
+	if signing == nil {
+		return fmt.Errorf("no usable signing key")
+	}
+	s.keys.Replace(signing, verify)
+	return nil
+}
+
+// rotate stores a new database key to replace current, or the first key when current is nil. The new key
+// is only published for PublishDelay, while current keeps signing; current then keeps verifying for one
+// access token lifetime plus a refresh interval, covering tokens signed by instances that have not reloaded.
+// It returns repository.ErrSigningKeyChanged when another instance rotated current first.
+func (s *SigningKeyService) rotate(ctx context.Context, current *models.SigningKey, now time.Time) error {
+	if s.box == nil {
+		return fmt.Errorf("signing keys are not stored in the database")
+	}
+
+	key, err := auth.GenerateKey(s.options.Algorithm)
+	if err != nil {
+		return err
+	}
+	pem, err := key.MarshalPrivateKeyPEM()
+	if err != nil {
+		return err
+	}
+	sealed, err := s.box.seal(pem)
+	if err != nil {
+		return err
+	}
+
+	// With no key at all nothing else can be signing yet, so the first key is used right away.
+	activatesAt := now
+	currentID := ""
+	if current != nil {
+		activatesAt = now.Add(s.options.PublishDelay)
+		currentID = current.ID
+	}
+	record := &models.SigningKey{ID: key.ID, Algorithm: key.Algorithm, PrivateKeyEnc: sealed, CreatedAt: now, ActivatesAt: &activatesAt}
+	verifyUntil := activatesAt.Add(s.options.AccessTokenTTL + s.options.RefreshInterval)
+	if err := s.repo.Rotate(ctx, record, currentID, verifyUntil); err != nil {
+		return err
+	}
+	slog.Info("rotated jwt signing key",
+		slog.String("kid", key.ID),
+		slog.String("alg", key.Algorithm),
+		slog.Time("activates_at", activatesAt),
+	)
+	return nil
+}
+
+// Run refreshes keys every RefreshInterval until ctx is cancelled.
+func (s *SigningKeyService) Run(ctx context.Context) {
+	if !s.Enabled() {
+		return
+	}
+
+	ticker := time.NewTicker(s.options.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := s.Refresh(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("refresh jwt signing keys failed", slog.Any("error", err))
+		}
+	}
+}
+
+func (s *SigningKeyService) loadDir() error {
+	keys, err := auth.LoadKeyDir(s.options.KeysDir)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("no *.pem signing keys found in %s", s.options.KeysDir)
+	}
+	s.keys.Replace(keys[len(keys)-1], keys)
+	return nil
+}
+
+func (s *SigningKeyService) decode(record *models.SigningKey) (*auth.SigningKey, error) {
+	pem, err := s.box.open(record.PrivateKeyEnc)
+	if err != nil {
+		return nil, err
+	}
+	key, err := auth.ParsePrivateKeyPEM(record.ID, pem)
+	if err != nil {
+		return nil, err
+	}
+	key.CreatedAt = record.CreatedAt
+	return key, nil
+}
+
+// newestSigningKey returns the key that is not retired, which signs now or will once activated.
+func newestSigningKey(keys []models.SigningKey) *models.SigningKey {
+	var newest *models.SigningKey
+	for i := range keys {
+		if keys[i].RetiredAt == nil {
+			newest = &keys[i]
+		}
+	}
+	return newest
+}
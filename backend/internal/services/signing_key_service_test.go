@@ -0,0 +1,148 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hdu-dp/backend/internal/auth"
+	"github.com/hdu-dp/backend/internal/models"
+	"github.com/hdu-dp/backend/internal/repository"
+)
+
+func TestSigningKeyRotationKeepsOldTokensValid(t *testing.T) {
+	db := newServiceTestDB(t, &models.SigningKey{})
+
+	keys := auth.NewKeySet()
+	svc, err := NewSigningKeyService(repository.NewSigningKeyRepository(db), keys, SigningKeyOptions{
+		Algorithm:        auth.AlgorithmEdDSA,
+		RotationInterval: 24 * time.Hour,
+		AccessTokenTTL:   15 * time.Minute,
+		EncryptionKey:    "test-secret",
+	})
+	if err != nil {
+		t.Fatalf("new signing key service failed: %v", err)
+	}
+	now := time.Now()
+	svc.now = func() time.Time { return now }
+
+	if err := svc.Refresh(t.Context()); err != nil {
+		t.Fatalf("initial refresh failed: %v", err)
+	}
+	first := keys.Signing()
+	if first == nil || first.Algorithm != auth.AlgorithmEdDSA {
+		t.Fatalf("expected an EdDSA key to be generated, got %+v", first)
+	}
+
+	legacy := auth.NewJWTManager("test-secret", 15*time.Minute)
+	legacyToken, err := legacy.Generate(&models.User{ID: uuid.New(), Role: "user"}, "session")
+	if err != nil {
+		t.Fatalf("generate hs256 token failed: %v", err)
+	}
+
+	manager := auth.NewJWTManager("test-secret", 15*time.Minute)
+	manager.UseKeys(keys, true)
+	user := &models.User{ID: uuid.New(), Role: "user"}
+	oldToken, err := manager.Generate(user, "session")
+	if err != nil {
+		t.Fatalf("generate token failed: %v", err)
+	}
+	if _, err := manager.Parse(legacyToken); err != nil {
+		t.Fatalf("expected hs256 tokens to be accepted during the transition: %v", err)
+	}
+
+	now = now.Add(25 * time.Hour)
+	if err := svc.Refresh(t.Context()); err != nil {
+		t.Fatalf("rotating refresh failed: %v", err)
+	}
+	if keys.Signing().ID != first.ID {
+		t.Fatalf("expected the current key to keep signing while the new one is published")
+	}
+	if jwks := keys.JWKS(); len(jwks) != 2 || jwks[0].KeyType != "OKP" {
+		t.Fatalf("expected the new key to be published before it signs, got %+v", jwks)
+	}
+
+	// Another instance refreshing in the meantime verifies with the new key but does not rotate again.
+	otherKeys := auth.NewKeySet()
+	other, err := NewSigningKeyService(repository.NewSigningKeyRepository(db), otherKeys, SigningKeyOptions{
+		Algorithm:        auth.AlgorithmEdDSA,
+		RotationInterval: 24 * time.Hour,
+		AccessTokenTTL:   15 * time.Minute,
+		EncryptionKey:    "test-secret",
+	})
+	if err != nil {
+		t.Fatalf("new signing key service failed: %v", err)
+	}
+	other.now = svc.now
+	if err := other.Refresh(t.Context()); err != nil {
+		t.Fatalf("other instance refresh failed: %v", err)
+	}
+	if otherKeys.Signing().ID != first.ID || len(otherKeys.JWKS()) != 2 {
+		t.Fatalf("expected the other instance to sign with the current key and know the new one")
+	}
+
+	now = now.Add(svc.options.PublishDelay)
+	if err := svc.Refresh(t.Context()); err != nil {
+		t.Fatalf("refresh after the publish delay failed: %v", err)
+	}
+	second := keys.Signing()
+	if second == nil || second.ID == first.ID {
+		t.Fatalf("expected the new key to sign once its publish delay passed")
+	}
+	if otherKeys.Lookup(second.ID) == nil {
+		t.Fatalf("expected tokens from the new key to verify on the other instance before it reloads")
+	}
+	if claims, err := manager.Parse(oldToken); err != nil || claims.UserID != user.ID.String() {
+		t.Fatalf("expected a token from the retired key to verify, got %+v (%v)", claims, err)
+	}
+
+	now = now.Add(time.Hour)
+	if err := svc.Refresh(t.Context()); err != nil {
+		t.Fatalf("refresh failed: %v", err)
+	}
+	if _, err := manager.Parse(oldToken); err == nil {
+		t.Fatalf("expected the retired key to stop verifying after its grace period")
+	}
+	if len(keys.JWKS()) != 1 {
+		t.Fatalf("expected only the active key to be published")
+	}
+
+	manager.UseKeys(keys, false)
+	if _, err := manager.Parse(legacyToken); err == nil {
+		t.Fatalf("expected hs256 tokens to be refused once the fallback is disabled")
+	}
+}
+
+func TestSigningKeyRotationIsConditional(t *testing.T) {
+	db := newServiceTestDB(t, &models.SigningKey{})
+	repo := repository.NewSigningKeyRepository(db)
+	now := time.Now()
+	key := func(id string) *models.SigningKey {
+		return &models.SigningKey{ID: id, Algorithm: auth.AlgorithmEdDSA, PrivateKeyEnc: "sealed", CreatedAt: now, ActivatesAt: &now}
+	}
+
+	// Instances starting together on an empty database agree on a single first key.
+	if err := repo.Rotate(t.Context(), key("a"), "", now); err != nil {
+		t.Fatalf("first key failed: %v", err)
+	}
+	if err := repo.Rotate(t.Context(), key("b"), "", now); !errors.Is(err, repository.ErrSigningKeyChanged) {
+		t.Fatalf("expected a second first key to be refused, got %v", err)
+	}
+
+	// Only one rotation away from a given key succeeds.
+	if err := repo.Rotate(t.Context(), key("c"), "a", now.Add(time.Hour)); err != nil {
+		t.Fatalf("rotate failed: %v", err)
+	}
+	if err := repo.Rotate(t.Context(), key("d"), "a", now.Add(time.Hour)); !errors.Is(err, repository.ErrSigningKeyChanged) {
+		t.Fatalf("expected a concurrent rotation to be refused, got %v", err)
+	}
+
+	stored, err := repo.ListUsable(t.Context(), now)
+	if err != nil {
+		t.Fatalf("list keys failed: %v", err)
+	}
+	if len(stored) != 2 || newestSigningKey(stored).ID != "c" {
+		t.Fatalf("expected keys a and c with c newest, got %+v", stored)
+	}
+}
@@ -0,0 +1,148 @@
+package services
+
+import (
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hdu-dp/backend/internal/config"
+	"github.com/hdu-dp/backend/internal/emailtemplate"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// fakeSMTPServer speaks just enough SMTP for net/smtp to deliver a message.
+type fakeSMTPServer struct {
+	listener net.Listener
+
+	mu       sync.Mutex
+	messages []string
+	reject   bool
+}
+
+func newFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen fake smtp: %v", err)
+	}
+
+	srv := &fakeSMTPServer{listener: ln}
+	go srv.serve()
+	t.Cleanup(func() { _ = ln.Close() })
+	return srv
+}
+
+func (s *fakeSMTPServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeSMTPServer) handle(conn net.Conn) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+
+	_ = tp.PrintfLine("220 localhost ESMTP fake")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+
+		switch cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); cmd {
+		case "EHLO", "HELO":
+			_ = tp.PrintfLine("250-localhost")
+			_ = tp.PrintfLine("250 AUTH PLAIN")
+		case "AUTH":
+			_ = tp.PrintfLine("235 2.7.0 authenticated")
+		case "MAIL":
+			s.mu.Lock()
+			reject := s.reject
+			s.mu.Unlock()
+			if reject {
+				_ = tp.PrintfLine("451 4.3.0 try again later")
+				continue
+			}
+			_ = tp.PrintfLine("250 OK")
+		case "RCPT", "RSET", "NOOP":
+			_ = tp.PrintfLine("250 OK")
+		case "DATA":
+			_ = tp.PrintfLine("354 go ahead")
+			lines, err := tp.ReadDotLines()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, strings.Join(lines, "\n"))
+			s.mu.Unlock()
+			_ = tp.PrintfLine("250 OK queued")
+		case "QUIT":
+			_ = tp.PrintfLine("221 bye")
+			return
+		default:
+			_ = tp.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+func (s *fakeSMTPServer) setReject(reject bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reject = reject
+}
+
+func (s *fakeSMTPServer) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.messages...)
+}
+
+func (s *fakeSMTPServer) emailConfig() *config.EmailConfig {
+	addr := s.listener.Addr().(*net.TCPAddr)
+	return &config.EmailConfig{
+		SMTPHost:     "127.0.0.1",
+		SMTPPort:     addr.Port,
+		SMTPUsername: "user",
+		SMTPPassword: "pass",
+		SMTPTimeout:  2 * time.Second,
+		FromEmail:    "noreply@example.com",
+		FromName:     "Test",
+	}
+}
+
+// newServiceTestDB opens a fresh in-memory database with only the given tables migrated.
+func newServiceTestDB(t *testing.T, tables ...any) *gorm.DB {
+	t.Helper()
+
+	dsn := fmt.Sprintf("file:%s_%d?mode=memory&cache=shared", strings.ReplaceAll(t.Name(), "/", "_"), time.Now().UnixNano())
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open sqlite failed: %v", err)
+	}
+
+	if err := db.AutoMigrate(tables...); err != nil {
+		t.Fatalf("auto migrate failed: %v", err)
+	}
+
+	return db
+}
+
+func newTestEmailTemplates(t *testing.T) *emailtemplate.Renderer {
+	t.Helper()
+
+	templates, err := emailtemplate.New("", emailtemplate.LocaleZhCN)
+	if err != nil {
+		t.Fatalf("load email templates failed: %v", err)
+	}
+	return templates
+}
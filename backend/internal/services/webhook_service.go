@@ -0,0 +1,585 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hdu-dp/backend/internal/models"
+	"github.com/hdu-dp/backend/internal/repository"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrWebhookNotFound indicates the webhook endpoint cannot be found.
+	ErrWebhookNotFound = errors.New("webhook endpoint not found")
+	// ErrWebhookDeliveryNotFound indicates the webhook delivery cannot be found.
+	ErrWebhookDeliveryNotFound = errors.New("webhook delivery not found")
+	// ErrInvalidWebhookURL indicates the endpoint URL is not an absolute http(s) URL.
+	ErrInvalidWebhookURL = errors.New("invalid webhook url")
+	// ErrInvalidWebhookEvents indicates an empty or unknown event subscription.
+	ErrInvalidWebhookEvents = errors.New("invalid webhook events")
+)
+
+// Headers attached to every webhook request.
+const (
+	WebhookHeaderEvent     = "X-Webhook-Event"
+	WebhookHeaderDelivery  = "X-Webhook-Delivery"
+	WebhookHeaderTimestamp = "X-Webhook-Timestamp"
+	WebhookHeaderSignature = "X-Webhook-Signature"
+)
+
+const (
+	defaultWebhookMaxAttempts  = 8
+	defaultWebhookTimeout      = 10 * time.Second
+	defaultWebhookPollInterval = 5 * time.Second
+	defaultWebhookBaseBackoff  = 30 * time.Second
+	defaultWebhookMaxBackoff   = 6 * time.Hour
+	defaultWebhookBatchSize    = 20
+	defaultWebhookRetention    = 30 * 24 * time.Hour
+	webhookCleanupInterval     = time.Hour
+	webhookResponseBodyLimit   = 1024
+)
+
+// WebhookOptions groups tuning options for webhook delivery.
+type WebhookOptions struct {
+	MaxAttempts  int
+	Timeout      time.Duration
+	PollInterval time.Duration
+	BaseBackoff  time.Duration
+	MaxBackoff   time.Duration
+	BatchSize    int
+	Retention    time.Duration
+}
+
+// WebhookEndpointInput describes the admin-editable fields of an endpoint.
+type WebhookEndpointInput struct {
+	Name    string
+	URL     string
+	Secret  string
+	Events  []string
+	Enabled *bool
+}
+
+// WebhookEnvelope is the JSON body posted to endpoints.
+type WebhookEnvelope struct {
+	ID        uuid.UUID `json:"id"`
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// WebhookService manages webhook endpoints and delivers signed event payloads from a persisted queue.
+type WebhookService struct {
+	webhooks     *repository.WebhookRepository
+	client       *http.Client
+	maxAttempts  int
+	pollInterval time.Duration
+	baseBackoff  time.Duration
+	maxBackoff   time.Duration
+	batchSize    int
+	retention    time.Duration
+	wake         chan struct{}
+	now          func() time.Time
+}
+
+// NewWebhookService constructs a webhook service.
+func NewWebhookService(webhooks *repository.WebhookRepository, options WebhookOptions) *WebhookService {
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = defaultWebhookMaxAttempts
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = defaultWebhookTimeout
+	}
+	if options.PollInterval <= 0 {
+		options.PollInterval = defaultWebhookPollInterval
+	}
+	if options.BaseBackoff <= 0 {
+		options.BaseBackoff = defaultWebhookBaseBackoff
+	}
+	if options.MaxBackoff <= 0 {
+		options.MaxBackoff = defaultWebhookMaxBackoff
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = defaultWebhookBatchSize
+	}
+	if options.Retention <= 0 {
+		options.Retention = defaultWebhookRetention
+	}
+
+	return &WebhookService{
+		webhooks: webhooks,
+		client: &http.Client{
+			Timeout: options.Timeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		maxAttempts:  options.MaxAttempts,
+		pollInterval: options.PollInterval,
+		baseBackoff:  options.BaseBackoff,
+		maxBackoff:   options.MaxBackoff,
+		batchSize:    options.BatchSize,
+		retention:    options.Retention,
+		wake:         make(chan struct{}, 1),
+		now:          time.Now,
+	}
+}
+
+// SignWebhookPayload returns the hex HMAC-SHA256 of "<timestamp>.<body>" keyed by the endpoint secret.
+// Receivers should recompute it and compare against the X-Webhook-Signature header ("sha256=<hex>").
+func SignWebhookPayload(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// CreateEndpoint registers a new endpoint, generating a signing secret when none is supplied.
+func (s *WebhookService) CreateEndpoint(ctx context.Context, input WebhookEndpointInput) (*models.WebhookEndpoint, error) {
+	endpointURL, err := normalizeWebhookURL(input.URL)
+	if err != nil {
+		return nil, err
+	}
+	events, err := normalizeWebhookEvents(input.Events)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := strings.TrimSpace(input.Secret)
+	if secret == "" {
+		if secret, err = generateWebhookSecret(); err != nil {
+			return nil, err
+		}
+	}
+
+	name := strings.TrimSpace(input.Name)
+	if name == "" {
+		name = endpointURL
+	}
+
+	endpoint := &models.WebhookEndpoint{
+		Name:    name,
+		URL:     endpointURL,
+		Secret:  secret,
+		Events:  strings.Join(events, ","),
+		Enabled: input.Enabled == nil || *input.Enabled,
+	}
+	if err := s.webhooks.CreateEndpoint(ctx, endpoint); err != nil {
+		return nil, err
+	}
+	return endpoint, nil
+}
+
+// UpdateEndpoint applies the non-empty fields of input to an existing endpoint.
+func (s *WebhookService) UpdateEndpoint(ctx context.Context, id uuid.UUID, input WebhookEndpointInput) (*models.WebhookEndpoint, error) {
+	endpoint, err := s.findEndpoint(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if name := strings.TrimSpace(input.Name); name != "" {
+		endpoint.Name = name
+	}
+	if strings.TrimSpace(input.URL) != "" {
+		endpointURL, err := normalizeWebhookURL(input.URL)
+		if err != nil {
+			return nil, err
+		}
+		endpoint.URL = endpointURL
+	}
+	if secret := strings.TrimSpace(input.Secret); secret != "" {
+		endpoint.Secret = secret
+	}
+	if input.Events != nil {
+		events, err := normalizeWebhookEvents(input.Events)
+		if err != nil {
+			return nil, err
+		}
+		endpoint.Events = strings.Join(events, ",")
+	}
+	if input.Enabled != nil {
+		endpoint.Enabled = *input.Enabled
+	}
+
+	if err := s.webhooks.SaveEndpoint(ctx, endpoint); err != nil {
+		return nil, err
+	}
+	return endpoint, nil
+}
+
+// DeleteEndpoint removes an endpoint and its delivery log.
+func (s *WebhookService) DeleteEndpoint(ctx context.Context, id uuid.UUID) error {
+	if _, err := s.findEndpoint(ctx, id); err != nil {
+		return err
+	}
+	return s.webhooks.DeleteEndpoint(ctx, id)
+}
+
+// GetEndpoint returns a single endpoint.
+func (s *WebhookService) GetEndpoint(ctx context.Context, id uuid.UUID) (*models.WebhookEndpoint, error) {
+	return s.findEndpoint(ctx, id)
+}
+
+// ListEndpoints returns all configured endpoints.
+func (s *WebhookService) ListEndpoints(ctx context.Context) ([]models.WebhookEndpoint, error) {
+	return s.webhooks.ListEndpoints(ctx)
+}
+
+// ListDeliveries returns the delivery log of an endpoint.
+func (s *WebhookService) ListDeliveries(ctx context.Context, endpointID uuid.UUID, page, pageSize int) ([]models.WebhookDelivery, int64, error) {
+	if _, err := s.findEndpoint(ctx, endpointID); err != nil {
+		return nil, 0, err
+	}
+	if page <= 0 {
+		page = 1
+	}
+	if pageSize <= 0 {
+		pageSize = 20
+	}
+	return s.webhooks.ListDeliveries(ctx, endpointID, (page-1)*pageSize, pageSize)
+}
+
+// Redeliver queues a fresh copy of a previous delivery's payload for the same endpoint.
+func (s *WebhookService) Redeliver(ctx context.Context, deliveryID uuid.UUID) (*models.WebhookDelivery, error) {
+	original, err := s.webhooks.FindDelivery(ctx, deliveryID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrWebhookDeliveryNotFound
+		}
+		return nil, err
+	}
+	if _, err := s.findEndpoint(ctx, original.EndpointID); err != nil {
+		return nil, err
+	}
+
+	originalID := original.ID
+	delivery := &models.WebhookDelivery{
+		EndpointID:    original.EndpointID,
+		Event:         original.Event,
+		Payload:       original.Payload,
+		Status:        models.WebhookDeliveryStatusPending,
+		MaxAttempts:   s.maxAttempts,
+		NextAttemptAt: s.now(),
+		RedeliveryOf:  &originalID,
+	}
+	if err := s.webhooks.CreateDelivery(ctx, delivery); err != nil {
+		return nil, err
+	}
+
+	s.nudge()
+	return delivery, nil
+}
+
+// Publish queues an event for every enabled endpoint subscribed to it. A nil service is a no-op.
+func (s *WebhookService) Publish(ctx context.Context, event string, data any) error {
+	if s == nil {
+		return nil
+	}
+
+	endpoints, err := s.webhooks.ListEnabledEndpoints(ctx)
+	if err != nil {
+		return fmt.Errorf("list webhook endpoints: %w", err)
+	}
+
+	var targets []models.WebhookEndpoint
+	for _, endpoint := range endpoints {
+		if endpoint.Subscribes(event) {
+			targets = append(targets, endpoint)
+		}
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+
+	now := s.now()
+	payload, err := json.Marshal(WebhookEnvelope{
+		ID:        uuid.New(),
+		Event:     event,
+		CreatedAt: now.UTC(),
+		Data:      data,
+	})
+	if err != nil {
+		return fmt.Errorf("encode webhook payload: %w", err)
+	}
+
+	deliveries := make([]models.WebhookDelivery, 0, len(targets))
+	for _, endpoint := range targets {
+		deliveries = append(deliveries, models.WebhookDelivery{
+			EndpointID:    endpoint.ID,
+			Event:         event,
+			Payload:       string(payload),
+			Status:        models.WebhookDeliveryStatusPending,
+			MaxAttempts:   s.maxAttempts,
+			NextAttemptAt: now,
+		})
+	}
+	if err := s.webhooks.CreateDeliveries(ctx, deliveries); err != nil {
+		return fmt.Errorf("queue webhook deliveries: %w", err)
+	}
+
+	s.nudge()
+	return nil
+}
+
+// Run processes the delivery queue until ctx is cancelled.
+func (s *WebhookService) Run(ctx context.Context) {
+	if err := s.webhooks.ResetSending(ctx, s.now()); err != nil {
+		slog.Error("reset in-flight webhook deliveries failed", slog.Any("error", err))
+	}
+
+	ticker := time.NewTicker(s.pollInterval)
+	defer ticker.Stop()
+
+	var lastCleanup time.Time
+	for {
+		if _, err := s.ProcessDue(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("process webhook deliveries failed", slog.Any("error", err))
+		}
+
+		if now := s.now(); now.Sub(lastCleanup) >= webhookCleanupInterval {
+			if err := s.webhooks.DeleteFinishedBefore(ctx, now.Add(-s.retention)); err != nil && ctx.Err() == nil {
+				slog.Warn("clean webhook delivery log failed", slog.Any("error", err))
+			}
+			lastCleanup = now
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+	}
+}
+
+// ProcessDue attempts every delivery whose next attempt is due and returns how many were attempted.
+func (s *WebhookService) ProcessDue(ctx context.Context) (int, error) {
+	deliveries, err := s.webhooks.ListDueDeliveries(ctx, s.now(), s.batchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	attempted := 0
+	for i := range deliveries {
+		if ctx.Err() != nil {
+			return attempted, ctx.Err()
+		}
+
+		claimed, err := s.webhooks.ClaimDelivery(ctx, deliveries[i].ID)
+		if err != nil {
+			return attempted, err
+		}
+		if !claimed {
+			continue
+		}
+
+		attempted++
+		if err := s.deliver(ctx, &deliveries[i]); err != nil {
+			return attempted, err
+		}
+	}
+
+	return attempted, nil
+}
+
+func (s *WebhookService) deliver(ctx context.Context, delivery *models.WebhookDelivery) error {
+	delivery.Attempts++
+
+	endpoint, err := s.webhooks.FindEndpoint(ctx, delivery.EndpointID)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return s.fail(ctx, delivery, "endpoint no longer exists")
+	case err != nil:
+		return err
+	case !endpoint.Enabled:
+		return s.fail(ctx, delivery, "endpoint disabled")
+	}
+
+	start := time.Now()
+	status, body, sendErr := s.post(ctx, endpoint, delivery)
+	delivery.DurationMs = time.Since(start).Milliseconds()
+	delivery.ResponseStatus = status
+	delivery.ResponseBody = body
+
+	if sendErr == nil && status >= 200 && status < 300 {
+		deliveredAt := s.now()
+		delivery.Status = models.WebhookDeliveryStatusSucceeded
+		delivery.DeliveredAt = &deliveredAt
+		delivery.LastError = ""
+		return s.webhooks.RecordAttempt(ctx, delivery)
+	}
+
+	reason := fmt.Sprintf("unexpected response status %d", status)
+	if sendErr != nil {
+		reason = sendErr.Error()
+	}
+
+	maxAttempts := delivery.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = s.maxAttempts
+	}
+	if delivery.Attempts >= maxAttempts {
+		slog.Error("webhook delivery failed permanently",
+			slog.String("delivery_id", delivery.ID.String()),
+			slog.String("endpoint_id", delivery.EndpointID.String()),
+			slog.Int("attempts", delivery.Attempts),
+			slog.String("error", reason),
+		)
+		return s.fail(ctx, delivery, reason)
+	}
+
+	delivery.Status = models.WebhookDeliveryStatusPending
+	delivery.NextAttemptAt = s.now().Add(exponentialBackoff(s.baseBackoff, s.maxBackoff, delivery.Attempts))
+	delivery.LastError = reason
+	slog.Warn("webhook delivery failed, will retry",
+		slog.String("delivery_id", delivery.ID.String()),
+		slog.String("endpoint_id", delivery.EndpointID.String()),
+		slog.Int("attempts", delivery.Attempts),
+		slog.Time("next_attempt_at", delivery.NextAttemptAt),
+		slog.String("error", reason),
+	)
+	return s.webhooks.RecordAttempt(ctx, delivery)
+}
+
+func (s *WebhookService) fail(ctx context.Context, delivery *models.WebhookDelivery, reason string) error {
+	delivery.Status = models.WebhookDeliveryStatusFailed
+	delivery.LastError = reason
+	return s.webhooks.RecordAttempt(ctx, delivery)
+}
+
+func (s *WebhookService) post(ctx context.Context, endpoint *models.WebhookEndpoint, delivery *models.WebhookDelivery) (int, string, error) {
+	body := []byte(delivery.Payload)
+	timestamp := s.now().Unix()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "hdu-comment-webhook/1.0")
+	req.Header.Set(WebhookHeaderEvent, delivery.Event)
+	req.Header.Set(WebhookHeaderDelivery, delivery.ID.String())
+	req.Header.Set(WebhookHeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookHeaderSignature, "sha256="+SignWebhookPayload(endpoint.Secret, timestamp, body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, webhookResponseBodyLimit))
+	return resp.StatusCode, string(snippet), nil
+}
+
+func (s *WebhookService) findEndpoint(ctx context.Context, id uuid.UUID) (*models.WebhookEndpoint, error) {
+	endpoint, err := s.webhooks.FindEndpoint(ctx, id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrWebhookNotFound
+		}
+		return nil, err
+	}
+	return endpoint, nil
+}
+
+func (s *WebhookService) nudge() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func normalizeWebhookURL(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.Host == "" || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return "", ErrInvalidWebhookURL
+	}
+	return raw, nil
+}
+
+func normalizeWebhookEvents(events []string) ([]string, error) {
+	seen := make(map[string]struct{}, len(events))
+	var result []string
+	for _, event := range events {
+		event = strings.TrimSpace(strings.ToLower(event))
+		if !isWebhookEvent(event) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidWebhookEvents, event)
+		}
+		if _, ok := seen[event]; ok {
+			continue
+		}
+		seen[event] = struct{}{}
+		result = append(result, event)
+	}
+	if len(result) == 0 {
+		return nil, ErrInvalidWebhookEvents
+	}
+	return result, nil
+}
+
+func isWebhookEvent(event string) bool {
+	for _, known := range models.WebhookEvents {
+		if event == known {
+			return true
+		}
+	}
+	return false
+}
+
+func generateWebhookSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(buf), nil
+}
+
+// reviewWebhookData is the public projection of a review sent to webhook endpoints.
+func reviewWebhookData(review *models.Review) map[string]any {
+	images := make([]string, 0, len(review.Images))
+	for _, image := range review.Images {
+		images = append(images, image.URL)
+	}
+	return map[string]any{
+		"id":               review.ID,
+		"title":            review.Title,
+		"address":          review.Address,
+		"description":      review.Description,
+		"rating":           review.Rating,
+		"status":           review.Status,
+		"rejection_reason": review.RejectionReason,
+		"author_id":        review.AuthorID,
+		"author_name":      review.Author.DisplayName,
+		"images":           images,
+		"created_at":       review.CreatedAt,
+		"updated_at":       review.UpdatedAt,
+	}
+}
+
+// userWebhookData is the public projection of a user sent to webhook endpoints.
+func userWebhookData(user *models.User) map[string]any {
+	return map[string]any{
+		"id":           user.ID,
+		"display_name": user.DisplayName,
+		"role":         user.Role,
+		"created_at":   user.CreatedAt,
+	}
+}
@@ -0,0 +1,217 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hdu-dp/backend/internal/models"
+	"github.com/hdu-dp/backend/internal/repository"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type webhookReceiver struct {
+	mu       sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func newWebhookReceiver(t *testing.T, status int) (*webhookReceiver, *httptest.Server) {
+	t.Helper()
+
+	receiver := &webhookReceiver{status: status}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		receiver.mu.Lock()
+		receiver.requests = append(receiver.requests, r)
+		receiver.bodies = append(receiver.bodies, body)
+		status := receiver.status
+		receiver.mu.Unlock()
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte("ack"))
+	}))
+	t.Cleanup(srv.Close)
+	return receiver, srv
+}
+
+func (r *webhookReceiver) setStatus(status int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status = status
+}
+
+func (r *webhookReceiver) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.requests)
+}
+
+func newWebhookTestService(t *testing.T, options WebhookOptions) (*WebhookService, *gorm.DB) {
+	t.Helper()
+
+	dsn := fmt.Sprintf("file:%s_%d?mode=memory&cache=shared", strings.ReplaceAll(t.Name(), "/", "_"), time.Now().UnixNano())
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("open sqlite failed: %v", err)
+	}
+	if err := db.AutoMigrate(&models.WebhookEndpoint{}, &models.WebhookDelivery{}); err != nil {
+		t.Fatalf("auto migrate failed: %v", err)
+	}
+
+	return NewWebhookService(repository.NewWebhookRepository(db), options), db
+}
+
+func TestWebhookPublishDeliversSignedPayloadToSubscribers(t *testing.T) {
+	receiver, srv := newWebhookReceiver(t, http.StatusOK)
+	webhooks, db := newWebhookTestService(t, WebhookOptions{})
+
+	subscribed, err := webhooks.CreateEndpoint(t.Context(), WebhookEndpointInput{
+		Name:   "qq bot",
+		URL:    srv.URL,
+		Secret: "s3cret",
+		Events: []string{models.WebhookEventReviewApproved},
+	})
+	if err != nil {
+		t.Fatalf("create endpoint failed: %v", err)
+	}
+	if _, err := webhooks.CreateEndpoint(t.Context(), WebhookEndpointInput{
+		URL:    srv.URL + "/other",
+		Events: []string{models.WebhookEventUserRegistered},
+	}); err != nil {
+		t.Fatalf("create endpoint failed: %v", err)
+	}
+
+	review := &models.Review{ID: uuid.New(), Title: "炸鸡", Status: models.ReviewStatusApproved}
+	if err := webhooks.Publish(t.Context(), models.WebhookEventReviewApproved, reviewWebhookData(review)); err != nil {
+		t.Fatalf("publish failed: %v", err)
+	}
+
+	attempted, err := webhooks.ProcessDue(t.Context())
+	if err != nil {
+		t.Fatalf("process deliveries failed: %v", err)
+	}
+	if attempted != 1 || receiver.count() != 1 {
+		t.Fatalf("expected exactly one delivery to the subscribed endpoint, attempted=%d received=%d", attempted, receiver.count())
+	}
+
+	req, body := receiver.requests[0], receiver.bodies[0]
+	if req.Header.Get(WebhookHeaderEvent) != models.WebhookEventReviewApproved {
+		t.Fatalf("unexpected event header %q", req.Header.Get(WebhookHeaderEvent))
+	}
+	timestamp, err := strconv.ParseInt(req.Header.Get(WebhookHeaderTimestamp), 10, 64)
+	if err != nil {
+		t.Fatalf("invalid timestamp header: %v", err)
+	}
+	if want := "sha256=" + SignWebhookPayload("s3cret", timestamp, body); req.Header.Get(WebhookHeaderSignature) != want {
+		t.Fatalf("signature mismatch: got %q want %q", req.Header.Get(WebhookHeaderSignature), want)
+	}
+
+	var envelope struct {
+		Event string         `json:"event"`
+		Data  map[string]any `json:"data"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		t.Fatalf("decode payload failed: %v", err)
+	}
+	if envelope.Event != models.WebhookEventReviewApproved || envelope.Data["title"] != "炸鸡" {
+		t.Fatalf("unexpected payload: %s", body)
+	}
+
+	var delivery models.WebhookDelivery
+	if err := db.First(&delivery, "endpoint_id = ?", subscribed.ID).Error; err != nil {
+		t.Fatalf("load delivery failed: %v", err)
+	}
+	if delivery.Status != models.WebhookDeliveryStatusSucceeded || delivery.ResponseStatus != http.StatusOK || delivery.ResponseBody != "ack" {
+		t.Fatalf("unexpected delivery log: %+v", delivery)
+	}
+}
+
+func TestWebhookRetriesFailsAndRedelivers(t *testing.T) {
+	receiver, srv := newWebhookReceiver(t, http.StatusInternalServerError)
+	webhooks, db := newWebhookTestService(t, WebhookOptions{MaxAttempts: 2, BaseBackoff: time.Minute, MaxBackoff: time.Hour})
+
+	now := time.Now()
+	webhooks.now = func() time.Time { return now }
+
+	endpoint, err := webhooks.CreateEndpoint(t.Context(), WebhookEndpointInput{
+		URL:    srv.URL,
+		Events: []string{models.WebhookEventReviewDeleted},
+	})
+	if err != nil {
+		t.Fatalf("create endpoint failed: %v", err)
+	}
+	if err := webhooks.Publish(t.Context(), models.WebhookEventReviewDeleted, map[string]any{"id": "r1"}); err != nil {
+		t.Fatalf("publish failed: %v", err)
+	}
+
+	if _, err := webhooks.ProcessDue(t.Context()); err != nil {
+		t.Fatalf("first attempt failed: %v", err)
+	}
+
+	var delivery models.WebhookDelivery
+	if err := db.First(&delivery, "endpoint_id = ?", endpoint.ID).Error; err != nil {
+		t.Fatalf("load delivery failed: %v", err)
+	}
+	if delivery.Status != models.WebhookDeliveryStatusPending || delivery.Attempts != 1 || delivery.NextAttemptAt.Sub(now) != time.Minute {
+		t.Fatalf("expected retry scheduled after one minute, got %+v", delivery)
+	}
+
+	now = now.Add(time.Minute)
+	if _, err := webhooks.ProcessDue(t.Context()); err != nil {
+		t.Fatalf("second attempt failed: %v", err)
+	}
+	if err := db.First(&delivery, "id = ?", delivery.ID).Error; err != nil {
+		t.Fatalf("reload delivery failed: %v", err)
+	}
+	if delivery.Status != models.WebhookDeliveryStatusFailed || delivery.Attempts != 2 || delivery.ResponseStatus != http.StatusInternalServerError {
+		t.Fatalf("expected delivery to fail permanently, got %+v", delivery)
+	}
+
+	receiver.setStatus(http.StatusNoContent)
+	redelivery, err := webhooks.Redeliver(t.Context(), delivery.ID)
+	if err != nil {
+		t.Fatalf("redeliver failed: %v", err)
+	}
+	if redelivery.RedeliveryOf == nil || *redelivery.RedeliveryOf != delivery.ID || redelivery.Payload != delivery.Payload {
+		t.Fatalf("unexpected redelivery: %+v", redelivery)
+	}
+
+	if _, err := webhooks.ProcessDue(t.Context()); err != nil {
+		t.Fatalf("redelivery attempt failed: %v", err)
+	}
+	if err := db.First(redelivery, "id = ?", redelivery.ID).Error; err != nil {
+		t.Fatalf("reload redelivery failed: %v", err)
+	}
+	if redelivery.Status != models.WebhookDeliveryStatusSucceeded || receiver.count() != 3 {
+		t.Fatalf("expected redelivery to succeed, got %+v after %d requests", redelivery, receiver.count())
+	}
+}
+
+func TestWebhookEndpointValidation(t *testing.T) {
+	webhooks, _ := newWebhookTestService(t, WebhookOptions{})
+
+	if _, err := webhooks.CreateEndpoint(t.Context(), WebhookEndpointInput{URL: "ftp://example.com", Events: []string{models.WebhookEventReviewApproved}}); err != ErrInvalidWebhookURL {
+		t.Fatalf("expected ErrInvalidWebhookURL, got %v", err)
+	}
+	if _, err := webhooks.CreateEndpoint(t.Context(), WebhookEndpointInput{URL: "https://example.com/hook", Events: []string{"review.created"}}); err == nil {
+		t.Fatalf("expected unknown event to be rejected")
+	}
+
+	endpoint, err := webhooks.CreateEndpoint(t.Context(), WebhookEndpointInput{URL: "https://example.com/hook", Events: []string{models.WebhookEventUserRegistered}})
+	if err != nil {
+		t.Fatalf("create endpoint failed: %v", err)
+	}
+	if !strings.HasPrefix(endpoint.Secret, "whsec_") || !endpoint.Enabled {
+		t.Fatalf("expected generated secret and enabled endpoint, got %+v", endpoint)
+	}
+}
@@ -0,0 +1,142 @@
+package sms
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultAliyunEndpoint = "https://dysmsapi.aliyuncs.com/"
+	defaultAliyunRegion   = "cn-hangzhou"
+)
+
+// AliyunConfig holds Aliyun Dysms credentials and template settings.
+type AliyunConfig struct {
+	AccessKeyID     string
+	AccessKeySecret string
+	RegionID        string
+	Endpoint        string
+	SignName        string
+	TemplateCode    string
+}
+
+// Aliyun sends codes through the Aliyun Dysms SendSms API. The template must take a "code" parameter.
+type Aliyun struct {
+	cfg    AliyunConfig
+	client *http.Client
+	now    func() time.Time
+}
+
+// NewAliyun validates configuration and creates an Aliyun sender.
+func NewAliyun(cfg AliyunConfig, client *http.Client) (*Aliyun, error) {
+	if cfg.AccessKeyID == "" || cfg.AccessKeySecret == "" || cfg.SignName == "" || cfg.TemplateCode == "" {
+		return nil, errors.New("aliyun sms requires access key id, access key secret, sign name and template code")
+	}
+	if cfg.RegionID == "" {
+		cfg.RegionID = defaultAliyunRegion
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = defaultAliyunEndpoint
+	}
+	if client == nil {
+		client = &http.Client{Timeout: defaultRequestTimeout}
+	}
+	return &Aliyun{cfg: cfg, client: client, now: time.Now}, nil
+}
+
+// SendCode implements Sender.
+func (a *Aliyun) SendCode(ctx context.Context, phone, code string) error {
+	templateParam, err := json.Marshal(map[string]string{"code": code})
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	params := map[string]string{
+		"AccessKeyId":      a.cfg.AccessKeyID,
+		"Action":           "SendSms",
+		"Format":           "JSON",
+		"PhoneNumbers":     phone,
+		"RegionId":         a.cfg.RegionID,
+		"SignName":         a.cfg.SignName,
+		"SignatureMethod":  "HMAC-SHA1",
+		"SignatureNonce":   hex.EncodeToString(nonce),
+		"SignatureVersion": "1.0",
+		"TemplateCode":     a.cfg.TemplateCode,
+		"TemplateParam":    string(templateParam),
+		"Timestamp":        a.now().UTC().Format("2006-01-02T15:04:05Z"),
+		"Version":          "2017-05-25",
+	}
+
+	query := aliyunCanonicalQuery(params)
+	signature := aliyunSign(a.cfg.AccessKeySecret, http.MethodGet, query)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.cfg.Endpoint+"?Signature="+aliyunEncode(signature)+"&"+query, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("aliyun sms request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Code      string `json:"Code"`
+		Message   string `json:"Message"`
+		RequestID string `json:"RequestId"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("aliyun sms response (status %d): %w", resp.StatusCode, err)
+	}
+	if result.Code != "OK" {
+		return fmt.Errorf("aliyun sms failed: %s %s (request %s)", result.Code, result.Message, result.RequestID)
+	}
+	return nil
+}
+
+// aliyunCanonicalQuery sorts and percent-encodes parameters as required by the RPC signature v1.
+func aliyunCanonicalQuery(params map[string]string) string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, aliyunEncode(key)+"="+aliyunEncode(params[key]))
+	}
+	return strings.Join(pairs, "&")
+}
+
+func aliyunSign(secret, method, canonicalQuery string) string {
+	stringToSign := method + "&" + aliyunEncode("/") + "&" + aliyunEncode(canonicalQuery)
+	mac := hmac.New(sha1.New, []byte(secret+"&"))
+	mac.Write([]byte(stringToSign))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func aliyunEncode(value string) string {
+	encoded := url.QueryEscape(value)
+	encoded = strings.ReplaceAll(encoded, "+", "%20")
+	encoded = strings.ReplaceAll(encoded, "*", "%2A")
+	return strings.ReplaceAll(encoded, "%7E", "~")
+}
@@ -0,0 +1,61 @@
+package sms
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// HTTP posts codes as JSON ({"phone": "...", "code": "..."}) to a URL. It stands in for a real
+// provider in local and integration environments, e.g. a mock server that records messages.
+type HTTP struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+// NewHTTP creates an HTTP sender; token, when set, is sent as a Bearer credential.
+func NewHTTP(target, token string, client *http.Client) (*HTTP, error) {
+	parsed, err := url.Parse(target)
+	if err != nil || parsed.Host == "" || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return nil, errors.New("http sms provider requires an absolute http(s) url")
+	}
+	if client == nil {
+		client = &http.Client{Timeout: defaultRequestTimeout}
+	}
+	return &HTTP{url: target, token: token, client: client}, nil
+}
+
+// SendCode implements Sender.
+func (h *HTTP) SendCode(ctx context.Context, phone, code string) error {
+	body, err := json.Marshal(map[string]string{"phone": phone, "code": code})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("http sms request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("http sms failed: status %d: %s", resp.StatusCode, snippet)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+// Package sms delivers one-time verification codes through a configurable SMS provider.
+package sms
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hdu-dp/backend/internal/config"
+)
+
+const defaultRequestTimeout = 10 * time.Second
+
+// Sender delivers a verification code to a mainland China mobile number (11 digits, no country code).
+type Sender interface {
+	SendCode(ctx context.Context, phone, code string) error
+}
+
+// New creates a sender based on configuration.
+func New(cfg *config.Config) (Sender, error) {
+	sms := cfg.Auth.SMS
+	client := &http.Client{Timeout: defaultRequestTimeout}
+
+	switch strings.ToLower(sms.Provider) {
+	case "log", "":
+		return LogSender{}, nil
+	case "aliyun":
+		return NewAliyun(AliyunConfig{
+			AccessKeyID:     sms.Aliyun.AccessKeyID,
+			AccessKeySecret: sms.Aliyun.AccessKeySecret,
+			RegionID:        sms.Aliyun.RegionID,
+			Endpoint:        sms.Aliyun.Endpoint,
+			SignName:        sms.SignName,
+			TemplateCode:    sms.TemplateID,
+		}, client)
+	case "tencent":
+		return NewTencent(TencentConfig{
+			SecretID:   sms.Tencent.SecretID,
+			SecretKey:  sms.Tencent.SecretKey,
+			SDKAppID:   sms.Tencent.SDKAppID,
+			Region:     sms.Tencent.Region,
+			Endpoint:   sms.Tencent.Endpoint,
+			SignName:   sms.SignName,
+			TemplateID: sms.TemplateID,
+		}, client)
+	case "http":
+		return NewHTTP(sms.HTTP.URL, sms.HTTP.Token, client)
+	default:
+		return nil, fmt.Errorf("unsupported sms provider: %s", sms.Provider)
+	}
+}
+
+// LogSender writes codes to the application log instead of sending them; only suitable for development.
+type LogSender struct{}
+
+// SendCode logs the code.
+func (LogSender) SendCode(ctx context.Context, phone, code string) error {
+	slog.InfoContext(ctx, "sms code generated (log provider, not sent)",
+		slog.String("phone", phone),
+		slog.String("code", code),
+	)
+	return nil
+}
@@ -0,0 +1,123 @@
+package sms
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAliyunSignatureMatchesDocumentedExample(t *testing.T) {
+	query := aliyunCanonicalQuery(map[string]string{
+		"AccessKeyId":      "testid",
+		"Action":           "DescribeRegions",
+		"Format":           "XML",
+		"SignatureMethod":  "HMAC-SHA1",
+		"SignatureNonce":   "3ee8c1b8-83d3-44af-a94f-4e0ad82fd6cf",
+		"SignatureVersion": "1.0",
+		"Timestamp":        "2016-02-23T12:46:24Z",
+		"Version":          "2014-05-26",
+	})
+
+	if got := aliyunSign("testsecret", http.MethodGet, query); got != "OLeaidS1JvxuMvnyHOwuJ+uX5qY=" {
+		t.Fatalf("unexpected signature %q", got)
+	}
+}
+
+func TestAliyunSendCode(t *testing.T) {
+	var captured map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		captured = map[string]string{}
+		for key, values := range r.URL.Query() {
+			captured[key] = values[0]
+		}
+		_, _ = w.Write([]byte(`{"Code":"OK","Message":"OK","RequestId":"req-1"}`))
+	}))
+	defer srv.Close()
+
+	sender, err := NewAliyun(AliyunConfig{
+		AccessKeyID:     "id",
+		AccessKeySecret: "secret",
+		Endpoint:        srv.URL + "/",
+		SignName:        "杭电点评",
+		TemplateCode:    "SMS_1",
+	}, srv.Client())
+	if err != nil {
+		t.Fatalf("new aliyun sender failed: %v", err)
+	}
+
+	if err := sender.SendCode(t.Context(), "13800138000", "123456"); err != nil {
+		t.Fatalf("send code failed: %v", err)
+	}
+	if captured["PhoneNumbers"] != "13800138000" || captured["TemplateParam"] != `{"code":"123456"}` || captured["Signature"] == "" {
+		t.Fatalf("unexpected request params: %v", captured)
+	}
+}
+
+func TestTencentSendCodeReportsAPIError(t *testing.T) {
+	var payload map[string]any
+	var authorization string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &payload)
+		_, _ = w.Write([]byte(`{"Response":{"Error":{"Code":"FailedOperation.SignatureIncorrectOrUnapproved","Message":"bad sign"},"RequestId":"req-2"}}`))
+	}))
+	defer srv.Close()
+
+	sender, err := NewTencent(TencentConfig{
+		SecretID:   "AKIDtest",
+		SecretKey:  "key",
+		SDKAppID:   "1400000000",
+		Endpoint:   srv.URL,
+		SignName:   "杭电点评",
+		TemplateID: "100",
+	}, srv.Client())
+	if err != nil {
+		t.Fatalf("new tencent sender failed: %v", err)
+	}
+
+	err = sender.SendCode(t.Context(), "13800138000", "654321")
+	if err == nil || !strings.Contains(err.Error(), "SignatureIncorrectOrUnapproved") {
+		t.Fatalf("expected api error to be surfaced, got %v", err)
+	}
+	if !strings.HasPrefix(authorization, "TC3-HMAC-SHA256 Credential=AKIDtest/") {
+		t.Fatalf("unexpected authorization header %q", authorization)
+	}
+	if phones, _ := payload["PhoneNumberSet"].([]any); len(phones) != 1 || phones[0] != "+8613800138000" {
+		t.Fatalf("unexpected payload: %v", payload)
+	}
+}
+
+func TestHTTPSendCode(t *testing.T) {
+	var received map[string]string
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	sender, err := NewHTTP(srv.URL, "token", srv.Client())
+	if err != nil {
+		t.Fatalf("new http sender failed: %v", err)
+	}
+
+	if err := sender.SendCode(t.Context(), "13800138000", "111222"); err != nil {
+		t.Fatalf("send code failed: %v", err)
+	}
+	if received["phone"] != "13800138000" || received["code"] != "111222" {
+		t.Fatalf("unexpected body: %v", received)
+	}
+
+	status = http.StatusServiceUnavailable
+	if err := sender.SendCode(t.Context(), "13800138000", "111222"); err == nil {
+		t.Fatalf("expected non-2xx response to fail")
+	}
+}
@@ -0,0 +1,154 @@
+package sms
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultTencentEndpoint = "https://sms.tencentcloudapi.com"
+	defaultTencentRegion   = "ap-guangzhou"
+	tencentService         = "sms"
+	tencentVersion         = "2021-01-11"
+)
+
+// TencentConfig holds Tencent Cloud SMS credentials and template settings.
+type TencentConfig struct {
+	SecretID   string
+	SecretKey  string
+	SDKAppID   string
+	Region     string
+	Endpoint   string
+	SignName   string
+	TemplateID string
+}
+
+// Tencent sends codes through the Tencent Cloud SMS SendSms API. The template's first parameter receives the code.
+type Tencent struct {
+	cfg    TencentConfig
+	host   string
+	client *http.Client
+	now    func() time.Time
+}
+
+// NewTencent validates configuration and creates a Tencent Cloud sender.
+func NewTencent(cfg TencentConfig, client *http.Client) (*Tencent, error) {
+	if cfg.SecretID == "" || cfg.SecretKey == "" || cfg.SDKAppID == "" || cfg.SignName == "" || cfg.TemplateID == "" {
+		return nil, errors.New("tencent sms requires secret id, secret key, sdk app id, sign name and template id")
+	}
+	if cfg.Region == "" {
+		cfg.Region = defaultTencentRegion
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = defaultTencentEndpoint
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid tencent sms endpoint: %s", cfg.Endpoint)
+	}
+	if client == nil {
+		client = &http.Client{Timeout: defaultRequestTimeout}
+	}
+	return &Tencent{cfg: cfg, host: endpoint.Host, client: client, now: time.Now}, nil
+}
+
+// SendCode implements Sender.
+func (t *Tencent) SendCode(ctx context.Context, phone, code string) error {
+	payload, err := json.Marshal(map[string]any{
+		"PhoneNumberSet":   []string{"+86" + phone},
+		"SmsSdkAppId":      t.cfg.SDKAppID,
+		"SignName":         t.cfg.SignName,
+		"TemplateId":       t.cfg.TemplateID,
+		"TemplateParamSet": []string{code},
+	})
+	if err != nil {
+		return err
+	}
+
+	timestamp := t.now().Unix()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.cfg.Endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("X-TC-Action", "SendSms")
+	req.Header.Set("X-TC-Version", tencentVersion)
+	req.Header.Set("X-TC-Region", t.cfg.Region)
+	req.Header.Set("X-TC-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("Authorization", tencentAuthorization(t.cfg.SecretID, t.cfg.SecretKey, t.host, timestamp, payload))
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("tencent sms request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Response struct {
+			Error *struct {
+				Code    string `json:"Code"`
+				Message string `json:"Message"`
+			} `json:"Error"`
+			SendStatusSet []struct {
+				Code    string `json:"Code"`
+				Message string `json:"Message"`
+			} `json:"SendStatusSet"`
+			RequestID string `json:"RequestId"`
+		} `json:"Response"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("tencent sms response (status %d): %w", resp.StatusCode, err)
+	}
+	if apiErr := result.Response.Error; apiErr != nil {
+		return fmt.Errorf("tencent sms failed: %s %s (request %s)", apiErr.Code, apiErr.Message, result.Response.RequestID)
+	}
+	if len(result.Response.SendStatusSet) == 0 {
+		return fmt.Errorf("tencent sms returned no send status (request %s)", result.Response.RequestID)
+	}
+	if status := result.Response.SendStatusSet[0]; status.Code != "Ok" {
+		return fmt.Errorf("tencent sms failed: %s %s (request %s)", status.Code, status.Message, result.Response.RequestID)
+	}
+	return nil
+}
+
+// tencentAuthorization builds a TC3-HMAC-SHA256 Authorization header.
+func tencentAuthorization(secretID, secretKey, host string, timestamp int64, payload []byte) string {
+	const signedHeaders = "content-type;host"
+
+	canonicalRequest := "POST\n/\n\n" +
+		"content-type:application/json; charset=utf-8\nhost:" + host + "\n\n" +
+		signedHeaders + "\n" + sha256Hex(payload)
+
+	date := time.Unix(timestamp, 0).UTC().Format("2006-01-02")
+	scope := date + "/" + tencentService + "/tc3_request"
+	stringToSign := "TC3-HMAC-SHA256\n" + strconv.FormatInt(timestamp, 10) + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	secretDate := hmacSHA256([]byte("TC3"+secretKey), date)
+	secretService := hmacSHA256(secretDate, tencentService)
+	secretSigning := hmacSHA256(secretService, "tc3_request")
+	signature := hex.EncodeToString(hmacSHA256(secretSigning, stringToSign))
+
+	return "TC3-HMAC-SHA256 Credential=" + secretID + "/" + scope +
+		", SignedHeaders=" + signedHeaders + ", Signature=" + signature
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -61,3 +61,8 @@ func (l *Local) Delete(ctx context.Context, key string) error {
 	}
 	return nil
 }
+
+// Open reads a stored file.
+func (l *Local) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(l.baseDir, filepath.FromSlash(key)))
+}
@@ -80,6 +80,19 @@ func (s *S3) Delete(ctx context.Context, key string) error {
 	return nil
 }
 
+// Open streams an object from the configured bucket.
+func (s *S3) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	object, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := object.Stat(); err != nil {
+		object.Close()
+		return nil, err
+	}
+	return object, nil
+}
+
 func (s *S3) objectURL(key string) (string, error) {
 	if s.baseURL != "" {
 		return resolveURL(s.baseURL, key)
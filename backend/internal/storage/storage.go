@@ -18,18 +18,20 @@ type FileInfo struct {
 	URL string
 }
 
-// UploadFile represents metadata required to persist a file.
+// UploadFile represents an uploaded file. It carries no content type: the client's claim is not trusted and
+// services detect the type from the data.
 type UploadFile struct {
-	Reader      io.ReadCloser
-	Size        int64
-	Filename    string
-	ContentType string
+	Reader   io.ReadCloser
+	Size     int64
+	Filename string
 }
 
 // FileStorage describes a generic storage backend.
 type FileStorage interface {
 	Save(ctx context.Context, key string, reader io.Reader, size int64, contentType string) (FileInfo, error)
 	Delete(ctx context.Context, key string) error
+	// Open returns the content of a stored object; callers must close it.
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
 }
 
 // New creates a storage implementation based on configuration.
@@ -0,0 +1,58 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCodeMatchesRFC6238Vectors(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+	// RFC 6238 appendix B lists 8-digit SHA1 codes; the 6-digit code is their last six digits.
+	cases := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for unix, want := range cases {
+		got, err := Code(secret, Counter(time.Unix(unix, 0)))
+		if err != nil {
+			t.Fatalf("code failed: %v", err)
+		}
+		if got != want {
+			t.Fatalf("at %d expected %s, got %s", unix, want, got)
+		}
+	}
+}
+
+func TestValidateAllowsSkewAndReportsCounter(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatalf("generate secret failed: %v", err)
+	}
+	now := time.Unix(1700000000, 0)
+	previous, _ := Code(secret, Counter(now)-1)
+
+	counter, ok := Validate(secret, previous, now, 1)
+	if !ok || counter != Counter(now)-1 {
+		t.Fatalf("expected previous step to validate with skew 1, got %d %v", counter, ok)
+	}
+	if _, ok := Validate(secret, previous, now, 0); ok {
+		t.Fatalf("expected previous step to fail without skew")
+	}
+	if _, ok := Validate(secret, "12345", now, 1); ok {
+		t.Fatalf("expected short code to fail")
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	uri := ProvisioningURI("杭电点评", "admin@example.com", "JBSWY3DPEHPK3PXP")
+	if !strings.HasPrefix(uri, "otpauth://totp/") || !strings.Contains(uri, "secret=JBSWY3DPEHPK3PXP") || !strings.Contains(uri, "period=30") {
+		t.Fatalf("unexpected uri %q", uri)
+	}
+}
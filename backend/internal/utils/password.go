@@ -1,17 +1,180 @@
 package utils
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
 
-// HashPassword wraps bcrypt generation with sane defaults.
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hashing algorithms accepted by PasswordParams.
+const (
+	PasswordAlgorithmArgon2id = "argon2id"
+	PasswordAlgorithmBcrypt   = "bcrypt"
+)
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+// ErrPasswordMismatch is returned by CheckPassword when the password does not match the hash.
+var ErrPasswordMismatch = errors.New("password does not match")
+
+// PasswordParams is the policy for new password hashes. Hashes are self-describing, so hashes made
+// under an earlier policy keep verifying after it changes.
+type PasswordParams struct {
+	Algorithm string
+	// Argon2Memory is in KiB.
+	Argon2Memory      uint32
+	Argon2Iterations  uint32
+	Argon2Parallelism uint8
+	BcryptCost        int
+}
+
+// DefaultPasswordParams follows the OWASP baseline for argon2id.
+var DefaultPasswordParams = PasswordParams{
+	Algorithm:         PasswordAlgorithmArgon2id,
+	Argon2Memory:      19 * 1024,
+	Argon2Iterations:  2,
+	Argon2Parallelism: 1,
+	BcryptCost:        bcrypt.DefaultCost,
+}
+
+var (
+	passwordParamsMu sync.RWMutex
+	passwordParams   = DefaultPasswordParams
+)
+
+// SetPasswordParams replaces the policy used by HashPassword and PasswordNeedsRehash.
+func SetPasswordParams(params PasswordParams) error {
+	switch params.Algorithm {
+	case PasswordAlgorithmArgon2id:
+		if params.Argon2Memory < 8*uint32(params.Argon2Parallelism) || params.Argon2Iterations < 1 || params.Argon2Parallelism < 1 {
+			return fmt.Errorf("invalid argon2id parameters: memory=%d iterations=%d parallelism=%d",
+				params.Argon2Memory, params.Argon2Iterations, params.Argon2Parallelism)
+		}
+	case PasswordAlgorithmBcrypt:
+		if params.BcryptCost < bcrypt.MinCost || params.BcryptCost > bcrypt.MaxCost {
+			return fmt.Errorf("invalid bcrypt cost %d", params.BcryptCost)
+		}
+	default:
+		return fmt.Errorf("unsupported password hash algorithm %q", params.Algorithm)
+	}
+
+	passwordParamsMu.Lock()
+	passwordParams = params
+	passwordParamsMu.Unlock()
+	return nil
+}
+
+func currentPasswordParams() PasswordParams {
+	passwordParamsMu.RLock()
+	defer passwordParamsMu.RUnlock()
+	return passwordParams
+}
+
+// HashPassword hashes password under the current policy. Argon2id hashes use the PHC string format
+// ($argon2id$v=19$m=...,t=...,p=...$salt$hash); bcrypt hashes use the usual $2a$ format.
 func HashPassword(password string) (string, error) {
-	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
+	params := currentPasswordParams()
+	if params.Algorithm == PasswordAlgorithmBcrypt {
+		hashed, err := bcrypt.GenerateFromPassword([]byte(password), params.BcryptCost)
+		if err != nil {
+			return "", err
+		}
+		return string(hashed), nil
+	}
+
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
 		return "", err
 	}
-	return string(hashed), nil
+	key := argon2.IDKey([]byte(password), salt, params.Argon2Iterations, params.Argon2Memory, params.Argon2Parallelism, argon2KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		params.Argon2Memory,
+		params.Argon2Iterations,
+		params.Argon2Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
 }
 
-// CheckPassword compares a bcrypt hashed password with plain text.
+// CheckPassword compares a hashed password of any supported format with plain text.
 func CheckPassword(hashed, password string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hashed), []byte(password))
+	if !strings.HasPrefix(hashed, "$argon2id$") {
+		return bcrypt.CompareHashAndPassword([]byte(hashed), []byte(password))
+	}
+
+	hash, err := parseArgon2Hash(hashed)
+	if err != nil {
+		return err
+	}
+	key := argon2.IDKey([]byte(password), hash.salt, hash.iterations, hash.memory, hash.parallelism, uint32(len(hash.key)))
+	if subtle.ConstantTimeCompare(key, hash.key) != 1 {
+		return ErrPasswordMismatch
+	}
+	return nil
+}
+
+// PasswordNeedsRehash reports whether hashed is weaker than the current policy and should be replaced
+// the next time the plain password is available.
+func PasswordNeedsRehash(hashed string) bool {
+	params := currentPasswordParams()
+	if params.Algorithm == PasswordAlgorithmBcrypt {
+		if strings.HasPrefix(hashed, "$argon2id$") {
+			return false
+		}
+		cost, err := bcrypt.Cost([]byte(hashed))
+		return err != nil || cost < params.BcryptCost
+	}
+
+	hash, err := parseArgon2Hash(hashed)
+	if err != nil {
+		return true
+	}
+	return hash.memory < params.Argon2Memory ||
+		hash.iterations < params.Argon2Iterations ||
+		hash.parallelism < params.Argon2Parallelism ||
+		len(hash.key) < argon2KeyLength
+}
+
+type argon2Hash struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	salt        []byte
+	key         []byte
+}
+
+func parseArgon2Hash(hashed string) (*argon2Hash, error) {
+	parts := strings.Split(hashed, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, errors.New("malformed argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, fmt.Errorf("unsupported argon2id version %q", parts[2])
+	}
+
+	hash := &argon2Hash{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &hash.memory, &hash.iterations, &hash.parallelism); err != nil {
+		return nil, fmt.Errorf("malformed argon2id parameters: %w", err)
+	}
+	var err error
+	if hash.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, fmt.Errorf("malformed argon2id salt: %w", err)
+	}
+	if hash.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(hash.key) == 0 {
+		return nil, errors.New("malformed argon2id key")
+	}
+	return hash, nil
 }
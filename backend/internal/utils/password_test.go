@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPasswordHashesAcrossPolicies(t *testing.T) {
+	t.Cleanup(func() { _ = SetPasswordParams(DefaultPasswordParams) })
+
+	weak := DefaultPasswordParams
+	weak.Argon2Memory = 1024
+	weak.Argon2Iterations = 1
+	if err := SetPasswordParams(weak); err != nil {
+		t.Fatalf("set params failed: %v", err)
+	}
+	weakHash, err := HashPassword("hunter2")
+	if err != nil {
+		t.Fatalf("hash failed: %v", err)
+	}
+	if !strings.HasPrefix(weakHash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Fatalf("unexpected hash format %q", weakHash)
+	}
+
+	if err := SetPasswordParams(DefaultPasswordParams); err != nil {
+		t.Fatalf("set params failed: %v", err)
+	}
+	if err := CheckPassword(weakHash, "hunter2"); err != nil {
+		t.Fatalf("expected a hash from an earlier policy to verify, got %v", err)
+	}
+	if err := CheckPassword(weakHash, "hunter3"); err == nil {
+		t.Fatalf("expected a wrong password to be rejected")
+	}
+	if !PasswordNeedsRehash(weakHash) {
+		t.Fatalf("expected a hash with weaker parameters to need a rehash")
+	}
+
+	legacy, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("bcrypt failed: %v", err)
+	}
+	if err := CheckPassword(string(legacy), "hunter2"); err != nil {
+		t.Fatalf("expected bcrypt hashes to keep verifying, got %v", err)
+	}
+	if !PasswordNeedsRehash(string(legacy)) {
+		t.Fatalf("expected bcrypt hashes to need a rehash under argon2id")
+	}
+
+	current, err := HashPassword("hunter2")
+	if err != nil {
+		t.Fatalf("hash failed: %v", err)
+	}
+	if PasswordNeedsRehash(current) {
+		t.Fatalf("expected a hash under the current policy to be kept")
+	}
+
+	if err := SetPasswordParams(PasswordParams{Algorithm: "md5"}); err == nil {
+		t.Fatalf("expected an unknown algorithm to be refused")
+	}
+}